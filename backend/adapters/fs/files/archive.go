@@ -0,0 +1,322 @@
+package files
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"filebrowser/adapters/fs/fileutils"
+	"filebrowser/common/errors"
+	"filebrowser/common/settings"
+	"filebrowser/database/users"
+	"filebrowser/indexing"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/gtsteffaniak/go-logger/logger"
+)
+
+// archive formats
+const (
+	ArchiveZip   = "zip"
+	ArchiveTar   = "tar"
+	ArchiveTarGz = "tar.gz"
+)
+
+// Archive is a selection of files and folders of one source that is streamed as an archive.
+type Archive struct {
+	Format string
+	Name   string // file name for the download, after the selected item or the common parent folder
+	Size   int64  // combined size of the selected files before compression
+
+	index     *indexing.Index
+	scopeRoot string   // resolved real path of the user's scope, symlinks must point inside it
+	base      string   // real path of the common parent, archived names are relative to it
+	selected  []string // real paths of the selected items
+}
+
+// NewArchive checks a selection of paths, relative to the user's scope, and its size against
+// Server.MaxArchiveSizeGB before anything is streamed. Folders are sized from the index.
+func NewArchive(user *users.User, source string, paths []string, format string) (*Archive, error) {
+	switch format {
+	case ArchiveZip, ArchiveTar, ArchiveTarGz:
+	default:
+		return nil, errors.ErrInvalidOption
+	}
+	if len(paths) == 0 {
+		return nil, errors.ErrEmptyRequest
+	}
+	index := indexing.GetIndex(source)
+	if index == nil {
+		return nil, fmt.Errorf("could not get index: %v ", source)
+	}
+	scope, err := userScope(user, index)
+	if err != nil {
+		return nil, err
+	}
+	scopeRoot, err := filepath.EvalSymlinks(filepath.Join(index.Path, scope))
+	if err != nil {
+		return nil, err
+	}
+	indexPaths := []string{}
+	for _, p := range paths {
+		indexPath := path.Join(scope, path.Clean("/"+p))
+		// trashed items are only downloaded after they are restored
+		if indexing.InTrash(indexPath) {
+			return nil, errors.ErrPermissionDenied
+		}
+		indexPaths = append(indexPaths, indexPath)
+	}
+	// items inside another selected folder are archived with it
+	slices.Sort(indexPaths)
+	indexPaths = slices.Compact(indexPaths)
+	selected := indexPaths[:0]
+	for _, indexPath := range indexPaths {
+		if len(selected) > 0 && isInside(selected[len(selected)-1], indexPath) {
+			continue
+		}
+		selected = append(selected, indexPath)
+	}
+
+	base := fileutils.CommonPrefix('/', selected...)
+	if slices.Contains(selected, base) {
+		base = path.Dir(base)
+	}
+	archive := &Archive{
+		Format:    format,
+		index:     index,
+		scopeRoot: scopeRoot,
+		base:      filepath.Join(index.Path, base),
+	}
+	name := path.Base(base)
+	if len(selected) == 1 {
+		name = path.Base(selected[0])
+	}
+	if name == "/" || name == "." {
+		name = index.Name
+	}
+	archive.Name = name + "." + format
+
+	for _, indexPath := range selected {
+		realPath := filepath.Join(index.Path, indexPath)
+		info, err := os.Lstat(realPath)
+		if os.IsNotExist(err) {
+			return nil, errors.ErrNotExist
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !archive.allowed(realPath) {
+			return nil, errors.ErrPermissionDenied
+		}
+		if info.IsDir() {
+			archive.Size += index.TreeSize(indexPath, realPath)
+		} else {
+			archive.Size += info.Size()
+		}
+		archive.selected = append(archive.selected, realPath)
+	}
+	maxSize := settings.Config.Server.MaxArchiveSizeGB * 1024 * 1024 * 1024
+	if maxSize > 0 && archive.Size > maxSize {
+		return nil, errors.ErrArchiveTooLarge
+	}
+	return archive, nil
+}
+
+// isInside reports whether indexPath is dir or below it.
+func isInside(dir, indexPath string) bool {
+	return indexPath == dir || strings.HasPrefix(indexPath, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// allowed reports whether a path, with every symlink in it resolved, is inside the user's scope.
+func (a *Archive) allowed(realPath string) bool {
+	resolved, err := filepath.EvalSymlinks(realPath)
+	if err != nil {
+		return false
+	}
+	return resolved == a.scopeRoot || strings.HasPrefix(resolved, strings.TrimSuffix(a.scopeRoot, string(filepath.Separator))+string(filepath.Separator))
+}
+
+// ContentType returns the mime type of the archive format.
+func (a *Archive) ContentType() string {
+	switch a.Format {
+	case ArchiveZip:
+		return "application/zip"
+	case ArchiveTarGz:
+		return "application/gzip"
+	}
+	return "application/x-tar"
+}
+
+// archiveWriter adds entries to an archive of one of the formats.
+type archiveWriter interface {
+	// add writes an entry, content is nil for folders and symlinks
+	add(name string, info fs.FileInfo, link string, content io.Reader) error
+	Close() error
+}
+
+type zipWriter struct {
+	*zip.Writer
+}
+
+func (w zipWriter) add(name string, info fs.FileInfo, link string, content io.Reader) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	} else if info.Mode().IsRegular() {
+		header.Method = zip.Deflate
+	}
+	entry, err := w.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	if link != "" {
+		// zip stores the target of a symlink as its content
+		content = strings.NewReader(link)
+	}
+	if content != nil {
+		_, err = io.Copy(entry, content)
+	}
+	return err
+}
+
+type tarWriter struct {
+	*tar.Writer
+	gzip *gzip.Writer // nil for uncompressed archives
+}
+
+func (w tarWriter) add(name string, info fs.FileInfo, link string, content io.Reader) error {
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	if err = w.WriteHeader(header); err != nil {
+		return err
+	}
+	if content != nil {
+		// the header holds the size, a file growing while it is archived is cut off
+		_, err = io.CopyN(w.Writer, content, header.Size)
+	}
+	return err
+}
+
+func (w tarWriter) Close() error {
+	err := w.Writer.Close()
+	if w.gzip != nil {
+		if gzipErr := w.gzip.Close(); err == nil {
+			err = gzipErr
+		}
+	}
+	return err
+}
+
+// countingWriter counts the bytes written to an io.Writer.
+type countingWriter struct {
+	io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// WriteTo streams the archive to out without temporary files. Symlinks are stored as links
+// when they point inside the user's scope and left out otherwise. Items removed while
+// streaming are skipped.
+func (a *Archive) WriteTo(out io.Writer) (int64, error) {
+	defer indexing.TrackRequest()()
+	counter := &countingWriter{Writer: out}
+	var archive archiveWriter
+	switch a.Format {
+	case ArchiveZip:
+		archive = zipWriter{zip.NewWriter(counter)}
+	case ArchiveTar:
+		archive = tarWriter{Writer: tar.NewWriter(counter)}
+	default:
+		compressed := gzip.NewWriter(counter)
+		archive = tarWriter{Writer: tar.NewWriter(compressed), gzip: compressed}
+	}
+	err := a.writeItems(archive)
+	if closeErr := archive.Close(); err == nil {
+		err = closeErr
+	}
+	return counter.n, err
+}
+
+func (a *Archive) writeItems(archive archiveWriter) error {
+	trashPath := filepath.Join(a.index.Path, indexing.TrashDirName)
+	for _, selected := range a.selected {
+		err := filepath.WalkDir(selected, func(realPath string, entry fs.DirEntry, err error) error {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if realPath == trashPath {
+				return fs.SkipDir
+			}
+			info, err := entry.Info()
+			if os.IsNotExist(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			name, err := filepath.Rel(a.base, realPath)
+			if err != nil {
+				return err
+			}
+			if name == "." {
+				// the whole source is selected, its content is archived without a parent folder
+				return nil
+			}
+			name = filepath.ToSlash(name)
+			switch {
+			case info.IsDir():
+				return archive.add(name, info, "", nil)
+			case info.Mode()&fs.ModeSymlink != 0:
+				if !a.allowed(realPath) {
+					logger.Debugf("archive excludes symlink %v pointing outside of the scope", realPath)
+					return nil
+				}
+				link, err := os.Readlink(realPath)
+				if err != nil {
+					return err
+				}
+				return archive.add(name, info, link, nil)
+			case info.Mode().IsRegular():
+				file, err := os.Open(realPath)
+				if os.IsNotExist(err) {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				return archive.add(name, info, "", file)
+			}
+			// devices, sockets and pipes have no content to archive
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package files
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"filebrowser/common/errors"
+	"filebrowser/common/utils"
+	"filebrowser/database/users"
+	"filebrowser/indexing"
+	"filebrowser/indexing/iteminfo"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gtsteffaniak/go-logger/logger"
+)
+
+// partialHashSize bytes from the start and the end of a file are compared before hashing all of it.
+const partialHashSize = 64 * 1024
+
+type DuplicateGroup struct {
+	Size        int64    `json:"size"`
+	Checksum    string   `json:"checksum"`    // sha256 of the file contents
+	Paths       []string `json:"paths"`       // relative to the searched scope
+	Reclaimable int64    `json:"reclaimable"` // bytes freed by keeping a single copy
+}
+
+type DuplicateReport struct {
+	Source      string           `json:"source"`
+	Scope       string           `json:"scope"`
+	Groups      []DuplicateGroup `json:"groups"`
+	Reclaimable int64            `json:"reclaimable"` // total of all groups
+	Hashed      int              `json:"hashed"`      // files that had to be read, cached checksums are not counted
+}
+
+// one duplicate job per source at a time, keyed by source path
+var duplicateJobs sync.Map
+
+// FindUserDuplicates looks for duplicates inside the user's scope of the given source.
+func FindUserDuplicates(user *users.User, source string, minSize int64) (DuplicateReport, error) {
+	index := indexing.GetIndex(source)
+	if index == nil {
+		return DuplicateReport{}, fmt.Errorf("could not get index: %v ", source)
+	}
+	scope, err := userScope(user, index)
+	if err != nil {
+		return DuplicateReport{}, err
+	}
+	return FindDuplicates(index.Name, scope, minSize)
+}
+
+// FindDuplicates groups indexed files under scope by size, then compares a partial hash
+// and finally the full checksum of the remaining candidates.
+// Checksums are cached by path, size and modification time so reruns only read changed files.
+func FindDuplicates(source, scope string, minSize int64) (DuplicateReport, error) {
+	index := indexing.GetIndex(source)
+	if index == nil {
+		return DuplicateReport{}, fmt.Errorf("could not get index: %v ", source)
+	}
+	if index.Config.DisableIndexing {
+		return DuplicateReport{}, errors.ErrNotIndexed
+	}
+	if minSize < 1 {
+		// empty files are all equal, they are not worth reporting
+		minSize = 1
+	}
+	job, _ := duplicateJobs.LoadOrStore(index.Path, &sync.Mutex{})
+	job.(*sync.Mutex).Lock()
+	defer job.(*sync.Mutex).Unlock()
+
+	scope = "/" + strings.Trim(scope, "/")
+	report := DuplicateReport{Source: index.Name, Scope: scope, Groups: []DuplicateGroup{}}
+	bySize := map[int64][]iteminfo.FileInfo{}
+	for _, file := range index.FilesInScope(scope) {
+		if file.Size >= minSize {
+			bySize[file.Size] = append(bySize[file.Size], file)
+		}
+	}
+
+	realPath := func(file iteminfo.FileInfo) string {
+		return strings.TrimRight(index.Path, "/") + file.Path
+	}
+	for size, candidates := range bySize {
+		if len(candidates) < 2 {
+			continue
+		}
+		for _, partialGroup := range groupByHash(candidates, func(file iteminfo.FileInfo) (string, error) {
+			return cachedHash(realPath(file), file, "partial", &report.Hashed, partialChecksum)
+		}) {
+			for checksum, group := range groupByHash(partialGroup, func(file iteminfo.FileInfo) (string, error) {
+				return cachedHash(realPath(file), file, "sha256", &report.Hashed, fullChecksum)
+			}) {
+				duplicate := DuplicateGroup{
+					Size:        size,
+					Checksum:    checksum,
+					Reclaimable: size * int64(len(group)-1),
+				}
+				for _, file := range group {
+					relativePath := strings.TrimPrefix(file.Path, strings.TrimSuffix(scope, "/"))
+					duplicate.Paths = append(duplicate.Paths, relativePath)
+				}
+				sort.Strings(duplicate.Paths)
+				report.Groups = append(report.Groups, duplicate)
+				report.Reclaimable += duplicate.Reclaimable
+			}
+		}
+	}
+	sort.Slice(report.Groups, func(i, j int) bool {
+		if report.Groups[i].Reclaimable != report.Groups[j].Reclaimable {
+			return report.Groups[i].Reclaimable > report.Groups[j].Reclaimable
+		}
+		return report.Groups[i].Paths[0] < report.Groups[j].Paths[0]
+	})
+	return report, nil
+}
+
+// groupByHash returns the groups of files with an equal hash that contain more than one file.
+// Files that can't be read are skipped.
+func groupByHash(files []iteminfo.FileInfo, hashFunc func(iteminfo.FileInfo) (string, error)) map[string][]iteminfo.FileInfo {
+	groups := map[string][]iteminfo.FileInfo{}
+	for _, file := range files {
+		sum, err := hashFunc(file)
+		if err != nil {
+			logger.Debugf("skipping %v for duplicate detection: %v", file.Path, err)
+			continue
+		}
+		groups[sum] = append(groups[sum], file)
+	}
+	for sum, group := range groups {
+		if len(group) < 2 {
+			delete(groups, sum)
+		}
+	}
+	return groups
+}
+
+func cachedHash(realPath string, file iteminfo.FileInfo, kind string, hashed *int, hashFunc func(string, int64) (string, error)) (string, error) {
+	cacheKey := fmt.Sprintf("%v:%v:%v:%v", kind, realPath, file.Size, file.ModTime.UnixNano())
+	if sum, ok := utils.ChecksumCache.Get(cacheKey).(string); ok {
+		return sum, nil
+	}
+	sum, err := hashFunc(realPath, file.Size)
+	if err != nil {
+		return "", err
+	}
+	*hashed++
+	utils.ChecksumCache.Set(cacheKey, sum)
+	return sum, nil
+}
+
+// partialChecksum hashes the first and last partialHashSize bytes of a file.
+func partialChecksum(realPath string, size int64) (string, error) {
+	file, err := os.Open(realPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	buf := make([]byte, partialHashSize)
+	n, err := file.ReadAt(buf, 0)
+	if n == 0 && err != nil {
+		return "", err
+	}
+	h.Write(buf[:n])
+	if size > 2*partialHashSize {
+		n, err = file.ReadAt(buf, size-partialHashSize)
+		if n == 0 && err != nil {
+			return "", err
+		}
+		h.Write(buf[:n])
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func fullChecksum(realPath string, _ int64) (string, error) {
+	sums, err := GetChecksum(realPath, "sha256")
+	if err != nil {
+		return "", err
+	}
+	return sums["sha256"], nil
+}
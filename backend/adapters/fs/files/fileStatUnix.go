@@ -0,0 +1,26 @@
+//go:build !windows
+// +build !windows
+
+package files
+
+import (
+	"os"
+	"syscall"
+)
+
+// keepOwner gives a file the owner and group of the file it replaces. It needs privileges
+// to change the owner, errors are ignored.
+func keepOwner(path string, replaced os.FileInfo) {
+	if stat, ok := replaced.Sys().(*syscall.Stat_t); ok {
+		_ = os.Lchown(path, int(stat.Uid), int(stat.Gid))
+	}
+}
+
+// hardLinks returns the number of hard links of a file.
+func hardLinks(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		// the field type differs between platforms
+		return uint64(stat.Nlink)
+	}
+	return 1
+}
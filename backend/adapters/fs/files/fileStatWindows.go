@@ -0,0 +1,16 @@
+//go:build windows
+// +build windows
+
+package files
+
+import (
+	"os"
+)
+
+// keepOwner is not supported on Windows, files get the owner of the process.
+func keepOwner(path string, replaced os.FileInfo) {}
+
+// hardLinks is not available from file info on Windows, files are replaced by a rename.
+func hardLinks(info os.FileInfo) uint64 {
+	return 1
+}
@@ -2,32 +2,41 @@ package files
 
 import (
 	"crypto/md5"
+	"crypto/rand"
 	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/hex"
 	"filebrowser/adapters/fs/fileutils"
+	"filebrowser/adapters/fs/versions"
 	"filebrowser/common/errors"
+	"filebrowser/common/metrics"
 	"filebrowser/common/settings"
 	"filebrowser/common/utils"
+	"filebrowser/database/tags"
 	"filebrowser/indexing"
 	"filebrowser/indexing/iteminfo"
 	"fmt"
 	"hash"
+	"hash/fnv"
 	"io"
 	"unicode"
 	"unicode/utf8"
 
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gtsteffaniak/go-logger/logger"
 )
 
 func FileInfoFaster(opts iteminfo.FileOptions) (iteminfo.ExtendedFileInfo, error) {
+	defer indexing.TrackRequest()()
 	response := iteminfo.ExtendedFileInfo{}
 	if opts.Source == "" {
 		opts.Source = settings.Config.Server.DefaultSource.Name
@@ -77,6 +86,12 @@ func FileInfoFaster(opts iteminfo.FileOptions) (iteminfo.ExtendedFileInfo, error
 	if settings.Config.Integrations.OnlyOffice.Secret != "" && info.Type != "directory" && iteminfo.IsOnlyOffice(info.Name) {
 		response.OnlyOfficeId = generateOfficeId(realPath)
 	}
+	if info.Type != "directory" {
+		response.ETag = iteminfo.ETag(info.ModTime, info.Size)
+	}
+	if opts.Metadata && info.Type != "directory" {
+		response.Metadata = index.Metadata(info.Path, realPath, info.Type, info.Size, info.ModTime)
+	}
 	if strings.HasPrefix(info.Type, "video") {
 		parentInfo, exists := index.GetReducedMetadata(filepath.Dir(info.Path), true)
 		if exists {
@@ -86,6 +101,19 @@ func FileInfoFaster(opts iteminfo.FileOptions) (iteminfo.ExtendedFileInfo, error
 	return response, nil
 }
 
+// ListDirectory returns a page of a directory, for folders too large to list at once.
+func ListDirectory(source, path string, opts iteminfo.ListOptions) (*iteminfo.DirectoryPage, error) {
+	defer indexing.TrackRequest()()
+	if source == "" {
+		source = settings.Config.Server.DefaultSource.Name
+	}
+	index := indexing.GetIndex(source)
+	if index == nil {
+		return nil, fmt.Errorf("could not get index: %v ", source)
+	}
+	return index.ListDir(path, opts)
+}
+
 func generateOfficeId(realPath string) string {
 	key, ok := utils.OnlyOfficeCache.Get(realPath).(string)
 	if !ok {
@@ -107,14 +135,7 @@ func GetChecksum(fullPath, algo string) (map[string]string, error) {
 	}
 	defer reader.Close()
 
-	hashFuncs := map[string]hash.Hash{
-		"md5":    md5.New(),
-		"sha1":   sha1.New(),
-		"sha256": sha256.New(),
-		"sha512": sha512.New(),
-	}
-
-	h, ok := hashFuncs[algo]
+	h, ok := newHash(algo)
 	if !ok {
 		return subs, errors.ErrInvalidOption
 	}
@@ -127,15 +148,42 @@ func GetChecksum(fullPath, algo string) (map[string]string, error) {
 	return subs, nil
 }
 
-func DeleteFiles(source, absPath string, absDirPath string) error {
-	err := os.RemoveAll(absPath)
-	if err != nil {
-		return err
-	}
+// newHash returns the hash of a checksum algorithm: md5, sha1, sha256 or sha512.
+func newHash(algo string) (hash.Hash, bool) {
+	switch algo {
+	case "md5":
+		return md5.New(), true
+	case "sha1":
+		return sha1.New(), true
+	case "sha256":
+		return sha256.New(), true
+	case "sha512":
+		return sha512.New(), true
+	}
+	return nil, false
+}
+
+// DeleteFiles moves a file or folder to the trash of its source, or deletes it
+// permanently when the trash is disabled for the source.
+func DeleteFiles(source, absPath, absDirPath, deletedBy string) error {
+	defer indexing.TrackRequest()()
 	index := indexing.GetIndex(source)
 	if index == nil {
 		return fmt.Errorf("could not get index: %v ", source)
 	}
+	var err error
+	if index.TrashEnabled() {
+		// tags and versions go with the item, see the trash hooks
+		_, err = index.MoveToTrash(index.MakeIndexPath(absPath), deletedBy)
+	} else {
+		err = os.RemoveAll(absPath)
+		if err == nil {
+			removePathData(index, index.MakeIndexPath(absPath))
+		}
+	}
+	if err != nil {
+		return err
+	}
 	refreshConfig := iteminfo.FileOptions{Path: index.MakeIndexPath(absDirPath), IsDir: true}
 	err = index.RefreshFileInfo(refreshConfig)
 	if err != nil {
@@ -145,17 +193,27 @@ func DeleteFiles(source, absPath string, absDirPath string) error {
 }
 
 func MoveResource(sourceIndex, destIndex, realsrc, realdst string) error {
-	err := fileutils.MoveFile(realsrc, realdst)
-	if err != nil {
-		return err
-	}
+	defer indexing.TrackRequest()()
 	idxSrc := indexing.GetIndex(sourceIndex)
 	if idxSrc == nil {
 		return fmt.Errorf("could not get index: %v ", sourceIndex)
 	}
 	idxDst := indexing.GetIndex(destIndex)
 	if idxDst == nil {
-		return fmt.Errorf("could not get index: %v ", sourceIndex)
+		return fmt.Errorf("could not get index: %v ", destIndex)
+	}
+	// the trash only changes through deletes and restores
+	if indexing.InTrash(idxDst.MakeIndexPath(realdst)) {
+		return errors.ErrPermissionDenied
+	}
+	err := fileutils.MoveFile(realsrc, realdst)
+	if err != nil {
+		return err
+	}
+	metrics.FileOperations.Inc("move")
+	tags.PathMoved(idxSrc.Name, idxSrc.MakeIndexPath(realsrc), idxDst.Name, idxDst.MakeIndexPath(realdst))
+	if err = versions.Move(idxSrc.Source, idxSrc.MakeIndexPath(realsrc), idxDst.Source, idxDst.MakeIndexPath(realdst)); err != nil {
+		logger.Errorf("could not move versions of %v: %v", realsrc, err)
 	}
 	refreshSourceDir := idxSrc.MakeIndexPath(filepath.Dir(realsrc))
 	refreshDestDir := idxDst.MakeIndexPath(filepath.Dir(realdst))
@@ -179,10 +237,12 @@ func MoveResource(sourceIndex, destIndex, realsrc, realdst string) error {
 }
 
 func CopyResource(sourceIndex, destIndex, realsrc, realdst string) error {
+	defer indexing.TrackRequest()()
 	err := fileutils.CopyFile(realsrc, realdst)
 	if err != nil {
 		return err
 	}
+	metrics.FileOperations.Inc("copy")
 	idxSrc := indexing.GetIndex(sourceIndex)
 	if idxSrc == nil {
 		return fmt.Errorf("could not get index: %v ", sourceIndex)
@@ -217,6 +277,9 @@ func WriteDirectory(opts iteminfo.FileOptions) error {
 	if idx == nil {
 		return fmt.Errorf("could not get index: %v ", opts.Source)
 	}
+	if indexing.InTrash(path.Clean("/" + opts.Path)) {
+		return errors.ErrPermissionDenied
+	}
 	realPath, _, _ := idx.GetRealPath(opts.Path)
 	// Ensure the parent directories exist
 	err := os.MkdirAll(realPath, 0775)
@@ -230,11 +293,29 @@ func WriteDirectory(opts iteminfo.FileOptions) error {
 	return nil
 }
 
+// writeLocks serializes writes to the same path, striped by a hash of the path
+var writeLocks [64]sync.Mutex
+
+func writeLock(path string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(path))
+	return &writeLocks[h.Sum32()%uint32(len(writeLocks))]
+}
+
+// WriteFile saves the content of in to the file. The content is written to a temporary file
+// in the same directory, synced and renamed into place, so a failed upload or a crash
+// never leaves a partially written file. When opts has an IfMatch or ExpectedModTime
+// precondition, the write fails with ErrConflict if the file changed in the meantime.
+// Files with several hard links are rewritten in place instead, see commitFile.
 func WriteFile(opts iteminfo.FileOptions, in io.Reader) error {
+	defer indexing.TrackRequest()()
 	idx := indexing.GetIndex(opts.Source)
 	if idx == nil {
 		return fmt.Errorf("could not get index: %v ", opts.Source)
 	}
+	if indexing.InTrash(path.Clean("/" + opts.Path)) {
+		return errors.ErrPermissionDenied
+	}
 	dst, _, _ := idx.GetRealPath(opts.Path)
 	parentDir := filepath.Dir(dst)
 	// Create the directory and all necessary parents
@@ -242,22 +323,198 @@ func WriteFile(opts iteminfo.FileOptions, in io.Reader) error {
 	if err != nil {
 		return err
 	}
+	// fail before reading the upload when the file already changed, commitFile checks again
+	if _, err = checkWritePreconditions(dst, opts); err != nil {
+		return err
+	}
+	tempPath, err := writeTemp(writeTarget(dst), in, idx.Name)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempPath) // fails once the file is renamed into place
+	return commitFile(idx, dst, tempPath, opts, true)
+}
+
+// newFileMode is the mode new files are created with, reduced by the umask.
+const newFileMode os.FileMode = 0775
 
-	// Open the file for writing (create if it doesn't exist, truncate if it does)
-	file, err := os.OpenFile(dst, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0775)
+// writeTarget resolves a symlink at dst, so writes replace the file it points to and keep the link.
+func writeTarget(dst string) string {
+	if target, err := filepath.EvalSymlinks(dst); err == nil {
+		return target
+	}
+	return dst
+}
+
+// writeTemp writes in to a synced temporary file with newFileMode next to target and returns its path.
+func writeTemp(target string, in io.Reader, source string) (string, error) {
+	temp, tempPath, err := createTemp(target)
 	if err != nil {
+		return "", err
+	}
+	written, err := io.Copy(temp, in)
+	metrics.WrittenBytes.Add(float64(written), source)
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return "", err
+	}
+	return tempPath, nil
+}
+
+// createTemp creates a hidden file with a random name next to target. Unlike os.CreateTemp,
+// the file gets newFileMode like any new file.
+func createTemp(target string) (*os.File, string, error) {
+	random := make([]byte, 8)
+	for {
+		if _, err := rand.Read(random); err != nil {
+			return nil, "", err
+		}
+		tempPath := filepath.Join(filepath.Dir(target), "."+filepath.Base(target)+".upload-"+hex.EncodeToString(random))
+		temp, err := os.OpenFile(tempPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, newFileMode)
+		if !os.IsExist(err) {
+			return temp, tempPath, err
+		}
+	}
+}
+
+// keepAttributes gives a file the mode of the file it replaces, and its owner where permitted.
+func keepAttributes(path string, replaced os.FileInfo) error {
+	if err := os.Chmod(path, replaced.Mode().Perm()); err != nil {
 		return err
 	}
-	defer file.Close()
+	keepOwner(path, replaced)
+	return nil
+}
 
-	// Copy the contents from the reader to the file
-	_, err = io.Copy(file, in)
+// commitFile moves a completely written temporary file next to the target of dst into place and
+// refreshes the index. The write lock of dst is only held while the preconditions are checked
+// again and the file is replaced, not while the content is received. Without overwrite, the
+// commit fails with ErrExist when dst was created in the meantime.
+//
+// A file with several hard links is rewritten in place, as a rename would leave the other
+// links with the old content. Unlike a rename this is not atomic, readers of the file can
+// see partial content and a crash can leave it partially written.
+func commitFile(idx *indexing.Index, dst, tempPath string, opts iteminfo.FileOptions, overwrite bool) error {
+	lock := writeLock(dst)
+	lock.Lock()
+	replaced, err := checkWritePreconditions(dst, opts)
+	if err == nil && replaced != nil && !overwrite {
+		err = errors.ErrExist
+	}
+	if err == nil && replaced != nil {
+		err = keepAttributes(tempPath, replaced)
+	}
+	if err != nil {
+		lock.Unlock()
+		return err
+	}
+	// keep the previous content, a failed snapshot doesn't prevent saving
+	if err = versions.Snapshot(idx.Source, idx.MakeIndexPath(dst), dst); err != nil {
+		logger.Errorf("could not save version of %v: %v", dst, err)
+	}
+	target := writeTarget(dst)
+	if replaced != nil && hardLinks(replaced) > 1 {
+		err = copyInPlace(tempPath, target)
+	} else {
+		err = os.Rename(tempPath, target)
+	}
+	lock.Unlock()
 	if err != nil {
 		return err
 	}
+	parentDir := filepath.Dir(dst)
+	syncDir(filepath.Dir(target))
+
+	filePath := idx.MakeIndexPath(dst)
 	opts.Path = idx.MakeIndexPath(parentDir)
 	opts.IsDir = true
-	return idx.RefreshFileInfo(opts)
+	err = idx.RefreshFileInfo(opts)
+	if err != nil && !(err == errors.ErrNotIndexed && idx.Config.DisableIndexing) {
+		return err
+	}
+	// same size and mod time rewrites are not detected by the refresh
+	idx.ReindexContent(filePath)
+	return nil
+}
+
+// copyInPlace overwrites target with the content of tempPath and removes tempPath.
+func copyInPlace(tempPath, target string) error {
+	in, err := os.Open(tempPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Remove(tempPath)
+}
+
+// checkWritePreconditions returns ErrConflict when the file doesn't match the expected ETag or
+// modification time, and the info of the file the write replaces, nil for a new file.
+func checkWritePreconditions(dst string, opts iteminfo.FileOptions) (os.FileInfo, error) {
+	info, err := os.Stat(dst)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	exists := err == nil
+	if exists && info.IsDir() {
+		return nil, errors.ErrIsDirectory
+	}
+	if opts.IfMatch != "" {
+		if !exists {
+			return nil, errors.ErrConflict
+		}
+		if opts.IfMatch != "*" && !iteminfo.MatchesETag(iteminfo.ETag(info.ModTime(), info.Size()), opts.IfMatch) {
+			return nil, errors.ErrConflict
+		}
+	}
+	if !opts.ExpectedModTime.IsZero() {
+		if !exists {
+			return nil, errors.ErrConflict
+		}
+		modTime := info.ModTime()
+		if opts.ExpectedModTime.Nanosecond() == 0 {
+			modTime = modTime.Truncate(time.Second)
+		}
+		if !modTime.Equal(opts.ExpectedModTime) {
+			return nil, errors.ErrConflict
+		}
+	}
+	if !exists {
+		return nil, nil
+	}
+	return info, nil
+}
+
+// syncDir persists a rename, it is not supported on every platform and errors are ignored.
+func syncDir(path string) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+	dir, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer dir.Close()
+	_ = dir.Sync()
 }
 
 // getContent reads and returns the file content if it's considered an editable text file.
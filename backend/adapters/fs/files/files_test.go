@@ -0,0 +1,646 @@
+package files
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"filebrowser/common/errors"
+	"filebrowser/common/settings"
+	"filebrowser/database/searches"
+	"filebrowser/database/users"
+	"filebrowser/indexing"
+	"filebrowser/indexing/iteminfo"
+)
+
+// TestMain sets the cache dir once, background work reads it while tests run.
+func TestMain(m *testing.M) {
+	cacheDir, err := os.MkdirTemp("", "files-test-cache")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	settings.Config.Server.CacheDir = cacheDir
+	code := m.Run()
+	os.RemoveAll(cacheDir)
+	os.Exit(code)
+}
+
+// newTestSource creates an unindexed source in a temporary directory.
+func newTestSource(t *testing.T) (string, string) {
+	t.Helper()
+	root := t.TempDir()
+	source := settings.Source{
+		Path:   root,
+		Name:   filepath.Base(root),
+		Config: settings.SourceConfig{DisableIndexing: true, Versions: settings.VersionsConfig{Dir: t.TempDir()}},
+	}
+	indexing.Initialize(source, true)
+	return source.Name, root
+}
+
+// failingReader returns some data and then an error, like an interrupted upload.
+type failingReader struct {
+	data string
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// assertFile checks the content of a file and that no temporary files were left behind.
+func assertFile(t *testing.T, root, name, want string) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(root, name))
+	if err != nil {
+		t.Fatalf("could not read %v: %v", name, err)
+	}
+	if string(data) != want {
+		t.Errorf("content of %v = %q, want %q", name, data, want)
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".upload-") {
+			t.Errorf("temporary file %v was not removed", entry.Name())
+		}
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	source, root := newTestSource(t)
+	err := WriteFile(iteminfo.FileOptions{Source: source, Path: "/new.txt"}, strings.NewReader("created"))
+	if err != nil {
+		t.Fatalf("creating a file failed: %v", err)
+	}
+	assertFile(t, root, "new.txt", "created")
+	err = WriteFile(iteminfo.FileOptions{Source: source, Path: "/new.txt"}, strings.NewReader("replaced"))
+	if err != nil {
+		t.Fatalf("replacing a file failed: %v", err)
+	}
+	assertFile(t, root, "new.txt", "replaced")
+}
+
+func TestWriteIntoTrash(t *testing.T) {
+	source, root := newTestSource(t)
+	writeFile := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("doc.txt", "doc")
+	trash := "/" + indexing.TrashDirName
+	for _, dest := range []string{trash, trash + "/new.txt", "/x/.." + trash + "/new.txt"} {
+		if err := WriteFile(iteminfo.FileOptions{Source: source, Path: dest}, strings.NewReader("new")); err != errors.ErrPermissionDenied {
+			t.Errorf("writing %v: got error %v, want %v", dest, err, errors.ErrPermissionDenied)
+		}
+		if err := WriteDirectory(iteminfo.FileOptions{Source: source, Path: dest, IsDir: true}); err != errors.ErrPermissionDenied {
+			t.Errorf("creating folder %v: got error %v, want %v", dest, err, errors.ErrPermissionDenied)
+		}
+	}
+	err := MoveResource(source, source, filepath.Join(root, "doc.txt"), filepath.Join(root, indexing.TrashDirName, "doc.txt"))
+	if err != errors.ErrPermissionDenied {
+		t.Errorf("moving into the trash: got error %v, want %v", err, errors.ErrPermissionDenied)
+	}
+	assertFile(t, root, "doc.txt", "doc")
+	if _, err = os.Stat(filepath.Join(root, indexing.TrashDirName)); !os.IsNotExist(err) {
+		t.Errorf("the trash was created: %v", err)
+	}
+}
+
+func TestWriteFileInterrupted(t *testing.T) {
+	source, root := newTestSource(t)
+	if err := os.WriteFile(filepath.Join(root, "doc.txt"), []byte("original"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	err := WriteFile(iteminfo.FileOptions{Source: source, Path: "/doc.txt"}, &failingReader{data: "partial"})
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected the read error, got %v", err)
+	}
+	assertFile(t, root, "doc.txt", "original")
+
+	err = WriteFile(iteminfo.FileOptions{Source: source, Path: "/missing.txt"}, &failingReader{data: "partial"})
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected the read error, got %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(root, "missing.txt")); !os.IsNotExist(err) {
+		t.Errorf("an interrupted upload created the file: %v", err)
+	}
+}
+
+func TestWriteFilePreconditions(t *testing.T) {
+	source, root := newTestSource(t)
+	path := filepath.Join(root, "doc.txt")
+	if err := os.WriteFile(path, []byte("original"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := iteminfo.ETag(info.ModTime(), info.Size())
+	stale := iteminfo.ETag(info.ModTime().Add(-time.Minute), info.Size())
+
+	tests := []struct {
+		name string
+		opts iteminfo.FileOptions
+		want error
+	}{
+		{"stale etag", iteminfo.FileOptions{IfMatch: stale}, errors.ErrConflict},
+		{"stale mod time", iteminfo.FileOptions{ExpectedModTime: info.ModTime().Add(-time.Hour)}, errors.ErrConflict},
+		{"stale mod time in seconds", iteminfo.FileOptions{ExpectedModTime: info.ModTime().Add(-time.Hour).Truncate(time.Second)}, errors.ErrConflict},
+		{"missing file with etag", iteminfo.FileOptions{Path: "/missing.txt", IfMatch: etag}, errors.ErrConflict},
+		{"missing file with any etag", iteminfo.FileOptions{Path: "/missing.txt", IfMatch: "*"}, errors.ErrConflict},
+		{"missing file with mod time", iteminfo.FileOptions{Path: "/missing.txt", ExpectedModTime: info.ModTime()}, errors.ErrConflict},
+		{"directory", iteminfo.FileOptions{Path: "/"}, errors.ErrIsDirectory},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Source = source
+			if tt.opts.Path == "" {
+				tt.opts.Path = "/doc.txt"
+			}
+			err := WriteFile(tt.opts, strings.NewReader("clobbered"))
+			if err != tt.want {
+				t.Fatalf("got error %v, want %v", err, tt.want)
+			}
+			assertFile(t, root, "doc.txt", "original")
+			if _, err = os.Stat(filepath.Join(root, "missing.txt")); !os.IsNotExist(err) {
+				t.Errorf("a failed write created a file: %v", err)
+			}
+		})
+	}
+
+	for i, opts := range []iteminfo.FileOptions{
+		{IfMatch: etag},
+		{IfMatch: "*"},
+		{ExpectedModTime: info.ModTime()},
+		{ExpectedModTime: info.ModTime().Truncate(time.Second)},
+	} {
+		if err = os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+			t.Fatal(err)
+		}
+		opts.Source = source
+		opts.Path = "/doc.txt"
+		content := fmt.Sprintf("saved %d", i)
+		if err = WriteFile(opts, strings.NewReader(content)); err != nil {
+			t.Fatalf("write %d with a matching precondition failed: %v", i, err)
+		}
+		assertFile(t, root, "doc.txt", content)
+	}
+	// the mode of the replaced file is kept
+	info, err = os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestWriteFileLinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("links need privileges on windows")
+	}
+	source, root := newTestSource(t)
+	write := func(path, content string) {
+		t.Helper()
+		if err := WriteFile(iteminfo.FileOptions{Source: source, Path: path}, strings.NewReader(content)); err != nil {
+			t.Fatalf("writing %v failed: %v", path, err)
+		}
+	}
+	write("/data/real.txt", "original")
+	if err := os.Symlink(filepath.Join("data", "real.txt"), filepath.Join(root, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	// writes through a symlink replace the file it points to and keep the link
+	write("/link.txt", "through the symlink")
+	if info, err := os.Lstat(filepath.Join(root, "link.txt")); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("the symlink was replaced: %v", err)
+	}
+	assertFile(t, root, "data/real.txt", "through the symlink")
+
+	// new files get the mode files were always created with
+	reference, err := os.OpenFile(filepath.Join(t.TempDir(), "reference"), os.O_CREATE|os.O_WRONLY, newFileMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := reference.Stat()
+	reference.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(root, "data", "real.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != want.Mode().Perm() {
+		t.Errorf("new file mode = %v, want %v", info.Mode().Perm(), want.Mode().Perm())
+	}
+}
+
+func TestWriteFileHardLink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hard links are replaced by a rename on windows")
+	}
+	source, root := newTestSource(t)
+	original := filepath.Join(root, "original.txt")
+	if err := os.WriteFile(original, []byte("original"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(original, filepath.Join(root, "hard.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(iteminfo.FileOptions{Source: source, Path: "/hard.txt"}, strings.NewReader("through the hard link")); err != nil {
+		t.Fatal(err)
+	}
+	// the file is rewritten in place, both names keep sharing it
+	assertFile(t, root, "original.txt", "through the hard link")
+	assertFile(t, root, "hard.txt", "through the hard link")
+	first, err := os.Stat(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := os.Stat(filepath.Join(root, "hard.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(first, second) || first.Mode().Perm() != 0640 {
+		t.Errorf("hard link was replaced: same file %v, mode %v", os.SameFile(first, second), first.Mode().Perm())
+	}
+}
+
+// blockingReader returns data once release is closed, like a slow upload.
+type blockingReader struct {
+	started chan struct{}
+	release chan struct{}
+	data    string
+}
+
+func (r *blockingReader) Read(p []byte) (int, error) {
+	if r.started != nil {
+		close(r.started)
+		r.started = nil
+		<-r.release
+	}
+	if r.data == "" {
+		return 0, io.EOF
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestWriteFileLock(t *testing.T) {
+	source, root := newTestSource(t)
+	slow := &blockingReader{started: make(chan struct{}), release: make(chan struct{}), data: "slow"}
+	started := slow.started
+	done := make(chan error)
+	go func() {
+		done <- WriteFile(iteminfo.FileOptions{Source: source, Path: "/slow.txt"}, slow)
+	}()
+	<-started
+	// the write lock is not held while the content is received
+	lock := writeLock(filepath.Join(root, "slow.txt"))
+	if !lock.TryLock() {
+		t.Fatal("the write lock is held while the content is received")
+	}
+	lock.Unlock()
+	close(slow.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	assertFile(t, root, "slow.txt", "slow")
+}
+
+func TestUpload(t *testing.T) {
+	source, root := newTestSource(t)
+	user := &users.User{
+		ID:          1,
+		Scopes:      []users.SourceScope{{Name: source, Scope: "/"}},
+		Permissions: users.Permissions{Modify: true},
+	}
+	upload, err := CreateUpload(user, source, "/videos/clip.mp4", 12, false, map[string]string{"filename": "clip.mp4"})
+	if err != nil {
+		t.Fatalf("creating the upload failed: %v", err)
+	}
+
+	// an interrupted request keeps what was received
+	upload, err = WriteUpload(user, upload.ID, 0, &failingReader{data: "hello"}, nil)
+	if err != io.ErrUnexpectedEOF || upload.Offset != 5 {
+		t.Fatalf("interrupted chunk: offset %v, error %v", upload.Offset, err)
+	}
+	if _, err = WriteUpload(user, upload.ID, 0, strings.NewReader("hello"), nil); err != errors.ErrConflict {
+		t.Fatalf("chunk at a stale offset: got error %v, want %v", err, errors.ErrConflict)
+	}
+	if _, err = GetUpload(&users.User{ID: 2}, upload.ID); err != errors.ErrNotExist {
+		t.Fatalf("upload of another user: got error %v, want %v", err, errors.ErrNotExist)
+	}
+
+	// a chunk that doesn't match its checksum is discarded
+	wrong := sha1.Sum([]byte("other"))
+	upload, err = WriteUpload(user, upload.ID, 5, strings.NewReader(" world"), &UploadChecksum{Algorithm: "sha1", Sum: wrong[:]})
+	if err != errors.ErrChecksumMismatch || upload.Offset != 5 {
+		t.Fatalf("chunk with a wrong checksum: offset %v, error %v", upload.Offset, err)
+	}
+	if _, err = WriteUpload(user, upload.ID, 5, strings.NewReader(" world and more"), nil); err != errors.ErrInvalidRequestParams {
+		t.Fatalf("chunk past the end: got error %v, want %v", err, errors.ErrInvalidRequestParams)
+	}
+
+	right := sha1.Sum([]byte(" world!"))
+	upload, err = WriteUpload(user, upload.ID, 5, strings.NewReader(" world!"), &UploadChecksum{Algorithm: "sha1", Sum: right[:]})
+	if err != nil || !upload.Complete() {
+		t.Fatalf("last chunk: offset %v, error %v", upload.Offset, err)
+	}
+	assertFile(t, filepath.Join(root, "videos"), "clip.mp4", "hello world!")
+	if _, err = GetUpload(user, upload.ID); err != errors.ErrNotExist {
+		t.Fatalf("finished upload: got error %v, want %v", err, errors.ErrNotExist)
+	}
+	entries, _ := os.ReadDir(uploadsDir())
+	if len(entries) != 0 {
+		t.Errorf("staged files of the finished upload were left behind: %v", entries)
+	}
+	if _, ok := uploadLocks.Load(upload.ID); ok {
+		t.Error("the lock of the finished upload was kept")
+	}
+
+	if _, err = CreateUpload(user, source, "/videos/clip.mp4", 3, false, nil); err != errors.ErrExist {
+		t.Fatalf("upload to an existing file: got error %v, want %v", err, errors.ErrExist)
+	}
+	upload, err = CreateUpload(user, source, "/videos/clip.mp4", 3, true, nil)
+	if err != nil {
+		t.Fatalf("creating an overwriting upload failed: %v", err)
+	}
+	if err = TerminateUpload(user, upload.ID); err != nil {
+		t.Fatalf("terminating the upload failed: %v", err)
+	}
+	if _, err = WriteUpload(user, upload.ID, 0, strings.NewReader("new"), nil); err != errors.ErrNotExist {
+		t.Fatalf("chunk of a terminated upload: got error %v, want %v", err, errors.ErrNotExist)
+	}
+	assertFile(t, filepath.Join(root, "videos"), "clip.mp4", "hello world!")
+}
+
+func TestUploadPermissions(t *testing.T) {
+	source, _ := newTestSource(t)
+	user := &users.User{
+		ID:          1,
+		Scopes:      []users.SourceScope{{Name: source, Scope: "/home"}},
+		Permissions: users.Permissions{Modify: true},
+	}
+	tests := map[string]struct {
+		user *users.User
+		dest string
+		want error
+	}{
+		"without modify":   {&users.User{ID: 1, Scopes: user.Scopes}, "/a.txt", errors.ErrPermissionDenied},
+		"without scope":    {&users.User{ID: 1, Permissions: user.Permissions}, "/a.txt", errors.ErrPermissionDenied},
+		"the scope itself": {user, "/", errors.ErrInvalidRequestParams},
+		"into the trash":   {&users.User{ID: 1, Scopes: []users.SourceScope{{Name: source, Scope: "/"}}, Permissions: user.Permissions}, "/" + indexing.TrashDirName + "/a.txt", errors.ErrPermissionDenied},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := CreateUpload(tt.user, source, tt.dest, 3, false, nil); err != tt.want {
+				t.Fatalf("got error %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	// chunks are rejected once the permission or the scope is taken away
+	upload, err := CreateUpload(user, source, "/a.txt", 3, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, changed := range []*users.User{
+		{ID: 1, Scopes: user.Scopes},
+		{ID: 1, Scopes: []users.SourceScope{{Name: source, Scope: "/other"}}, Permissions: user.Permissions},
+	} {
+		if _, err = WriteUpload(changed, upload.ID, 0, strings.NewReader("abc"), nil); err != errors.ErrPermissionDenied {
+			t.Errorf("chunk of user %+v: got error %v, want %v", changed, err, errors.ErrPermissionDenied)
+		}
+	}
+	if err = TerminateUpload(user, upload.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := uploadLocks.Load(upload.ID); ok {
+		t.Error("the lock of the terminated upload was kept")
+	}
+}
+
+func TestArchive(t *testing.T) {
+	source, root := newTestSource(t)
+	outside := t.TempDir()
+	for name, content := range map[string]string{
+		"docs/a.txt":     "a",
+		"docs/sub/b.txt": "bb",
+		"photos/c.jpg":   "ccc",
+		"other/d.txt":    "dddd",
+	} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../photos/c.jpg", filepath.Join(root, "docs", "inside")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(root, "docs", "outside")); err != nil {
+		t.Fatal(err)
+	}
+	user := &users.User{ID: 1, Scopes: []users.SourceScope{{Name: source, Scope: "/"}}}
+
+	archive, err := NewArchive(user, source, []string{"/docs", "photos/", "/docs/sub/b.txt"}, ArchiveTarGz)
+	if err != nil {
+		t.Fatalf("creating the archive failed: %v", err)
+	}
+	if archive.Name != source+".tar.gz" {
+		t.Errorf("archive name = %v, want %v", archive.Name, source+".tar.gz")
+	}
+	var out bytes.Buffer
+	if _, err = archive.WriteTo(&out); err != nil {
+		t.Fatalf("streaming the archive failed: %v", err)
+	}
+	compressed, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	reader := tar.NewReader(compressed)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("reading the archive failed: %v", err)
+		}
+		if header.Typeflag == tar.TypeSymlink {
+			names = append(names, header.Name+" -> "+header.Linkname)
+			continue
+		}
+		names = append(names, header.Name)
+	}
+	slices.Sort(names)
+	want := []string{"docs/", "docs/a.txt", "docs/inside -> ../photos/c.jpg", "docs/sub/", "docs/sub/b.txt", "photos/", "photos/c.jpg"}
+	if !slices.Equal(names, want) {
+		t.Errorf("archived %v, want %v", names, want)
+	}
+
+	archive, err = NewArchive(user, source, []string{"/docs/sub/b.txt"}, ArchiveZip)
+	if err != nil {
+		t.Fatalf("single file archive: %v", err)
+	}
+	if archive.Name != "b.txt.zip" {
+		t.Errorf("single file archive name = %v, want b.txt.zip", archive.Name)
+	}
+	if _, err = NewArchive(user, source, []string{"/docs/outside"}, ArchiveZip); err != errors.ErrPermissionDenied {
+		t.Errorf("selected symlink outside of the scope: got error %v, want %v", err, errors.ErrPermissionDenied)
+	}
+	if err = os.MkdirAll(filepath.Join(root, indexing.TrashDirName, "item"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, selection := range []string{"/" + indexing.TrashDirName, "/" + indexing.TrashDirName + "/item"} {
+		if _, err = NewArchive(user, source, []string{"/docs", selection}, ArchiveZip); err != errors.ErrPermissionDenied {
+			t.Errorf("selected %v: got error %v, want %v", selection, err, errors.ErrPermissionDenied)
+		}
+	}
+
+	large, err := os.Create(filepath.Join(root, "other", "large.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer large.Close()
+	if err = large.Truncate(2 << 30); err != nil {
+		t.Skipf("sparse files are not supported: %v", err)
+	}
+	settings.Config.Server.MaxArchiveSizeGB = 1
+	defer func() { settings.Config.Server.MaxArchiveSizeGB = 0 }()
+	if _, err = NewArchive(user, source, []string{"/other"}, ArchiveTar); err != errors.ErrArchiveTooLarge {
+		t.Errorf("selection over the size limit: got error %v, want %v", err, errors.ErrArchiveTooLarge)
+	}
+}
+
+func TestSavedSearchScope(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"users/bob/report-1.txt", "users/bobby/report-2.txt"} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, name), []byte("report"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	source := settings.Source{Path: root, Name: filepath.Base(root)}
+	indexing.InitializeOnce(source)
+	user := &users.User{ID: 2, Scopes: []users.SourceScope{{Name: source.Name, Scope: "/users/bob"}}}
+
+	search := &searches.SavedSearch{UserID: 2, Name: "reports", Source: source.Name, Scope: "/", Query: "report"}
+	listing, err := SavedSearchListing(user, search, nil)
+	if err != nil || len(listing.Files) != 1 || listing.Files[0].Name != "report-1.txt" {
+		t.Fatalf("listing = %v, %v, want report-1.txt", listing.Files, err)
+	}
+	// another user's private search
+	if _, err = SavedSearchListing(&users.User{ID: 3, Scopes: user.Scopes}, search, nil); err != errors.ErrPermissionDenied {
+		t.Errorf("another user listed a private search: %v, want %v", err, errors.ErrPermissionDenied)
+	}
+}
+
+func TestFindDuplicates(t *testing.T) {
+	root := t.TempDir()
+	half := strings.Repeat("a", 2*partialHashSize)
+	for name, content := range map[string]string{
+		"a.txt":          "same content",
+		"docs/b.txt":     "same content",
+		"docs/sub/c.txt": "same content",
+		"d.txt":          "same-content", // same size, other content
+		"big1.bin":       half + "1" + half,
+		"big2.bin":       half + "2" + half, // same start and end, only the full checksum differs
+		"docs/big3.bin":  half + "1" + half,
+		"unique.txt":     "unique",
+		"empty1":         "",
+		"empty2":         "",
+		"x1.txt":         "xy",
+		"x2.txt":         "xy",
+	} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	source := settings.Source{Path: root, Name: filepath.Base(root)}
+	indexing.InitializeOnce(source)
+	big := int64(len(half)*2 + 1)
+
+	testCases := map[string]struct {
+		scope   string
+		minSize int64
+		want    []DuplicateGroup
+	}{
+		"whole source": {scope: "/", want: []DuplicateGroup{
+			{Size: big, Paths: []string{"/big1.bin", "/docs/big3.bin"}, Reclaimable: big},
+			{Size: 12, Paths: []string{"/a.txt", "/docs/b.txt", "/docs/sub/c.txt"}, Reclaimable: 24},
+			{Size: 2, Paths: []string{"/x1.txt", "/x2.txt"}, Reclaimable: 2},
+		}},
+		"minimum size": {scope: "/", minSize: 3, want: []DuplicateGroup{
+			{Size: big, Paths: []string{"/big1.bin", "/docs/big3.bin"}, Reclaimable: big},
+			{Size: 12, Paths: []string{"/a.txt", "/docs/b.txt", "/docs/sub/c.txt"}, Reclaimable: 24},
+		}},
+		"paths relative to the scope": {scope: "/docs/", want: []DuplicateGroup{
+			{Size: 12, Paths: []string{"/b.txt", "/sub/c.txt"}, Reclaimable: 12},
+		}},
+		"nothing in scope": {scope: "/docs/sub", want: []DuplicateGroup{}},
+	}
+	for name, tt := range testCases {
+		t.Run(name, func(t *testing.T) {
+			report, err := FindDuplicates(source.Name, tt.scope, tt.minSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var total int64
+			for i := range report.Groups {
+				if report.Groups[i].Checksum == "" {
+					t.Errorf("group %v has no checksum", report.Groups[i].Paths)
+				}
+				report.Groups[i].Checksum = ""
+				total += report.Groups[i].Reclaimable
+			}
+			if !reflect.DeepEqual(report.Groups, tt.want) {
+				t.Errorf("groups = %+v, want %+v", report.Groups, tt.want)
+			}
+			if report.Reclaimable != total {
+				t.Errorf("reclaimable = %v, want the total of the groups %v", report.Reclaimable, total)
+			}
+		})
+	}
+
+	// checksums are cached, an unchanged tree is not read again
+	report, err := FindDuplicates(source.Name, "/", 1)
+	if err != nil || report.Hashed != 0 {
+		t.Errorf("rerun hashed %v files, %v, want none", report.Hashed, err)
+	}
+}
@@ -0,0 +1,67 @@
+package files
+
+import (
+	"filebrowser/common/errors"
+	"filebrowser/database/searches"
+	"filebrowser/database/users"
+	"filebrowser/indexing"
+	"filebrowser/indexing/iteminfo"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// SavedSearchListing runs a saved search inside the user's scope of its source and returns
+// a virtual directory holding the current matches. Path is the searched folder relative to
+// the user's scope and item names are relative to it, so joining them gives the real location.
+// tagLookup answers tag: filters of the query, usually the tag storage.
+func SavedSearchListing(user *users.User, search *searches.SavedSearch, tagLookup indexing.TagLookup) (iteminfo.FileInfo, error) {
+	listing := iteminfo.FileInfo{
+		ItemInfo: iteminfo.ItemInfo{Name: search.Name, Type: "directory"},
+		Files:    []iteminfo.ItemInfo{},
+		Folders:  []iteminfo.ItemInfo{},
+		Path:     search.Scope,
+	}
+	if !search.VisibleTo(user) {
+		return listing, errors.ErrPermissionDenied
+	}
+	index := indexing.GetIndex(search.Source)
+	if index == nil {
+		return listing, fmt.Errorf("could not get index: %v ", search.Source)
+	}
+	if index.Config.DisableIndexing {
+		return listing, errors.ErrNotIndexed
+	}
+	// published searches can point to a source the user has no access to
+	root, err := userScope(user, index)
+	if err != nil {
+		return listing, err
+	}
+	scope := path.Join(root, search.Scope)
+	cursor := ""
+	for {
+		response, err := index.Search(user.ID, tagLookup, scope, search.Query, 0, cursor)
+		if err != nil {
+			return listing, err
+		}
+		for _, result := range response.Results {
+			item := iteminfo.ItemInfo{
+				Name:    strings.TrimPrefix(result.Path, "/"),
+				Size:    result.Size,
+				ModTime: result.Modified,
+				Type:    result.Type,
+			}
+			if item.Type == "directory" {
+				listing.Folders = append(listing.Folders, item)
+			} else {
+				listing.Files = append(listing.Files, item)
+				listing.Size += item.Size
+			}
+		}
+		if response.Cursor == "" {
+			break
+		}
+		cursor = response.Cursor
+	}
+	return listing, nil
+}
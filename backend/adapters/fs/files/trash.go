@@ -0,0 +1,127 @@
+package files
+
+import (
+	"filebrowser/adapters/fs/versions"
+	"filebrowser/common/errors"
+	"filebrowser/database/tags"
+	"filebrowser/database/users"
+	"filebrowser/indexing"
+	"filebrowser/indexing/iteminfo"
+	"fmt"
+	"path"
+
+	"github.com/gtsteffaniak/go-logger/logger"
+)
+
+func init() {
+	indexing.SetTrashHooks(indexing.TrashHooks{Moved: movePathData, Purged: removePathData})
+}
+
+// movePathData keeps the tags and versions of a file or folder moved within a source.
+func movePathData(index *indexing.Index, indexPath, destPath string) {
+	tags.PathMoved(index.Name, indexPath, index.Name, destPath)
+	if err := versions.Move(index.Source, indexPath, index.Source, destPath); err != nil {
+		logger.Errorf("could not move versions of %v: %v", indexPath, err)
+	}
+}
+
+// removePathData drops the tags and versions of a permanently deleted file or folder.
+func removePathData(index *indexing.Index, indexPath string) {
+	tags.PathDeleted(index.Name, indexPath)
+	if err := versions.Remove(index.Source, indexPath); err != nil {
+		logger.Errorf("could not remove versions of %v: %v", indexPath, err)
+	}
+}
+
+func trashIndex(user *users.User, source string) (*indexing.Index, string, error) {
+	index := indexing.GetIndex(source)
+	if index == nil {
+		return nil, "", fmt.Errorf("could not get index: %v ", source)
+	}
+	scope, err := userScope(user, index)
+	return index, scope, err
+}
+
+// ListTrash returns the items of the trash of a source that were deleted inside the user's scope,
+// their paths are relative to the scope.
+func ListTrash(user *users.User, source string) ([]indexing.TrashItem, error) {
+	index, scope, err := trashIndex(user, source)
+	if err != nil {
+		return nil, err
+	}
+	items, err := index.Trash()
+	if err != nil {
+		return nil, err
+	}
+	visible := []indexing.TrashItem{}
+	for _, item := range items {
+		if relative, ok := inScope(scope, item.Path); ok {
+			item.Path = relative
+			visible = append(visible, item)
+		}
+	}
+	return visible, nil
+}
+
+// RestoreTrash restores an item to dest, relative to the user's scope, or to where it was deleted
+// from when dest is empty. See indexing.RestoreFail for the conflict modes.
+// It returns the restored path relative to the scope.
+func RestoreTrash(user *users.User, source, id, dest, conflict string) (string, error) {
+	defer indexing.TrackRequest()()
+	index, scope, err := trashIndex(user, source)
+	if err != nil {
+		return "", err
+	}
+	item, err := index.TrashItem(id)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := inScope(scope, item.Path); !ok {
+		return "", errors.ErrPermissionDenied
+	}
+	if dest != "" {
+		dest = path.Join(scope, path.Clean("/"+dest))
+	}
+	restored, err := index.RestoreFromTrash(id, dest, conflict, user.Username)
+	if err != nil {
+		return "", err
+	}
+	refreshConfig := iteminfo.FileOptions{Path: path.Dir(restored), IsDir: true}
+	if err = index.RefreshFileInfo(refreshConfig); err != nil && !index.Config.DisableIndexing {
+		return "", err
+	}
+	relative, _ := inScope(scope, restored)
+	return relative, nil
+}
+
+// PurgeTrash permanently deletes an item of the trash, or every item deleted inside
+// the user's scope when id is empty.
+func PurgeTrash(user *users.User, source, id string) error {
+	index, scope, err := trashIndex(user, source)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		item, err := index.TrashItem(id)
+		if err != nil {
+			return err
+		}
+		if _, ok := inScope(scope, item.Path); !ok {
+			return errors.ErrPermissionDenied
+		}
+		return index.PurgeTrashItem(id)
+	}
+	items, err := index.Trash()
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if _, ok := inScope(scope, item.Path); !ok {
+			continue
+		}
+		if err = index.PurgeTrashItem(item.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,432 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"filebrowser/common/errors"
+	"filebrowser/common/metrics"
+	"filebrowser/common/settings"
+	"filebrowser/database/users"
+	"filebrowser/indexing"
+	"filebrowser/indexing/iteminfo"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gtsteffaniak/go-logger/logger"
+)
+
+const (
+	defaultUploadExpiry   = 24 * time.Hour
+	uploadCleanupInterval = time.Hour
+)
+
+// UploadChecksumAlgorithms are the algorithms accepted for checksums of uploaded chunks.
+var UploadChecksumAlgorithms = []string{"md5", "sha1", "sha256", "sha512"}
+
+// Upload is a resumable upload. Received data is staged in the cache dir and moved
+// into place once the upload is complete.
+type Upload struct {
+	ID        string            `json:"id"`
+	Source    string            `json:"source"`
+	Path      string            `json:"path"`      // index path of the destination
+	Overwrite bool              `json:"overwrite"` // replace an existing file at path
+	Length    int64             `json:"length"`
+	Offset    int64             `json:"offset"` // bytes received
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	UserID    uint              `json:"userID"`
+	Created   time.Time         `json:"created"`
+	Expires   time.Time         `json:"expires"` // extended on every received chunk
+}
+
+// Complete reports whether all data was received.
+func (u *Upload) Complete() bool {
+	return u.Offset == u.Length
+}
+
+// UploadChecksum is the expected checksum of a chunk.
+type UploadChecksum struct {
+	Algorithm string // one of UploadChecksumAlgorithms
+	Sum       []byte
+}
+
+// one lock per upload id, concurrent writes to an upload are rejected
+var uploadLocks sync.Map
+
+func uploadLock(id string) *sync.Mutex {
+	lock, _ := uploadLocks.LoadOrStore(id, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+// unlockUpload releases the lock of an upload, the lock is dropped once the upload is gone.
+func unlockUpload(id string, lock *sync.Mutex) {
+	lock.Unlock()
+	if _, err := os.Stat(filepath.Join(uploadsDir(), id+".json")); os.IsNotExist(err) {
+		uploadLocks.Delete(id)
+	}
+}
+
+func uploadsDir() string {
+	return filepath.Join(settings.Config.Server.CacheDir, "uploads")
+}
+
+func uploadExpiry() time.Duration {
+	if hours := settings.Config.Server.UploadExpiryHours; hours > 0 {
+		return time.Duration(hours) * time.Hour
+	}
+	return defaultUploadExpiry
+}
+
+// CreateUpload starts a resumable upload of length bytes to dest, relative to the user's scope.
+// Unless overwrite is set, the upload fails when a file already exists at dest.
+func CreateUpload(user *users.User, source, dest string, length int64, overwrite bool, metadata map[string]string) (*Upload, error) {
+	if !user.Permissions.Modify {
+		return nil, errors.ErrPermissionDenied
+	}
+	if length < 0 {
+		return nil, errors.ErrInvalidRequestParams
+	}
+	index := indexing.GetIndex(source)
+	if index == nil {
+		return nil, fmt.Errorf("could not get index: %v ", source)
+	}
+	scope, err := userScope(user, index)
+	if err != nil {
+		return nil, err
+	}
+	indexPath := path.Join(scope, path.Clean("/"+dest))
+	if _, ok := inScope(scope, indexPath); !ok || indexPath == scope || strings.HasSuffix(dest, "/") {
+		return nil, errors.ErrInvalidRequestParams
+	}
+	// the trash only changes through deletes and restores
+	if indexing.InTrash(indexPath) {
+		return nil, errors.ErrPermissionDenied
+	}
+	realPath, _, _ := index.GetRealPath(indexPath)
+	if info, err := os.Stat(realPath); err == nil {
+		if info.IsDir() {
+			return nil, errors.ErrIsDirectory
+		}
+		if !overwrite {
+			return nil, errors.ErrExist
+		}
+	}
+	random := make([]byte, 16)
+	if _, err = rand.Read(random); err != nil {
+		return nil, err
+	}
+	upload := &Upload{
+		ID:        hex.EncodeToString(random),
+		Source:    index.Name,
+		Path:      indexPath,
+		Overwrite: overwrite,
+		Length:    length,
+		Metadata:  metadata,
+		UserID:    user.ID,
+		Created:   time.Now(),
+		Expires:   time.Now().Add(uploadExpiry()),
+	}
+	if err = os.MkdirAll(uploadsDir(), 0755); err != nil {
+		return nil, err
+	}
+	// created with the mode of new files, the data is renamed into place when the upload finishes
+	data, err := os.OpenFile(filepath.Join(uploadsDir(), upload.ID), os.O_WRONLY|os.O_CREATE|os.O_EXCL, newFileMode)
+	if err != nil {
+		return nil, err
+	}
+	data.Close()
+	if err = saveUpload(upload); err != nil {
+		removeUpload(upload.ID)
+		return nil, err
+	}
+	if length == 0 {
+		return upload, finishUpload(upload)
+	}
+	return upload, nil
+}
+
+// GetUpload returns an unfinished upload of the user.
+func GetUpload(user *users.User, id string) (*Upload, error) {
+	return readUpload(user, id)
+}
+
+// WriteUpload appends a chunk to an upload at offset, which has to be the number of bytes
+// received so far. A chunk that doesn't match its checksum is discarded, otherwise the
+// data received before an interrupted request is kept. The upload is moved into place
+// when the last byte is received.
+func WriteUpload(user *users.User, id string, offset int64, in io.Reader, checksum *UploadChecksum) (*Upload, error) {
+	if !validUploadID(id) {
+		return nil, errors.ErrNotExist
+	}
+	lock := uploadLock(id)
+	if !lock.TryLock() {
+		return nil, errors.ErrConflict
+	}
+	defer unlockUpload(id, lock)
+	upload, err := readUpload(user, id)
+	if err != nil {
+		return nil, err
+	}
+	// the permission or the scope may have been taken away since the upload was created
+	if err = checkUploadAccess(user, upload); err != nil {
+		return nil, err
+	}
+	if offset != upload.Offset {
+		return nil, errors.ErrConflict
+	}
+	var sum hash.Hash
+	if checksum != nil {
+		var ok bool
+		if sum, ok = newHash(checksum.Algorithm); !ok {
+			return nil, errors.ErrInvalidOption
+		}
+	}
+	data, err := os.OpenFile(filepath.Join(uploadsDir(), id), os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = data.Seek(offset, io.SeekStart); err != nil {
+		data.Close()
+		return nil, err
+	}
+	var out io.Writer = data
+	if sum != nil {
+		out = io.MultiWriter(data, sum)
+	}
+	// one byte more than missing tells chunks running past the end apart
+	written, err := io.Copy(out, io.LimitReader(in, upload.Length-offset+1))
+	if err == nil && written > upload.Length-offset {
+		err = errors.ErrInvalidRequestParams
+	}
+	if err == nil && sum != nil && !bytes.Equal(sum.Sum(nil), checksum.Sum) {
+		err = errors.ErrChecksumMismatch
+	}
+	if err != nil && (sum != nil || err == errors.ErrInvalidRequestParams) {
+		// the chunk is rejected as a whole
+		written = 0
+		if truncateErr := data.Truncate(offset); truncateErr != nil {
+			logger.Errorf("could not discard rejected chunk of upload %v: %v", id, truncateErr)
+		}
+	}
+	if syncErr := data.Sync(); syncErr != nil && err == nil {
+		err, written = syncErr, 0
+	}
+	if closeErr := data.Close(); closeErr != nil && err == nil {
+		err, written = closeErr, 0
+	}
+	upload.Offset += written
+	upload.Expires = time.Now().Add(uploadExpiry())
+	if saveErr := saveUpload(upload); saveErr != nil {
+		return nil, saveErr
+	}
+	if err != nil {
+		return upload, err
+	}
+	if upload.Complete() {
+		return upload, finishUpload(upload)
+	}
+	return upload, nil
+}
+
+// TerminateUpload cancels an upload and removes the data received so far.
+func TerminateUpload(user *users.User, id string) error {
+	if !validUploadID(id) {
+		return errors.ErrNotExist
+	}
+	lock := uploadLock(id)
+	if !lock.TryLock() {
+		return errors.ErrConflict
+	}
+	defer unlockUpload(id, lock)
+	if _, err := readUpload(user, id); err != nil {
+		return err
+	}
+	return removeUpload(id)
+}
+
+// finishUpload moves the received data into place like WriteFile. The staged file is renamed
+// when the cache dir is on the same file system as the source, and copied otherwise.
+// On failure the upload is kept, so the client can finish it with an empty chunk.
+func finishUpload(upload *Upload) error {
+	defer indexing.TrackRequest()()
+	idx := indexing.GetIndex(upload.Source)
+	if idx == nil {
+		return fmt.Errorf("could not get index: %v ", upload.Source)
+	}
+	dst, _, _ := idx.GetRealPath(upload.Path)
+	if err := os.MkdirAll(filepath.Dir(dst), 0775); err != nil {
+		return err
+	}
+	// fail early, commitFile checks again while it holds the write lock
+	if _, err := os.Stat(dst); err == nil && !upload.Overwrite {
+		return errors.ErrExist
+	}
+	opts := iteminfo.FileOptions{Source: upload.Source, Path: upload.Path}
+	if _, err := checkWritePreconditions(dst, opts); err != nil {
+		return err
+	}
+	target := writeTarget(dst)
+	staged := filepath.Join(uploadsDir(), upload.ID)
+	tempPath := filepath.Join(filepath.Dir(target), "."+filepath.Base(target)+".upload-"+upload.ID)
+	renamed := os.Rename(staged, tempPath) == nil
+	var err error
+	if renamed {
+		metrics.WrittenBytes.Add(float64(upload.Length), idx.Name)
+	} else {
+		var data *os.File
+		if data, err = os.Open(staged); err == nil {
+			tempPath, err = writeTemp(target, data, idx.Name)
+			data.Close()
+		}
+	}
+	if err == nil {
+		err = commitFile(idx, dst, tempPath, opts, upload.Overwrite)
+	}
+	if err != nil {
+		if renamed {
+			if restoreErr := os.Rename(tempPath, staged); restoreErr != nil {
+				logger.Errorf("could not keep data of upload %v: %v", upload.ID, restoreErr)
+			}
+		} else if tempPath != "" {
+			os.Remove(tempPath)
+		}
+		return err
+	}
+	return removeUpload(upload.ID)
+}
+
+func validUploadID(id string) bool {
+	_, err := hex.DecodeString(id)
+	return err == nil && len(id) == 32
+}
+
+// readUpload returns an upload of the user, uploads of other users and expired uploads don't exist.
+func readUpload(user *users.User, id string) (*Upload, error) {
+	if !validUploadID(id) {
+		return nil, errors.ErrNotExist
+	}
+	data, err := os.ReadFile(filepath.Join(uploadsDir(), id+".json"))
+	if os.IsNotExist(err) {
+		return nil, errors.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	upload := &Upload{}
+	if err = json.Unmarshal(data, upload); err != nil {
+		return nil, err
+	}
+	if upload.UserID != user.ID || time.Now().After(upload.Expires) {
+		return nil, errors.ErrNotExist
+	}
+	return upload, nil
+}
+
+// checkUploadAccess returns ErrPermissionDenied when the user may no longer write the destination of an upload.
+func checkUploadAccess(user *users.User, upload *Upload) error {
+	if !user.Permissions.Modify {
+		return errors.ErrPermissionDenied
+	}
+	index := indexing.GetIndex(upload.Source)
+	if index == nil {
+		return fmt.Errorf("could not get index: %v ", upload.Source)
+	}
+	scope, err := userScope(user, index)
+	if err != nil {
+		return err
+	}
+	if _, ok := inScope(scope, upload.Path); !ok {
+		return errors.ErrPermissionDenied
+	}
+	return nil
+}
+
+func saveUpload(upload *Upload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	temp := filepath.Join(uploadsDir(), upload.ID+".json.tmp")
+	if err = os.WriteFile(temp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(temp, filepath.Join(uploadsDir(), upload.ID+".json"))
+}
+
+func removeUpload(id string) error {
+	err := os.Remove(filepath.Join(uploadsDir(), id))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err = os.Remove(filepath.Join(uploadsDir(), id+".json"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// CleanupUploads removes uploads that expired, and staged data left without its upload.
+func CleanupUploads() {
+	entries, err := os.ReadDir(uploadsDir())
+	if err != nil {
+		return
+	}
+	removed := 0
+	for _, entry := range entries {
+		id, isInfo := strings.CutSuffix(entry.Name(), ".json")
+		if !validUploadID(id) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if !isInfo {
+			// staged data without upload, the info is written right after the data is created
+			if _, err = os.Stat(filepath.Join(uploadsDir(), id+".json")); os.IsNotExist(err) && time.Since(info.ModTime()) > uploadExpiry() {
+				os.Remove(filepath.Join(uploadsDir(), id))
+			}
+			continue
+		}
+		lock := uploadLock(id)
+		if !lock.TryLock() {
+			continue
+		}
+		upload := &Upload{}
+		data, err := os.ReadFile(filepath.Join(uploadsDir(), entry.Name()))
+		if err == nil && json.Unmarshal(data, upload) == nil && time.Now().After(upload.Expires) {
+			if err = removeUpload(id); err != nil {
+				logger.Errorf("could not remove expired upload %v: %v", id, err)
+			} else {
+				removed++
+			}
+		}
+		unlockUpload(id, lock)
+	}
+	if removed > 0 {
+		logger.Infof("removed %v expired uploads", removed)
+	}
+}
+
+// RunUploadCleanup removes expired uploads in the background until ctx is cancelled.
+func RunUploadCleanup(ctx context.Context) {
+	ticker := time.NewTicker(uploadCleanupInterval)
+	defer ticker.Stop()
+	for {
+		CleanupUploads()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
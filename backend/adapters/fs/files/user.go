@@ -1,11 +1,14 @@
 package files
 
 import (
+	"filebrowser/common/errors"
 	"filebrowser/common/settings"
 	"filebrowser/database/users"
+	"filebrowser/indexing"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 func MakeUserDir(fullPath string) error {
@@ -59,3 +62,27 @@ func MakeUserDirs(u *users.User, disableScopeChange bool) error {
 	return nil
 
 }
+
+// userScope returns the scope of the user in the source of an index.
+func userScope(user *users.User, index *indexing.Index) (string, error) {
+	for _, scope := range user.Scopes {
+		if scope.Name == index.Path || scope.Name == index.Name {
+			return "/" + strings.Trim(scope.Scope, "/"), nil
+		}
+	}
+	return "", errors.ErrPermissionDenied
+}
+
+// inScope returns indexPath relative to scope, false when it is outside of it.
+func inScope(scope, indexPath string) (string, bool) {
+	if scope == "/" {
+		return indexPath, true
+	}
+	if indexPath == scope {
+		return "/", true
+	}
+	if strings.HasPrefix(indexPath, scope+"/") {
+		return strings.TrimPrefix(indexPath, scope), true
+	}
+	return "", false
+}
@@ -0,0 +1,67 @@
+package files
+
+import (
+	"filebrowser/adapters/fs/versions"
+	"filebrowser/indexing"
+	"filebrowser/indexing/iteminfo"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+func versionedFile(source, path string) (*indexing.Index, string, string, error) {
+	index := indexing.GetIndex(source)
+	if index == nil {
+		return nil, "", "", fmt.Errorf("could not get index: %v ", source)
+	}
+	realPath, _, err := index.GetRealPath(path)
+	if err != nil {
+		return nil, "", "", err
+	}
+	return index, index.MakeIndexPath(realPath), realPath, nil
+}
+
+// ListVersions returns the saved versions of a file, newest first.
+func ListVersions(source, path string) ([]versions.Version, error) {
+	index, indexPath, _, err := versionedFile(source, path)
+	if err != nil {
+		return nil, err
+	}
+	return versions.List(index.Source, indexPath)
+}
+
+// OpenVersion opens a version of a file for download, the caller closes it.
+func OpenVersion(source, path, id string) (*os.File, *versions.Version, error) {
+	index, indexPath, _, err := versionedFile(source, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return versions.Open(index.Source, indexPath, id)
+}
+
+// DiffVersion returns a unified diff from a version of a text file to its current content.
+func DiffVersion(source, path, id string) (string, error) {
+	index, indexPath, realPath, err := versionedFile(source, path)
+	if err != nil {
+		return "", err
+	}
+	return versions.Diff(index.Source, indexPath, id, realPath)
+}
+
+// RestoreVersion replaces a file with one of its versions, the replaced content becomes a new version.
+func RestoreVersion(source, path, id string) error {
+	defer indexing.TrackRequest()()
+	index, indexPath, realPath, err := versionedFile(source, path)
+	if err != nil {
+		return err
+	}
+	if err = versions.Restore(index.Source, indexPath, id, realPath); err != nil {
+		return err
+	}
+	err = index.RefreshFileInfo(iteminfo.FileOptions{Path: index.MakeIndexPath(filepath.Dir(realPath)), IsDir: true})
+	if err != nil && !index.Config.DisableIndexing {
+		return err
+	}
+	index.ReindexContent(indexPath)
+	return nil
+}
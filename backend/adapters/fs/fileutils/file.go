@@ -185,6 +185,11 @@ func ClearCacheDir() {
 	}
 
 	for _, entry := range entries {
+		// index snapshots are kept so restarts don't require a full scan
+		// and unfinished uploads can be resumed after a restart
+		if entry.Name() == "index" || entry.Name() == "uploads" {
+			continue
+		}
 		path := filepath.Join(cacheDir, entry.Name())
 		err = os.RemoveAll(path)
 		if err != nil {
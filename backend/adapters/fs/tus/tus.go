@@ -0,0 +1,257 @@
+// Package tus serves resumable uploads with the tus protocol 1.0.0 and its creation,
+// termination, checksum and expiration extensions, see https://tus.io/protocols/resumable-upload.
+// Uploads are staged and finished by the files adapter.
+package tus
+
+import (
+	"encoding/base64"
+	"filebrowser/adapters/fs/files"
+	"filebrowser/common/errors"
+	"filebrowser/common/settings"
+	"filebrowser/database/users"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gtsteffaniak/go-logger/logger"
+)
+
+const (
+	Version    = "1.0.0"
+	Extensions = "creation,termination,checksum,expiration"
+
+	contentType = "application/offset+octet-stream"
+	// statusChecksumMismatch is defined by the checksum extension
+	statusChecksumMismatch = 460
+)
+
+// Handler serves the upload endpoint. A new upload is created with a POST to BasePath, the
+// destination is given by the query parameters source, path and override. The path may end
+// with a slash, the "filename" of the upload metadata is appended then.
+type Handler struct {
+	BasePath string // without trailing slash, the upload URLs are BasePath followed by "/" and the upload id
+	Auther   Auther
+	Users    *users.Storage
+}
+
+// Auther authenticates upload requests, it is implemented by the authers of the auth package.
+type Auther interface {
+	Auth(r *http.Request, userStore *users.Storage) (*users.User, error)
+}
+
+// NewHandler returns a handler for uploads below basePath.
+func NewHandler(basePath string, auther Auther, userStore *users.Storage) *Handler {
+	return &Handler{BasePath: strings.TrimSuffix(basePath, "/"), Auther: auther, Users: userStore}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", Version)
+	// requests of browsers that can't send PATCH or DELETE
+	method := r.Method
+	if override := r.Header.Get("X-HTTP-Method-Override"); override != "" {
+		method = override
+	}
+	if method == http.MethodOptions {
+		w.Header().Set("Tus-Version", Version)
+		w.Header().Set("Tus-Extension", Extensions)
+		w.Header().Set("Tus-Checksum-Algorithm", strings.Join(files.UploadChecksumAlgorithms, ","))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Header.Get("Tus-Resumable") != Version {
+		w.Header().Set("Tus-Version", Version)
+		http.Error(w, "unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+	user, err := h.Auther.Auth(r, h.Users)
+	if err != nil {
+		writeError(w, errors.ErrUnauthorized)
+		return
+	}
+	id, ok := strings.CutPrefix(r.URL.Path, h.BasePath)
+	if !ok || (id != "" && !strings.HasPrefix(id, "/")) {
+		http.NotFound(w, r)
+		return
+	}
+	id = strings.Trim(id, "/")
+	switch {
+	case id == "" && method == http.MethodPost:
+		h.create(w, r, user)
+	case id != "" && method == http.MethodHead:
+		h.head(w, user, id)
+	case id != "" && method == http.MethodPatch:
+		h.patch(w, r, user, id)
+	case id != "" && method == http.MethodDelete:
+		writeError(w, files.TerminateUpload(user, id))
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) create(w http.ResponseWriter, r *http.Request, user *users.User) {
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		http.Error(w, "deferred upload length is not supported", http.StatusBadRequest)
+		return
+	}
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	metadata, err := ParseMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, "invalid Upload-Metadata", http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query()
+	source := query.Get("source")
+	if source == "" {
+		source = settings.Config.Server.DefaultSource.Name
+	}
+	dest := query.Get("path")
+	if strings.HasSuffix(dest, "/") || dest == "" {
+		dest += metadata["filename"]
+	}
+	overwrite := query.Get("override") == "true"
+	upload, err := files.CreateUpload(user, source, dest, length, overwrite, metadata)
+	if upload == nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Location", h.BasePath+"/"+upload.ID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !upload.Complete() {
+		w.Header().Set("Upload-Expires", upload.Expires.UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *Handler) head(w http.ResponseWriter, user *users.User, id string) {
+	w.Header().Set("Cache-Control", "no-store")
+	upload, err := files.GetUpload(user, id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Upload-Expires", upload.Expires.UTC().Format(http.TimeFormat))
+	if len(upload.Metadata) > 0 {
+		w.Header().Set("Upload-Metadata", FormatMetadata(upload.Metadata))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) patch(w http.ResponseWriter, r *http.Request, user *users.User, id string) {
+	if r.Header.Get("Content-Type") != contentType {
+		http.Error(w, "Content-Type must be "+contentType, http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+	var checksum *files.UploadChecksum
+	if header := r.Header.Get("Upload-Checksum"); header != "" {
+		if checksum, err = ParseChecksum(header); err != nil {
+			http.Error(w, "invalid Upload-Checksum", http.StatusBadRequest)
+			return
+		}
+	}
+	upload, err := files.WriteUpload(user, id, offset, r.Body, checksum)
+	if err != nil {
+		if upload != nil {
+			logger.Debugf("upload %v stopped at %v of %v bytes: %v", id, upload.Offset, upload.Length, err)
+		}
+		if err == errors.ErrConflict {
+			http.Error(w, "Upload-Offset doesn't match the upload, or another request is writing to it", http.StatusConflict)
+			return
+		}
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	if !upload.Complete() {
+		w.Header().Set("Upload-Expires", upload.Expires.UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeError writes the status of an error, or 204 when there is none.
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case errors.ErrNotExist:
+		status = http.StatusNotFound
+	case errors.ErrConflict, errors.ErrExist, errors.ErrIsDirectory:
+		status = http.StatusConflict
+	case errors.ErrChecksumMismatch:
+		status = statusChecksumMismatch
+	case errors.ErrInvalidRequestParams, errors.ErrInvalidOption:
+		status = http.StatusBadRequest
+	case errors.ErrPermissionDenied:
+		status = http.StatusForbidden
+	case errors.ErrUnauthorized:
+		status = http.StatusUnauthorized
+	default:
+		logger.Errorf("upload failed: %v", err)
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// ParseMetadata decodes an Upload-Metadata header, comma separated keys each followed by
+// a space and a base64 value. Values are optional.
+func ParseMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// FormatMetadata encodes metadata as an Upload-Metadata header.
+func FormatMetadata(metadata map[string]string) string {
+	pairs := make([]string, 0, len(metadata))
+	for key, value := range metadata {
+		if value == "" {
+			pairs = append(pairs, key)
+		} else {
+			pairs = append(pairs, key+" "+base64.StdEncoding.EncodeToString([]byte(value)))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// ParseChecksum decodes an Upload-Checksum header, the algorithm and the base64 checksum
+// separated by a space.
+func ParseChecksum(header string) (*files.UploadChecksum, error) {
+	algorithm, encoded, ok := strings.Cut(header, " ")
+	if !ok {
+		return nil, errors.ErrInvalidRequestParams
+	}
+	sum, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.ErrInvalidRequestParams
+	}
+	return &files.UploadChecksum{Algorithm: algorithm, Sum: sum}, nil
+}
@@ -0,0 +1,206 @@
+package tus
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"filebrowser/common/errors"
+	"filebrowser/common/settings"
+	"filebrowser/database/users"
+	"filebrowser/indexing"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestMain sets the cache dir once, uploads are staged in it.
+func TestMain(m *testing.M) {
+	cacheDir, err := os.MkdirTemp("", "tus-test-cache")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	settings.Config.Server.CacheDir = cacheDir
+	code := m.Run()
+	os.RemoveAll(cacheDir)
+	os.Exit(code)
+}
+
+// stubAuther authenticates every request as user, or none when user is nil.
+type stubAuther struct {
+	user *users.User
+}
+
+func (a stubAuther) Auth(r *http.Request, userStore *users.Storage) (*users.User, error) {
+	if a.user == nil {
+		return nil, errors.ErrUnauthorized
+	}
+	return a.user, nil
+}
+
+// newTestHandler serves uploads to an unindexed source in a temporary directory.
+func newTestHandler(t *testing.T) (*Handler, string) {
+	t.Helper()
+	root := t.TempDir()
+	source := settings.Source{
+		Path:   root,
+		Name:   filepath.Base(root),
+		Config: settings.SourceConfig{DisableIndexing: true, Versions: settings.VersionsConfig{Dir: t.TempDir()}},
+	}
+	indexing.Initialize(source, true)
+	settings.Config.Server.DefaultSource = source
+	user := &users.User{
+		ID:          1,
+		Scopes:      []users.SourceScope{{Name: source.Name, Scope: "/"}},
+		Permissions: users.Permissions{Modify: true},
+	}
+	return NewHandler("/api/tus/", stubAuther{user: user}, nil), root
+}
+
+func request(h *Handler, method, target string, headers map[string]string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Tus-Resumable", Version)
+	for key, value := range headers {
+		r.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestUploadRequests(t *testing.T) {
+	h, root := newTestHandler(t)
+	w := request(h, http.MethodPost, "/api/tus?path=/docs/", map[string]string{
+		"Upload-Length":   "11",
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("notes.txt")),
+	}, "")
+	if w.Code != http.StatusCreated || !strings.HasPrefix(w.Header().Get("Location"), "/api/tus/") {
+		t.Fatalf("create: status %v, location %q", w.Code, w.Header().Get("Location"))
+	}
+	location := w.Header().Get("Location")
+	if w.Header().Get("Upload-Expires") == "" {
+		t.Error("create: no Upload-Expires")
+	}
+
+	patch := func(offset, body string, headers map[string]string) *httptest.ResponseRecorder {
+		if headers == nil {
+			headers = map[string]string{}
+		}
+		headers["Upload-Offset"] = offset
+		if _, ok := headers["Content-Type"]; !ok {
+			headers["Content-Type"] = contentType
+		}
+		return request(h, http.MethodPatch, location, headers, body)
+	}
+	if w = patch("0", "hello", nil); w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != "5" {
+		t.Fatalf("first chunk: status %v, offset %q", w.Code, w.Header().Get("Upload-Offset"))
+	}
+	if w = patch("0", "hello", nil); w.Code != http.StatusConflict {
+		t.Errorf("chunk at a stale offset: status %v, want %v", w.Code, http.StatusConflict)
+	}
+	if w = patch("5", " world", map[string]string{"Content-Type": "text/plain"}); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("chunk without the tus content type: status %v, want %v", w.Code, http.StatusUnsupportedMediaType)
+	}
+	wrong := sha1.Sum([]byte("other"))
+	if w = patch("5", " world", map[string]string{"Upload-Checksum": "sha1 " + base64.StdEncoding.EncodeToString(wrong[:])}); w.Code != statusChecksumMismatch {
+		t.Errorf("chunk with a wrong checksum: status %v, want %v", w.Code, statusChecksumMismatch)
+	}
+
+	w = request(h, http.MethodHead, location, nil, "")
+	if w.Code != http.StatusOK || w.Header().Get("Upload-Offset") != "5" || w.Header().Get("Upload-Length") != "11" {
+		t.Fatalf("head: status %v, offset %q, length %q", w.Code, w.Header().Get("Upload-Offset"), w.Header().Get("Upload-Length"))
+	}
+	if w.Header().Get("Upload-Metadata") != "filename "+base64.StdEncoding.EncodeToString([]byte("notes.txt")) {
+		t.Errorf("head: metadata %q", w.Header().Get("Upload-Metadata"))
+	}
+
+	// browsers without PATCH send a POST with the method override header
+	right := sha1.Sum([]byte(" world"))
+	w = request(h, http.MethodPost, location, map[string]string{
+		"X-HTTP-Method-Override": http.MethodPatch,
+		"Content-Type":           contentType,
+		"Upload-Offset":          "5",
+		"Upload-Checksum":        "sha1 " + base64.StdEncoding.EncodeToString(right[:]),
+	}, " world")
+	if w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != "11" {
+		t.Fatalf("last chunk: status %v, offset %q", w.Code, w.Header().Get("Upload-Offset"))
+	}
+	data, err := os.ReadFile(filepath.Join(root, "docs", "notes.txt"))
+	if err != nil || string(data) != "hello world" {
+		t.Errorf("uploaded file = %q, %v", data, err)
+	}
+	if w = request(h, http.MethodHead, location, nil, ""); w.Code != http.StatusNotFound {
+		t.Errorf("head of a finished upload: status %v, want %v", w.Code, http.StatusNotFound)
+	}
+
+	w = request(h, http.MethodPost, "/api/tus?path=/docs/notes.txt", map[string]string{"Upload-Length": "3"}, "")
+	if w.Code != http.StatusConflict {
+		t.Errorf("upload to an existing file: status %v, want %v", w.Code, http.StatusConflict)
+	}
+	w = request(h, http.MethodPost, "/api/tus?path=/docs/notes.txt&override=true", map[string]string{"Upload-Length": "3"}, "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("overwriting upload: status %v", w.Code)
+	}
+	location = w.Header().Get("Location")
+	if w = request(h, http.MethodDelete, location, nil, ""); w.Code != http.StatusNoContent {
+		t.Errorf("terminate: status %v, want %v", w.Code, http.StatusNoContent)
+	}
+	if w = request(h, http.MethodHead, location, nil, ""); w.Code != http.StatusNotFound {
+		t.Errorf("head of a terminated upload: status %v, want %v", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestRejectedRequests(t *testing.T) {
+	h, _ := newTestHandler(t)
+	w := request(h, http.MethodOptions, "/api/tus", nil, "")
+	if w.Code != http.StatusNoContent || w.Header().Get("Tus-Version") != Version || w.Header().Get("Tus-Extension") != Extensions {
+		t.Errorf("options: status %v, headers %v", w.Code, w.Header())
+	}
+	tests := map[string]struct {
+		handler *Handler
+		method  string
+		target  string
+		headers map[string]string
+		want    int
+	}{
+		"unsupported version":    {h, http.MethodPost, "/api/tus", map[string]string{"Tus-Resumable": "0.2.2"}, http.StatusPreconditionFailed},
+		"unauthenticated":        {NewHandler("/api/tus", stubAuther{}, nil), http.MethodPost, "/api/tus", nil, http.StatusUnauthorized},
+		"other path":             {h, http.MethodPost, "/api/tusother", nil, http.StatusNotFound},
+		"missing length":         {h, http.MethodPost, "/api/tus?path=/a.txt", nil, http.StatusBadRequest},
+		"deferred length":        {h, http.MethodPost, "/api/tus?path=/a.txt", map[string]string{"Upload-Defer-Length": "1"}, http.StatusBadRequest},
+		"invalid metadata":       {h, http.MethodPost, "/api/tus?path=/a.txt", map[string]string{"Upload-Length": "1", "Upload-Metadata": "filename %%%"}, http.StatusBadRequest},
+		"without modify":         {NewHandler("/api/tus", stubAuther{user: &users.User{ID: 1}}, nil), http.MethodPost, "/api/tus?path=/a.txt", map[string]string{"Upload-Length": "1"}, http.StatusForbidden},
+		"unknown upload":         {h, http.MethodHead, "/api/tus/0123456789abcdef0123456789abcdef", nil, http.StatusNotFound},
+		"invalid offset":         {h, http.MethodPatch, "/api/tus/0123456789abcdef0123456789abcdef", map[string]string{"Content-Type": contentType, "Upload-Offset": "-1"}, http.StatusBadRequest},
+		"invalid checksum":       {h, http.MethodPatch, "/api/tus/0123456789abcdef0123456789abcdef", map[string]string{"Content-Type": contentType, "Upload-Offset": "0", "Upload-Checksum": "sha1"}, http.StatusBadRequest},
+		"method not allowed":     {h, http.MethodGet, "/api/tus/0123456789abcdef0123456789abcdef", nil, http.StatusMethodNotAllowed},
+		"post to an upload":      {h, http.MethodPost, "/api/tus/0123456789abcdef0123456789abcdef", nil, http.StatusMethodNotAllowed},
+		"delete unknown upload":  {h, http.MethodDelete, "/api/tus/0123456789abcdef0123456789abcdef", nil, http.StatusNotFound},
+		"upload id not hex":      {h, http.MethodHead, "/api/tus/../../etc", nil, http.StatusNotFound},
+		"upload into the trash":  {h, http.MethodPost, "/api/tus?path=/" + indexing.TrashDirName + "/a.txt", map[string]string{"Upload-Length": "1"}, http.StatusForbidden},
+		"destination is the dir": {h, http.MethodPost, "/api/tus?path=/", map[string]string{"Upload-Length": "1"}, http.StatusBadRequest},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if w := request(tt.handler, tt.method, tt.target, tt.headers, ""); w.Code != tt.want {
+				t.Errorf("status %v, want %v: %v", w.Code, tt.want, strings.TrimSpace(w.Body.String()))
+			}
+		})
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	metadata := map[string]string{"filename": "notes, final.txt", "empty": "", "type": "text/plain"}
+	header := FormatMetadata(metadata)
+	parsed, err := ParseMetadata(header)
+	if err != nil || !reflect.DeepEqual(parsed, metadata) {
+		t.Errorf("ParseMetadata(%q) = %v, %v, want %v", header, parsed, err, metadata)
+	}
+	if _, err = ParseMetadata("filename not-base64!"); err == nil {
+		t.Error("invalid base64 was accepted")
+	}
+}
@@ -0,0 +1,190 @@
+package versions
+
+import (
+	"bytes"
+	"filebrowser/common/errors"
+	"filebrowser/common/settings"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	diffContext     = 3
+	maxDiffFileSize = 2 * 1024 * 1024 // larger files are not compared
+	maxDiffEdits    = 2000            // larger differences are not worth showing line by line
+)
+
+// Diff returns a unified diff from a version to the current content of the file.
+func Diff(source settings.Source, indexPath, id, realPath string) (string, error) {
+	version, err := Get(source, indexPath, id)
+	if err != nil {
+		return "", err
+	}
+	old, err := readText(filepath.Join(fileDir(source, indexPath), version.ID))
+	if err != nil {
+		return "", err
+	}
+	current, err := readText(realPath)
+	if err != nil {
+		return "", err
+	}
+	name := strings.TrimPrefix(indexPath, "/")
+	return unifiedDiff(name+"@"+version.Created.Format("2006-01-02T15:04:05"), name, old, current)
+}
+
+func readText(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxDiffFileSize {
+		return nil, errors.ErrNotText
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.IndexByte(data, 0) >= 0 || !utf8.Valid(data) {
+		return nil, errors.ErrNotText
+	}
+	text := string(data)
+	if text == "" {
+		return []string{}, nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n"), nil
+}
+
+type edit struct {
+	op   byte // ' ', '-' or '+'
+	line string
+}
+
+// diffLines returns the edits turning a into b with the Myers algorithm.
+func diffLines(a, b []string) ([]edit, error) {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	// trace[d] holds v for k in [-d-1, d+1] before round d
+	trace := [][]int{}
+	for d := 0; d <= n+m; d++ {
+		if d > maxDiffEdits {
+			return nil, fmt.Errorf("the files differ in more than %d lines", maxDiffEdits)
+		}
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			x := 0
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(a, b, trace), nil
+			}
+		}
+	}
+	return backtrack(a, b, trace), nil
+}
+
+func backtrack(a, b []string, trace [][]int) []edit {
+	edits := []edit{}
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d >= 0; d-- {
+		at := func(k int) int { return trace[d][k+d+1] }
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			edits = append(edits, edit{' ', a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				edits = append(edits, edit{'+', b[y-1]})
+			} else {
+				edits = append(edits, edit{'-', a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+// unifiedDiff formats the edits as hunks with diffContext unchanged lines around changes.
+// It returns an empty string when there is no difference.
+func unifiedDiff(fromName, toName string, a, b []string) (string, error) {
+	edits, err := diffLines(a, b)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	start := -1 // first edit of the current hunk
+	lastChange := -1
+	flush := func(end int) {
+		oldStart, newStart := 1, 1
+		for _, e := range edits[:start] {
+			if e.op != '+' {
+				oldStart++
+			}
+			if e.op != '-' {
+				newStart++
+			}
+		}
+		oldCount, newCount := 0, 0
+		for _, e := range edits[start:end] {
+			if e.op != '+' {
+				oldCount++
+			}
+			if e.op != '-' {
+				newCount++
+			}
+		}
+		if oldCount == 0 {
+			oldStart--
+		}
+		if newCount == 0 {
+			newStart--
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount)
+		for _, e := range edits[start:end] {
+			out.WriteByte(e.op)
+			out.WriteString(e.line)
+			out.WriteByte('\n')
+		}
+	}
+	for i, e := range edits {
+		if e.op == ' ' {
+			continue
+		}
+		if start >= 0 && i-lastChange-1 > 2*diffContext {
+			flush(lastChange + diffContext + 1)
+			start = -1
+		}
+		if start < 0 {
+			start = max(0, i-diffContext)
+		}
+		lastChange = i
+	}
+	if start < 0 {
+		return "", nil
+	}
+	flush(min(len(edits), lastChange+diffContext+1))
+	return fmt.Sprintf("--- %s\n+++ %s\n", fromName, toName) + out.String(), nil
+}
@@ -0,0 +1,377 @@
+package versions
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"filebrowser/common/errors"
+	"filebrowser/common/settings"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gtsteffaniak/go-logger/logger"
+)
+
+const (
+	defaultMaxVersions   = 20
+	defaultMaxFileSizeMB = 100
+	historyFile          = "history.json"
+)
+
+// Version is a previous content of a file, saved before the file was overwritten.
+type Version struct {
+	ID       string    `json:"id"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"` // modification time of the replaced content
+	Created  time.Time `json:"created"`  // when the content was replaced
+	Checksum string    `json:"checksum"` // sha256 of the content
+}
+
+// history is stored next to the versions of a file.
+type history struct {
+	Source   string    `json:"source"`
+	Path     string    `json:"path"`     // index path of the file
+	Versions []Version `json:"versions"` // oldest first
+}
+
+// one lock per source store, versions are only written on overwrites, restores and deletes
+var storeLocks sync.Map
+
+func lock(source settings.Source) func() {
+	l, _ := storeLocks.LoadOrStore(storeDir(source), &sync.Mutex{})
+	l.(*sync.Mutex).Lock()
+	return l.(*sync.Mutex).Unlock
+}
+
+func hashKey(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:16])
+}
+
+// storeDir is the folder holding the versions of every file of a source. The default is
+// next to the database, versions must outlive the cache dir.
+func storeDir(source settings.Source) string {
+	dir := source.Config.Versions.Dir
+	if dir == "" {
+		dir = filepath.Join(filepath.Dir(settings.Config.Server.Database), "versions")
+	}
+	return filepath.Join(dir, hashKey(source.Path))
+}
+
+// fileDir is the folder holding the versions of a file.
+func fileDir(source settings.Source, indexPath string) string {
+	return filepath.Join(storeDir(source), hashKey(indexPath))
+}
+
+func readHistory(dir string) (*history, error) {
+	data, err := os.ReadFile(filepath.Join(dir, historyFile))
+	if os.IsNotExist(err) {
+		return nil, errors.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	h := &history{}
+	return h, json.Unmarshal(data, h)
+}
+
+func writeHistory(dir string, h *history) error {
+	if len(h.Versions) == 0 {
+		return os.RemoveAll(dir)
+	}
+	data, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, historyFile), data, 0644)
+}
+
+// Enabled reports whether overwritten files of the source keep versions.
+func Enabled(source settings.Source) bool {
+	return !source.Config.Versions.Disabled
+}
+
+// Snapshot saves the current content of a file before it is overwritten. Missing files,
+// folders and files over the size limit are skipped, as is content equal to the latest version.
+func Snapshot(source settings.Source, indexPath, realPath string) error {
+	if !Enabled(source) {
+		return nil
+	}
+	info, err := os.Stat(realPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	maxFileSize := source.Config.Versions.MaxFileSizeMB
+	if maxFileSize == 0 {
+		maxFileSize = defaultMaxFileSizeMB
+	}
+	if !info.Mode().IsRegular() || info.Size() > maxFileSize*1024*1024 {
+		return nil
+	}
+	unlock := lock(source)
+	defer unlock()
+	dir := fileDir(source, indexPath)
+	h, err := readHistory(dir)
+	if err == errors.ErrNotExist {
+		h = &history{Source: source.Name, Path: indexPath}
+	} else if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	version := Version{
+		ID:       fmt.Sprintf("%d", time.Now().UnixNano()),
+		Size:     info.Size(),
+		Modified: info.ModTime(),
+		Created:  time.Now(),
+	}
+	version.Checksum, err = copyContent(realPath, filepath.Join(dir, version.ID))
+	if err != nil {
+		os.Remove(filepath.Join(dir, version.ID))
+		return err
+	}
+	if n := len(h.Versions); n > 0 && h.Versions[n-1].Checksum == version.Checksum {
+		return os.Remove(filepath.Join(dir, version.ID))
+	}
+	h.Versions = append(h.Versions, version)
+	h.Versions = prune(dir, h.Versions, source.Config.Versions)
+	if err = writeHistory(dir, h); err != nil {
+		return err
+	}
+	if source.Config.Versions.MaxSizeMB > 0 {
+		enforceStoreSize(source)
+	}
+	return nil
+}
+
+// copyContent copies a file and returns the sha256 of its content.
+func copyContent(src, dst string) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, hash), in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return hex.EncodeToString(hash.Sum(nil)), err
+}
+
+// prune drops versions over the count and age limits, oldest first.
+func prune(dir string, versions []Version, config settings.VersionsConfig) []Version {
+	maxVersions := config.MaxVersions
+	if maxVersions <= 0 {
+		maxVersions = defaultMaxVersions
+	}
+	kept := []Version{}
+	for i, version := range versions {
+		expired := config.MaxAgeDays > 0 && time.Since(version.Created) > time.Duration(config.MaxAgeDays)*24*time.Hour
+		if expired || len(versions)-i > maxVersions {
+			os.Remove(filepath.Join(dir, version.ID))
+			continue
+		}
+		kept = append(kept, version)
+	}
+	return kept
+}
+
+// enforceStoreSize removes the oldest versions of the source until all of them fit the size limit.
+func enforceStoreSize(source settings.Source) {
+	type stored struct {
+		dir     string
+		version Version
+	}
+	all := []stored{}
+	var total int64
+	entries, _ := os.ReadDir(storeDir(source))
+	for _, entry := range entries {
+		dir := filepath.Join(storeDir(source), entry.Name())
+		h, err := readHistory(dir)
+		if err != nil {
+			continue
+		}
+		for _, version := range h.Versions {
+			all = append(all, stored{dir: dir, version: version})
+			total += version.Size
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].version.Created.Before(all[j].version.Created)
+	})
+	maxSize := source.Config.Versions.MaxSizeMB * 1024 * 1024
+	for _, oldest := range all {
+		if total <= maxSize {
+			return
+		}
+		if err := removeVersion(oldest.dir, oldest.version.ID); err != nil {
+			logger.Errorf("could not remove version %v: %v", oldest.version.ID, err)
+			continue
+		}
+		total -= oldest.version.Size
+	}
+}
+
+func removeVersion(dir, id string) error {
+	h, err := readHistory(dir)
+	if err != nil {
+		return err
+	}
+	for i, version := range h.Versions {
+		if version.ID == id {
+			h.Versions = append(h.Versions[:i], h.Versions[i+1:]...)
+			break
+		}
+	}
+	if err = os.Remove(filepath.Join(dir, id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return writeHistory(dir, h)
+}
+
+// List returns the versions of a file, newest first.
+func List(source settings.Source, indexPath string) ([]Version, error) {
+	h, err := readHistory(fileDir(source, indexPath))
+	if err == errors.ErrNotExist {
+		return []Version{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]Version, 0, len(h.Versions))
+	for i := len(h.Versions) - 1; i >= 0; i-- {
+		versions = append(versions, h.Versions[i])
+	}
+	return versions, nil
+}
+
+// Get returns a version of a file.
+func Get(source settings.Source, indexPath, id string) (*Version, error) {
+	h, err := readHistory(fileDir(source, indexPath))
+	if err != nil {
+		return nil, err
+	}
+	for _, version := range h.Versions {
+		if version.ID == id {
+			return &version, nil
+		}
+	}
+	return nil, errors.ErrNotExist
+}
+
+// Open opens the content of a version for reading.
+func Open(source settings.Source, indexPath, id string) (*os.File, *Version, error) {
+	version, err := Get(source, indexPath, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := os.Open(filepath.Join(fileDir(source, indexPath), version.ID))
+	return file, version, err
+}
+
+// Restore replaces a file with a version. The replaced content is saved as a new version
+// first, so a restore can be undone.
+func Restore(source settings.Source, indexPath, id, realPath string) error {
+	file, _, err := Open(source, indexPath, id)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err = Snapshot(source, indexPath, realPath); err != nil {
+		return err
+	}
+	// the restored file keeps the mode of the file it replaces
+	mode := os.FileMode(0644)
+	if info, statErr := os.Stat(realPath); statErr == nil {
+		mode = info.Mode().Perm()
+	}
+	temp, err := os.CreateTemp(filepath.Dir(realPath), "."+filepath.Base(realPath)+".restore-*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(temp, file)
+	if err == nil {
+		err = temp.Chmod(mode)
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), realPath)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+	}
+	return err
+}
+
+// Remove drops the versions of a file, or of every file inside a deleted folder.
+func Remove(source settings.Source, indexPath string) error {
+	unlock := lock(source)
+	defer unlock()
+	return eachInside(source, indexPath, func(dir string, h *history) error {
+		return os.RemoveAll(dir)
+	})
+}
+
+// Move keeps the versions of a moved or renamed file or folder. Versions of files moved
+// to another source are dropped, the sources can use different version settings.
+func Move(source settings.Source, indexPath string, dest settings.Source, destPath string) error {
+	if source.Path != dest.Path {
+		return Remove(source, indexPath)
+	}
+	unlock := lock(source)
+	defer unlock()
+	return eachInside(source, indexPath, func(dir string, h *history) error {
+		h.Path = destPath + strings.TrimPrefix(h.Path, indexPath)
+		newDir := fileDir(source, h.Path)
+		if err := os.RemoveAll(newDir); err != nil {
+			return err
+		}
+		if err := os.Rename(dir, newDir); err != nil {
+			return err
+		}
+		return writeHistory(newDir, h)
+	})
+}
+
+// eachInside calls fn for the history of indexPath and of every file below it.
+func eachInside(source settings.Source, indexPath string, fn func(dir string, h *history) error) error {
+	entries, err := os.ReadDir(storeDir(source))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	prefix := strings.TrimSuffix(indexPath, "/") + "/"
+	for _, entry := range entries {
+		dir := filepath.Join(storeDir(source), entry.Name())
+		h, err := readHistory(dir)
+		if err != nil {
+			continue
+		}
+		if h.Path != indexPath && !strings.HasPrefix(h.Path, prefix) {
+			continue
+		}
+		if err = fn(dir, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
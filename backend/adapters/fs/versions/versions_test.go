@@ -0,0 +1,233 @@
+package versions
+
+import (
+	"filebrowser/common/errors"
+	"filebrowser/common/settings"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSource(t *testing.T, config settings.VersionsConfig) settings.Source {
+	t.Helper()
+	config.Dir = t.TempDir()
+	return settings.Source{Name: "test", Path: t.TempDir(), Config: settings.SourceConfig{Versions: config}}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// contents returns the content of the versions of a file, newest first.
+func contents(t *testing.T, source settings.Source, indexPath string) []string {
+	t.Helper()
+	list, err := List(source, indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := []string{}
+	for _, version := range list {
+		file, _, err := Open(source, indexPath, version.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, string(data))
+	}
+	return out
+}
+
+// save writes content to the file after keeping its current content, like an overwrite.
+func save(t *testing.T, source settings.Source, indexPath, content string) {
+	t.Helper()
+	realPath := filepath.Join(source.Path, indexPath)
+	if err := Snapshot(source, indexPath, realPath); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, realPath, content)
+}
+
+func TestSnapshot(t *testing.T) {
+	source := newTestSource(t, settings.VersionsConfig{MaxVersions: 3})
+	save(t, source, "/a.txt", "one")
+	if got := contents(t, source, "/a.txt"); len(got) != 0 {
+		t.Errorf("a new file has versions %q", got)
+	}
+	for _, content := range []string{"two", "two", "three", "four", "five"} {
+		save(t, source, "/a.txt", content)
+	}
+	// unchanged content is not saved twice and only the newest versions are kept
+	if got, want := contents(t, source, "/a.txt"), []string{"four", "three", "two"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("versions = %q, want %q", got, want)
+	}
+	entries, err := os.ReadDir(fileDir(source, "/a.txt"))
+	if err != nil || len(entries) != 4 {
+		t.Errorf("store holds %v files, want 3 versions and the history: %v", len(entries), err)
+	}
+
+	if err = Snapshot(source, "/dir", source.Path); err != nil {
+		t.Errorf("Snapshot of a folder = %v", err)
+	}
+	large := newTestSource(t, settings.VersionsConfig{MaxFileSizeMB: 1})
+	writeFile(t, filepath.Join(large.Path, "big.bin"), strings.Repeat("x", 1024*1024+1))
+	save(t, large, "/big.bin", "small")
+	disabled := newTestSource(t, settings.VersionsConfig{Disabled: true})
+	writeFile(t, filepath.Join(disabled.Path, "a.txt"), "one")
+	save(t, disabled, "/a.txt", "two")
+	for _, source := range []settings.Source{large, disabled} {
+		if entries, _ := os.ReadDir(source.Config.Versions.Dir); len(entries) != 0 {
+			t.Errorf("versions of %v were kept", source.Config.Versions)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	versions := []Version{}
+	for i, age := range []time.Duration{72, 50, 30, 1} {
+		versions = append(versions, Version{ID: string(rune('a' + i)), Created: time.Now().Add(-age * time.Hour)})
+	}
+	testCases := map[string]struct {
+		config settings.VersionsConfig
+		want   []string
+	}{
+		"defaults keep everything": {settings.VersionsConfig{}, []string{"a", "b", "c", "d"}},
+		"count limit":              {settings.VersionsConfig{MaxVersions: 3}, []string{"b", "c", "d"}},
+		"age limit":                {settings.VersionsConfig{MaxAgeDays: 2}, []string{"c", "d"}},
+		"both limits":              {settings.VersionsConfig{MaxVersions: 1, MaxAgeDays: 2}, []string{"d"}},
+	}
+	for name, tt := range testCases {
+		t.Run(name, func(t *testing.T) {
+			for _, version := range versions {
+				writeFile(t, filepath.Join(dir, version.ID), version.ID)
+			}
+			got := []string{}
+			for _, version := range prune(dir, versions, tt.config) {
+				got = append(got, version.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("kept %q, want %q", got, tt.want)
+			}
+			entries, _ := os.ReadDir(dir)
+			if len(entries) != len(tt.want) {
+				t.Errorf("%v files left, want %v", len(entries), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestStoreSize(t *testing.T) {
+	source := newTestSource(t, settings.VersionsConfig{MaxSizeMB: 1})
+	half := strings.Repeat("x", 512*1024)
+	for _, path := range []string{"/a.txt", "/b.txt"} {
+		save(t, source, path, half+"1")
+		save(t, source, path, half+"2")
+	}
+	// the oldest version of the source goes, not the oldest of the file just saved
+	if got := contents(t, source, "/a.txt"); len(got) != 0 {
+		t.Errorf("a.txt keeps %v versions, want none", len(got))
+	}
+	save(t, source, "/b.txt", half+"3")
+	if got := contents(t, source, "/b.txt"); !reflect.DeepEqual(got, []string{half + "2"}) {
+		t.Errorf("b.txt keeps %v versions, want the latest", len(got))
+	}
+}
+
+func TestMoveAndRemove(t *testing.T) {
+	source := newTestSource(t, settings.VersionsConfig{})
+	for _, path := range []string{"/docs/a.txt", "/docs/sub/b.txt", "/docs2/c.txt"} {
+		save(t, source, path, "old")
+		save(t, source, path, "new")
+	}
+
+	if err := Move(source, "/docs", source, "/archive"); err != nil {
+		t.Fatal(err)
+	}
+	for path, count := range map[string]int{"/docs/a.txt": 0, "/archive/a.txt": 1, "/archive/sub/b.txt": 1, "/docs2/c.txt": 1} {
+		if got := contents(t, source, path); len(got) != count {
+			t.Errorf("%v has %v versions after the move, want %v", path, len(got), count)
+		}
+	}
+	// histories keep their new path, a second move finds them
+	if err := Move(source, "/archive/sub/b.txt", source, "/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if got := contents(t, source, "/b.txt"); !reflect.DeepEqual(got, []string{"old"}) {
+		t.Errorf("versions of /b.txt = %q, want old", got)
+	}
+
+	other := newTestSource(t, settings.VersionsConfig{})
+	if err := Move(source, "/b.txt", other, "/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if len(contents(t, source, "/b.txt")) != 0 || len(contents(t, other, "/b.txt")) != 0 {
+		t.Error("versions of a file moved to another source were kept")
+	}
+
+	if err := Remove(source, "/archive"); err != nil {
+		t.Fatal(err)
+	}
+	if got := contents(t, source, "/archive/a.txt"); len(got) != 0 {
+		t.Errorf("versions inside a removed folder = %q", got)
+	}
+	if got := contents(t, source, "/docs2/c.txt"); len(got) != 1 {
+		t.Errorf("versions of a sibling sharing the prefix = %q, want them kept", got)
+	}
+	if err := Remove(newTestSource(t, settings.VersionsConfig{}), "/"); err != nil {
+		t.Errorf("Remove without stored versions = %v", err)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	source := newTestSource(t, settings.VersionsConfig{})
+	realPath := filepath.Join(source.Path, "script.sh")
+	writeFile(t, realPath, "echo one\n")
+	save(t, source, "/script.sh", "echo two\n")
+	if err := os.Chmod(realPath, 0750); err != nil {
+		t.Fatal(err)
+	}
+	list, err := List(source, "/script.sh")
+	if err != nil || len(list) != 1 {
+		t.Fatalf("List = %v, %v, want one version", list, err)
+	}
+
+	diff, err := Diff(source, "/script.sh", list[0].ID, realPath)
+	if err != nil || !strings.Contains(diff, "-echo one\n+echo two\n") {
+		t.Errorf("Diff = %q, %v", diff, err)
+	}
+	if err = Restore(source, "/script.sh", list[0].ID, realPath); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(realPath)
+	if err != nil || string(data) != "echo one\n" {
+		t.Errorf("restored content = %q, %v, want the version", data, err)
+	}
+	info, err := os.Stat(realPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0750 {
+		t.Errorf("restored file mode = %v, want the mode of the replaced file", info.Mode())
+	}
+	// the replaced content can be restored in turn
+	if got := contents(t, source, "/script.sh"); !reflect.DeepEqual(got, []string{"echo two\n", "echo one\n"}) {
+		t.Errorf("versions after restore = %q", got)
+	}
+	if err = Restore(source, "/script.sh", "missing", realPath); err != errors.ErrNotExist {
+		t.Errorf("Restore of a missing version = %v, want %v", err, errors.ErrNotExist)
+	}
+}
@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"filebrowser/common/metrics"
 	"filebrowser/database/users"
 	"net/http"
 	"sync"
@@ -18,6 +19,15 @@ type Auther interface {
 	LoginPage() bool
 }
 
+// recordLogin counts the result of an authentication attempt per auth method.
+func recordLogin(method string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	metrics.Logins.Inc(method, result)
+}
+
 func IsRevokedApiKey(key string) bool {
 	_, exists := revokedApiKeyList[key]
 	return exists
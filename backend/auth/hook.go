@@ -12,6 +12,8 @@ import (
 	"github.com/gtsteffaniak/go-logger/logger"
 )
 
+const MethodHookAuth = "hook"
+
 type hookCred struct {
 	Password string `json:"password"`
 	Username string `json:"username"`
@@ -28,13 +30,14 @@ type hookFields struct {
 	Values map[string]string
 }
 
-func (a *HookAuth) Auth(r *http.Request, usr *users.Storage) (*users.User, error) {
+func (a *HookAuth) Auth(r *http.Request, usr *users.Storage) (user *users.User, err error) {
+	defer func() { recordLogin(MethodHookAuth, err) }()
 	var cred hookCred
 	if r.Body == nil {
 		return nil, os.ErrPermission
 	}
 
-	err := json.NewDecoder(r.Body).Decode(&cred)
+	err = json.NewDecoder(r.Body).Decode(&cred)
 	if err != nil {
 		logger.Error("decode body error")
 		return nil, os.ErrPermission
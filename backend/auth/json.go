@@ -13,11 +13,14 @@ import (
 	"github.com/gtsteffaniak/go-logger/logger"
 )
 
+const MethodJSONAuth = "json"
+
 type JSONAuth struct {
 	ReCaptcha *ReCaptcha `json:"recaptcha" yaml:"recaptcha"`
 }
 
-func (auther JSONAuth) Auth(r *http.Request, userStore *users.Storage) (*users.User, error) {
+func (auther JSONAuth) Auth(r *http.Request, userStore *users.Storage) (user *users.User, err error) {
+	defer func() { recordLogin(MethodJSONAuth, err) }()
 	username := r.URL.Query().Get("username")
 	recaptcha := r.URL.Query().Get("recaptcha")
 	password := r.Header.Get("X-Password")
@@ -36,7 +39,7 @@ func (auther JSONAuth) Auth(r *http.Request, userStore *users.Storage) (*users.U
 		}
 	}
 
-	user, err := userStore.Get(username)
+	user, err = userStore.Get(username)
 	if err != nil {
 		return nil, fmt.Errorf("unable to get user from store: %v", err)
 	}
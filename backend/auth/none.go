@@ -9,7 +9,8 @@ const MethodNoAuth = "noauth"
 
 type NoAuth struct{}
 
-func (a NoAuth) Auth(r *http.Request, usr *users.Storage) (*users.User, error) {
+func (a NoAuth) Auth(r *http.Request, usr *users.Storage) (user *users.User, err error) {
+	defer func() { recordLogin(MethodNoAuth, err) }()
 	return usr.Get(uint(1))
 }
 func (a NoAuth) LoginPage() bool {
@@ -13,9 +13,10 @@ type ProxyAuth struct {
 	Header string `json:"header"`
 }
 
-func (a ProxyAuth) Auth(r *http.Request, usr *users.Storage) (*users.User, error) {
+func (a ProxyAuth) Auth(r *http.Request, usr *users.Storage) (user *users.User, err error) {
+	defer func() { recordLogin(MethodProxyAuth, err) }()
 	username := r.Header.Get(a.Header)
-	user, err := usr.Get(username)
+	user, err = usr.Get(username)
 	if err == errors.ErrNotExist {
 		return nil, os.ErrPermission
 	}
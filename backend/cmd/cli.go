@@ -2,9 +2,11 @@ package cmd
 
 import (
 	"bufio"
+	"filebrowser/adapters/fs/files"
 	"filebrowser/common/settings"
 	"filebrowser/common/version"
 	"filebrowser/database/users"
+	"filebrowser/indexing"
 	"flag"
 	"fmt"
 	"os"
@@ -84,6 +86,13 @@ func runCLI() bool {
 	setCmd.BoolVar(&asAdmin, "a", false, "Create user as admin user, used in combination with -u")
 	setCmd.StringVar(&scope, "s", "", "Specify a user scope, otherwise default user config scope is used")
 	setCmd.StringVar(&dbConfig, "c", "config.yaml", "Path to the config file, default: config.yaml")
+	duplicatesCmd := flag.NewFlagSet("duplicates", flag.ExitOnError)
+	var sourceName string
+	var minSize int64
+	duplicatesCmd.StringVar(&sourceName, "s", "", "Source name, otherwise the default source is used")
+	duplicatesCmd.StringVar(&scope, "p", "/", "Only look for duplicates under this path of the source")
+	duplicatesCmd.Int64Var(&minSize, "m", 1, "Ignore files smaller than this many bytes")
+	duplicatesCmd.StringVar(&dbConfig, "c", "config.yaml", "Path to the config file, default: config.yaml")
 	if len(os.Args) > 1 {
 		switch os.Args[1] {
 		case "setup":
@@ -154,6 +163,36 @@ func runCLI() bool {
 			fmt.Printf("successfully updated user: %s\n", username)
 			return false
 
+		case "duplicates":
+			err := duplicatesCmd.Parse(os.Args[2:])
+			if err != nil {
+				duplicatesCmd.PrintDefaults()
+				os.Exit(1)
+			}
+			settings.Initialize(dbConfig)
+			source := settings.Config.Server.DefaultSource
+			if sourceName != "" {
+				var ok bool
+				source, ok = settings.Config.Server.NameToSource[sourceName]
+				if !ok {
+					logger.Fatalf("source not found: %v", sourceName)
+				}
+			}
+			// a fresh scan, a snapshot may be outdated and the command exits right after
+			indexing.InitializeOnce(source)
+			report, err := files.FindDuplicates(source.Name, scope, minSize)
+			if err != nil {
+				logger.Fatalf("could not find duplicates: %v", err)
+			}
+			for _, group := range report.Groups {
+				fmt.Printf("%v copies of %v bytes, %v reclaimable (sha256 %v)\n", len(group.Paths), group.Size, group.Reclaimable, group.Checksum)
+				for _, path := range group.Paths {
+					fmt.Printf("\t%v\n", path)
+				}
+			}
+			fmt.Printf("found %v duplicate groups in [%v] %v, %v bytes reclaimable\n", len(report.Groups), report.Source, report.Scope, report.Reclaimable)
+			return false
+
 		case "version":
 			fmt.Printf(`FileBrowser Quantum - A modern web-based file manager
 	Version 	 : %v
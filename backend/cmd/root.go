@@ -1,8 +1,13 @@
 package cmd
 
 import (
+	"filebrowser/adapters/fs/files"
 	"filebrowser/common/settings"
+	"filebrowser/indexing"
 	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/gtsteffaniak/go-logger/logger"
 )
@@ -17,8 +22,23 @@ commands:
 	set -a	Create user as admin
 	set -s	Specify a user scope
 	set -h	Print this help message
+	duplicates -s	Source name to look for duplicate files in
+	duplicates -p	Only report duplicates under this path
+	duplicates -m	Minimum file size in bytes
 `)
 }
+
+// StartFilebrowser runs the command given on the command line, or starts the server
+// together with its background work when there is none.
+func StartFilebrowser() {
+	if !runCLI() {
+		return
+	}
+	// indexes are saved when the server is stopped
+	go shutdownOnSignal()
+	go files.RunUploadCleanup(indexing.Background())
+}
+
 func getStore(configFile string) bool {
 	// Use the config file (global flag)
 	settings.Initialize(configFile)
@@ -29,3 +49,13 @@ func getStore(configFile string) bool {
 	store = s
 	return hasDB
 }
+
+// shutdownOnSignal saves the index snapshots before the process exits on an interrupt or terminate signal.
+func shutdownOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	received := <-signals
+	logger.Infof("received %v, saving indexes before shutting down", received)
+	indexing.Shutdown()
+	os.Exit(0)
+}
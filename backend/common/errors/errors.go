@@ -22,4 +22,10 @@ var (
 	ErrNoTotpConfigured     = errors.New("OTP is enforced, but user is not yet configured")
 	ErrUnauthorized         = errors.New("user unauthorized")
 	ErrNotIndexed           = errors.New("directory or item excluded from indexing")
+	ErrIndexBoundary        = errors.New("directory is a mount point or already indexed at another path")
+	ErrScanCancelled        = errors.New("scan cancelled")
+	ErrNotText              = errors.New("only text files can be compared")
+	ErrConflict             = errors.New("the file was changed since it was read")
+	ErrChecksumMismatch     = errors.New("the checksum of the uploaded data does not match")
+	ErrArchiveTooLarge      = errors.New("the selection is larger than the archive size limit")
 )
@@ -0,0 +1,22 @@
+package metrics
+
+// metrics recorded by the other packages, index statistics are filled in by the indexing package on scrape
+var (
+	IndexDirectories   = NewGauge("filebrowser_index_directories", "Number of indexed directories.", "source")
+	IndexFiles         = NewGauge("filebrowser_index_files", "Number of indexed files.", "source")
+	IndexDeleted       = NewGauge("filebrowser_index_deleted_directories", "Number of directories removed from the index since startup.", "source")
+	IndexQuickScanTime = NewGauge("filebrowser_index_quick_scan_seconds", "Duration of the last quick scan.", "source")
+	IndexFullScanTime  = NewGauge("filebrowser_index_full_scan_seconds", "Duration of the last full scan.", "source")
+	IndexAssessment    = NewGauge("filebrowser_index_assessment", "Complexity assessment of the source, the value is always 1.", "source", "assessment")
+	IndexScanDuration  = NewHistogram("filebrowser_index_scan_duration_seconds", "Duration of index scans.", DurationBuckets, "source", "type")
+	IndexRefreshes     = NewCounter("filebrowser_index_refresh_total", "Calls to RefreshFileInfo.", "source")
+
+	Logins = NewCounter("filebrowser_logins_total", "Login attempts by auth method and result.", "method", "result")
+
+	WrittenBytes   = NewCounter("filebrowser_write_bytes_total", "Bytes written by file uploads and saves.", "source")
+	FileOperations = NewCounter("filebrowser_file_operations_total", "Completed copy and move operations.", "operation")
+
+	EventClients           = NewGauge("filebrowser_event_clients", "Currently connected event stream clients.")
+	EventClientsRegistered = NewCounter("filebrowser_event_clients_registered_total", "Event stream clients registered since startup.")
+	EventsDropped          = NewCounter("filebrowser_events_dropped_total", "Events dropped because a client was not reading fast enough.", "type")
+)
@@ -0,0 +1,250 @@
+package metrics
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"filebrowser/common/settings"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gtsteffaniak/go-logger/logger"
+)
+
+// DurationBuckets are histogram buckets in seconds, suited for scans that take from milliseconds to an hour.
+var DurationBuckets = []float64{0.1, 0.5, 1, 5, 15, 30, 60, 300, 900, 3600}
+
+type metricType string
+
+const (
+	counterType   metricType = "counter"
+	gaugeType     metricType = "gauge"
+	histogramType metricType = "histogram"
+)
+
+// metric is a family of series that share a name and label names.
+type metric struct {
+	name       string
+	help       string
+	kind       metricType
+	labelNames []string
+	buckets    []float64
+	series     map[string]*series // key is the joined label values
+	mu         sync.Mutex
+}
+
+type series struct {
+	labelValues []string
+	value       float64  // counter and gauge value, histogram sum
+	count       uint64   // histogram observations
+	buckets     []uint64 // histogram observations per bucket, not cumulative
+}
+
+type Counter struct{ m *metric }
+type Gauge struct{ m *metric }
+type Histogram struct{ m *metric }
+
+var (
+	registry   []*metric
+	registryMu sync.RWMutex
+	onScrape   []func()
+)
+
+func register(m *metric) *metric {
+	m.series = make(map[string]*series)
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, m)
+	return m
+}
+
+// NewCounter registers a counter, label values are passed in the same order to Inc and Add.
+func NewCounter(name, help string, labelNames ...string) *Counter {
+	return &Counter{register(&metric{name: name, help: help, kind: counterType, labelNames: labelNames})}
+}
+
+// NewGauge registers a gauge, label values are passed in the same order to Set and Add.
+func NewGauge(name, help string, labelNames ...string) *Gauge {
+	return &Gauge{register(&metric{name: name, help: help, kind: gaugeType, labelNames: labelNames})}
+}
+
+// NewHistogram registers a histogram with the given upper bucket bounds in ascending order.
+func NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	return &Histogram{register(&metric{name: name, help: help, kind: histogramType, labelNames: labelNames, buckets: buckets})}
+}
+
+// OnScrape registers a function that is called before every scrape, used to update gauges
+// from state that is owned by other packages.
+func OnScrape(fn func()) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	onScrape = append(onScrape, fn)
+}
+
+// get returns the series for the label values, must be called with m.mu held.
+func (m *metric) get(labelValues []string) *series {
+	if len(labelValues) != len(m.labelNames) {
+		panic(fmt.Sprintf("metric %v expects %v label values, got %v", m.name, len(m.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string{}, labelValues...)}
+		if m.kind == histogramType {
+			s.buckets = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increases the counter, negative values are ignored.
+func (c *Counter) Add(value float64, labelValues ...string) {
+	if value < 0 {
+		return
+	}
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+	c.m.get(labelValues).value += value
+}
+
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.m.mu.Lock()
+	defer g.m.mu.Unlock()
+	g.m.get(labelValues).value = value
+}
+
+func (g *Gauge) Add(value float64, labelValues ...string) {
+	g.m.mu.Lock()
+	defer g.m.mu.Unlock()
+	g.m.get(labelValues).value += value
+}
+
+// Reset drops all series, used for gauges whose label values can disappear.
+func (g *Gauge) Reset() {
+	g.m.mu.Lock()
+	defer g.m.mu.Unlock()
+	g.m.series = make(map[string]*series)
+}
+
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.m.mu.Lock()
+	defer h.m.mu.Unlock()
+	s := h.m.get(labelValues)
+	s.value += value
+	s.count++
+	for i, bound := range h.m.buckets {
+		if value <= bound {
+			s.buckets[i]++
+			break
+		}
+	}
+}
+
+// Write outputs all registered metrics in the Prometheus text exposition format.
+func Write(w io.Writer) error {
+	registryMu.RLock()
+	hooks := append([]func(){}, onScrape...)
+	metrics := append([]*metric{}, registry...)
+	registryMu.RUnlock()
+	for _, hook := range hooks {
+		hook()
+	}
+	out := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(out)
+	}
+	return out.Flush()
+}
+
+func (m *metric) write(out *bufio.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprintf(out, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+	fmt.Fprintf(out, "# TYPE %s %s\n", m.name, m.kind)
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := m.series[key]
+		if m.kind != histogramType {
+			fmt.Fprintf(out, "%s%s %s\n", m.name, m.labels(s.labelValues, ""), formatValue(s.value))
+			continue
+		}
+		var cumulative uint64
+		for i, bound := range m.buckets {
+			cumulative += s.buckets[i]
+			fmt.Fprintf(out, "%s_bucket%s %d\n", m.name, m.labels(s.labelValues, formatValue(bound)), cumulative)
+		}
+		fmt.Fprintf(out, "%s_bucket%s %d\n", m.name, m.labels(s.labelValues, "+Inf"), s.count)
+		fmt.Fprintf(out, "%s_sum%s %s\n", m.name, m.labels(s.labelValues, ""), formatValue(s.value))
+		fmt.Fprintf(out, "%s_count%s %d\n", m.name, m.labels(s.labelValues, ""), s.count)
+	}
+}
+
+// labels formats the label set of a series, le is added for histogram buckets when not empty.
+func (m *metric) labels(values []string, le string) string {
+	if len(values) == 0 && le == "" {
+		return ""
+	}
+	pairs := make([]string, 0, len(values)+1)
+	for i, name := range m.labelNames {
+		pairs = append(pairs, name+`="`+escapeLabel(values[i])+`"`)
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(value)
+}
+
+// Handler serves the metrics. When server.metricsToken is configured, requests have to send
+// it as bearer token or as token query parameter.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	token := settings.Config.Server.MetricsToken
+	if token != "" {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if given == "" {
+			given = r.URL.Query().Get("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	err := Write(w)
+	if err != nil {
+		logger.Debugf("could not write metrics: %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"filebrowser/common/settings"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T, target string, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	for key, value := range headers {
+		r.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	Handler(w, r)
+	return w
+}
+
+func TestHandlerMetrics(t *testing.T) {
+	settings.Config.Server.MetricsToken = ""
+	Logins.Inc("password", "success")
+	FileOperations.Add(2, "copy")
+	IndexScanDuration.Observe(3, "test", "full")
+	w := scrape(t, "/metrics", nil)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("status %v, content type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		"# TYPE filebrowser_index_directories gauge",
+		"# TYPE filebrowser_index_files gauge",
+		"# TYPE filebrowser_index_deleted_directories gauge",
+		"# TYPE filebrowser_index_quick_scan_seconds gauge",
+		"# TYPE filebrowser_index_full_scan_seconds gauge",
+		"# TYPE filebrowser_index_assessment gauge",
+		"# TYPE filebrowser_index_scan_duration_seconds histogram",
+		"# TYPE filebrowser_index_refresh_total counter",
+		"# TYPE filebrowser_logins_total counter",
+		"# TYPE filebrowser_write_bytes_total counter",
+		"# TYPE filebrowser_file_operations_total counter",
+		"# TYPE filebrowser_event_clients gauge",
+		"# TYPE filebrowser_event_clients_registered_total counter",
+		"# TYPE filebrowser_events_dropped_total counter",
+		`filebrowser_logins_total{method="password",result="success"} 1`,
+		`filebrowser_file_operations_total{operation="copy"} 2`,
+		`filebrowser_index_scan_duration_seconds_bucket{source="test",type="full",le="1"} 0`,
+		`filebrowser_index_scan_duration_seconds_bucket{source="test",type="full",le="5"} 1`,
+		`filebrowser_index_scan_duration_seconds_bucket{source="test",type="full",le="+Inf"} 1`,
+		`filebrowser_index_scan_duration_seconds_sum{source="test",type="full"} 3`,
+		`filebrowser_index_scan_duration_seconds_count{source="test",type="full"} 1`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("scrape does not contain %q", want)
+		}
+	}
+}
+
+func TestHandlerToken(t *testing.T) {
+	settings.Config.Server.MetricsToken = "secret"
+	defer func() { settings.Config.Server.MetricsToken = "" }()
+	testCases := map[string]struct {
+		target  string
+		headers map[string]string
+		want    int
+	}{
+		"anonymous":          {target: "/metrics", want: http.StatusUnauthorized},
+		"wrong bearer token": {target: "/metrics", headers: map[string]string{"Authorization": "Bearer other"}, want: http.StatusUnauthorized},
+		"wrong query token":  {target: "/metrics?token=other", want: http.StatusUnauthorized},
+		"bearer token":       {target: "/metrics", headers: map[string]string{"Authorization": "Bearer secret"}, want: http.StatusOK},
+		"query token":        {target: "/metrics?token=secret", want: http.StatusOK},
+	}
+	for name, tt := range testCases {
+		t.Run(name, func(t *testing.T) {
+			w := scrape(t, tt.target, tt.headers)
+			if w.Code != tt.want {
+				t.Fatalf("status %v, want %v", w.Code, tt.want)
+			}
+			if w.Code != http.StatusOK && strings.Contains(w.Body.String(), "# TYPE") {
+				t.Error("rejected request received metrics")
+			}
+		})
+	}
+}
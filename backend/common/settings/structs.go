@@ -41,10 +41,12 @@ type Server struct {
 	DebugMedia                   bool        `json:"debugMedia"` // output ffmpeg stdout for media integration -- careful can produces lots of output!
 	Database                     string      `json:"database"`   // path to the database file
 	Sources                      []Source    `json:"sources" validate:"required,dive"`
-	ExternalUrl                  string      `json:"externalUrl"`    // used by share links if set
-	InternalUrl                  string      `json:"internalUrl"`    // used by integrations if set, this is the url that an integration service will use to communicate with filebrowser
-	CacheDir                     string      `json:"cacheDir"`       // path to the cache directory, used for thumbnails and other cached files
-	MaxArchiveSizeGB             int64       `json:"maxArchiveSize"` // max pre-archive combined size of files/folder that are allowed to be archived (in GB)
+	ExternalUrl                  string      `json:"externalUrl"`       // used by share links if set
+	InternalUrl                  string      `json:"internalUrl"`       // used by integrations if set, this is the url that an integration service will use to communicate with filebrowser
+	CacheDir                     string      `json:"cacheDir"`          // path to the cache directory, used for thumbnails and other cached files
+	MaxArchiveSizeGB             int64       `json:"maxArchiveSize"`    // max pre-archive combined size of files/folder that are allowed to be archived (in GB)
+	MetricsToken                 string      `json:"metricsToken"`      // token required to read the prometheus /metrics endpoint, empty allows anonymous access
+	UploadExpiryHours            int         `json:"uploadExpiryHours"` // unfinished resumable uploads are removed after this many hours without progress, default 24
 	// not exposed to config
 	SourceMap      map[string]Source `json:"-" validate:"omitempty"` // uses realpath as key
 	NameToSource   map[string]Source `json:"-" validate:"omitempty"` // uses name as key
@@ -59,22 +61,70 @@ type Source struct {
 }
 
 type SourceConfig struct {
-	IndexingInterval      uint32      `json:"indexingIntervalMinutes"` // optional manual overide interval in seconds to re-index the source
-	DisableIndexing       bool        `json:"disableIndexing"`         // disable the indexing of this source
-	MaxWatchers           int         `json:"maxWatchers"`             // number of concurrent watchers to use for this source, currently not supported
-	NeverWatch            []string    `json:"neverWatchPaths"`         // paths to never watch, relative to the source path (eg. "/folder/file.txt")
-	IgnoreHidden          bool        `json:"ignoreHidden"`            // ignore hidden files and folders.
-	IgnoreZeroSizeFolders bool        `json:"ignoreZeroSizeFolders"`   // ignore folders with 0 size
-	Exclude               IndexFilter `json:"exclude"`                 // exclude files and folders from indexing, if include is not set
-	Include               IndexFilter `json:"include"`                 // include files and folders from indexing, if exclude is not set
-	DefaultUserScope      string      `json:"defaultUserScope"`        // default "/" should match folders under path
-	DefaultEnabled        bool        `json:"defaultEnabled"`          // should be added as a default source for new users?
-	CreateUserDir         bool        `json:"createUserDir"`           // create a user directory for each user
+	IndexingInterval      uint32             `json:"indexingIntervalMinutes"` // optional manual overide interval in seconds to re-index the source
+	DisableIndexing       bool               `json:"disableIndexing"`         // disable the indexing of this source
+	QuickScanCron         string             `json:"quickScanCron"`           // cron expression for quick scans (eg. "*/30 * * * *"), replaces the adaptive schedule
+	FullScanCron          string             `json:"fullScanCron"`            // cron expression for full scans (eg. "0 1 * * *"), otherwise every 5th scan is a full scan
+	NoScanWindows         []string           `json:"noScanWindows"`           // daily local time windows without scheduled scans (eg. "08:00-18:00"). Quick scans are skipped, full scans deferred until the window ends.
+	MaxWatchers           int                `json:"maxWatchers"`             // max number of directories to watch for real-time changes, 0 disables watching. Scheduled scans cover the rest.
+	NeverWatch            []string           `json:"neverWatchPaths"`         // paths to never watch, relative to the source path (eg. "/folder/subfolder"). Subfolders are not watched either.
+	ChangeJournalSize     int                `json:"changeJournalSize"`       // number of created, modified and deleted entries kept for change queries, default 10000, -1 disables the journal
+	OneFileSystem         bool               `json:"oneFileSystem"`           // don't descend into mount points of other file systems, they are listed with size 0
+	ScanConcurrency       int                `json:"scanConcurrency"`         // number of directories scanned in parallel, default 1 scans serially. Higher values speed up scans on SSDs.
+	IgnoreHidden          bool               `json:"ignoreHidden"`            // ignore hidden files and folders.
+	IgnoreZeroSizeFolders bool               `json:"ignoreZeroSizeFolders"`   // ignore folders with 0 size
+	Exclude               IndexFilter        `json:"exclude"`                 // exclude files and folders from indexing, takes precedence over include
+	Include               IndexFilter        `json:"include"`                 // only index matching files and folders, see IndexFilter
+	DefaultUserScope      string             `json:"defaultUserScope"`        // default "/" should match folders under path
+	DefaultEnabled        bool               `json:"defaultEnabled"`          // should be added as a default source for new users?
+	CreateUserDir         bool               `json:"createUserDir"`           // create a user directory for each user
+	ScanThrottle          ScanThrottleConfig `json:"scanThrottle"`            // limits disk I/O of scheduled scans, file operations and the watcher are not throttled
+	ContentIndex          ContentIndexConfig `json:"contentIndex"`            // full-text index of text file contents, used by "content:" searches
+	IndexMetadata         bool               `json:"indexMetadata"`           // read photo, audio and video metadata during scans, used by "camera:", "artist:", "taken:" and similar searches
+	Trash                 TrashConfig        `json:"trash"`                   // deleted items are moved to a trash folder of the source and can be restored
+	Versions              VersionsConfig     `json:"versions"`                // previous contents of overwritten files are kept and can be restored
 }
+type VersionsConfig struct {
+	Disabled      bool   `json:"disabled"`      // overwrite files without keeping versions
+	Dir           string `json:"dir"`           // where versions are stored, default "versions" next to the database
+	MaxVersions   int    `json:"maxVersions"`   // versions kept per file, default 20
+	MaxAgeDays    int    `json:"maxAgeDays"`    // versions older than this are removed, 0 keeps them regardless of age
+	MaxSizeMB     int64  `json:"maxSizeMB"`     // oldest versions of the source are removed when all versions take more space, 0 is unlimited
+	MaxFileSizeMB int64  `json:"maxFileSizeMB"` // larger files are overwritten without keeping a version, default 100
+}
+type TrashConfig struct {
+	Disabled      bool  `json:"disabled"`      // delete items permanently
+	RetentionDays int   `json:"retentionDays"` // items are purged after this many days, default 30, -1 keeps them until purged manually
+	MaxSizeMB     int64 `json:"maxSizeMB"`     // oldest items are purged when the trash grows larger, 0 is unlimited
+}
+type ContentIndexConfig struct {
+	Enabled       bool  `json:"enabled"`       // index the contents of text files
+	MaxFileSizeKB int64 `json:"maxFileSizeKB"` // files larger than this are not content indexed, default 1024
+	MaxMemoryMB   int64 `json:"maxMemoryMB"`   // approximate memory limit of the content index, default 256
+	MaxDiskMB     int64 `json:"maxDiskMB"`     // max size of the content index saved with the index snapshot, default 512
+}
+
+type ScanThrottleConfig struct {
+	MaxDirsPerSecond int `json:"maxDirsPerSecond"` // max directories read per second, 0 is unlimited
+	BatchSize        int `json:"batchSize"`        // number of directories read between pauses, 0 disables pauses
+	BatchPauseMs     int `json:"batchPauseMs"`     // pause after every batch in milliseconds
+	BusyRequests     int `json:"busyRequests"`     // pause scans while at least this many user requests are in flight, 0 never pauses
+}
+
+// IndexFilter rules decide which items are indexed. Exclude rules always win over include rules.
+// When include has file rules, a file is indexed if it matches any of them. Include patterns and
+// regexes only apply to files, folders are limited with include folders, which keeps their parent
+// and child folders indexed as well.
+//
+// Older versions matched include folders exactly, so subfolders of an included folder were skipped,
+// and required a file to match both include files and fileEndsWith when both were set. Configs that
+// relied on either now index more than before.
 type IndexFilter struct {
 	Files        []string `json:"files"`        // array of file names to include/exclude
 	Folders      []string `json:"folders"`      // array of folder names to include/exclude
 	FileEndsWith []string `json:"fileEndsWith"` // array of file names to include/exclude (eg "a.jpg")
+	Patterns     []string `json:"patterns"`     // glob patterns matched against the name and the full index path (eg "*.tmp", "**/node_modules")
+	Regexes      []string `json:"regexes"`      // anchored regular expressions matched against the name and the full index path (eg "cache-[0-9]+")
 }
 type Frontend struct {
 	Name                  string         `json:"name"`                  // display name
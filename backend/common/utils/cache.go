@@ -12,4 +12,5 @@ var (
 	SearchResultsCache = cache.NewCache(15*time.Second, 1*time.Hour)
 	OnlyOfficeCache    = cache.NewCache(48*time.Hour, 1*time.Hour)
 	JwtCache           = cache.NewCache(1*time.Hour, 72*time.Hour)
+	ChecksumCache      = cache.NewCache(72*time.Hour, 24*time.Hour)
 )
@@ -0,0 +1,43 @@
+package searches
+
+import (
+	"filebrowser/common/errors"
+	"filebrowser/database/users"
+	"path"
+	"strings"
+)
+
+// SavedSearch is a named query a user can open like a folder, its contents are
+// the current matches. Published searches are listed for every user but each user
+// only sees the matches inside their own scope.
+type SavedSearch struct {
+	ID       uint   `json:"id" storm:"id,increment"`
+	UserID   uint   `json:"userID" storm:"index"`
+	Name     string `json:"name"`
+	Source   string `json:"source"` // source name
+	Scope    string `json:"scope"`  // path inside the user's scope to search under
+	Query    string `json:"query"`
+	Public   bool   `json:"public" storm:"index"` // published by an admin to all users
+	Created  int64  `json:"created"`
+	Modified int64  `json:"modified"`
+}
+
+// VisibleTo reports whether the user can open the search.
+func (s *SavedSearch) VisibleTo(user *users.User) bool {
+	return s.Public || s.UserID == user.ID
+}
+
+// EditableBy reports whether the user can change or delete the search.
+func (s *SavedSearch) EditableBy(user *users.User) bool {
+	return s.UserID == user.ID || user.Permissions.Admin
+}
+
+// checkScope rejects scopes with ".." segments, which could point above the user's scope.
+func checkScope(scope string) (string, error) {
+	for _, segment := range strings.Split(scope, "/") {
+		if strings.TrimSpace(segment) == ".." {
+			return "", errors.ErrInvalidRequestParams
+		}
+	}
+	return path.Clean("/" + strings.TrimSpace(scope)), nil
+}
@@ -0,0 +1,139 @@
+package searches
+
+import (
+	"filebrowser/common/errors"
+	"filebrowser/database/users"
+	"filebrowser/indexing/iteminfo"
+	"sort"
+	"strings"
+	"time"
+)
+
+// StorageBackend is the interface to implement for a saved search storage.
+type StorageBackend interface {
+	Get(id uint) (*SavedSearch, error)
+	FindByUser(userID uint) ([]*SavedSearch, error)
+	FindPublic() ([]*SavedSearch, error)
+	Save(s *SavedSearch) error
+	Delete(id uint) error
+}
+
+// Storage is a storage.
+type Storage struct {
+	back StorageBackend
+}
+
+// NewStorage creates a saved search storage from a backend.
+func NewStorage(back StorageBackend) *Storage {
+	return &Storage{back: back}
+}
+
+// Get returns a saved search the user can open, ErrPermissionDenied for another user's private search.
+func (s *Storage) Get(user *users.User, id uint) (*SavedSearch, error) {
+	search, err := s.back.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if !search.VisibleTo(user) {
+		return nil, errors.ErrPermissionDenied
+	}
+	return search, nil
+}
+
+// List returns the searches of a user and the published ones, ordered by name.
+func (s *Storage) List(user *users.User) ([]*SavedSearch, error) {
+	own, err := s.back.FindByUser(user.ID)
+	if err != nil && err != errors.ErrNotExist {
+		return nil, err
+	}
+	public, err := s.back.FindPublic()
+	if err != nil && err != errors.ErrNotExist {
+		return nil, err
+	}
+	list := []*SavedSearch{}
+	seen := map[uint]bool{}
+	for _, search := range append(own, public...) {
+		if !seen[search.ID] {
+			seen[search.ID] = true
+			list = append(list, search)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !strings.EqualFold(list[i].Name, list[j].Name) {
+			return strings.ToLower(list[i].Name) < strings.ToLower(list[j].Name)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+// Save creates or updates a saved search. The query is validated, only admins can
+// publish searches or change searches of other users. The owner of an existing search is kept.
+func (s *Storage) Save(user *users.User, search *SavedSearch) error {
+	search.Name = strings.TrimSpace(search.Name)
+	if search.Name == "" || search.Source == "" {
+		return errors.ErrEmptyKey
+	}
+	if _, err := iteminfo.ParseQuery(strings.TrimSpace(search.Query)); err != nil {
+		return err
+	}
+	if search.Public && !user.Permissions.Admin {
+		return errors.ErrPermissionDenied
+	}
+	scope, err := checkScope(search.Scope)
+	if err != nil {
+		return err
+	}
+	search.Scope = scope
+	search.Modified = time.Now().Unix()
+	if search.ID == 0 {
+		search.UserID = user.ID
+		search.Created = search.Modified
+		return s.back.Save(search)
+	}
+	existing, err := s.back.Get(search.ID)
+	if err != nil {
+		return err
+	}
+	if !existing.EditableBy(user) {
+		return errors.ErrPermissionDenied
+	}
+	if existing.Public && !search.Public && !user.Permissions.Admin {
+		return errors.ErrPermissionDenied
+	}
+	search.UserID = existing.UserID
+	search.Created = existing.Created
+	return s.back.Save(search)
+}
+
+// Delete removes a saved search owned by the user, admins can delete any search.
+func (s *Storage) Delete(user *users.User, id uint) error {
+	search, err := s.back.Get(id)
+	if err != nil {
+		return err
+	}
+	if !search.EditableBy(user) {
+		return errors.ErrPermissionDenied
+	}
+	return s.back.Delete(id)
+}
+
+// DeleteByUser removes the private searches of a deleted user, published ones are kept.
+func (s *Storage) DeleteByUser(userID uint) error {
+	own, err := s.back.FindByUser(userID)
+	if err == errors.ErrNotExist {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, search := range own {
+		if search.Public {
+			continue
+		}
+		if err = s.back.Delete(search.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package searches
+
+import (
+	"filebrowser/common/errors"
+	"filebrowser/database/users"
+	"testing"
+)
+
+// memoryBackend keeps searches like the bolt backend, it returns copies and ErrNotExist
+// for missing searches.
+type memoryBackend map[uint]SavedSearch
+
+func (m memoryBackend) Get(id uint) (*SavedSearch, error) {
+	search, ok := m[id]
+	if !ok {
+		return nil, errors.ErrNotExist
+	}
+	return &search, nil
+}
+
+func (m memoryBackend) find(match func(s SavedSearch) bool) ([]*SavedSearch, error) {
+	list := []*SavedSearch{}
+	for _, search := range m {
+		if match(search) {
+			list = append(list, &search)
+		}
+	}
+	if len(list) == 0 {
+		return list, errors.ErrNotExist
+	}
+	return list, nil
+}
+
+func (m memoryBackend) FindByUser(userID uint) ([]*SavedSearch, error) {
+	return m.find(func(s SavedSearch) bool { return s.UserID == userID })
+}
+
+func (m memoryBackend) FindPublic() ([]*SavedSearch, error) {
+	return m.find(func(s SavedSearch) bool { return s.Public })
+}
+
+func (m memoryBackend) Save(s *SavedSearch) error {
+	if s.ID == 0 {
+		s.ID = uint(len(m) + 1)
+	}
+	m[s.ID] = *s
+	return nil
+}
+
+func (m memoryBackend) Delete(id uint) error {
+	delete(m, id)
+	return nil
+}
+
+func TestSaveScope(t *testing.T) {
+	s := NewStorage(memoryBackend{})
+	user := &users.User{ID: 1}
+	testCases := map[string]struct {
+		scope   string
+		want    string
+		wantErr error
+	}{
+		"empty":            {scope: "", want: "/"},
+		"root":             {scope: "/", want: "/"},
+		"relative":         {scope: "docs/reports/", want: "/docs/reports"},
+		"double slashes":   {scope: "//docs//reports", want: "/docs/reports"},
+		"dot":              {scope: "/docs/./reports", want: "/docs/reports"},
+		"dots in names":    {scope: "/docs/..reports", want: "/docs/..reports"},
+		"parent":           {scope: "..", wantErr: errors.ErrInvalidRequestParams},
+		"above the scope":  {scope: "/../bobby", wantErr: errors.ErrInvalidRequestParams},
+		"back out and in":  {scope: "/sub/../../bobby", wantErr: errors.ErrInvalidRequestParams},
+		"parent of a path": {scope: "/docs/..", wantErr: errors.ErrInvalidRequestParams},
+	}
+	for name, tt := range testCases {
+		t.Run(name, func(t *testing.T) {
+			search := &SavedSearch{Name: "reports", Source: "src", Scope: tt.scope, Query: "report"}
+			err := s.Save(user, search)
+			if err != tt.wantErr {
+				t.Fatalf("Save() = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && search.Scope != tt.want {
+				t.Errorf("scope = %q, want %q", search.Scope, tt.want)
+			}
+		})
+	}
+}
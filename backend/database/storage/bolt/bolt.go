@@ -3,19 +3,23 @@ package bolt
 import (
 	"filebrowser/auth"
 	"filebrowser/common/settings"
+	"filebrowser/database/searches"
 	"filebrowser/database/share"
+	"filebrowser/database/tags"
 	"filebrowser/database/users"
 
 	"github.com/asdine/storm/v3"
 )
 
-func NewStorage(db *storm.DB) (*auth.Storage, *users.Storage, *share.Storage, *settings.Storage, error) {
+func NewStorage(db *storm.DB) (*auth.Storage, *users.Storage, *share.Storage, *settings.Storage, *tags.Storage, *searches.Storage, error) {
 	userStore := users.NewStorage(usersBackend{db: db})
 	shareStore := share.NewStorage(shareBackend{db: db})
 	settingsStore := settings.NewStorage(settingsBackend{db: db})
+	tagStore := tags.NewStorage(tagsBackend{db: db})
+	searchStore := searches.NewStorage(searchesBackend{db: db})
 	authStore, err := auth.NewStorage(authBackend{db: db}, userStore)
 	if err != nil {
-		return nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, nil, err
 	}
-	return authStore, userStore, shareStore, settingsStore, nil
+	return authStore, userStore, shareStore, settingsStore, tagStore, searchStore, nil
 }
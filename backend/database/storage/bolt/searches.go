@@ -0,0 +1,51 @@
+package bolt
+
+import (
+	"filebrowser/common/errors"
+	"filebrowser/database/searches"
+
+	storm "github.com/asdine/storm/v3"
+)
+
+type searchesBackend struct {
+	db *storm.DB
+}
+
+func (s searchesBackend) Get(id uint) (*searches.SavedSearch, error) {
+	var v searches.SavedSearch
+	err := s.db.One("ID", id, &v)
+	if err == storm.ErrNotFound {
+		return nil, errors.ErrNotExist
+	}
+	return &v, err
+}
+
+func (s searchesBackend) FindByUser(userID uint) ([]*searches.SavedSearch, error) {
+	var v []*searches.SavedSearch
+	err := s.db.Find("UserID", userID, &v)
+	if err == storm.ErrNotFound {
+		return v, errors.ErrNotExist
+	}
+	return v, err
+}
+
+func (s searchesBackend) FindPublic() ([]*searches.SavedSearch, error) {
+	var v []*searches.SavedSearch
+	err := s.db.Find("Public", true, &v)
+	if err == storm.ErrNotFound {
+		return v, errors.ErrNotExist
+	}
+	return v, err
+}
+
+func (s searchesBackend) Save(search *searches.SavedSearch) error {
+	return s.db.Save(search)
+}
+
+func (s searchesBackend) Delete(id uint) error {
+	err := s.db.DeleteStruct(&searches.SavedSearch{ID: id})
+	if err == storm.ErrNotFound {
+		return nil
+	}
+	return err
+}
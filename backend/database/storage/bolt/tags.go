@@ -0,0 +1,81 @@
+package bolt
+
+import (
+	"filebrowser/common/errors"
+	"filebrowser/database/tags"
+
+	storm "github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/q"
+)
+
+type tagsBackend struct {
+	db *storm.DB
+}
+
+func (s tagsBackend) Get(id string) (*tags.Entry, error) {
+	var v tags.Entry
+	err := s.db.One("ID", id, &v)
+	if err == storm.ErrNotFound {
+		return nil, errors.ErrNotExist
+	}
+	return &v, err
+}
+
+func (s tagsBackend) FindByUser(userID uint, source string) ([]*tags.Entry, error) {
+	var v []*tags.Entry
+	err := s.db.Select(q.Eq("UserID", userID), q.Eq("Source", source)).Find(&v)
+	if err == storm.ErrNotFound {
+		return v, errors.ErrNotExist
+	}
+	return v, err
+}
+
+func (s tagsBackend) FindBySource(source string) ([]*tags.Entry, error) {
+	var v []*tags.Entry
+	err := s.db.Find("Source", source, &v)
+	if err == storm.ErrNotFound {
+		return v, errors.ErrNotExist
+	}
+	return v, err
+}
+
+func (s tagsBackend) FindAllByUser(userID uint) ([]*tags.Entry, error) {
+	var v []*tags.Entry
+	err := s.db.Find("UserID", userID, &v)
+	if err == storm.ErrNotFound {
+		return v, errors.ErrNotExist
+	}
+	return v, err
+}
+
+func (s tagsBackend) Save(e *tags.Entry) error {
+	return s.db.Save(e)
+}
+
+func (s tagsBackend) Delete(id string) error {
+	err := s.db.DeleteStruct(&tags.Entry{ID: id})
+	if err == storm.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+func (s tagsBackend) Replace(deleted []string, saved []*tags.Entry) error {
+	tx, err := s.db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+	for _, id := range deleted {
+		err = tx.DeleteStruct(&tags.Entry{ID: id})
+		if err != nil && err != storm.ErrNotFound {
+			return err
+		}
+	}
+	for _, entry := range saved {
+		if err = tx.Save(entry); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
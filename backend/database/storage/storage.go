@@ -3,8 +3,10 @@ package storage
 import (
 	"filebrowser/auth"
 	"filebrowser/common/settings"
+	"filebrowser/database/searches"
 	"filebrowser/database/share"
 	"filebrowser/database/storage/bolt"
+	"filebrowser/database/tags"
 	"filebrowser/database/users"
 	"os"
 	"path/filepath"
@@ -19,6 +21,8 @@ type Storage struct {
 	Share    *share.Storage
 	Auth     *auth.Storage
 	Settings *settings.Storage
+	Tags     *tags.Storage
+	Searches *searches.Storage
 }
 
 var storage *Storage
@@ -35,7 +39,7 @@ func InitializeDb(path string) (*Storage, bool, error) {
 		}
 		logger.Fatalf("could not open database: %v", err)
 	}
-	authStore, userStore, shareStore, settingsStore, err := bolt.NewStorage(db)
+	authStore, userStore, shareStore, settingsStore, tagStore, searchStore, err := bolt.NewStorage(db)
 	if err != nil {
 		return nil, exists, err
 	}
@@ -48,7 +52,18 @@ func InitializeDb(path string) (*Storage, bool, error) {
 		Users:    userStore,
 		Share:    shareStore,
 		Settings: settingsStore,
+		Tags:     tagStore,
+		Searches: searchStore,
 	}
+	tags.SetStorage(tagStore)
+	userStore.OnDelete(func(userID uint) {
+		if err := searchStore.DeleteByUser(userID); err != nil {
+			logger.Errorf("could not delete saved searches of user %v: %v", userID, err)
+		}
+		if err := tagStore.DeleteByUser(userID); err != nil {
+			logger.Errorf("could not delete tags of user %v: %v", userID, err)
+		}
+	})
 	if !exists {
 		quickSetup(store)
 	}
@@ -0,0 +1,316 @@
+package tags
+
+import (
+	"filebrowser/common/errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gtsteffaniak/go-logger/logger"
+)
+
+// StorageBackend is the interface to implement for a tag storage.
+type StorageBackend interface {
+	Get(id string) (*Entry, error)
+	FindByUser(userID uint, source string) ([]*Entry, error)
+	FindBySource(source string) ([]*Entry, error)
+	FindAllByUser(userID uint) ([]*Entry, error)
+	Save(e *Entry) error
+	Delete(id string) error
+	Replace(deleted []string, saved []*Entry) error // in a single transaction
+}
+
+// Storage is a storage.
+type Storage struct {
+	back StorageBackend
+	mu   sync.Mutex // serializes read, modify, write cycles
+}
+
+// store is kept in step with file operations, see SetStorage.
+var store *Storage
+
+// NewStorage creates a tag storage from a backend.
+func NewStorage(back StorageBackend) *Storage {
+	return &Storage{back: back}
+}
+
+// SetStorage sets the storage kept in step with file operations.
+func SetStorage(s *Storage) {
+	store = s
+}
+
+// Get returns the entry of a path, ErrNotExist when the user didn't tag it.
+func (s *Storage) Get(userID uint, source, path string) (*Entry, error) {
+	return s.back.Get(entryID(userID, source, path))
+}
+
+// List returns the tagged paths of a user in a source, ordered by path.
+func (s *Storage) List(userID uint, source string) ([]*Entry, error) {
+	entries, err := s.back.FindByUser(userID, source)
+	if err == errors.ErrNotExist {
+		return []*Entry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+	return entries, nil
+}
+
+// Labels returns every label of a user in a source with the number of paths using it.
+func (s *Storage) Labels(userID uint, source string) (map[string]int, error) {
+	entries, err := s.List(userID, source)
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]int{}
+	for _, entry := range entries {
+		for _, label := range entry.Labels {
+			counts[label]++
+		}
+	}
+	return counts, nil
+}
+
+// Favorites returns the paths a user marked as favourite in a source.
+func (s *Storage) Favorites(userID uint, source string) ([]*Entry, error) {
+	entries, err := s.List(userID, source)
+	if err != nil {
+		return nil, err
+	}
+	favorites := []*Entry{}
+	for _, entry := range entries {
+		if entry.Favorite {
+			favorites = append(favorites, entry)
+		}
+	}
+	return favorites, nil
+}
+
+// WithLabels returns the entries of a user that have every label.
+func (s *Storage) WithLabels(userID uint, source string, labels []string) ([]*Entry, error) {
+	entries, err := s.List(userID, source)
+	if err != nil {
+		return nil, err
+	}
+	matched := []*Entry{}
+	for _, entry := range entries {
+		all := true
+		for _, label := range labels {
+			if !entry.HasLabel(label) {
+				all = false
+				break
+			}
+		}
+		if all {
+			matched = append(matched, entry)
+		}
+	}
+	return matched, nil
+}
+
+// update applies fn to the entry of a path, creating it when needed. Entries left
+// without labels and favourite flag are deleted.
+func (s *Storage) update(userID uint, source, path string, fn func(e *Entry)) (*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := entryID(userID, source, path)
+	entry, err := s.back.Get(id)
+	if err == errors.ErrNotExist {
+		entry = &Entry{ID: id, UserID: userID, Source: source, Path: path, Labels: []string{}}
+	} else if err != nil {
+		return nil, err
+	}
+	fn(entry)
+	entry.Labels = cleanLabels(entry.Labels)
+	entry.Modified = time.Now().Unix()
+	if entry.IsEmpty() {
+		return entry, s.back.Delete(id)
+	}
+	return entry, s.back.Save(entry)
+}
+
+// AddLabels adds labels to a path, labels are compared case insensitively.
+func (s *Storage) AddLabels(userID uint, source, path string, labels ...string) (*Entry, error) {
+	return s.update(userID, source, path, func(e *Entry) {
+		e.Labels = append(e.Labels, labels...)
+	})
+}
+
+// SetLabels replaces the labels of a path.
+func (s *Storage) SetLabels(userID uint, source, path string, labels []string) (*Entry, error) {
+	return s.update(userID, source, path, func(e *Entry) {
+		e.Labels = labels
+	})
+}
+
+// RemoveLabels removes labels from a path.
+func (s *Storage) RemoveLabels(userID uint, source, path string, labels ...string) (*Entry, error) {
+	return s.update(userID, source, path, func(e *Entry) {
+		e.Labels = withoutLabels(e.Labels, labels)
+	})
+}
+
+// SetFavorite marks or unmarks a path as favourite.
+func (s *Storage) SetFavorite(userID uint, source, path string, favorite bool) (*Entry, error) {
+	return s.update(userID, source, path, func(e *Entry) {
+		e.Favorite = favorite
+	})
+}
+
+// RenameLabel renames a label on every path of a user in a source.
+func (s *Storage) RenameLabel(userID uint, source, label, newLabel string) error {
+	newLabel = strings.TrimSpace(newLabel)
+	if newLabel == "" {
+		return errors.ErrEmptyKey
+	}
+	return s.eachWithLabel(userID, source, label, func(e *Entry) {
+		e.Labels = append(withoutLabels(e.Labels, []string{label}), newLabel)
+	})
+}
+
+// DeleteLabel removes a label from every path of a user in a source.
+func (s *Storage) DeleteLabel(userID uint, source, label string) error {
+	return s.eachWithLabel(userID, source, label, func(e *Entry) {
+		e.Labels = withoutLabels(e.Labels, []string{label})
+	})
+}
+
+func (s *Storage) eachWithLabel(userID uint, source, label string, fn func(e *Entry)) error {
+	entries, err := s.WithLabels(userID, source, []string{label})
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if _, err = s.update(userID, source, entry.Path, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Move points the entries of a path and everything below it to the new location,
+// for all users. The destination may be in another source.
+func (s *Storage) Move(source, path, destSource, destPath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := s.inside(source, path)
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+	deleted := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		deleted = append(deleted, entry.ID)
+		entry.Source = destSource
+		entry.Path = destPath + strings.TrimPrefix(entry.Path, path)
+		entry.ID = entryID(entry.UserID, entry.Source, entry.Path)
+	}
+	return s.back.Replace(deleted, entries)
+}
+
+// Delete removes the entries of a path and everything below it, for all users.
+func (s *Storage) Delete(source, path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := s.inside(source, path)
+	if err != nil {
+		return err
+	}
+	return s.deleteEntries(entries)
+}
+
+// DeleteByUser removes the entries of a deleted user in all sources.
+func (s *Storage) DeleteByUser(userID uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := s.back.FindAllByUser(userID)
+	if err == errors.ErrNotExist {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.deleteEntries(entries)
+}
+
+func (s *Storage) deleteEntries(entries []*Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	deleted := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		deleted = append(deleted, entry.ID)
+	}
+	return s.back.Replace(deleted, nil)
+}
+
+func (s *Storage) inside(source, path string) ([]*Entry, error) {
+	entries, err := s.back.FindBySource(source)
+	if err == errors.ErrNotExist {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	inside := []*Entry{}
+	for _, entry := range entries {
+		if entry.within(path) {
+			inside = append(inside, entry)
+		}
+	}
+	return inside, nil
+}
+
+func withoutLabels(labels, remove []string) []string {
+	kept := []string{}
+	for _, label := range labels {
+		removed := false
+		for _, r := range remove {
+			if strings.EqualFold(label, strings.TrimSpace(r)) {
+				removed = true
+				break
+			}
+		}
+		if !removed {
+			kept = append(kept, label)
+		}
+	}
+	return kept
+}
+
+// PathMoved keeps tags in step with a moved or renamed file or folder.
+func PathMoved(source, path, destSource, destPath string) {
+	if store == nil {
+		return
+	}
+	if err := store.Move(source, path, destSource, destPath); err != nil {
+		logger.Errorf("could not move tags of %v to %v: %v", path, destPath, err)
+	}
+}
+
+// PathDeleted drops the tags of a deleted file or folder.
+func PathDeleted(source, path string) {
+	if store == nil {
+		return
+	}
+	if err := store.Delete(source, path); err != nil {
+		logger.Errorf("could not delete tags of %v: %v", path, err)
+	}
+}
+
+// TaggedPaths returns the index paths a user gave every label, used by tag: searches.
+func (s *Storage) TaggedPaths(userID uint, source string, labels []string) map[string]struct{} {
+	paths := map[string]struct{}{}
+	entries, err := s.WithLabels(userID, source, labels)
+	if err != nil {
+		logger.Errorf("could not read tags for search: %v", err)
+		return paths
+	}
+	for _, entry := range entries {
+		paths[entry.Path] = struct{}{}
+	}
+	return paths
+}
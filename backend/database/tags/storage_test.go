@@ -0,0 +1,237 @@
+package tags
+
+import (
+	"filebrowser/common/errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// memoryBackend keeps entries like the bolt backend, it returns copies and ErrNotExist
+// for empty results.
+type memoryBackend map[string]Entry
+
+func (m memoryBackend) Get(id string) (*Entry, error) {
+	entry, ok := m[id]
+	if !ok {
+		return nil, errors.ErrNotExist
+	}
+	return &entry, nil
+}
+
+func (m memoryBackend) find(match func(e Entry) bool) ([]*Entry, error) {
+	entries := []*Entry{}
+	for _, entry := range m {
+		if match(entry) {
+			entries = append(entries, &entry)
+		}
+	}
+	if len(entries) == 0 {
+		return entries, errors.ErrNotExist
+	}
+	return entries, nil
+}
+
+func (m memoryBackend) FindByUser(userID uint, source string) ([]*Entry, error) {
+	return m.find(func(e Entry) bool { return e.UserID == userID && e.Source == source })
+}
+
+func (m memoryBackend) FindBySource(source string) ([]*Entry, error) {
+	return m.find(func(e Entry) bool { return e.Source == source })
+}
+
+func (m memoryBackend) FindAllByUser(userID uint) ([]*Entry, error) {
+	return m.find(func(e Entry) bool { return e.UserID == userID })
+}
+
+func (m memoryBackend) Save(e *Entry) error {
+	m[e.ID] = *e
+	return nil
+}
+
+func (m memoryBackend) Delete(id string) error {
+	delete(m, id)
+	return nil
+}
+
+func (m memoryBackend) Replace(deleted []string, saved []*Entry) error {
+	for _, id := range deleted {
+		delete(m, id)
+	}
+	for _, e := range saved {
+		m[e.ID] = *e
+	}
+	return nil
+}
+
+// entryPaths lists source:path of every stored entry, sorted.
+func entryPaths(m memoryBackend) []string {
+	paths := []string{}
+	for _, entry := range m {
+		paths = append(paths, entry.Source+":"+entry.Path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestLabels(t *testing.T) {
+	back := memoryBackend{}
+	s := NewStorage(back)
+	entry, err := s.AddLabels(1, "src", "/a.txt", " Work ", "work", "", "urgent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(entry.Labels, []string{"Work", "urgent"}) {
+		t.Errorf("labels = %q, want trimmed labels without duplicates", entry.Labels)
+	}
+	if _, err = s.AddLabels(1, "src", "/b.txt", "urgent"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.SetFavorite(2, "src", "/a.txt", true); err != nil {
+		t.Fatal(err)
+	}
+
+	counts, err := s.Labels(1, "src")
+	if err != nil || !reflect.DeepEqual(counts, map[string]int{"Work": 1, "urgent": 2}) {
+		t.Errorf("Labels = %v, %v", counts, err)
+	}
+	if got := s.TaggedPaths(1, "src", []string{"URGENT"}); len(got) != 2 {
+		t.Errorf("TaggedPaths(URGENT) = %v, want both files", got)
+	}
+	if got := s.TaggedPaths(1, "src", []string{"urgent", "work"}); !reflect.DeepEqual(got, map[string]struct{}{"/a.txt": {}}) {
+		t.Errorf("TaggedPaths(urgent, work) = %v, want /a.txt", got)
+	}
+	if got := s.TaggedPaths(2, "src", []string{"urgent"}); len(got) != 0 {
+		t.Errorf("labels of another user matched %v", got)
+	}
+	favorites, err := s.Favorites(2, "src")
+	if err != nil || len(favorites) != 1 || favorites[0].Path != "/a.txt" {
+		t.Errorf("Favorites = %v, %v, want /a.txt", favorites, err)
+	}
+
+	if err = s.RenameLabel(1, "src", "urgent", "Later"); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.TaggedPaths(1, "src", []string{"later"}); len(got) != 2 {
+		t.Errorf("renamed label is on %v, want both files", got)
+	}
+	if err = s.RenameLabel(1, "src", "later", " "); err != errors.ErrEmptyKey {
+		t.Errorf("renaming to an empty label = %v, want %v", err, errors.ErrEmptyKey)
+	}
+	// entries left without labels and favourite flag are dropped
+	if err = s.DeleteLabel(1, "src", "LATER"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.RemoveLabels(1, "src", "/a.txt", "work"); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := s.List(1, "src"); err != nil || len(entries) != 0 {
+		t.Errorf("List = %v, %v, want no entries", entries, err)
+	}
+	if len(back) != 1 {
+		t.Errorf("backend keeps %v entries, want the favourite of user 2", len(back))
+	}
+}
+
+func TestMoveAndDelete(t *testing.T) {
+	back := memoryBackend{}
+	s := NewStorage(back)
+	for _, path := range []string{"/docs", "/docs/a.txt", "/docs/sub/b.txt", "/docs2/c.txt", "/other.txt"} {
+		for _, userID := range []uint{1, 2} {
+			if _, err := s.AddLabels(userID, "src", path, "label"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := s.AddLabels(1, "other", "/docs/a.txt", "label"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a folder moves with everything below it, but not a sibling sharing the prefix
+	if err := s.Move("src", "/docs", "dest", "/archive/docs"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"dest:/archive/docs", "dest:/archive/docs", "dest:/archive/docs/a.txt", "dest:/archive/docs/a.txt",
+		"dest:/archive/docs/sub/b.txt", "dest:/archive/docs/sub/b.txt", "other:/docs/a.txt",
+		"src:/docs2/c.txt", "src:/docs2/c.txt", "src:/other.txt", "src:/other.txt",
+	}
+	if got := entryPaths(back); !reflect.DeepEqual(got, want) {
+		t.Errorf("after Move entries = %v, want %v", got, want)
+	}
+	entry, err := s.Get(2, "dest", "/archive/docs/sub/b.txt")
+	if err != nil || entry.ID != entryID(2, "dest", "/archive/docs/sub/b.txt") {
+		t.Errorf("moved entry = %+v, %v, want it stored under its new id", entry, err)
+	}
+
+	if err = s.Delete("dest", "/archive/docs/sub"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Delete("src", "/other.txt"); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{
+		"dest:/archive/docs", "dest:/archive/docs", "dest:/archive/docs/a.txt", "dest:/archive/docs/a.txt",
+		"other:/docs/a.txt", "src:/docs2/c.txt", "src:/docs2/c.txt",
+	}
+	if got := entryPaths(back); !reflect.DeepEqual(got, want) {
+		t.Errorf("after Delete entries = %v, want %v", got, want)
+	}
+	// the root holds everything of a source, sources without entries are no error
+	if err = s.Delete("dest", "/"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Move("missing", "/", "dest", "/"); err != nil {
+		t.Errorf("moving a source without entries = %v", err)
+	}
+	if got := entryPaths(back); !reflect.DeepEqual(got, []string{"other:/docs/a.txt", "src:/docs2/c.txt", "src:/docs2/c.txt"}) {
+		t.Errorf("after deleting the root entries = %v", got)
+	}
+}
+
+func TestDeleteByUser(t *testing.T) {
+	back := memoryBackend{}
+	s := NewStorage(back)
+	for _, source := range []string{"src", "other"} {
+		for _, userID := range []uint{1, 2} {
+			if _, err := s.SetFavorite(userID, source, "/a.txt", true); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := s.DeleteByUser(1); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range back {
+		if entry.UserID == 1 {
+			t.Errorf("entry %v of the deleted user was kept", entry.Source+":"+entry.Path)
+		}
+	}
+	if len(back) != 2 {
+		t.Errorf("%v entries left, want the 2 of the other user", len(back))
+	}
+	if err := s.DeleteByUser(1); err != nil {
+		t.Errorf("deleting a user without entries = %v", err)
+	}
+}
+
+func TestWithin(t *testing.T) {
+	testCases := []struct {
+		entry, path string
+		want        bool
+	}{
+		{"/docs", "/docs", true},
+		{"/docs/a.txt", "/docs", true},
+		{"/docs/a.txt", "/docs/", true},
+		{"/docs2/a.txt", "/docs", false},
+		{"/doc", "/docs", false},
+		{"/docs", "/docs/a.txt", false},
+		{"/a.txt", "/", true},
+		{"/", "/", true},
+	}
+	for _, tt := range testCases {
+		if got := (&Entry{Path: tt.entry}).within(tt.path); got != tt.want {
+			t.Errorf("%q within %q = %v, want %v", tt.entry, tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package tags
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Entry holds the labels and favourite flag a user gave to a file or folder.
+// Nothing is written to the file itself, entries follow the path on moves and are
+// removed with it.
+type Entry struct {
+	ID       string   `json:"id" storm:"id"` // user, source and path
+	UserID   uint     `json:"userID" storm:"index"`
+	Source   string   `json:"source" storm:"index"`
+	Path     string   `json:"path"` // index path of the file or folder
+	Labels   []string `json:"labels"`
+	Favorite bool     `json:"favorite"`
+	Modified int64    `json:"modified"`
+}
+
+func entryID(userID uint, source, path string) string {
+	return fmt.Sprintf("%d\x00%s\x00%s", userID, source, path)
+}
+
+// IsEmpty reports whether the entry can be dropped.
+func (e *Entry) IsEmpty() bool {
+	return len(e.Labels) == 0 && !e.Favorite
+}
+
+// HasLabel compares labels case insensitively.
+func (e *Entry) HasLabel(label string) bool {
+	for _, existing := range e.Labels {
+		if strings.EqualFold(existing, label) {
+			return true
+		}
+	}
+	return false
+}
+
+// within reports whether the entry is path itself or inside it.
+func (e *Entry) within(path string) bool {
+	return e.Path == path || strings.HasPrefix(e.Path, strings.TrimSuffix(path, "/")+"/")
+}
+
+// cleanLabels trims labels and drops empty and duplicate ones, keeping the first spelling.
+func cleanLabels(labels []string) []string {
+	cleaned := []string{}
+	seen := map[string]bool{}
+	for _, label := range labels {
+		label = strings.TrimSpace(label)
+		key := strings.ToLower(label)
+		if label == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		cleaned = append(cleaned, label)
+	}
+	return cleaned
+}
@@ -106,16 +106,42 @@ func (s *Storage) Delete(id interface{}) error {
 		if user.ID == 1 {
 			return errors.ErrRootUserDeletion
 		}
-		return s.back.DeleteByUsername(id)
+		if err = s.back.DeleteByUsername(id); err != nil {
+			return err
+		}
+		s.deleted(user.ID)
+		return nil
 	case uint:
 		if id == 1 {
 			return errors.ErrRootUserDeletion
 		}
-		return s.back.DeleteByID(id)
+		if err := s.back.DeleteByID(id); err != nil {
+			return err
+		}
+		s.deleted(id)
+		return nil
 	default:
 		return errors.ErrInvalidDataType
 	}
 }
+
+// OnDelete registers a function called after a user was deleted, to drop what other
+// storages keep for the user.
+func (s *Storage) OnDelete(fn func(userID uint)) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.onDelete = append(s.onDelete, fn)
+}
+
+func (s *Storage) deleted(userID uint) {
+	s.mux.RLock()
+	callbacks := s.onDelete
+	s.mux.RUnlock()
+	for _, fn := range callbacks {
+		fn(userID)
+	}
+}
+
 func (s *Storage) LastUpdate(id uint) int64 {
 	s.mux.RLock()
 	defer s.mux.RUnlock()
@@ -89,9 +89,10 @@ type Preview struct {
 	PopUp              bool `json:"popup"`              // show larger popup preview when hovering
 }
 type Storage struct {
-	back    StorageBackend
-	updated map[uint]int64
-	mux     sync.RWMutex
+	back     StorageBackend
+	updated  map[uint]int64
+	mux      sync.RWMutex
+	onDelete []func(userID uint) // see OnDelete
 }
 
 func CleanUsername(s string) string {
@@ -1,6 +1,7 @@
 package events
 
 import (
+	"filebrowser/common/metrics"
 	"sync"
 )
 
@@ -48,6 +49,7 @@ func handleUserEvents() {
 				select {
 				case ch <- ue.event:
 				default:
+					metrics.EventsDropped.Inc("user")
 				}
 			}
 		}
@@ -70,6 +72,8 @@ func Register(username string, sources []string) chan EventMessage {
 	}
 	sourceClientsMu.Unlock()
 
+	metrics.EventClients.Add(1)
+	metrics.EventClientsRegistered.Inc()
 	return ch
 }
 
@@ -96,6 +100,7 @@ func Unregister(username string, ch chan EventMessage) {
 	}
 	sourceClientsMu.Unlock()
 
+	metrics.EventClients.Add(-1)
 	close(ch)
 }
 
@@ -116,6 +121,17 @@ func SendSourceUpdate(source string, message string) {
 	}
 }
 
+// SendSourceFileChange notifies clients of a source about a file or folder change.
+func SendSourceFileChange(source string, message string) {
+	sourceUpdateChan <- sourceEvent{
+		source: source,
+		event: EventMessage{
+			EventType: "fileChange",
+			Message:   message,
+		},
+	}
+}
+
 func DebouncedBroadcast(eventType, message string) {
 	debounceInputChan <- EventMessage{
 		EventType: eventType,
@@ -133,7 +149,7 @@ func handleSourceUpdates() {
 			select {
 			case ch <- update.event:
 			default:
-				// Optional: log dropped message
+				metrics.EventsDropped.Inc("source")
 			}
 		}
 	}
@@ -3,25 +3,28 @@ module filebrowser
 go 1.24.4
 
 require (
+	github.com/asdine/storm/v3 v3.2.1
+	github.com/bmatcuk/doublestar/v4 v4.9.1
 	github.com/coreos/go-oidc/v3 v3.14.1
+	github.com/fsnotify/fsnotify v1.9.0
 	github.com/goccy/go-yaml v1.18.0
 	github.com/golang-jwt/jwt/v4 v4.5.2
+	github.com/gtsteffaniak/go-cache v0.0.0-20250521142451-edc77dfcb063
 	github.com/gtsteffaniak/go-logger v0.1.2
+	github.com/pquerna/otp v1.5.0
+	github.com/robfig/cron/v3 v3.0.1
+	github.com/shirou/gopsutil/v3 v3.24.5
+	golang.org/x/crypto v0.36.0
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
-	github.com/asdine/storm/v3 v3.2.1 // indirect
 	github.com/boombuler/barcode v1.0.1-0.20190219062509-6c824513bacc // indirect
 	github.com/go-jose/go-jose/v4 v4.0.5 // indirect
 	github.com/go-ole/go-ole v1.2.6 // indirect
-	github.com/gtsteffaniak/go-cache v0.0.0-20250521142451-edc77dfcb063 // indirect
 	github.com/power-devops/perfstat v0.0.0-20210106213030-5aafc221ea8c // indirect
-	github.com/pquerna/otp v1.5.0 // indirect
-	github.com/shirou/gopsutil/v3 v3.24.5 // indirect
 	github.com/yusufpapurcu/wmi v1.2.4 // indirect
 	go.etcd.io/bbolt v1.3.4 // indirect
-	golang.org/x/crypto v0.36.0 // indirect
 	golang.org/x/oauth2 v0.28.0 // indirect
 	golang.org/x/sys v0.31.0 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
@@ -0,0 +1,358 @@
+package indexing
+
+import (
+	"bufio"
+	"filebrowser/common/settings"
+	"filebrowser/indexing/iteminfo"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gtsteffaniak/go-logger/logger"
+)
+
+const (
+	defaultContentMaxFileSizeKB = 1024
+	defaultContentMaxMemoryMB   = 256
+	defaultContentMaxDiskMB     = 512
+	// number of matching lines returned per search result
+	maxContentSnippets = 3
+	// matching lines are trimmed to this many characters
+	maxSnippetLength = 200
+	// rough per entry overhead of the maps used for postings, used to estimate memory
+	contentPostingOverhead = 48
+)
+
+// contentDoc is the indexed state of a single file, it is persisted with the index snapshot.
+type contentDoc struct {
+	ModTime time.Time
+	Size    int64
+	Tokens  []string
+}
+
+// docDirs groups the documents of an index by the directory holding them, so a directory
+// update only visits its own files.
+type docDirs map[string]map[string]struct{} // directory index path -> index paths
+
+func (d docDirs) add(indexPath string) {
+	dir := path.Dir(indexPath)
+	paths, ok := d[dir]
+	if !ok {
+		paths = make(map[string]struct{})
+		d[dir] = paths
+	}
+	paths[indexPath] = struct{}{}
+}
+
+func (d docDirs) remove(indexPath string) {
+	dir := path.Dir(indexPath)
+	delete(d[dir], indexPath)
+	if len(d[dir]) == 0 {
+		delete(d, dir)
+	}
+}
+
+// contentIndex is an inverted index over the contents of text files in a source.
+type contentIndex struct {
+	maxFileSize int64
+	maxMemory   int64
+	maxDisk     int64
+	docs        map[string]*contentDoc         // index path -> doc
+	dirs        docDirs                        // directory -> index paths of its docs
+	postings    map[string]map[string]struct{} // token -> index paths
+	memory      int64                          // estimated memory used by docs and postings
+	full        bool                           // memory limit reached, new files are skipped
+	mu          sync.RWMutex
+}
+
+func newContentIndex(config settings.ContentIndexConfig) *contentIndex {
+	c := &contentIndex{
+		maxFileSize: config.MaxFileSizeKB * 1024,
+		maxMemory:   config.MaxMemoryMB * 1024 * 1024,
+		maxDisk:     config.MaxDiskMB * 1024 * 1024,
+		docs:        make(map[string]*contentDoc),
+		dirs:        make(docDirs),
+		postings:    make(map[string]map[string]struct{}),
+	}
+	if c.maxFileSize <= 0 {
+		c.maxFileSize = defaultContentMaxFileSizeKB * 1024
+	}
+	if c.maxMemory <= 0 {
+		c.maxMemory = defaultContentMaxMemoryMB * 1024 * 1024
+	}
+	if c.maxDisk <= 0 {
+		c.maxDisk = defaultContentMaxDiskMB * 1024 * 1024
+	}
+	return c
+}
+
+// tokenize splits text into lowercase words, callers dedupe as needed.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func docMemory(path string, tokens []string) int64 {
+	size := int64(len(path)) + contentPostingOverhead
+	for _, token := range tokens {
+		size += int64(len(token)) + contentPostingOverhead
+	}
+	return size
+}
+
+// readTokens returns the unique tokens of a file.
+func readTokens(realPath string, maxSize int64) ([]string, error) {
+	file, err := os.Open(realPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	seen := map[string]struct{}{}
+	tokens := []string{}
+	scanner := bufio.NewScanner(io.LimitReader(file, maxSize))
+	scanner.Buffer(make([]byte, 64*1024), int(maxSize)+1)
+	for scanner.Scan() {
+		for _, token := range tokenize(scanner.Text()) {
+			if len(token) < 2 {
+				continue
+			}
+			if _, ok := seen[token]; ok {
+				continue
+			}
+			seen[token] = struct{}{}
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, scanner.Err()
+}
+
+// set stores the tokens of a document, must be called with c.mu held.
+func (c *contentIndex) set(indexPath string, doc *contentDoc) bool {
+	c.remove(indexPath)
+	size := docMemory(indexPath, doc.Tokens)
+	if c.memory+size > c.maxMemory {
+		if !c.full {
+			logger.Warningf("content index memory limit reached, %v and further files are not content searchable", indexPath)
+		}
+		c.full = true
+		return false
+	}
+	c.docs[indexPath] = doc
+	c.dirs.add(indexPath)
+	c.memory += size
+	for _, token := range doc.Tokens {
+		paths, ok := c.postings[token]
+		if !ok {
+			paths = make(map[string]struct{})
+			c.postings[token] = paths
+		}
+		paths[indexPath] = struct{}{}
+	}
+	return true
+}
+
+// remove drops a document, must be called with c.mu held.
+func (c *contentIndex) remove(indexPath string) {
+	doc, ok := c.docs[indexPath]
+	if !ok {
+		return
+	}
+	for _, token := range doc.Tokens {
+		paths := c.postings[token]
+		delete(paths, indexPath)
+		if len(paths) == 0 {
+			delete(c.postings, token)
+		}
+	}
+	delete(c.docs, indexPath)
+	c.dirs.remove(indexPath)
+	c.memory -= docMemory(indexPath, doc.Tokens)
+	c.full = false
+}
+
+// removeDir drops the documents of files in the given directory, subdirectories are
+// removed on their own.
+func (c *contentIndex) removeDir(dirPath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for path := range c.dirs[dirPath] {
+		c.remove(path)
+	}
+}
+
+// candidates returns the index paths containing every token of the given terms.
+func (c *contentIndex) candidates(terms []string) map[string]struct{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var result map[string]struct{}
+	for _, term := range terms {
+		for _, token := range tokenize(term) {
+			paths := c.postings[token]
+			next := make(map[string]struct{})
+			for path := range paths {
+				if _, ok := result[path]; result == nil || ok {
+					next[path] = struct{}{}
+				}
+			}
+			result = next
+		}
+	}
+	if result == nil {
+		result = map[string]struct{}{}
+	}
+	return result
+}
+
+func (c *contentIndex) isIndexable(item iteminfo.ItemInfo) bool {
+	return item.Size <= c.maxFileSize && iteminfo.IsText(strings.ToLower(filepath.Ext(item.Name)))
+}
+
+// updateContentIndex reindexes text files of a freshly indexed directory that changed
+// since they were last read, and forgets files that are no longer there.
+func (idx *Index) updateContentIndex(dir *iteminfo.FileInfo) {
+	c := idx.content
+	if c == nil || dir == nil {
+		return
+	}
+	prefix := strings.TrimSuffix(dir.Path, "/") + "/"
+	present := make(map[string]struct{}, len(dir.Files))
+	for _, item := range dir.Files {
+		if !c.isIndexable(item) {
+			continue
+		}
+		indexPath := prefix + item.Name
+		present[indexPath] = struct{}{}
+		c.mu.RLock()
+		doc, ok := c.docs[indexPath]
+		c.mu.RUnlock()
+		if ok && doc.ModTime.Equal(item.ModTime) && doc.Size == item.Size {
+			continue
+		}
+		idx.indexContent(indexPath, item)
+	}
+	// drop files of this directory that were removed or are no longer indexable
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for path := range c.dirs[dir.Path] {
+		if _, ok := present[path]; !ok {
+			c.remove(path)
+		}
+	}
+}
+
+func (idx *Index) indexContent(indexPath string, item iteminfo.ItemInfo) {
+	c := idx.content
+	realPath := strings.TrimRight(idx.Path, "/") + indexPath
+	tokens, err := readTokens(realPath, c.maxFileSize)
+	if err != nil {
+		logger.Debugf("could not index content of %v: %v", realPath, err)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(indexPath, &contentDoc{ModTime: item.ModTime, Size: item.Size, Tokens: tokens})
+}
+
+// ReindexContent forces the content of a file to be read again, even if its
+// size and modification time did not change.
+func (idx *Index) ReindexContent(indexPath string) {
+	if idx.content == nil {
+		return
+	}
+	info, exists := idx.GetReducedMetadata(indexPath, false)
+	if !exists || !idx.content.isIndexable(info.ItemInfo) {
+		return
+	}
+	idx.indexContent(info.Path, info.ItemInfo)
+}
+
+// contentSnippets returns up to maxContentSnippets lines of the file that contain one of the terms.
+func (idx *Index) contentSnippets(indexPath string, terms []string) []string {
+	snippets := []string{}
+	file, err := os.Open(strings.TrimRight(idx.Path, "/") + indexPath)
+	if err != nil {
+		return snippets
+	}
+	defer file.Close()
+	lowerTerms := make([]string, len(terms))
+	for i, term := range terms {
+		lowerTerms[i] = strings.ToLower(term)
+	}
+	scanner := bufio.NewScanner(io.LimitReader(file, idx.content.maxFileSize))
+	scanner.Buffer(make([]byte, 64*1024), int(idx.content.maxFileSize)+1)
+	for scanner.Scan() && len(snippets) < maxContentSnippets {
+		line := scanner.Text()
+		lowerLine := strings.ToLower(line)
+		for _, term := range lowerTerms {
+			pos := strings.Index(lowerLine, term)
+			if pos == -1 {
+				continue
+			}
+			snippets = append(snippets, trimSnippet(line, pos, len(term)))
+			break
+		}
+	}
+	return snippets
+}
+
+// trimSnippet shortens a line around the match at pos.
+func trimSnippet(line string, pos, length int) string {
+	line = strings.TrimSpace(line)
+	if len(line) <= maxSnippetLength {
+		return line
+	}
+	start := pos - (maxSnippetLength-length)/2
+	if start < 0 {
+		start = 0
+	}
+	end := start + maxSnippetLength
+	if end > len(line) {
+		end = len(line)
+		start = end - maxSnippetLength
+	}
+	// avoid cutting multi-byte characters in half
+	for start > 0 && !isRuneStart(line[start]) {
+		start--
+	}
+	for end < len(line) && !isRuneStart(line[end]) {
+		end++
+	}
+	return strings.TrimSpace(line[start:end])
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+// persistedDocs returns the documents to store with the index snapshot,
+// nil if they would exceed the configured disk limit.
+func (c *contentIndex) persistedDocs() map[string]*contentDoc {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.memory > c.maxDisk {
+		logger.Warningf("content index exceeds the disk limit, it will be rebuilt on the next start")
+		return nil
+	}
+	docs := make(map[string]*contentDoc, len(c.docs))
+	for path, doc := range c.docs {
+		docs[path] = doc
+	}
+	return docs
+}
+
+// restore loads documents from a snapshot.
+func (c *contentIndex) restore(docs map[string]*contentDoc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for path, doc := range docs {
+		if !c.set(path, doc) {
+			return
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package indexing
+
+import (
+	"filebrowser/common/settings"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func newContentTestIndex(t *testing.T, name string, files map[string]string) (*Index, string) {
+	t.Helper()
+	root := t.TempDir()
+	for path, content := range files {
+		writeTestFile(t, filepath.Join(root, path), content)
+	}
+	idx := newTestIndex(root, 1)
+	idx.Name = name
+	idx.content = newContentIndex(settings.ContentIndexConfig{})
+	if err := idx.indexDirectory("/", fullScan, true); err != nil {
+		t.Fatal(err)
+	}
+	return idx, root
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func contentCandidates(idx *Index, term string) []string {
+	paths := []string{}
+	for path := range idx.content.candidates([]string{term}) {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestContentIndexUpdates(t *testing.T) {
+	idx, root := newContentTestIndex(t, "content-updates", map[string]string{
+		"notes.txt":        "the quick brown fox",
+		"docs/a.txt":       "brown bear",
+		"docs/sub/b.txt":   "Brown, sugar",
+		"docs2/c.txt":      "brown paper",
+		"docs/picture.png": "brown",
+	})
+	want := []string{"/docs/a.txt", "/docs/sub/b.txt", "/docs2/c.txt", "/notes.txt"}
+	if got := contentCandidates(idx, "brown"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("candidates(brown) = %v, want %v", got, want)
+	}
+	if got := contentCandidates(idx, "brown fox"); !reflect.DeepEqual(got, []string{"/notes.txt"}) {
+		t.Errorf("candidates(brown fox) = %v, want [/notes.txt]", got)
+	}
+
+	// changed and removed files of a directory are picked up when it is indexed again
+	writeTestFile(t, filepath.Join(root, "docs", "a.txt"), "black bear")
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(root, "docs", "a.txt"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(root, "notes.txt")); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{"/docs", "/"} {
+		if err := idx.indexDirectory(dir, directRead, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want = []string{"/docs/sub/b.txt", "/docs2/c.txt"}
+	if got := contentCandidates(idx, "brown"); !reflect.DeepEqual(got, want) {
+		t.Errorf("candidates(brown) after update = %v, want %v", got, want)
+	}
+	if got := contentCandidates(idx, "black"); !reflect.DeepEqual(got, []string{"/docs/a.txt"}) {
+		t.Errorf("candidates(black) = %v, want [/docs/a.txt]", got)
+	}
+
+	// removing a directory drops its subdirectories, but not siblings sharing the prefix
+	idx.removeDirectory("/docs")
+	if got := contentCandidates(idx, "brown"); !reflect.DeepEqual(got, []string{"/docs2/c.txt"}) {
+		t.Errorf("candidates(brown) after removal = %v, want [/docs2/c.txt]", got)
+	}
+	if len(idx.content.docs) != 1 || len(idx.content.dirs) != 1 {
+		t.Errorf("content index keeps %v docs in %v dirs, want 1 in 1", len(idx.content.docs), len(idx.content.dirs))
+	}
+}
+
+func TestContentIndexMemoryLimit(t *testing.T) {
+	c := newContentIndex(settings.ContentIndexConfig{})
+	c.maxMemory = docMemory("/a.txt", []string{"one", "two"})
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.set("/a.txt", &contentDoc{Tokens: []string{"one", "two"}}) {
+		t.Fatal("first document should fit")
+	}
+	if c.set("/b.txt", &contentDoc{Tokens: []string{"three"}}) || !c.full {
+		t.Fatal("second document should exceed the limit")
+	}
+	c.remove("/a.txt")
+	if c.full || c.memory != 0 || len(c.postings) != 0 {
+		t.Errorf("after removal full=%v memory=%v postings=%v, want an empty index", c.full, c.memory, len(c.postings))
+	}
+}
+
+func TestContentSearch(t *testing.T) {
+	idx, _ := newContentTestIndex(t, "content-search", map[string]string{
+		"users/bob/todo.txt":   "buy milk\nwalk the dog\nMILK again",
+		"users/bob/recipe.md":  "flour and water",
+		"users/alice/milk.txt": "milk",
+		"users/bob/milk.bin":   "milk",
+	})
+	testCases := map[string]struct {
+		scope, query string
+		want         []string
+		snippets     []string
+	}{
+		"content term":      {"/users/bob", "content:milk", []string{"/todo.txt"}, []string{"buy milk", "MILK again"}},
+		"combined with ext": {"/users", "content:milk ext:txt", []string{"/alice/milk.txt", "/bob/todo.txt"}, nil},
+		"negated content":   {"/users/bob", "-content:milk ext:md", []string{"/recipe.md"}, nil},
+		"quoted phrase":     {"/users/bob", `content:"walk the"`, []string{"/todo.txt"}, nil},
+		"no match":          {"/users/bob", "content:bread", []string{}, nil},
+	}
+	for name, tt := range testCases {
+		t.Run(name, func(t *testing.T) {
+			response, err := idx.Search(1, nil, tt.scope, tt.query, 0, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, result := range response.Results {
+				got = append(got, result.Path)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Search(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+			if tt.snippets != nil && !reflect.DeepEqual(response.Results[0].Snippets, tt.snippets) {
+				t.Errorf("snippets = %q, want %q", response.Results[0].Snippets, tt.snippets)
+			}
+		})
+	}
+
+	idx.content = nil
+	response, err := idx.Search(1, nil, "/", "content:milk other", 0, "")
+	if err != nil || len(response.Results) != 0 {
+		t.Errorf("content search without a content index = %v, %v, want no results", response.Results, err)
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package indexing
+
+import (
+	"os"
+	"syscall"
+)
+
+// statIdentity returns the device and inode of a file, its number of hard links
+// and the bytes allocated on disk.
+func statIdentity(info os.FileInfo) (id fileID, links uint64, allocated int64, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, 1, info.Size(), false
+	}
+	// field types differ between platforms, hence the conversions
+	id = fileID{Dev: uint64(stat.Dev), Ino: uint64(stat.Ino)}
+	return id, uint64(stat.Nlink), int64(stat.Blocks) * 512, true
+}
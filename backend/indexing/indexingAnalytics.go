@@ -0,0 +1,343 @@
+package indexing
+
+import (
+	"encoding/json"
+	"filebrowser/common/errors"
+	"filebrowser/indexing/iteminfo"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gtsteffaniak/go-logger/logger"
+)
+
+const (
+	defaultTopN      = 10
+	maxTopN          = 100
+	defaultTreeDepth = 2
+	maxTreeDepth     = 6
+	// size history keeps one sample per day for every top level folder
+	maxHistorySamples = 90
+	// category for files that don't match any of iteminfo.AllFiletypeOptions
+	otherCategory = "other"
+)
+
+type SizeEntry struct {
+	Path     string    `json:"path"` // path relative to the analyzed scope
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+type CategoryUsage struct {
+	Category string `json:"category"` // one of iteminfo.AllFiletypeOptions or "other"
+	Size     int64  `json:"size"`
+	Files    int    `json:"files"`
+}
+
+// TreemapNode is a folder or file with its children sorted by size,
+// small children beyond the requested limit are summed up in a single node without path.
+type TreemapNode struct {
+	Name     string         `json:"name"`
+	Path     string         `json:"path,omitempty"`
+	Size     int64          `json:"size"`
+	IsDir    bool           `json:"isDir,omitempty"`
+	Children []*TreemapNode `json:"children,omitempty"`
+}
+
+type UsageReport struct {
+	Path         string          `json:"path"`
+	Size         int64           `json:"size"`
+	LargestDirs  []SizeEntry     `json:"largestDirs"`
+	LargestFiles []SizeEntry     `json:"largestFiles"`
+	Categories   []CategoryUsage `json:"categories"`
+	Treemap      *TreemapNode    `json:"treemap"`
+}
+
+type SizeSample struct {
+	Time time.Time `json:"time"`
+	Size int64     `json:"size"`
+}
+
+type FolderGrowth struct {
+	Path         string `json:"path"`
+	Size         int64  `json:"size"`
+	PreviousSize int64  `json:"previousSize"` // size at the newest sample before the requested time
+	Change       int64  `json:"change"`
+}
+
+// UsageAnalytics reports the largest directories and files under scope, the size per file type
+// category and nested treemap data limited to depth levels with topN children each.
+func (idx *Index) UsageAnalytics(scope string, topN, depth int) (UsageReport, error) {
+	if topN <= 0 {
+		topN = defaultTopN
+	} else if topN > maxTopN {
+		topN = maxTopN
+	}
+	if depth <= 0 {
+		depth = defaultTreeDepth
+	} else if depth > maxTreeDepth {
+		depth = maxTreeDepth
+	}
+	scope = normalizeScope(scope)
+	scopePrefix := strings.TrimSuffix(scope, "/") + "/"
+	relative := func(indexPath string) string {
+		return "/" + strings.TrimPrefix(indexPath, scopePrefix)
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	root, ok := idx.store.get(scope)
+	if !ok {
+		return UsageReport{}, errors.ErrNotExist
+	}
+	report := UsageReport{
+		Path:         scope,
+		Size:         root.Size,
+		LargestDirs:  []SizeEntry{},
+		LargestFiles: []SizeEntry{},
+	}
+	categories := map[string]*CategoryUsage{}
+	extCategories := map[string]string{}
+	for dirPath, dir := range idx.store.all(scope) {
+		if dirPath != scope {
+			report.LargestDirs = insertLargest(report.LargestDirs, SizeEntry{Path: relative(dirPath), Size: dir.Size, Modified: unpackTime(dir.ModTime)}, topN)
+		}
+		prefix := strings.TrimSuffix(dirPath, "/") + "/"
+		for file := range idx.store.files(dir) {
+			report.LargestFiles = insertLargest(report.LargestFiles, SizeEntry{Path: relative(prefix + file.Name), Size: file.Size, Modified: file.ModTime}, topN)
+			ext := strings.ToLower(filepath.Ext(file.Name))
+			category, ok := extCategories[ext]
+			if !ok {
+				category = fileCategory(ext)
+				extCategories[ext] = category
+			}
+			usage, ok := categories[category]
+			if !ok {
+				usage = &CategoryUsage{Category: category}
+				categories[category] = usage
+			}
+			usage.Size += file.Size
+			usage.Files++
+		}
+	}
+	report.Categories = make([]CategoryUsage, 0, len(categories))
+	for _, usage := range categories {
+		report.Categories = append(report.Categories, *usage)
+	}
+	sort.Slice(report.Categories, func(i, j int) bool {
+		return report.Categories[i].Size > report.Categories[j].Size
+	})
+	report.Treemap = idx.treemapNode(root, scope, "/", depth, topN)
+	return report, nil
+}
+
+// insertLargest keeps entries sorted by size descending and at most limit long.
+func insertLargest(entries []SizeEntry, entry SizeEntry, limit int) []SizeEntry {
+	if len(entries) == limit && entries[limit-1].Size >= entry.Size {
+		return entries
+	}
+	pos := sort.Search(len(entries), func(i int) bool {
+		return entries[i].Size < entry.Size
+	})
+	if len(entries) < limit {
+		entries = append(entries, SizeEntry{})
+	}
+	copy(entries[pos+1:], entries[pos:])
+	entries[pos] = entry
+	return entries
+}
+
+func fileCategory(ext string) string {
+	for _, category := range iteminfo.AllFiletypeOptions {
+		if iteminfo.IsMatchingType(ext, category) {
+			return category
+		}
+	}
+	return otherCategory
+}
+
+// treemapNode builds the nested size data of a directory, must be called with idx.mu held.
+func (idx *Index) treemapNode(dir *storedDir, indexPath, relativePath string, depth, limit int) *TreemapNode {
+	node := &TreemapNode{
+		Name:  dir.Name,
+		Path:  relativePath,
+		Size:  dir.Size,
+		IsDir: true,
+	}
+	if depth == 0 {
+		return node
+	}
+	prefix := strings.TrimSuffix(indexPath, "/") + "/"
+	relativePrefix := strings.TrimSuffix(relativePath, "/") + "/"
+	children := []*TreemapNode{}
+	for folder := range idx.store.folders(dir) {
+		child, ok := idx.store.get(prefix + folder.Name)
+		if !ok {
+			children = append(children, &TreemapNode{Name: folder.Name, Path: relativePrefix + folder.Name, Size: folder.Size, IsDir: true})
+			continue
+		}
+		children = append(children, idx.treemapNode(child, prefix+folder.Name, relativePrefix+folder.Name, depth-1, limit))
+	}
+	for file := range idx.store.files(dir) {
+		children = append(children, &TreemapNode{Name: file.Name, Path: relativePrefix + file.Name, Size: file.Size})
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].Size > children[j].Size
+	})
+	if len(children) > limit {
+		other := &TreemapNode{Name: otherCategory}
+		for _, child := range children[limit:] {
+			other.Size += child.Size
+		}
+		children = append(children[:limit], other)
+	}
+	node.Children = children
+	return node
+}
+
+// recordSizeHistory stores the current size of every top level folder,
+// called after each full scan. Only the latest sample of a day is kept.
+func (idx *Index) recordSizeHistory() {
+	if idx.addSizeSamples() {
+		idx.saveSizeHistory()
+	}
+}
+
+func (idx *Index) addSizeSamples() bool {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	root, ok := idx.store.get("/")
+	if !ok {
+		return false
+	}
+	if idx.sizeHistory == nil {
+		idx.sizeHistory = make(map[string][]SizeSample)
+	}
+	now := time.Now()
+	current := map[string]bool{}
+	for folder := range idx.store.folders(root) {
+		path := "/" + folder.Name
+		current[path] = true
+		size := folder.Size
+		if dir, ok := idx.store.get(path); ok {
+			size = dir.Size
+		}
+		samples := idx.sizeHistory[path]
+		sample := SizeSample{Time: now, Size: size}
+		if n := len(samples); n > 0 && sameDay(samples[n-1].Time, now) {
+			samples[n-1] = sample
+		} else {
+			samples = append(samples, sample)
+		}
+		if len(samples) > maxHistorySamples {
+			samples = samples[len(samples)-maxHistorySamples:]
+		}
+		idx.sizeHistory[path] = samples
+	}
+	for path := range idx.sizeHistory {
+		if !current[path] {
+			delete(idx.sizeHistory, path)
+		}
+	}
+	return true
+}
+
+// sizeHistoryPath is next to the index snapshot, but kept apart from it so the history
+// survives snapshots that are discarded for a newer format.
+func (idx *Index) sizeHistoryPath() string {
+	return strings.TrimSuffix(idx.snapshotPath(), ".snapshot") + ".history.json"
+}
+
+func (idx *Index) saveSizeHistory() {
+	if idx.mock {
+		return
+	}
+	idx.mu.RLock()
+	data, err := json.Marshal(idx.sizeHistory)
+	idx.mu.RUnlock()
+	path := idx.sizeHistoryPath()
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0700)
+	}
+	if err == nil {
+		// replaced by rename, a crash never leaves a truncated history behind
+		err = os.WriteFile(path+".tmp", data, 0600)
+	}
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		logger.Errorf("could not save size history for [%v]: %v", idx.Name, err)
+	}
+}
+
+func (idx *Index) loadSizeHistory() {
+	data, err := os.ReadFile(idx.sizeHistoryPath())
+	if os.IsNotExist(err) {
+		return
+	}
+	history := map[string][]SizeSample{}
+	if err == nil {
+		err = json.Unmarshal(data, &history)
+	}
+	if err != nil {
+		logger.Warningf("discarding size history for [%v]: %v", idx.Name, err)
+		return
+	}
+	idx.mu.Lock()
+	idx.sizeHistory = history
+	idx.mu.Unlock()
+}
+
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
+
+// SizeHistory returns the recorded size samples of the top level folders, oldest first.
+func (idx *Index) SizeHistory() map[string][]SizeSample {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	history := make(map[string][]SizeSample, len(idx.sizeHistory))
+	for path, samples := range idx.sizeHistory {
+		history[path] = append([]SizeSample{}, samples...)
+	}
+	return history
+}
+
+// FolderGrowth compares the current size of every top level folder with its size
+// at the given time, sorted by the largest growth first.
+func (idx *Index) FolderGrowth(since time.Time) []FolderGrowth {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	growth := []FolderGrowth{}
+	for path, samples := range idx.sizeHistory {
+		if len(samples) == 0 {
+			continue
+		}
+		latest := samples[len(samples)-1]
+		previous := samples[0]
+		for _, sample := range samples {
+			if sample.Time.After(since) {
+				break
+			}
+			previous = sample
+		}
+		growth = append(growth, FolderGrowth{
+			Path:         path,
+			Size:         latest.Size,
+			PreviousSize: previous.Size,
+			Change:       latest.Size - previous.Size,
+		})
+	}
+	sort.Slice(growth, func(i, j int) bool {
+		if growth[i].Change != growth[j].Change {
+			return growth[i].Change > growth[j].Change
+		}
+		return growth[i].Path < growth[j].Path
+	})
+	return growth
+}
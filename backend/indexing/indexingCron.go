@@ -0,0 +1,137 @@
+package indexing
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gtsteffaniak/go-logger/logger"
+	"github.com/robfig/cron/v3"
+)
+
+// scanWindow is a daily time range in minutes since midnight, end may be before start
+// for windows that span midnight.
+type scanWindow struct {
+	start int
+	end   int
+}
+
+// scanPlan holds the parsed scheduling options of a source.
+type scanPlan struct {
+	quick   cron.Schedule // nil uses the adaptive schedule
+	full    cron.Schedule // nil makes every 5th scan a full scan
+	windows []scanWindow
+}
+
+func (idx *Index) parseScanPlan() scanPlan {
+	plan := scanPlan{}
+	var err error
+	if idx.Config.QuickScanCron != "" {
+		plan.quick, err = cron.ParseStandard(idx.Config.QuickScanCron)
+		if err != nil {
+			logger.Errorf("invalid quickScanCron %q for [%v], using the adaptive schedule: %v", idx.Config.QuickScanCron, idx.Name, err)
+		}
+	}
+	if idx.Config.FullScanCron != "" {
+		plan.full, err = cron.ParseStandard(idx.Config.FullScanCron)
+		if err != nil {
+			logger.Errorf("invalid fullScanCron %q for [%v], every 5th scan is a full scan: %v", idx.Config.FullScanCron, idx.Name, err)
+		}
+	}
+	for _, window := range idx.Config.NoScanWindows {
+		parsed, err := parseScanWindow(window)
+		if err != nil {
+			logger.Errorf("invalid noScanWindow %q for [%v]: %v", window, idx.Name, err)
+			continue
+		}
+		plan.windows = append(plan.windows, parsed)
+	}
+	return plan
+}
+
+// parseScanWindow parses "HH:MM-HH:MM", a window can not be empty.
+func parseScanWindow(window string) (scanWindow, error) {
+	start, end, found := strings.Cut(window, "-")
+	if !found {
+		return scanWindow{}, fmt.Errorf("expected HH:MM-HH:MM")
+	}
+	startTime, err := time.Parse("15:04", strings.TrimSpace(start))
+	if err != nil {
+		return scanWindow{}, err
+	}
+	endTime, err := time.Parse("15:04", strings.TrimSpace(end))
+	if err != nil {
+		return scanWindow{}, err
+	}
+	if startTime.Equal(endTime) {
+		return scanWindow{}, fmt.Errorf("start and end are equal")
+	}
+	return scanWindow{
+		start: startTime.Hour()*60 + startTime.Minute(),
+		end:   endTime.Hour()*60 + endTime.Minute(),
+	}, nil
+}
+
+func (w scanWindow) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if w.start <= w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+// blockedUntil returns the end of the no-scan window containing t, or the zero time when scans are allowed.
+// Overlapping windows are followed to the end of the last one.
+func (p scanPlan) blockedUntil(t time.Time) time.Time {
+	until := time.Time{}
+	for i := 0; i < len(p.windows); i++ {
+		end := p.windowEnd(t)
+		if end.IsZero() {
+			break
+		}
+		until, t = end, end
+	}
+	return until
+}
+
+func (p scanPlan) windowEnd(t time.Time) time.Time {
+	for _, w := range p.windows {
+		if !w.contains(t) {
+			continue
+		}
+		midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		end := midnight.Add(time.Duration(w.end) * time.Minute)
+		if !end.After(t) {
+			end = end.AddDate(0, 0, 1)
+		}
+		return end
+	}
+	return time.Time{}
+}
+
+// next returns when the next scan should run and whether it is a full scan.
+// fullScanCounter is the number of the upcoming scan since the last full scan.
+func (p scanPlan) next(now time.Time, adaptive time.Duration, fullScanCounter int) (time.Time, bool) {
+	quickAt := now.Add(adaptive)
+	if p.quick != nil {
+		quickAt = p.quick.Next(now)
+	}
+	if p.full != nil {
+		fullAt := p.full.Next(now)
+		if !fullAt.After(quickAt) {
+			return fullAt, true
+		}
+		return quickAt, false
+	}
+	return quickAt, fullScanCounter == 5
+}
+
+func (idx *Index) setNextScan(at time.Time, full bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.NextScanUnix = at.Unix()
+	idx.NextScanType = "quick"
+	if full {
+		idx.NextScanType = "full"
+	}
+}
@@ -2,6 +2,7 @@ package indexing
 
 import (
 	"filebrowser/common/errors"
+	"filebrowser/common/metrics"
 	"filebrowser/common/settings"
 	"filebrowser/common/utils"
 	"filebrowser/indexing/iteminfo"
@@ -9,9 +10,9 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
-	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gtsteffaniak/go-cache/cache"
@@ -22,28 +23,45 @@ var RealPathCache = cache.NewCache(48*time.Hour, 72*time.Hour)
 
 // reduced index is json exposed to the client
 type ReducedIndex struct {
-	IdxName         string      `json:"name"`
-	DiskUsed        int64       `json:"used"`
-	DiskTotal       int64       `json:"total"`
-	Status          IndexStatus `json:"status"`
-	NumDirs         uint64      `json:"numDirs"`
-	NumFiles        uint64      `json:"numFiles"`
-	NumDeleted      uint64      `json:"numDeleted"`
-	LastIndexed     time.Time   `json:"-"`
-	LastIndexedUnix int64       `json:"lastIndexedUnixTime"`
-	QuickScanTime   int         `json:"quickScanDurationSeconds"`
-	FullScanTime    int         `json:"fullScanDurationSeconds"`
-	Assessment      string      `json:"assessment"`
+	IdxName         string         `json:"name"`
+	DiskUsed        int64          `json:"used"`
+	DiskTotal       int64          `json:"total"`
+	Status          IndexStatus    `json:"status"`
+	NumDirs         uint64         `json:"numDirs"`
+	NumFiles        uint64         `json:"numFiles"`
+	NumDeleted      uint64         `json:"numDeleted"`
+	LastIndexed     time.Time      `json:"-"`
+	LastIndexedUnix int64          `json:"lastIndexedUnixTime"`
+	QuickScanTime   int            `json:"quickScanDurationSeconds"`
+	FullScanTime    int            `json:"fullScanDurationSeconds"`
+	Assessment      string         `json:"assessment"`
+	Stale           bool           `json:"stale"` // loaded from a snapshot and not yet verified by a scan
+	NextScanUnix    int64          `json:"nextScanUnixTime"`
+	NextScanType    string         `json:"nextScanType"` // "quick" or "full"
+	Throttle        ThrottleStatus `json:"throttle"`
 }
 type Index struct {
 	ReducedIndex
 	CurrentSchedule            int `json:"-"`
 	settings.Source            `json:"-"`
-	Directories                map[string]*iteminfo.FileInfo `json:"-"`
-	DirectoriesLedger          map[string]bool               `json:"-"`
-	runningScannerCount        int                           `json:"-"`
-	SmartModifier              time.Duration                 `json:"-"`
-	FilesChangedDuringIndexing bool                          `json:"-"`
+	DirectoriesLedger          map[string]bool `json:"-"`
+	runningScannerCount        int             `json:"-"`
+	SmartModifier              time.Duration   `json:"-"`
+	FilesChangedDuringIndexing bool            `json:"-"`
+	watcher                    *indexWatcher
+	content                    *contentIndex // nil unless content indexing is enabled for the source
+	media                      *mediaIndex   // nil unless metadata indexing is enabled for the source
+	scanSlots                  chan struct{} // extra workers for parallel scans, nil scans serially
+	store                      dirStore      // indexed directories
+	scan                       scanControl
+	include                    compiledFilter
+	ignores                    ignoreFiles
+	links                      linkTracker
+	journal                    changeJournal
+	sizeHistory                map[string][]SizeSample // top level folder -> daily sizes, recorded after full scans
+	savedChanges               atomic.Uint64           // store.changes when the snapshot was last saved
+	exclude                    compiledFilter
+	filtersOnce                sync.Once
 	mock                       bool
 	mu                         sync.RWMutex
 }
@@ -61,33 +79,100 @@ func init() {
 }
 
 func Initialize(source settings.Source, mock bool) {
+	newIndex := register(source, mock)
+	if !mock {
+		go newIndex.runTrashRetention(background)
+		if newIndex.media != nil {
+			go newIndex.runMediaExtraction(background)
+		}
+	}
+	if !newIndex.Config.DisableIndexing {
+		if !mock {
+			newIndex.loadSizeHistory()
+			go newIndex.runSnapshotSaves(background)
+		}
+		time.Sleep(time.Second)
+		err := newIndex.loadSnapshot()
+		if err == nil {
+			newIndex.SetStatus(READY)
+			logger.Infof("loaded index snapshot: [%v] directories=%v files=%v", newIndex.Name, newIndex.NumDirs, newIndex.NumFiles)
+			go newIndex.warmStart()
+			return
+		}
+		if !os.IsNotExist(err) {
+			logger.Warningf("discarding index snapshot for [%v], running full scan: %v", newIndex.Name, err)
+		}
+		logger.Infof("initializing index: [%v]", newIndex.Name)
+		newIndex.RunIndexing("/", false)
+		err = newIndex.SaveSnapshot()
+		if err != nil {
+			logger.Errorf("could not save index snapshot for [%v]: %v", newIndex.Name, err)
+		}
+		go newIndex.setupIndexingScanners()
+	} else {
+		newIndex.Status = "ready"
+		logger.Debug("indexing disabled for source: " + newIndex.Name)
+	}
+}
+
+// InitializeOnce indexes a source with a single full scan and nothing running in the
+// background, no snapshot, scanners or watcher. For commands that exit after using the index.
+func InitializeOnce(source settings.Source) {
+	newIndex := register(source, true)
+	if newIndex.Config.DisableIndexing {
+		newIndex.Status = "ready"
+		return
+	}
+	newIndex.RunIndexing("/", false)
+}
+
+// register creates the index of a source and makes it available through GetIndex.
+func register(source settings.Source, mock bool) *Index {
 	indexesMutex.Lock()
+	defer indexesMutex.Unlock()
 	newIndex := Index{
 		mock:              mock,
 		Source:            source,
-		Directories:       make(map[string]*iteminfo.FileInfo),
 		DirectoriesLedger: make(map[string]bool),
+		store:             newDirStore(),
+	}
+	if source.Config.ContentIndex.Enabled {
+		newIndex.content = newContentIndex(source.Config.ContentIndex)
+	}
+	if source.Config.IndexMetadata {
+		newIndex.media = newMediaIndex()
+	}
+	if source.Config.ScanConcurrency > 1 {
+		newIndex.scanSlots = make(chan struct{}, source.Config.ScanConcurrency-1)
 	}
 	newIndex.ReducedIndex = ReducedIndex{
 		Status:     "indexing",
 		IdxName:    source.Name,
 		Assessment: "unknown",
+		Throttle:   newIndex.configuredThrottle(),
 	}
 	indexes[newIndex.Name] = &newIndex
-	indexesMutex.Unlock()
-	if !newIndex.Config.DisableIndexing {
-		time.Sleep(time.Second)
-		logger.Infof("initializing index: [%v]", newIndex.Name)
-		newIndex.RunIndexing("/", false)
-		go newIndex.setupIndexingScanners()
-	} else {
-		newIndex.Status = "ready"
-		logger.Debug("indexing disabled for source: " + newIndex.Name)
-	}
+	return &newIndex
 }
 
+// scanKind tells indexDirectory why a directory is read. Only scheduled scans are throttled
+// and can be paused or cancelled, watcher changes and refreshes are applied right away.
+type scanKind int
+
+const (
+	directRead scanKind = iota // watcher changes, ignore file changes and refreshes
+	fullScan
+	quickScan // unchanged directories are not read again, only their subdirectories are checked
+)
+
 // Define a function to recursively index files and directories
-func (idx *Index) indexDirectory(adjustedPath string, quick, recursive bool) error {
+func (idx *Index) indexDirectory(adjustedPath string, kind scanKind, recursive bool) error {
+	if recursive && kind != directRead {
+		err := idx.throttle()
+		if err != nil {
+			return err
+		}
+	}
 	realPath := strings.TrimRight(idx.Path, "/") + adjustedPath
 	// Open the directory
 	dir, err := os.Open(realPath)
@@ -103,15 +188,33 @@ func (idx *Index) indexDirectory(adjustedPath string, quick, recursive bool) err
 	}
 
 	// check if excluded from indexing
+	if InTrash(adjustedPath) {
+		return errors.ErrNotIndexed
+	}
 	hidden := isHidden(dirInfo, idx.Path+adjustedPath)
 	if idx.shouldSkip(dirInfo.IsDir(), hidden, adjustedPath) {
 		logger.Debugf("skipping directory %s due to exclusion rules", adjustedPath)
 		return errors.ErrNotIndexed
 	}
+	if idx.isIgnored(adjustedPath, true) {
+		return errors.ErrNotIndexed
+	}
+	if recursive {
+		if adjustedPath == "/" {
+			idx.links.setRoot(dirInfo)
+		} else if reason := idx.boundary(adjustedPath, dirInfo); reason != "" {
+			logger.Debugf("not descending into %v: %v", adjustedPath, reason)
+			return errors.ErrIndexBoundary
+		}
+	}
 
 	// if indexing, mark the directory as valid and indexed.
 	if recursive {
-		// Prevent race conditions if scanning becomes concurrent in the future.
+		if idx.refreshIgnoreFile(adjustedPath) && kind == quickScan {
+			// ignore rules changed, the whole subtree has to be read again
+			kind = fullScan
+		}
+		// sibling subtrees may be scanned concurrently
 		idx.mu.Lock()
 		idx.DirectoriesLedger[adjustedPath] = true
 		idx.mu.Unlock()
@@ -122,12 +225,14 @@ func (idx *Index) indexDirectory(adjustedPath string, quick, recursive bool) err
 	}
 	// get whats currently in cache
 	idx.mu.RLock()
-	cacheDirItems := []iteminfo.ItemInfo{}
+	cachedFolders := []string{}
 	modChange := false
-	cachedDir, exists := idx.Directories[adjustedPath]
+	stored, exists := idx.store.get(adjustedPath)
 	if exists {
-		modChange = dirInfo.ModTime() != cachedDir.ModTime
-		cacheDirItems = cachedDir.Folders
+		modChange = packTime(dirInfo.ModTime()) != stored.ModTime
+		for folder := range idx.store.folders(stored) {
+			cachedFolders = append(cachedFolders, combinedPath+folder.Name)
+		}
 	}
 	idx.mu.RUnlock()
 
@@ -138,26 +243,41 @@ func (idx *Index) indexDirectory(adjustedPath string, quick, recursive bool) err
 			idx.mu.Lock()
 			idx.FilesChangedDuringIndexing = true
 			idx.mu.Unlock()
-		} else if quick {
-			for _, item := range cacheDirItems {
-				err = idx.indexDirectory(combinedPath+item.Name, quick, true)
-				if err != nil && err != errors.ErrNotIndexed {
-					logger.Errorf("error indexing directory %v : %v", combinedPath+item.Name, err)
+		} else if kind == quickScan && exists {
+			// new directories have nothing cached and are always read, otherwise a quick
+			// scan, like the one after loading a snapshot, would never index or journal them
+			errs := idx.indexSubdirectories(cachedFolders, kind)
+			for i, err := range errs {
+				if err == errors.ErrScanCancelled {
+					return err
+				}
+				if err != nil && err != errors.ErrNotIndexed && err != errors.ErrIndexBoundary {
+					logger.Errorf("error indexing directory %v : %v", cachedFolders[i], err)
 				}
 			}
 			return nil
 		}
 	}
-	dirFileInfo, err2 := idx.GetDirInfo(dir, dirInfo, realPath, adjustedPath, combinedPath, quick, recursive)
+	dirFileInfo, err2 := idx.GetDirInfo(dir, dirInfo, realPath, adjustedPath, combinedPath, kind, recursive)
 	if err2 != nil {
 		return err2
 	}
+	var cachedDir *iteminfo.FileInfo
+	if exists {
+		cachedDir, _ = idx.GetMetadataInfo(adjustedPath, true)
+	}
+	idx.journalDirChanges(cachedDir, dirFileInfo)
 	// Update the current directory metadata in the index
 	idx.UpdateMetadata(dirFileInfo)
+	idx.updateContentIndex(dirFileInfo)
+	idx.updateMediaIndex(dirFileInfo)
 	return nil
 }
 
 func (idx *Index) GetFsDirInfo(adjustedPath string) (*iteminfo.FileInfo, error) {
+	if InTrash(idx.MakeIndexPath(filepath.Join(idx.Path, adjustedPath))) {
+		return nil, errors.ErrNotExist
+	}
 	realPath, isDir, err := idx.GetRealPath(adjustedPath)
 	if err != nil {
 		return nil, err
@@ -182,7 +302,7 @@ func (idx *Index) GetFsDirInfo(adjustedPath string) (*iteminfo.FileInfo, error)
 		combinedPath = "/"
 	}
 	var response *iteminfo.FileInfo
-	response, err = idx.GetDirInfo(dir, dirInfo, realPath, adjustedPath, combinedPath, false, false)
+	response, err = idx.GetDirInfo(dir, dirInfo, realPath, adjustedPath, combinedPath, directRead, false)
 	if err != nil {
 		return nil, err
 	}
@@ -209,22 +329,24 @@ func (idx *Index) GetFsDirInfo(adjustedPath string) (*iteminfo.FileInfo, error)
 
 }
 
-func (idx *Index) GetDirInfo(dirInfo *os.File, stat os.FileInfo, realPath, adjustedPath, combinedPath string, quick, recursive bool) (*iteminfo.FileInfo, error) {
+func (idx *Index) GetDirInfo(dirInfo *os.File, stat os.FileInfo, realPath, adjustedPath, combinedPath string, kind scanKind, recursive bool) (*iteminfo.FileInfo, error) {
 	// Read directory contents
 	files, err := dirInfo.Readdir(-1)
 	if err != nil {
 		return nil, err
 	}
-	var totalSize int64
+	var totalSize, totalAllocated int64
+	var numFiles, numDirs uint64
 	fileInfos := []iteminfo.ItemInfo{}
 	dirInfos := []iteminfo.ItemInfo{}
+	subDirs := []string{}
 
 	// Process each file and directory in the current directory
 	for _, file := range files {
 		hidden := isHidden(file, idx.Path+combinedPath)
 		isDir := iteminfo.IsDirectory(file)
 		fullCombined := combinedPath + file.Name()
-		if idx.shouldSkip(isDir, hidden, fullCombined) && recursive {
+		if recursive && (idx.shouldSkip(isDir, hidden, fullCombined) || idx.isIgnoredEntry(fullCombined, isDir)) {
 			continue
 		}
 		itemInfo := &iteminfo.ItemInfo{
@@ -235,37 +357,73 @@ func (idx *Index) GetDirInfo(dirInfo *os.File, stat os.FileInfo, realPath, adjus
 
 		if isDir {
 			// skip non-indexable dirs.
-			if file.Name() == "$RECYCLE.BIN" || file.Name() == "System Volume Information" {
+			if file.Name() == "$RECYCLE.BIN" || file.Name() == "System Volume Information" || InTrash(fullCombined) {
 				continue
 			}
-
-			dirPath := combinedPath + file.Name()
-			if recursive {
-				// clear for garbage collection
-				file = nil
-				// Recursively index the subdirectory
-				err = idx.indexDirectory(dirPath, quick, recursive)
-				if err != nil {
-					logger.Errorf("Failed to index directory %s: %v", dirPath, err)
-					continue
-				}
-			}
-			realDirInfo, exists := idx.GetMetadataInfo(dirPath, true)
-			if exists {
-				itemInfo.Size = realDirInfo.Size
-			}
-			totalSize += itemInfo.Size
 			itemInfo.Type = "directory"
 			dirInfos = append(dirInfos, *itemInfo)
-			idx.NumDirs++
+			subDirs = append(subDirs, fullCombined)
 		} else {
 			itemInfo.DetectType(fullCombined, false)
 			itemInfo.Size = file.Size()
+			id, links, allocated, ok := statIdentity(file)
+			itemInfo.AllocatedSize = allocated
 			fileInfos = append(fileInfos, *itemInfo)
+			numFiles++
+			if ok && links > 1 && !idx.claimLink(id, fullCombined) {
+				logger.Debugf("not counting size of %v, hard link of a file counted at another path", fullCombined)
+				continue
+			}
 			totalSize += itemInfo.Size
-			idx.NumFiles++
+			totalAllocated += itemInfo.AllocatedSize
 		}
 	}
+	// clear for garbage collection
+	files = nil
+
+	var errs []error
+	if recursive {
+		// Recursively index the subdirectories, possibly in parallel
+		errs = idx.indexSubdirectories(subDirs, kind)
+	}
+	for _, err := range errs {
+		if err == errors.ErrScanCancelled {
+			// the listing would miss the directories that weren't scanned
+			return nil, err
+		}
+	}
+	indexedDirs := dirInfos[:0]
+	for i, itemInfo := range dirInfos {
+		if errs != nil && errs[i] == errors.ErrIndexBoundary {
+			// listed, but its size belongs to another file system or path
+			indexedDirs = append(indexedDirs, itemInfo)
+			continue
+		}
+		if errs != nil && errs[i] != nil {
+			logger.Errorf("Failed to index directory %s: %v", subDirs[i], errs[i])
+			continue
+		}
+		idx.mu.RLock()
+		realDirInfo, exists := idx.store.get(subDirs[i])
+		if exists {
+			itemInfo.Size = realDirInfo.Size
+			itemInfo.AllocatedSize = realDirInfo.AllocatedSize
+		}
+		idx.mu.RUnlock()
+		totalSize += itemInfo.Size
+		totalAllocated += itemInfo.AllocatedSize
+		indexedDirs = append(indexedDirs, itemInfo)
+		numDirs++
+	}
+	dirInfos = indexedDirs
+
+	if recursive {
+		// refreshes of a single directory would count its items again
+		idx.mu.Lock()
+		idx.NumDirs += numDirs
+		idx.NumFiles += numFiles
+		idx.mu.Unlock()
+	}
 
 	if totalSize == 0 && idx.Config.IgnoreZeroSizeFolders {
 		return nil, errors.ErrNotIndexed
@@ -277,16 +435,41 @@ func (idx *Index) GetDirInfo(dirInfo *os.File, stat os.FileInfo, realPath, adjus
 		Files:   fileInfos,
 		Folders: dirInfos,
 	}
+	_, _, allocated, _ := statIdentity(stat)
 	dirFileInfo.ItemInfo = iteminfo.ItemInfo{
-		Name:    filepath.Base(dirInfo.Name()),
-		Type:    "directory",
-		Size:    totalSize,
-		ModTime: stat.ModTime(),
+		Name:          filepath.Base(dirInfo.Name()),
+		Type:          "directory",
+		Size:          totalSize,
+		AllocatedSize: totalAllocated + allocated,
+		ModTime:       stat.ModTime(),
 	}
 	dirFileInfo.SortItems()
 	return dirFileInfo, nil
 }
 
+// indexSubdirectories recursively indexes the given directories and returns an error per directory.
+// Subtrees are handed to idle scan workers when the source allows concurrent scanning,
+// otherwise or when all workers are busy they are scanned on the calling goroutine.
+func (idx *Index) indexSubdirectories(dirPaths []string, kind scanKind) []error {
+	errs := make([]error, len(dirPaths))
+	var wg sync.WaitGroup
+	for i, dirPath := range dirPaths {
+		select {
+		case idx.scanSlots <- struct{}{}:
+			wg.Add(1)
+			go func(i int, dirPath string) {
+				defer wg.Done()
+				defer func() { <-idx.scanSlots }()
+				errs[i] = idx.indexDirectory(dirPath, kind, true)
+			}(i, dirPath)
+		default:
+			errs[i] = idx.indexDirectory(dirPath, kind, true)
+		}
+	}
+	wg.Wait()
+	return errs
+}
+
 // input should be non-index path.
 func (idx *Index) MakeIndexPath(subPath string) string {
 	if strings.HasPrefix(subPath, "./") {
@@ -308,16 +491,15 @@ func (idx *Index) MakeIndexPath(subPath string) string {
 	return adjustedPath
 }
 
-func (idx *Index) recursiveUpdateDirSizes(childInfo *iteminfo.FileInfo, previousSize int64) {
-	parentDir := utils.GetParentDirectoryPath(childInfo.Path)
-	parentInfo, exists := idx.GetMetadataInfo(parentDir, true)
-	if !exists || parentDir == "" {
+// updateDirSizes applies the changed sizes of a directory to all of its parents.
+func (idx *Index) updateDirSizes(indexPath string, previousSize, previousAllocated int64) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	dir, exists := idx.store.get(indexPath)
+	if !exists {
 		return
 	}
-	newSize := parentInfo.Size - previousSize + childInfo.Size
-	parentInfo.Size += newSize
-	idx.UpdateMetadata(parentInfo)
-	idx.recursiveUpdateDirSizes(parentInfo, newSize)
+	idx.store.addSize(indexPath, dir.Size-previousSize, dir.AllocatedSize-previousAllocated)
 }
 
 func (idx *Index) GetRealPath(relativePath ...string) (string, bool, error) {
@@ -343,6 +525,7 @@ func (idx *Index) GetRealPath(relativePath ...string) (string, bool, error) {
 }
 
 func (idx *Index) RefreshFileInfo(opts iteminfo.FileOptions) error {
+	metrics.IndexRefreshes.Inc(idx.Name)
 	refreshOptions := iteminfo.FileOptions{
 		Path:  opts.Path,
 		IsDir: opts.IsDir,
@@ -351,27 +534,25 @@ func (idx *Index) RefreshFileInfo(opts iteminfo.FileOptions) error {
 		refreshOptions.Path = idx.MakeIndexPath(filepath.Dir(refreshOptions.Path))
 		refreshOptions.IsDir = true
 	}
-	err := idx.indexDirectory(refreshOptions.Path, false, false)
+	idx.mu.RLock()
+	previous, existed := idx.store.get(refreshOptions.Path)
+	var previousSize, previousAllocated int64
+	if existed {
+		previousSize, previousAllocated = previous.Size, previous.AllocatedSize
+	}
+	idx.mu.RUnlock()
+	err := idx.indexDirectory(refreshOptions.Path, directRead, false)
 	if err != nil {
 		return err
 	}
-	file, exists := idx.GetMetadataInfo(refreshOptions.Path, true)
+	idx.mu.RLock()
+	exists := idx.store.has(refreshOptions.Path)
+	idx.mu.RUnlock()
 	if !exists {
 		return fmt.Errorf("file/folder does not exist in metadata: %s", refreshOptions.Path)
 	}
-
-	current, firstExisted := idx.GetMetadataInfo(refreshOptions.Path, true)
-	refreshParentInfo := firstExisted && current.Size != file.Size
-	//utils.PrintStructFields(*file)
-	result := idx.UpdateMetadata(file)
-	if !result {
-		return fmt.Errorf("file/folder does not exist in metadata: %s", refreshOptions.Path)
-	}
-	if !exists {
-		return nil
-	}
-	if refreshParentInfo {
-		idx.recursiveUpdateDirSizes(file, current.Size)
+	if existed {
+		idx.updateDirSizes(refreshOptions.Path, previousSize, previousAllocated)
 	}
 	return nil
 }
@@ -390,60 +571,6 @@ func isHidden(file os.FileInfo, srcPath string) bool {
 	return false
 }
 
-func (idx *Index) shouldSkip(isDir bool, isHidden bool, fullCombined string) bool {
-	if idx.Config.DisableIndexing {
-		return true
-	}
-	// check inclusions first
-	if isDir && len(idx.Config.Include.Folders) > 0 {
-		if !slices.Contains(idx.Config.Include.Folders, fullCombined) {
-			return true
-		}
-	}
-	if !isDir && len(idx.Config.Include.Files) > 0 {
-		if !slices.Contains(idx.Config.Include.Files, fullCombined) {
-			return true
-		}
-	}
-
-	if !isDir && len(idx.Config.Include.FileEndsWith) > 0 {
-		shouldSkip := true
-		for _, end := range idx.Config.Include.FileEndsWith {
-			if strings.HasSuffix(fullCombined, end) {
-				shouldSkip = false
-				break
-			}
-		}
-		if shouldSkip {
-			return true
-		}
-	}
-
-	// check exclusions
-	if isDir && slices.Contains(idx.Config.Exclude.Folders, fullCombined) {
-		return true
-	}
-	if !isDir && slices.Contains(idx.Config.Exclude.Files, fullCombined) {
-		return true
-	}
-	if idx.Config.IgnoreHidden && isHidden {
-		return true
-	}
-
-	if !isDir && len(idx.Config.Exclude.FileEndsWith) > 0 {
-		shouldSkip := false
-		for _, end := range idx.Config.Exclude.FileEndsWith {
-			if strings.HasSuffix(fullCombined, end) {
-				shouldSkip = true
-				break
-			}
-		}
-		return shouldSkip
-	}
-
-	return false
-}
-
 type DiskUsage struct {
 	Total uint64 `json:"total"`
 	Used  uint64 `json:"used"`
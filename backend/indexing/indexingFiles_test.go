@@ -0,0 +1,138 @@
+package indexing
+
+import (
+	"filebrowser/common/settings"
+	"filebrowser/indexing/iteminfo"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// createTestTree builds a synthetic source with width^depth directories
+// and filesPerDir files in every directory.
+func createTestTree(t testing.TB, width, depth, filesPerDir int) string {
+	root := t.TempDir()
+	var build func(dir string, level int)
+	build = func(dir string, level int) {
+		for i := 0; i < filesPerDir; i++ {
+			err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%d.txt", i)), make([]byte, i+1), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if level == depth {
+			return
+		}
+		for i := 0; i < width; i++ {
+			sub := filepath.Join(dir, fmt.Sprintf("dir%d", i))
+			err := os.Mkdir(sub, 0755)
+			if err != nil {
+				t.Fatal(err)
+			}
+			build(sub, level+1)
+		}
+	}
+	build(root, 0)
+	return root
+}
+
+func newTestIndex(path string, concurrency int) *Index {
+	idx := &Index{
+		mock: true,
+		Source: settings.Source{
+			Path:   path,
+			Name:   "test",
+			Config: settings.SourceConfig{ScanConcurrency: concurrency},
+		},
+		DirectoriesLedger: make(map[string]bool),
+		store:             newDirStore(),
+	}
+	if concurrency > 1 {
+		idx.scanSlots = make(chan struct{}, concurrency-1)
+	}
+	return idx
+}
+
+func TestParallelScanMatchesSerial(t *testing.T) {
+	root := createTestTree(t, 4, 3, 5)
+	serial := newTestIndex(root, 1)
+	parallel := newTestIndex(root, 8)
+	if err := serial.indexDirectory("/", fullScan, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := parallel.indexDirectory("/", fullScan, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// 4 + 16 + 64 subdirectories, 5 files in each directory including root
+	if parallel.NumDirs != 84 || parallel.NumFiles != 425 {
+		t.Errorf("parallel scan counted dirs=%v files=%v, want dirs=84 files=425", parallel.NumDirs, parallel.NumFiles)
+	}
+	if serial.NumDirs != parallel.NumDirs || serial.NumFiles != parallel.NumFiles {
+		t.Errorf("counts differ: serial dirs=%v files=%v, parallel dirs=%v files=%v", serial.NumDirs, serial.NumFiles, parallel.NumDirs, parallel.NumFiles)
+	}
+	if serial.store.len() != parallel.store.len() || len(serial.DirectoriesLedger) != len(parallel.DirectoriesLedger) {
+		t.Fatalf("directory maps differ: serial=%v parallel=%v", serial.store.len(), parallel.store.len())
+	}
+	for path := range serial.store.all("/") {
+		want, _ := serial.GetMetadataInfo(path, true)
+		got, ok := parallel.GetMetadataInfo(path, true)
+		if !ok {
+			t.Errorf("directory %v missing from parallel scan", path)
+			continue
+		}
+		if got.Size != want.Size || len(got.Files) != len(want.Files) || len(got.Folders) != len(want.Folders) {
+			t.Errorf("directory %v differs: serial size=%v parallel size=%v", path, want.Size, got.Size)
+		}
+	}
+	// each level holds 1+2+3+4+5 = 15 bytes of files
+	if root, _ := parallel.GetMetadataInfo("/", true); root.Size != 85*15 {
+		t.Errorf("root size = %v, want %v", root.Size, 85*15)
+	}
+}
+
+func benchmarkScan(b *testing.B, concurrency int) {
+	root := createTestTree(b, 6, 3, 20)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		idx := newTestIndex(root, concurrency)
+		err := idx.indexDirectory("/", fullScan, true)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkScanSerial(b *testing.B)     { benchmarkScan(b, 1) }
+func BenchmarkScanParallel4(b *testing.B)  { benchmarkScan(b, 4) }
+func BenchmarkScanParallel16(b *testing.B) { benchmarkScan(b, 16) }
+
+func TestRefreshUpdatesParentSizes(t *testing.T) {
+	root := createTestTree(t, 1, 2, 1)
+	idx := newTestIndex(root, 1)
+	if err := idx.indexDirectory("/", fullScan, true); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := idx.GetMetadataInfo("/", true)
+	changedBefore, _ := idx.GetMetadataInfo("/dir0/dir0", true)
+	if err := os.WriteFile(filepath.Join(root, "dir0", "dir0", "new.txt"), make([]byte, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.RefreshFileInfo(iteminfo.FileOptions{Path: "/dir0/dir0", IsDir: true}); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := idx.GetMetadataInfo("/", true)
+	parent, _ := idx.GetMetadataInfo("/dir0", true)
+	changed, _ := idx.GetMetadataInfo("/dir0/dir0", true)
+	if after.Size != before.Size+1000 || after.Folders[0].Size != parent.Size {
+		t.Errorf("root size %v -> %v with folder entry %v, want +1000 and %v", before.Size, after.Size, after.Folders[0].Size, parent.Size)
+	}
+	allocated := changed.AllocatedSize - changedBefore.AllocatedSize
+	if allocated <= 0 {
+		t.Fatalf("allocated size of the changed folder %v -> %v, want it to grow", changedBefore.AllocatedSize, changed.AllocatedSize)
+	}
+	if after.AllocatedSize != before.AllocatedSize+allocated || after.Folders[0].AllocatedSize != parent.AllocatedSize || parent.Folders[0].AllocatedSize != changed.AllocatedSize {
+		t.Errorf("root allocated size %v -> %v with folder entry %v, want +%v and %v", before.AllocatedSize, after.AllocatedSize, after.Folders[0].AllocatedSize, allocated, parent.AllocatedSize)
+	}
+}
@@ -0,0 +1,125 @@
+package indexing
+
+import (
+	"filebrowser/common/settings"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/gtsteffaniak/go-logger/logger"
+)
+
+// compiledFilter is an IndexFilter with its patterns and regexes compiled once per source.
+type compiledFilter struct {
+	settings.IndexFilter
+	patterns []string // validated glob patterns without leading slash
+	regexes  []*regexp.Regexp
+}
+
+func compileFilter(filter settings.IndexFilter, sourceName string) compiledFilter {
+	compiled := compiledFilter{IndexFilter: filter}
+	for _, pattern := range filter.Patterns {
+		pattern = strings.TrimPrefix(pattern, "/")
+		if pattern == "" || !doublestar.ValidatePattern(pattern) {
+			logger.Errorf("ignoring invalid index pattern %q for source [%v]", pattern, sourceName)
+			continue
+		}
+		compiled.patterns = append(compiled.patterns, pattern)
+	}
+	for _, expr := range filter.Regexes {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			logger.Errorf("ignoring invalid index regex %q for source [%v]: %v", expr, sourceName, err)
+			continue
+		}
+		compiled.regexes = append(compiled.regexes, re)
+	}
+	return compiled
+}
+
+// compileFilters prepares the include and exclude rules of the source, called once per index.
+func (idx *Index) compileFilters() {
+	idx.include = compileFilter(idx.Config.Include, idx.Name)
+	idx.exclude = compileFilter(idx.Config.Exclude, idx.Name)
+}
+
+// hasFileRules returns true when the filter restricts files in any way. Invalid patterns
+// and regexes were dropped when compiling and don't count, so they can't hide every file.
+func (f *compiledFilter) hasFileRules() bool {
+	return len(f.Files) > 0 || len(f.FileEndsWith) > 0 || len(f.patterns) > 0 || len(f.regexes) > 0
+}
+
+// matchesFile returns true when the file matches any file rule of the filter.
+func (f *compiledFilter) matchesFile(indexPath string) bool {
+	if slices.Contains(f.Files, indexPath) {
+		return true
+	}
+	for _, end := range f.FileEndsWith {
+		if strings.HasSuffix(indexPath, end) {
+			return true
+		}
+	}
+	return f.matchesPattern(indexPath)
+}
+
+// matchesPattern returns true when the base name or the full index path matches a pattern or regex.
+func (f *compiledFilter) matchesPattern(indexPath string) bool {
+	if indexPath == "/" {
+		// the source root is never filtered by patterns
+		return false
+	}
+	name := path.Base(indexPath)
+	relativePath := strings.TrimPrefix(indexPath, "/")
+	for _, pattern := range f.patterns {
+		if doublestar.MatchUnvalidated(pattern, name) || doublestar.MatchUnvalidated(pattern, relativePath) {
+			return true
+		}
+	}
+	for _, re := range f.regexes {
+		if re.MatchString(name) || re.MatchString(indexPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// includesFolder returns true when the folder is one of the included folders, inside one,
+// or a parent that has to be indexed to reach one.
+func (f *compiledFilter) includesFolder(indexPath string) bool {
+	for _, folder := range f.Folders {
+		folder = "/" + strings.Trim(folder, "/")
+		if indexPath == folder || folder == "/" || indexPath == "/" {
+			return true
+		}
+		if strings.HasPrefix(indexPath, folder+"/") || strings.HasPrefix(folder, indexPath+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldSkip returns true when the item must not be indexed, see settings.IndexFilter for the precedence.
+func (idx *Index) shouldSkip(isDir bool, isHidden bool, fullCombined string) bool {
+	if idx.Config.DisableIndexing {
+		return true
+	}
+	idx.filtersOnce.Do(idx.compileFilters)
+
+	// check exclusions first, they always win
+	if idx.Config.IgnoreHidden && isHidden {
+		return true
+	}
+	if isDir {
+		if slices.Contains(idx.exclude.Folders, fullCombined) || idx.exclude.matchesPattern(fullCombined) {
+			return true
+		}
+		// include patterns only restrict files
+		return len(idx.include.Folders) > 0 && !idx.include.includesFolder(fullCombined)
+	}
+	if idx.exclude.matchesFile(fullCombined) {
+		return true
+	}
+	return idx.include.hasFileRules() && !idx.include.matchesFile(fullCombined)
+}
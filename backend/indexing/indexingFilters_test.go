@@ -0,0 +1,185 @@
+package indexing
+
+import (
+	"filebrowser/common/settings"
+	"testing"
+)
+
+func TestShouldSkip(t *testing.T) {
+	type check struct {
+		path   string
+		isDir  bool
+		hidden bool
+		skip   bool
+	}
+	testCases := map[string]struct {
+		config settings.SourceConfig
+		checks []check
+	}{
+		"no rules": {
+			checks: []check{
+				{path: "/", isDir: true},
+				{path: "/a/b.txt"},
+				{path: "/.git", isDir: true, hidden: true},
+			},
+		},
+		"exact paths stay exact": {
+			config: settings.SourceConfig{Exclude: settings.IndexFilter{
+				Files:   []string{"/a/secret.txt"},
+				Folders: []string{"/a/private"},
+			}},
+			checks: []check{
+				{path: "/a/secret.txt", skip: true},
+				{path: "/b/secret.txt"},
+				{path: "/a/private", isDir: true, skip: true},
+				{path: "/b/a/private", isDir: true},
+			},
+		},
+		"glob on base name anywhere": {
+			config: settings.SourceConfig{Exclude: settings.IndexFilter{
+				Patterns: []string{"node_modules", ".git", "*.tmp"},
+			}},
+			checks: []check{
+				{path: "/node_modules", isDir: true, skip: true},
+				{path: "/web/app/node_modules", isDir: true, skip: true},
+				{path: "/web/.git", isDir: true, skip: true},
+				{path: "/web/.gitignore"},
+				{path: "/a/b/c.tmp", skip: true},
+				{path: "/a/b/c.tmp.txt"},
+				{path: "/a/tmp", isDir: true},
+			},
+		},
+		"glob on full path": {
+			config: settings.SourceConfig{Exclude: settings.IndexFilter{
+				Patterns: []string{"/projects/*/build", "**/cache/**/*.bin"},
+			}},
+			checks: []check{
+				{path: "/projects/app/build", isDir: true, skip: true},
+				{path: "/projects/app/src/build", isDir: true},
+				{path: "/other/app/build", isDir: true},
+				{path: "/cache/x.bin", skip: true},
+				{path: "/a/cache/b/c/x.bin", skip: true},
+				{path: "/a/cache/b/c/x.txt"},
+			},
+		},
+		"root is never matched by patterns": {
+			config: settings.SourceConfig{Exclude: settings.IndexFilter{
+				Patterns: []string{"**", "*"},
+				Regexes:  []string{".*"},
+			}},
+			checks: []check{
+				{path: "/", isDir: true},
+				{path: "/a", isDir: true, skip: true},
+			},
+		},
+		"regexes are anchored": {
+			config: settings.SourceConfig{Exclude: settings.IndexFilter{
+				Regexes: []string{`cache-[0-9]+`, `/logs/.*\.log`},
+			}},
+			checks: []check{
+				{path: "/a/cache-12", isDir: true, skip: true},
+				{path: "/a/cache-12-old", isDir: true},
+				{path: "/a/my-cache-12", isDir: true},
+				{path: "/logs/app.log", skip: true},
+				{path: "/logs/nested/app.log", skip: true},
+				{path: "/old/logs/app.log"},
+			},
+		},
+		"invalid rules are ignored": {
+			config: settings.SourceConfig{Exclude: settings.IndexFilter{
+				Patterns: []string{"[unclosed"},
+				Regexes:  []string{"(unclosed"},
+			}},
+			checks: []check{
+				{path: "/[unclosed"},
+				{path: "/(unclosed"},
+			},
+		},
+		"include files matches any rule": {
+			config: settings.SourceConfig{Include: settings.IndexFilter{
+				Files:        []string{"/docs/readme.md"},
+				FileEndsWith: []string{".jpg"},
+				Patterns:     []string{"*.png"},
+			}},
+			checks: []check{
+				{path: "/docs/readme.md"},
+				{path: "/photos/a.jpg"},
+				{path: "/photos/b.png"},
+				{path: "/photos/c.gif", skip: true},
+				// include patterns only restrict files
+				{path: "/photos", isDir: true},
+			},
+		},
+		"include folders keep parents and children": {
+			config: settings.SourceConfig{Include: settings.IndexFilter{
+				Folders: []string{"/media/photos"},
+			}},
+			checks: []check{
+				{path: "/", isDir: true},
+				{path: "/media", isDir: true},
+				{path: "/media/photos", isDir: true},
+				{path: "/media/photos/2024", isDir: true},
+				{path: "/media/photos-old", isDir: true, skip: true},
+				{path: "/media/videos", isDir: true, skip: true},
+			},
+		},
+		"exclude wins over include": {
+			config: settings.SourceConfig{
+				Include: settings.IndexFilter{Patterns: []string{"*.jpg"}, Folders: []string{"/photos"}},
+				Exclude: settings.IndexFilter{Patterns: []string{"thumb-*", "**/.cache"}},
+			},
+			checks: []check{
+				{path: "/photos/a.jpg"},
+				{path: "/photos/thumb-a.jpg", skip: true},
+				{path: "/photos/.cache", isDir: true, skip: true},
+			},
+		},
+		"hidden items": {
+			config: settings.SourceConfig{
+				IgnoreHidden: true,
+				Include:      settings.IndexFilter{Patterns: []string{".*"}},
+			},
+			checks: []check{
+				{path: "/.env", hidden: true, skip: true},
+				{path: "/.config", isDir: true, hidden: true, skip: true},
+			},
+		},
+		"invalid include patterns are ignored": {
+			config: settings.SourceConfig{Include: settings.IndexFilter{
+				Patterns: []string{"[unclosed"},
+				Regexes:  []string{"(unclosed"},
+			}},
+			checks: []check{
+				{path: "/a.txt"},
+				{path: "/a", isDir: true},
+			},
+		},
+		"invalid and valid include patterns": {
+			config: settings.SourceConfig{Include: settings.IndexFilter{
+				Patterns: []string{"[unclosed", "*.md"},
+			}},
+			checks: []check{
+				{path: "/a.md"},
+				{path: "/a.txt", skip: true},
+			},
+		},
+		"disabled indexing": {
+			config: settings.SourceConfig{DisableIndexing: true},
+			checks: []check{
+				{path: "/", isDir: true, skip: true},
+				{path: "/a.txt", skip: true},
+			},
+		},
+	}
+	for name, tt := range testCases {
+		t.Run(name, func(t *testing.T) {
+			idx := newTestIndex("/srv", 1)
+			idx.Config = tt.config
+			for _, c := range tt.checks {
+				if got := idx.shouldSkip(c.isDir, c.hidden, c.path); got != c.skip {
+					t.Errorf("shouldSkip(isDir=%v, hidden=%v, %q) = %v, want %v", c.isDir, c.hidden, c.path, got, c.skip)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package indexing
+
+import (
+	"bufio"
+	"filebrowser/common/utils"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/gtsteffaniak/go-logger/logger"
+)
+
+// ignoreFileName is read from every indexed directory, its gitignore style rules apply to the whole subtree.
+const ignoreFileName = ".fbignore"
+
+type ignoreRule struct {
+	dir      string // index path of the directory holding the ignore file
+	line     int
+	text     string // the rule as written, for logs
+	pattern  string
+	negate   bool // "!" re-includes a previously ignored path
+	dirOnly  bool // trailing "/" only matches directories
+	anchored bool // contains a "/", matched relative to dir instead of against the name
+}
+
+type ignoreFile struct {
+	modTime time.Time
+	size    int64
+	rules   []ignoreRule
+}
+
+// ignoreFiles caches the parsed ignore files of a source, keyed by the index path of their directory.
+type ignoreFiles struct {
+	files map[string]*ignoreFile
+	mu    sync.RWMutex
+}
+
+// parseIgnoreRules reads gitignore syntax, invalid patterns are logged and skipped.
+func parseIgnoreRules(dirPath string, file *os.File) []ignoreRule {
+	rules := []ignoreRule{}
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := ignoreRule{dir: dirPath, line: lineNumber, text: line}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		rule.anchored = strings.Contains(line, "/")
+		rule.pattern = strings.TrimPrefix(line, "/")
+		if rule.pattern == "" || !doublestar.ValidatePattern(rule.pattern) {
+			logger.Warningf("ignoring invalid rule %q in %v", rule.text, path.Join(dirPath, ignoreFileName))
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func (r ignoreRule) matches(indexPath string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	prefix := strings.TrimSuffix(r.dir, "/") + "/"
+	if !strings.HasPrefix(indexPath, prefix) {
+		return false
+	}
+	relativePath := indexPath[len(prefix):]
+	if r.anchored {
+		return doublestar.MatchUnvalidated(r.pattern, relativePath)
+	}
+	return doublestar.MatchUnvalidated(r.pattern, path.Base(relativePath))
+}
+
+func (r ignoreRule) String() string {
+	return fmt.Sprintf("%v:%v %q", path.Join(r.dir, ignoreFileName), r.line, r.text)
+}
+
+// refreshIgnoreFile reloads the ignore file of a directory when it was added, changed or removed
+// and returns true in that case, so the caller can rescan the subtree with the new rules.
+func (idx *Index) refreshIgnoreFile(dirPath string) bool {
+	realPath := filepath.Join(idx.Path, dirPath, ignoreFileName)
+	info, err := os.Stat(realPath)
+	idx.ignores.mu.RLock()
+	cached, exists := idx.ignores.files[dirPath]
+	idx.ignores.mu.RUnlock()
+	if err != nil {
+		if !exists {
+			return false
+		}
+		idx.ignores.mu.Lock()
+		delete(idx.ignores.files, dirPath)
+		idx.ignores.mu.Unlock()
+		logger.Debugf("removed ignore rules of %v", dirPath)
+		return true
+	}
+	if exists && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return false
+	}
+	file, err := os.Open(realPath)
+	if err != nil {
+		logger.Debugf("could not read %v: %v", realPath, err)
+		return false
+	}
+	defer file.Close()
+	loaded := &ignoreFile{
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		rules:   parseIgnoreRules(dirPath, file),
+	}
+	idx.ignores.mu.Lock()
+	if idx.ignores.files == nil {
+		idx.ignores.files = make(map[string]*ignoreFile)
+	}
+	idx.ignores.files[dirPath] = loaded
+	idx.ignores.mu.Unlock()
+	logger.Debugf("loaded %v ignore rules from %v", len(loaded.rules), realPath)
+	return true
+}
+
+// ignoredBy returns the rule that excludes the path, nil if it is not ignored.
+// Rules of deeper ignore files and later lines take precedence, like git. Also like git,
+// a path can not be re-included by a negated rule when one of its parent directories is excluded.
+func (idx *Index) ignoredBy(indexPath string, isDir bool) *ignoreRule {
+	idx.ignores.mu.RLock()
+	defer idx.ignores.mu.RUnlock()
+	if len(idx.ignores.files) == 0 {
+		return nil
+	}
+	parents := []string{}
+	for dir := utils.GetParentDirectoryPath(indexPath); dir != "" && dir != "/"; dir = utils.GetParentDirectoryPath(dir) {
+		parents = append(parents, dir)
+	}
+	for i := len(parents) - 1; i >= 0; i-- {
+		if rule := idx.matchIgnoreRules(parents[i], true); rule != nil {
+			return rule
+		}
+	}
+	return idx.matchIgnoreRules(indexPath, isDir)
+}
+
+// matchIgnoreRules returns the rule that excludes the path itself, without looking at its
+// parent directories. Must be called with idx.ignores.mu held.
+func (idx *Index) matchIgnoreRules(indexPath string, isDir bool) *ignoreRule {
+	for dir := utils.GetParentDirectoryPath(indexPath); dir != ""; dir = utils.GetParentDirectoryPath(dir) {
+		if file, ok := idx.ignores.files[dir]; ok {
+			for i := len(file.rules) - 1; i >= 0; i-- {
+				rule := file.rules[i]
+				if !rule.matches(indexPath, isDir) {
+					continue
+				}
+				if rule.negate {
+					return nil
+				}
+				return &rule
+			}
+		}
+	}
+	return nil
+}
+
+// isIgnored checks the ignore files of the parent directories and logs the matching rule.
+func (idx *Index) isIgnored(indexPath string, isDir bool) bool {
+	return idx.logIgnored(indexPath, idx.ignoredBy(indexPath, isDir))
+}
+
+// isIgnoredEntry is isIgnored for an entry of a directory that is known not to be ignored,
+// the parent directories don't have to be checked again.
+func (idx *Index) isIgnoredEntry(indexPath string, isDir bool) bool {
+	idx.ignores.mu.RLock()
+	rule := idx.matchIgnoreRules(indexPath, isDir)
+	idx.ignores.mu.RUnlock()
+	return idx.logIgnored(indexPath, rule)
+}
+
+func (idx *Index) logIgnored(indexPath string, rule *ignoreRule) bool {
+	if rule == nil {
+		return false
+	}
+	logger.Debugf("skipping %v due to ignore rule %v", indexPath, rule)
+	return true
+}
+
+// forgetIgnoreFiles drops cached ignore files of directories no longer in the index,
+// must be called with idx.mu held.
+func (idx *Index) forgetIgnoreFiles() {
+	idx.ignores.mu.Lock()
+	defer idx.ignores.mu.Unlock()
+	for dir := range idx.ignores.files {
+		if !idx.store.has(dir) {
+			delete(idx.ignores.files, dir)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package indexing
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// loadIgnoreRules parses content as the ignore file of dirPath.
+func loadIgnoreRules(t *testing.T, dirPath, content string) []ignoreRule {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), ignoreFileName)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	return parseIgnoreRules(dirPath, file)
+}
+
+func TestParseIgnoreRules(t *testing.T) {
+	testCases := map[string]struct {
+		content string
+		want    []ignoreRule
+	}{
+		"name":             {content: "*.log", want: []ignoreRule{{line: 1, text: "*.log", pattern: "*.log"}}},
+		"comments, blanks": {content: "# comment\n\n  \n*.tmp  \r\n", want: []ignoreRule{{line: 4, text: "*.tmp", pattern: "*.tmp"}}},
+		"negation":         {content: "!keep.log", want: []ignoreRule{{line: 1, text: "!keep.log", pattern: "keep.log", negate: true}}},
+		"escaped !":        {content: `\!important`, want: []ignoreRule{{line: 1, text: `\!important`, pattern: "!important"}}},
+		"escaped #":        {content: `\#notes`, want: []ignoreRule{{line: 1, text: `\#notes`, pattern: "#notes"}}},
+		"dir only":         {content: "build/", want: []ignoreRule{{line: 1, text: "build/", pattern: "build", dirOnly: true}}},
+		"anchored":         {content: "/build", want: []ignoreRule{{line: 1, text: "/build", pattern: "build", anchored: true}}},
+		"nested anchored":  {content: "docs/*.pdf", want: []ignoreRule{{line: 1, text: "docs/*.pdf", pattern: "docs/*.pdf", anchored: true}}},
+		"negated dir only": {content: "!/cache/", want: []ignoreRule{{line: 1, text: "!/cache/", pattern: "cache", negate: true, dirOnly: true, anchored: true}}},
+		"invalid skipped":  {content: "[unclosed\n/\nok", want: []ignoreRule{{line: 3, text: "ok", pattern: "ok"}}},
+	}
+	for name, tt := range testCases {
+		t.Run(name, func(t *testing.T) {
+			got := loadIgnoreRules(t, "/dir", tt.content)
+			for i := range tt.want {
+				tt.want[i].dir = "/dir"
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rules = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIgnoredBy(t *testing.T) {
+	idx := newTestIndex(t.TempDir(), 1)
+	idx.ignores.files = map[string]*ignoreFile{
+		"/":         {rules: loadIgnoreRules(t, "/", "*.log\n!keep.log\nbuild/\n/tmp\ndocs/*.pdf\n")},
+		"/app":      {rules: loadIgnoreRules(t, "/app", "!debug.log\ndebug.log\n!*.log\n")},
+		"/app/deep": {rules: loadIgnoreRules(t, "/app/deep", "*.log\n")},
+		"/build":    {rules: loadIgnoreRules(t, "/build", "!keep\n")},
+	}
+	testCases := map[string]struct {
+		path    string
+		isDir   bool
+		ignored bool
+		rule    string // text of the matching rule
+	}{
+		"matched by name":                  {path: "/a/b.log", ignored: true, rule: "*.log"},
+		"later line re-includes":           {path: "/a/keep.log"},
+		"not matched":                      {path: "/a/b.txt"},
+		"dir only rule on a file":          {path: "/a/build"},
+		"dir only rule on a dir":           {path: "/a/build", isDir: true, ignored: true, rule: "build/"},
+		"anchored at its file":             {path: "/tmp", isDir: true, ignored: true, rule: "/tmp"},
+		"anchored, deeper path":            {path: "/a/tmp", isDir: true},
+		"anchored with a slash":            {path: "/docs/a.pdf", ignored: true, rule: "docs/*.pdf"},
+		"anchored with a slash, deeper":    {path: "/a/docs/a.pdf"},
+		"deeper file wins":                 {path: "/app/b.log"},
+		"later line wins in a file":        {path: "/app/debug.log"},
+		"deepest file wins":                {path: "/app/deep/c.log", ignored: true, rule: "*.log"},
+		"excluded parent":                  {path: "/build/keep", ignored: true, rule: "build/"},
+		"excluded parent, deeper file":     {path: "/build/sub/a.txt", ignored: true, rule: "build/"},
+		"excluded parent of a directory":   {path: "/build/keep", isDir: true, ignored: true, rule: "build/"},
+		"excluded grandparent is followed": {path: "/a/build/x/y.txt", ignored: true, rule: "build/"},
+	}
+	for name, tt := range testCases {
+		t.Run(name, func(t *testing.T) {
+			rule := idx.ignoredBy(tt.path, tt.isDir)
+			if (rule != nil) != tt.ignored {
+				t.Fatalf("ignoredBy(%q, %v) = %v, want ignored: %v", tt.path, tt.isDir, rule, tt.ignored)
+			}
+			if rule != nil && rule.text != tt.rule {
+				t.Errorf("ignored by %v, want %q", rule, tt.rule)
+			}
+		})
+	}
+}
+
+func TestRefreshIgnoreFile(t *testing.T) {
+	root := createTestTree(t, 2, 2, 1)
+	idx := newTestIndex(root, 1)
+	scan := func(kind scanKind) {
+		t.Helper()
+		if err := idx.indexDirectory("/", kind, true); err != nil {
+			t.Fatal(err)
+		}
+		idx.mu.Lock()
+		idx.garbageCollection()
+		idx.mu.Unlock()
+	}
+	files := func(indexPath string) int {
+		info, _ := idx.GetMetadataInfo(indexPath, true)
+		return len(info.Files)
+	}
+	scan(fullScan)
+	if idx.refreshIgnoreFile("/") {
+		t.Error("refresh without an ignore file reported a change")
+	}
+	ignorePath := filepath.Join(root, ignoreFileName)
+	writeTestFile(t, ignorePath, "*.txt\n")
+	if !idx.refreshIgnoreFile("/") {
+		t.Error("new ignore file was not loaded")
+	}
+	if idx.refreshIgnoreFile("/") {
+		t.Error("unchanged ignore file reported a change")
+	}
+	scan(fullScan)
+	if got := files("/dir0/dir1"); got != 0 {
+		t.Fatalf("%v files indexed in /dir0/dir1, want them ignored", got)
+	}
+
+	// same size, only the modification time tells the rules changed
+	writeTestFile(t, ignorePath, "*.log\n")
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(ignorePath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	// a quick scan does not read unchanged directories, the changed rules make it a full one
+	scan(quickScan)
+	if got := files("/dir0/dir1"); got != 1 {
+		t.Errorf("%v files indexed in /dir0/dir1 after the rules changed, want 1", got)
+	}
+
+	writeTestFile(t, ignorePath, "/dir1/\n")
+	scan(quickScan)
+	if idx.store.has("/dir1") || !idx.store.has("/dir0/dir1") {
+		t.Errorf("after ignoring /dir1 it is indexed: %v, /dir0/dir1 indexed: %v", idx.store.has("/dir1"), idx.store.has("/dir0/dir1"))
+	}
+	if err := os.Remove(ignorePath); err != nil {
+		t.Fatal(err)
+	}
+	scan(quickScan)
+	if !idx.store.has("/dir1/dir0") {
+		t.Error("directory was not indexed again after removing the ignore file")
+	}
+	if len(idx.ignores.files) != 0 {
+		t.Errorf("removed ignore file is still cached: %v", idx.ignores.files)
+	}
+}
@@ -0,0 +1,227 @@
+package indexing
+
+import (
+	"filebrowser/indexing/iteminfo"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultJournalSize = 10000
+	defaultChangesPage = 100
+	maxChangesPage     = 1000
+)
+
+type ChangeType string
+
+const (
+	ChangeCreated  ChangeType = "created"
+	ChangeModified ChangeType = "modified"
+	ChangeDeleted  ChangeType = "deleted" // for folders this covers everything they contained
+)
+
+type Change struct {
+	Seq   uint64     `json:"seq"` // increasing per source, used as paging cursor
+	Time  time.Time  `json:"time"`
+	Type  ChangeType `json:"type"`
+	Path  string     `json:"path"` // index path
+	IsDir bool       `json:"isDir,omitempty"`
+	Size  int64      `json:"size"`
+}
+
+type ChangeQuery struct {
+	Since  time.Time // only changes after this time
+	Scope  string    // only changes at or below this index path, defaults to "/"
+	Cursor uint64    // NextCursor of the previous page, 0 for the first page
+	Limit  int       // defaults to 100, at most 1000
+}
+
+type ChangePage struct {
+	Changes    []Change `json:"changes"`
+	NextCursor uint64   `json:"nextCursor"` // 0 when there are no more changes
+	// Complete is false when the journal already dropped changes after Since,
+	// callers then have to resync from the index instead.
+	Complete bool `json:"complete"`
+}
+
+// changeJournal is a ring buffer of the latest changes of a source.
+type changeJournal struct {
+	entries []Change
+	head    int // position of the oldest entry once the buffer is full
+	lastSeq uint64
+	dropped time.Time // time of the newest entry that was overwritten
+	mu      sync.RWMutex
+}
+
+func (idx *Index) journalSize() int {
+	if idx.Config.ChangeJournalSize == 0 {
+		return defaultJournalSize
+	}
+	return idx.Config.ChangeJournalSize
+}
+
+func (idx *Index) journalAdd(changes []Change) {
+	limit := idx.journalSize()
+	if limit < 0 || len(changes) == 0 {
+		return
+	}
+	j := &idx.journal
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	for _, change := range changes {
+		j.lastSeq++
+		change.Seq = j.lastSeq
+		change.Time = now
+		if len(j.entries) < limit {
+			j.entries = append(j.entries, change)
+			continue
+		}
+		j.dropped = j.entries[j.head].Time
+		j.entries[j.head] = change
+		j.head = (j.head + 1) % len(j.entries)
+	}
+}
+
+// ordered returns the entries oldest first, must be called with j.mu held.
+func (j *changeJournal) ordered() []Change {
+	return append(append([]Change{}, j.entries[j.head:]...), j.entries[:j.head]...)
+}
+
+// journalDirChanges compares a freshly read directory with its previous state.
+// Folders are reported as created or deleted, changes inside them are reported when they are read.
+// Nothing is recorded during the initial scan.
+func (idx *Index) journalDirChanges(previous, current *iteminfo.FileInfo) {
+	idx.mu.RLock()
+	initialScan := idx.LastIndexed.IsZero()
+	idx.mu.RUnlock()
+	if initialScan || idx.journalSize() < 0 {
+		return
+	}
+	prefix := strings.TrimSuffix(current.Path, "/") + "/"
+	changes := []Change{}
+	if previous == nil {
+		for _, folder := range current.Folders {
+			changes = append(changes, Change{Type: ChangeCreated, Path: prefix + folder.Name, IsDir: true})
+		}
+		for _, file := range current.Files {
+			changes = append(changes, Change{Type: ChangeCreated, Path: prefix + file.Name, Size: file.Size})
+		}
+		idx.journalAdd(changes)
+		return
+	}
+	previousFolders := make(map[string]bool, len(previous.Folders))
+	for _, folder := range previous.Folders {
+		previousFolders[folder.Name] = true
+	}
+	for _, folder := range current.Folders {
+		if !previousFolders[folder.Name] {
+			changes = append(changes, Change{Type: ChangeCreated, Path: prefix + folder.Name, IsDir: true})
+		}
+		delete(previousFolders, folder.Name)
+	}
+	for name := range previousFolders {
+		changes = append(changes, Change{Type: ChangeDeleted, Path: prefix + name, IsDir: true})
+	}
+	previousFiles := make(map[string]iteminfo.ItemInfo, len(previous.Files))
+	for _, file := range previous.Files {
+		previousFiles[file.Name] = file
+	}
+	for _, file := range current.Files {
+		old, ok := previousFiles[file.Name]
+		if !ok {
+			changes = append(changes, Change{Type: ChangeCreated, Path: prefix + file.Name, Size: file.Size})
+		} else if !old.ModTime.Equal(file.ModTime) || old.Size != file.Size {
+			changes = append(changes, Change{Type: ChangeModified, Path: prefix + file.Name, Size: file.Size})
+		}
+		delete(previousFiles, file.Name)
+	}
+	for name, file := range previousFiles {
+		changes = append(changes, Change{Type: ChangeDeleted, Path: prefix + name, Size: file.Size})
+	}
+	idx.journalAdd(changes)
+}
+
+// ChangesSince returns all journaled changes after t, oldest first.
+func (idx *Index) ChangesSince(t time.Time) []Change {
+	idx.journal.mu.RLock()
+	defer idx.journal.mu.RUnlock()
+	changes := []Change{}
+	for _, change := range idx.journal.ordered() {
+		if change.Time.After(t) {
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+// Changes returns a page of journaled changes below the query scope, oldest first.
+func (idx *Index) Changes(query ChangeQuery) ChangePage {
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultChangesPage
+	} else if limit > maxChangesPage {
+		limit = maxChangesPage
+	}
+	scope := normalizeScope(query.Scope)
+	scopePrefix := strings.TrimSuffix(scope, "/") + "/"
+
+	idx.journal.mu.RLock()
+	defer idx.journal.mu.RUnlock()
+	page := ChangePage{
+		Changes:  []Change{},
+		Complete: !idx.journal.dropped.After(query.Since),
+	}
+	for _, change := range idx.journal.ordered() {
+		if change.Seq <= query.Cursor || !change.Time.After(query.Since) {
+			continue
+		}
+		if change.Path != scope && !strings.HasPrefix(change.Path, scopePrefix) {
+			continue
+		}
+		if len(page.Changes) == limit {
+			page.NextCursor = page.Changes[limit-1].Seq
+			break
+		}
+		page.Changes = append(page.Changes, change)
+	}
+	return page
+}
+
+// GetChanges returns a page of the change journal of a source.
+func GetChanges(sourceName string, query ChangeQuery) (ChangePage, error) {
+	idx := GetIndex(sourceName)
+	if idx == nil {
+		return ChangePage{}, fmt.Errorf("index %s not found", sourceName)
+	}
+	return idx.Changes(query), nil
+}
+
+func (j *changeJournal) snapshot() []Change {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.ordered()
+}
+
+// restoreJournal loads persisted changes, keeping the newest when the configured size shrank.
+func (idx *Index) restoreJournal(changes []Change) {
+	limit := idx.journalSize()
+	j := &idx.journal
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.head = 0
+	j.entries = nil
+	if len(changes) > 0 {
+		j.lastSeq = changes[len(changes)-1].Seq
+	}
+	if limit < 0 {
+		return
+	}
+	if len(changes) > limit {
+		j.dropped = changes[len(changes)-limit-1].Time
+		changes = changes[len(changes)-limit:]
+	}
+	j.entries = changes
+}
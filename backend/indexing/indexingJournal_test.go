@@ -0,0 +1,124 @@
+package indexing
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func journalPaths(changes []Change) []string {
+	paths := []string{}
+	for _, change := range changes {
+		paths = append(paths, fmt.Sprintf("%v %v", change.Type, change.Path))
+	}
+	return paths
+}
+
+func addTestChanges(idx *Index, paths ...string) {
+	changes := []Change{}
+	for _, path := range paths {
+		changes = append(changes, Change{Type: ChangeCreated, Path: path})
+	}
+	idx.journalAdd(changes)
+}
+
+func TestJournalRingBuffer(t *testing.T) {
+	idx := newTestIndex(t.TempDir(), 1)
+	idx.Config.ChangeJournalSize = 3
+	addTestChanges(idx, "/a", "/b")
+	page := idx.Changes(ChangeQuery{})
+	if !page.Complete || len(page.Changes) != 2 {
+		t.Fatalf("journal below its size: complete=%v changes=%v", page.Complete, page.Changes)
+	}
+
+	addTestChanges(idx, "/c", "/d", "/e")
+	page = idx.Changes(ChangeQuery{})
+	if got := journalPaths(page.Changes); !reflect.DeepEqual(got, []string{"created /c", "created /d", "created /e"}) {
+		t.Errorf("journal kept %v, want the 3 newest changes oldest first", got)
+	}
+	if page.Changes[0].Seq != 3 || page.Changes[2].Seq != 5 {
+		t.Errorf("sequence numbers %v to %v, want 3 to 5", page.Changes[0].Seq, page.Changes[2].Seq)
+	}
+	if page.Complete {
+		t.Error("journal that dropped changes after since should not be complete")
+	}
+	if !idx.Changes(ChangeQuery{Since: time.Now().Add(time.Minute)}).Complete {
+		t.Error("journal should be complete for a time after the dropped changes")
+	}
+
+	// a restart with a smaller journal keeps the newest changes and the sequence
+	restored := newTestIndex(t.TempDir(), 1)
+	restored.Config.ChangeJournalSize = 2
+	restored.restoreJournal(idx.journal.snapshot())
+	addTestChanges(restored, "/f")
+	got := journalPaths(restored.Changes(ChangeQuery{}).Changes)
+	if !reflect.DeepEqual(got, []string{"created /e", "created /f"}) || restored.journal.lastSeq != 6 {
+		t.Errorf("restored journal = %v with last seq %v, want [/e /f] and 6", got, restored.journal.lastSeq)
+	}
+
+	disabled := newTestIndex(t.TempDir(), 1)
+	disabled.Config.ChangeJournalSize = -1
+	addTestChanges(disabled, "/a")
+	if len(disabled.Changes(ChangeQuery{}).Changes) != 0 {
+		t.Error("disabled journal recorded changes")
+	}
+}
+
+func TestChangesPaging(t *testing.T) {
+	idx := newTestIndex(t.TempDir(), 1)
+	addTestChanges(idx, "/docs/a", "/docs2/b", "/docs/c", "/docs", "/docs/sub/d", "/e")
+	want := []string{"created /docs/a", "created /docs/c", "created /docs", "created /docs/sub/d"}
+	got := []string{}
+	query := ChangeQuery{Scope: "/docs/", Limit: 3}
+	for pages := 0; ; pages++ {
+		if pages > len(want) {
+			t.Fatal("paging does not end")
+		}
+		page := idx.Changes(query)
+		got = append(got, journalPaths(page.Changes)...)
+		if page.NextCursor == 0 {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("paged changes = %v, want %v", got, want)
+	}
+	if page := idx.Changes(ChangeQuery{Limit: 6}); len(page.Changes) != 6 || page.NextCursor != 0 {
+		t.Errorf("a page fitting all changes returned %v changes and cursor %v", len(page.Changes), page.NextCursor)
+	}
+}
+
+func TestJournalScanChanges(t *testing.T) {
+	root := createTestTree(t, 1, 1, 2)
+	idx := newTestIndex(root, 1)
+	if err := idx.indexDirectory("/", fullScan, true); err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.Changes(ChangeQuery{}).Changes) != 0 {
+		t.Fatal("the initial scan should not be journaled")
+	}
+	idx.LastIndexed = time.Now()
+
+	// a quick scan reads new directories, they have nothing cached to compare with
+	if err := os.MkdirAll(filepath.Join(root, "dir0", "new"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "dir0", "new", "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(root, "dir0", "file1.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.indexDirectory("/", quickScan, true); err != nil {
+		t.Fatal(err)
+	}
+	got := journalPaths(idx.Changes(ChangeQuery{}).Changes)
+	want := []string{"created /dir0/new/a.txt", "created /dir0/new", "deleted /dir0/file1.txt"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("journaled %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,124 @@
+package indexing
+
+import (
+	"filebrowser/common/utils"
+	"os"
+	"strings"
+	"sync"
+)
+
+// fileID identifies an inode within a source.
+type fileID struct {
+	Dev uint64
+	Ino uint64
+}
+
+// linkTracker makes sure every inode is counted once per source. Hard links are
+// counted at the first path they are found at and bind mounted directories are only descended once.
+// Owners are kept across quick scans, so unchanged directories keep their totals, and reset on full scans.
+type linkTracker struct {
+	owners     map[fileID]string // inode -> index path that counts it
+	rootDev    uint64
+	rootDevSet bool
+	mu         sync.Mutex
+}
+
+// claimLink returns true when indexPath is the path that counts the inode. A claim only
+// stands while its path still exists on disk as the same inode, otherwise it moves to
+// indexPath, eg. when a directory was renamed or a new directory reuses a freed inode.
+func (idx *Index) claimLink(id fileID, indexPath string) bool {
+	l := &idx.links
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.owners == nil {
+		l.owners = make(map[fileID]string)
+	}
+	owner, ok := l.owners[id]
+	if ok && owner != indexPath && idx.linkedAt(id, owner) {
+		return false
+	}
+	l.owners[id] = indexPath
+	return true
+}
+
+// linkedAt reports whether indexPath currently is the inode.
+func (idx *Index) linkedAt(id fileID, indexPath string) bool {
+	info, err := os.Lstat(strings.TrimRight(idx.Path, "/") + indexPath)
+	if err != nil {
+		return false
+	}
+	current, _, _, ok := statIdentity(info)
+	return ok && current == id
+}
+
+func (l *linkTracker) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.owners = make(map[fileID]string)
+}
+
+func (l *linkTracker) setRoot(info os.FileInfo) {
+	id, _, _, ok := statIdentity(info)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rootDev, l.rootDevSet = id.Dev, ok
+}
+
+// boundary returns why a directory must not be descended, or an empty string.
+func (idx *Index) boundary(indexPath string, info os.FileInfo) string {
+	id, _, _, ok := statIdentity(info)
+	if !ok {
+		return ""
+	}
+	idx.links.mu.Lock()
+	otherDevice := idx.links.rootDevSet && id.Dev != idx.links.rootDev
+	idx.links.mu.Unlock()
+	if otherDevice && idx.Config.OneFileSystem {
+		return "mount point on another file system"
+	}
+	if !idx.claimLink(id, indexPath) {
+		return "same directory is already indexed at another path"
+	}
+	return ""
+}
+
+// forgetLinks drops owners whose path is no longer indexed so another link can take over,
+// must be called with idx.mu held.
+func (idx *Index) forgetLinks() {
+	idx.links.mu.Lock()
+	defer idx.links.mu.Unlock()
+	for id, owner := range idx.links.owners {
+		if idx.store.has(owner) || idx.store.has(utils.GetParentDirectoryPath(owner)) {
+			continue
+		}
+		delete(idx.links.owners, id)
+	}
+}
+
+// releaseLinks drops the owners at or below a directory that left the index.
+func (l *linkTracker) releaseLinks(dirPath string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	prefix := strings.TrimSuffix(dirPath, "/") + "/"
+	for id, owner := range l.owners {
+		if owner == dirPath || strings.HasPrefix(owner, prefix) {
+			delete(l.owners, id)
+		}
+	}
+}
+
+func (l *linkTracker) snapshot() map[fileID]string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	owners := make(map[fileID]string, len(l.owners))
+	for id, owner := range l.owners {
+		owners[id] = owner
+	}
+	return owners
+}
+
+func (l *linkTracker) restore(owners map[fileID]string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.owners = owners
+}
@@ -0,0 +1,49 @@
+package indexing
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHardLinkCountedOnce(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"a", "b"} {
+		if err := os.Mkdir(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "a", "file"), make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(root, "a", "file"), filepath.Join(root, "b", "file")); err != nil {
+		t.Skipf("hard links not supported: %v", err)
+	}
+	idx := newTestIndex(root, 1)
+	if err := idx.indexDirectory("/", fullScan, true); err != nil {
+		t.Fatal(err)
+	}
+	size := func(path string) int64 {
+		info, ok := idx.GetMetadataInfo(path, true)
+		if !ok {
+			t.Fatalf("%v is not indexed", path)
+		}
+		return info.Size
+	}
+	if got := size("/"); got != 100 {
+		t.Fatalf("root size = %v, want the linked file counted once", got)
+	}
+
+	// once the counting link is gone, the other one takes over
+	if err := os.Remove(filepath.Join(root, "a", "file")); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{"/a", "/b"} {
+		if err := idx.indexDirectory(dir, directRead, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if size("/a") != 0 || size("/b") != 100 {
+		t.Errorf("sizes after removing a link: a=%v b=%v, want 0 and 100", size("/a"), size("/b"))
+	}
+}
@@ -0,0 +1,209 @@
+package indexing
+
+import (
+	"filebrowser/common/errors"
+	"filebrowser/indexing/iteminfo"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// streamBatch is the number of directory entries read from disk at a time.
+const streamBatch = 1000
+
+// ListDir returns a page of a directory. Indexed directories are served from the index,
+// they are only read from disk again when the directory changed since it was indexed.
+// Directories the index leaves out, and all directories of sources with indexing disabled,
+// are streamed from disk.
+func (idx *Index) ListDir(indexPath string, opts iteminfo.ListOptions) (*iteminfo.DirectoryPage, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	indexPath = idx.MakeIndexPath(filepath.Join(idx.Path, indexPath))
+	if InTrash(indexPath) {
+		return nil, errors.ErrNotExist
+	}
+	if idx.Config.DisableIndexing {
+		return idx.listFsDir(indexPath, opts)
+	}
+	realPath := strings.TrimRight(idx.Path, "/") + indexPath
+	stat, err := os.Stat(realPath)
+	if err != nil {
+		return nil, err
+	}
+	if !stat.IsDir() {
+		return nil, fmt.Errorf("not a directory: %v", indexPath)
+	}
+	if !idx.indexedSince(indexPath, stat) {
+		err = idx.RefreshFileInfo(iteminfo.FileOptions{Path: indexPath, IsDir: true})
+		if err == errors.ErrNotIndexed {
+			// excluded, ignored, hidden or empty directories are never in the index
+			return idx.listFsDir(indexPath, opts)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	idx.mu.RLock()
+	dir, exists := idx.store.get(indexPath)
+	if !exists {
+		idx.mu.RUnlock()
+		return idx.listFsDir(indexPath, opts)
+	}
+	defer idx.mu.RUnlock()
+	page := &iteminfo.DirectoryPage{
+		ItemInfo: iteminfo.ItemInfo{
+			Name:          filepath.Base(realPath),
+			Type:          "directory",
+			Size:          dir.Size,
+			AllocatedSize: dir.AllocatedSize,
+			ModTime:       unpackTime(dir.ModTime),
+			Hidden:        dir.Hidden,
+		},
+		Path: indexPath,
+	}
+	if opts.IsDefaultOrder() {
+		// items are stored in this order, the page is a slice of them
+		if items, cursor, ok := idx.store.slice(dir, opts); ok {
+			page.Items, page.Total, page.Cursor = items, len(dir.Items), cursor
+			return page, nil
+		}
+	}
+	pager := iteminfo.NewPager(opts)
+	for item := range idx.store.items(dir, 0, len(dir.Items)) {
+		pager.Add(item)
+	}
+	page.Items, page.Total, page.Cursor = pager.Page()
+	return page, nil
+}
+
+// indexedSince reports whether the index holds the directory as it is on disk.
+// Adding, removing or renaming entries changes the modification time of a directory.
+func (idx *Index) indexedSince(indexPath string, stat os.FileInfo) bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	dir, exists := idx.store.get(indexPath)
+	return exists && dir.Present && dir.ModTime == packTime(stat.ModTime())
+}
+
+// slice returns a page of a directory in stored order and the cursor of the next page.
+// It returns false when the cursor was made before the directory changed.
+func (s *dirStore) slice(d *storedDir, opts iteminfo.ListOptions) ([]iteminfo.ItemInfo, string, bool) {
+	start := 0
+	if opts.Cursor != "" {
+		position, name := iteminfo.CursorPosition(opts.Cursor)
+		if position < 1 || position > len(d.Items) || s.item(d, position-1).Name != name {
+			return nil, "", false
+		}
+		start = position
+	}
+	start = min(start+opts.Offset, len(d.Items))
+	end := min(start+opts.Limit, len(d.Items))
+	items := make([]iteminfo.ItemInfo, 0, end-start)
+	for item := range s.items(d, start, end) {
+		items = append(items, item)
+	}
+	cursor := ""
+	if end < len(d.Items) && end > start {
+		cursor = iteminfo.PageCursor(items[len(items)-1], end)
+	}
+	return items, cursor, true
+}
+
+// StreamDir reads a directory from disk in batches and yields its items in directory order,
+// without holding the whole directory in memory. Folder sizes are taken from the index when known.
+func (idx *Index) StreamDir(indexPath string) iter.Seq2[iteminfo.ItemInfo, error] {
+	return func(yield func(iteminfo.ItemInfo, error) bool) {
+		realPath := strings.TrimRight(idx.Path, "/") + indexPath
+		dir, err := os.Open(realPath)
+		if err != nil {
+			yield(iteminfo.ItemInfo{}, err)
+			return
+		}
+		defer dir.Close()
+		combinedPath := strings.TrimSuffix(indexPath, "/") + "/"
+		for {
+			entries, err := dir.ReadDir(streamBatch)
+			for _, entry := range entries {
+				file, infoErr := entry.Info()
+				if infoErr != nil {
+					// removed while listing
+					continue
+				}
+				item, ok := idx.streamedItem(file, combinedPath)
+				if ok && !yield(item, nil) {
+					return
+				}
+			}
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				yield(iteminfo.ItemInfo{}, err)
+				return
+			}
+		}
+	}
+}
+
+func (idx *Index) streamedItem(file os.FileInfo, combinedPath string) (iteminfo.ItemInfo, bool) {
+	item := iteminfo.ItemInfo{
+		Name:    file.Name(),
+		ModTime: file.ModTime(),
+		Hidden:  isHidden(file, idx.Path+combinedPath),
+	}
+	if iteminfo.IsDirectory(file) {
+		if file.Name() == "$RECYCLE.BIN" || file.Name() == "System Volume Information" || InTrash(combinedPath+file.Name()) {
+			return item, false
+		}
+		item.Type = "directory"
+		idx.mu.RLock()
+		if dir, exists := idx.store.get(combinedPath + file.Name()); exists {
+			item.Size = dir.Size
+			item.AllocatedSize = dir.AllocatedSize
+		}
+		idx.mu.RUnlock()
+		return item, true
+	}
+	item.DetectType(combinedPath+file.Name(), false)
+	item.Size = file.Size()
+	_, _, item.AllocatedSize, _ = statIdentity(file)
+	return item, true
+}
+
+// listFsDir pages a directory that is not indexed. Entries are streamed from disk and only
+// the items up to the end of the page are kept, so it works on directories of any size.
+func (idx *Index) listFsDir(indexPath string, opts iteminfo.ListOptions) (*iteminfo.DirectoryPage, error) {
+	realPath := strings.TrimRight(idx.Path, "/") + indexPath
+	stat, err := os.Stat(realPath)
+	if err != nil {
+		return nil, err
+	}
+	if !stat.IsDir() {
+		return nil, fmt.Errorf("not a directory: %v", indexPath)
+	}
+	page := &iteminfo.DirectoryPage{
+		ItemInfo: iteminfo.ItemInfo{
+			Name:    filepath.Base(realPath),
+			Type:    "directory",
+			ModTime: stat.ModTime(),
+			Hidden:  isHidden(stat, filepath.Dir(realPath)),
+		},
+		Path: indexPath,
+	}
+	pager := iteminfo.NewPager(opts)
+	for item, err := range idx.StreamDir(indexPath) {
+		if err != nil {
+			return nil, err
+		}
+		page.Size += item.Size
+		page.AllocatedSize += item.AllocatedSize
+		pager.Add(item)
+	}
+	page.Items, page.Total, page.Cursor = pager.Page()
+	return page, nil
+}
@@ -0,0 +1,186 @@
+package indexing
+
+import (
+	"filebrowser/common/settings"
+	"filebrowser/indexing/iteminfo"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func listingNames(items []iteminfo.ItemInfo) []string {
+	out := []string{}
+	for _, item := range items {
+		out = append(out, item.Name)
+	}
+	return out
+}
+
+// newListingTestIndex indexes a tree with a few directories the index leaves out.
+func newListingTestIndex(t *testing.T) (*Index, string) {
+	t.Helper()
+	root := t.TempDir()
+	for path, content := range map[string]string{
+		"docs/img10.jpg": "1",
+		"docs/img2.jpg":  "333",
+		"docs/notes.txt": "22",
+		"docs/sub/a.txt": "4444",
+		"excluded/x.txt": "x",
+		"ignored/y.txt":  "y",
+		".hidden/z.txt":  "z",
+		ignoreFileName:   "ignored/\n",
+	} {
+		writeTestFile(t, filepath.Join(root, path), content)
+	}
+	if err := os.Mkdir(filepath.Join(root, "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// modification times of the entries, the directory itself keeps its own
+	base := time.Now().Add(-24 * time.Hour)
+	for i, name := range []string{"img10.jpg", "notes.txt", "img2.jpg", "sub"} {
+		modTime := base.Add(time.Duration(i) * time.Hour)
+		if err := os.Chtimes(filepath.Join(root, "docs", name), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	idx := newTestIndex(root, 1)
+	idx.Config = settings.SourceConfig{
+		Exclude:               settings.IndexFilter{Folders: []string{"/excluded"}},
+		IgnoreHidden:          true,
+		IgnoreZeroSizeFolders: true,
+	}
+	if err := idx.indexDirectory("/", fullScan, true); err != nil {
+		t.Fatal(err)
+	}
+	return idx, root
+}
+
+func TestListDir(t *testing.T) {
+	idx, _ := newListingTestIndex(t)
+	testCases := map[string]struct {
+		path  string
+		opts  iteminfo.ListOptions
+		want  []string
+		total int
+		more  bool
+	}{
+		"index order":         {path: "/docs", opts: iteminfo.ListOptions{FoldersFirst: true}, want: []string{"sub", "img10.jpg", "img2.jpg", "notes.txt"}, total: 4},
+		"name descending":     {path: "/docs", opts: iteminfo.ListOptions{FoldersFirst: true, Desc: true}, want: []string{"sub", "notes.txt", "img2.jpg", "img10.jpg"}, total: 4},
+		"natural":             {path: "/docs", opts: iteminfo.ListOptions{Sort: "natural"}, want: []string{"img2.jpg", "img10.jpg", "notes.txt", "sub"}, total: 4},
+		"size":                {path: "/docs", opts: iteminfo.ListOptions{Sort: "size"}, want: []string{"img10.jpg", "notes.txt", "img2.jpg", "sub"}, total: 4},
+		"modified":            {path: "/docs", opts: iteminfo.ListOptions{Sort: "modified", Desc: true}, want: []string{"sub", "img2.jpg", "notes.txt", "img10.jpg"}, total: 4},
+		"type":                {path: "/docs", opts: iteminfo.ListOptions{Sort: "type"}, want: []string{"sub", "img10.jpg", "img2.jpg", "notes.txt"}, total: 4},
+		"filter":              {path: "/docs", opts: iteminfo.ListOptions{Filter: "img", Sort: "natural"}, want: []string{"img2.jpg", "img10.jpg"}, total: 2},
+		"offset and limit":    {path: "/docs", opts: iteminfo.ListOptions{FoldersFirst: true, Offset: 1, Limit: 2}, want: []string{"img10.jpg", "img2.jpg"}, total: 4, more: true},
+		"offset past the end": {path: "/docs", opts: iteminfo.ListOptions{FoldersFirst: true, Offset: 10}, want: []string{}, total: 4},
+		"excluded folder":     {path: "/excluded", want: []string{"x.txt"}, total: 1},
+		"ignored folder":      {path: "/ignored", want: []string{"y.txt"}, total: 1},
+		"hidden folder":       {path: "/.hidden", want: []string{"z.txt"}, total: 1},
+		"empty folder":        {path: "/empty", want: []string{}, total: 0},
+	}
+	for name, tt := range testCases {
+		t.Run(name, func(t *testing.T) {
+			page, err := idx.ListDir(tt.path, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := listingNames(page.Items); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("items = %q, want %q", got, tt.want)
+			}
+			if page.Total != tt.total || (page.Cursor != "") != tt.more {
+				t.Errorf("total %v, cursor %q, want %v items and a cursor: %v", page.Total, page.Cursor, tt.total, tt.more)
+			}
+			// reading the directory from disk gives the same page
+			opts := tt.opts
+			if err = opts.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			streamed, err := idx.listFsDir(tt.path, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := listingNames(streamed.Items); !reflect.DeepEqual(got, tt.want) || streamed.Total != tt.total {
+				t.Errorf("listFsDir items = %q of %v, want %q", got, streamed.Total, tt.want)
+			}
+		})
+	}
+	if _, err := idx.ListDir("/docs/notes.txt", iteminfo.ListOptions{}); err == nil {
+		t.Error("listing a file succeeded")
+	}
+	if _, err := idx.ListDir("/missing", iteminfo.ListOptions{}); !os.IsNotExist(err) {
+		t.Errorf("listing a missing folder = %v, want not exist", err)
+	}
+	if _, err := idx.ListDir("/docs", iteminfo.ListOptions{Offset: iteminfo.MaxListOffset + 1}); err == nil {
+		t.Error("an offset past the maximum was accepted")
+	}
+}
+
+func TestListDirCursor(t *testing.T) {
+	idx, root := newListingTestIndex(t)
+	for _, opts := range []iteminfo.ListOptions{{FoldersFirst: true, Limit: 1}, {Sort: "size", Limit: 1}} {
+		paged := []string{}
+		for range 10 {
+			page, err := idx.ListDir("/docs", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			paged = append(paged, listingNames(page.Items)...)
+			if page.Cursor == "" {
+				break
+			}
+			opts.Cursor = page.Cursor
+		}
+		if len(paged) != 4 {
+			t.Errorf("sort %q: pages hold %q, want all 4 items", opts.Sort, paged)
+		}
+	}
+
+	first, err := idx.ListDir("/docs", iteminfo.ListOptions{FoldersFirst: true, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an item sorted before the cursor shifts the stored positions, the cursor is stale
+	writeTestFile(t, filepath.Join(root, "docs", "aaa.txt"), "a")
+	next, err := idx.ListDir("/docs", iteminfo.ListOptions{FoldersFirst: true, Limit: 2, Cursor: first.Cursor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := listingNames(next.Items), []string{"img2.jpg", "notes.txt"}; !reflect.DeepEqual(got, want) || next.Total != 5 {
+		t.Errorf("page after a stale cursor = %q of %v, want %q of 5", got, next.Total, want)
+	}
+}
+
+func TestSliceCursor(t *testing.T) {
+	idx, _ := newListingTestIndex(t)
+	dir, ok := idx.store.get("/docs")
+	if !ok {
+		t.Fatal("/docs is not indexed")
+	}
+	testCases := map[string]struct {
+		cursor string
+		offset int
+		want   []string
+		ok     bool
+	}{
+		"first page":            {want: []string{"sub", "img10.jpg"}, ok: true},
+		"valid cursor":          {cursor: iteminfo.PageCursor(iteminfo.ItemInfo{Name: "img10.jpg"}, 2), want: []string{"img2.jpg", "notes.txt"}, ok: true},
+		"cursor and offset":     {cursor: iteminfo.PageCursor(iteminfo.ItemInfo{Name: "sub"}, 1), offset: 1, want: []string{"img2.jpg", "notes.txt"}, ok: true},
+		"offset past the end":   {offset: 10, want: []string{}, ok: true},
+		"other name":            {cursor: iteminfo.PageCursor(iteminfo.ItemInfo{Name: "img2.jpg"}, 2)},
+		"position zero":         {cursor: iteminfo.PageCursor(iteminfo.ItemInfo{Name: "sub"}, 0)},
+		"position past the end": {cursor: iteminfo.PageCursor(iteminfo.ItemInfo{Name: "notes.txt"}, 5)},
+	}
+	for name, tt := range testCases {
+		t.Run(name, func(t *testing.T) {
+			items, _, ok := idx.store.slice(dir, iteminfo.ListOptions{Cursor: tt.cursor, Offset: tt.offset, Limit: 2})
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && !reflect.DeepEqual(listingNames(items), tt.want) {
+				t.Errorf("items = %q, want %q", listingNames(items), tt.want)
+			}
+		})
+	}
+}
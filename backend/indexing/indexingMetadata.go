@@ -0,0 +1,223 @@
+package indexing
+
+import (
+	"context"
+	"filebrowser/indexing/iteminfo"
+	"filebrowser/indexing/metadata"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mediaDoc is the extracted metadata of a file, it is persisted with the index snapshot.
+type mediaDoc struct {
+	ModTime time.Time
+	Size    int64
+	Type    string             // mimetype, it selects the extractors
+	Meta    *iteminfo.Metadata // nil when the file has no metadata or it was not read yet
+	Pending bool               // queued for extraction
+}
+
+// mediaIndex holds the metadata of photos, audio and video files of a source for metadata searches.
+// Scans only queue changed files, their metadata is read in the background by runMediaExtraction.
+type mediaIndex struct {
+	docs  map[string]*mediaDoc // index path -> doc
+	dirs  docDirs              // directory -> index paths of its docs
+	queue []string             // index paths of pending docs, oldest first
+	wake  chan struct{}        // signals queued files to runMediaExtraction
+	mu    sync.RWMutex
+}
+
+func newMediaIndex() *mediaIndex {
+	return &mediaIndex{
+		docs: make(map[string]*mediaDoc),
+		dirs: make(docDirs),
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// set adds or replaces a document, pending documents are queued. Must be called with m.mu held.
+func (m *mediaIndex) set(indexPath string, doc *mediaDoc) {
+	if _, ok := m.docs[indexPath]; !ok {
+		m.dirs.add(indexPath)
+	}
+	m.docs[indexPath] = doc
+	if doc.Pending {
+		m.queue = append(m.queue, indexPath)
+	}
+}
+
+// remove drops a document, must be called with m.mu held.
+func (m *mediaIndex) remove(indexPath string) {
+	if _, ok := m.docs[indexPath]; !ok {
+		return
+	}
+	delete(m.docs, indexPath)
+	m.dirs.remove(indexPath)
+}
+
+// notify wakes runMediaExtraction without blocking when it is already signalled.
+func (m *mediaIndex) notify() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// removeDir drops the documents of files in the given directory, subdirectories are
+// removed on their own.
+func (m *mediaIndex) removeDir(dirPath string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for path := range m.dirs[dirPath] {
+		m.remove(path)
+	}
+}
+
+// fresh returns the metadata of a file if it was read since the file last changed.
+func (m *mediaIndex) fresh(indexPath string, modTime time.Time, size int64) (*mediaDoc, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	doc, ok := m.docs[indexPath]
+	if !ok || doc.Pending || !doc.ModTime.Equal(modTime) || doc.Size != size {
+		return nil, false
+	}
+	return doc, true
+}
+
+// updateMediaIndex queues the files of a freshly indexed directory that changed since they
+// were last read, and forgets files that are no longer there.
+func (idx *Index) updateMediaIndex(dir *iteminfo.FileInfo) {
+	m := idx.media
+	if m == nil || dir == nil {
+		return
+	}
+	prefix := strings.TrimSuffix(dir.Path, "/") + "/"
+	present := make(map[string]struct{}, len(dir.Files))
+	queued := false
+	m.mu.Lock()
+	for _, item := range dir.Files {
+		if !metadata.Supported(item.Name, item.Type) {
+			continue
+		}
+		indexPath := prefix + item.Name
+		present[indexPath] = struct{}{}
+		if doc, ok := m.docs[indexPath]; ok && doc.ModTime.Equal(item.ModTime) && doc.Size == item.Size {
+			continue
+		}
+		m.set(indexPath, &mediaDoc{ModTime: item.ModTime, Size: item.Size, Type: item.Type, Pending: true})
+		queued = true
+	}
+	for path := range m.dirs[dir.Path] {
+		if _, ok := present[path]; !ok {
+			m.remove(path)
+		}
+	}
+	m.mu.Unlock()
+	if queued {
+		m.notify()
+	}
+}
+
+// runMediaExtraction reads the metadata of queued files until ctx is cancelled.
+func (idx *Index) runMediaExtraction(ctx context.Context) {
+	for {
+		idx.extractPendingMedia(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-idx.media.wake:
+		}
+	}
+}
+
+// extractPendingMedia reads the metadata of the queued files. Files that changed or were removed
+// while they were read are left to their next update, files that could not be read are dropped
+// so the next scan of their directory queues them again.
+func (idx *Index) extractPendingMedia(ctx context.Context) {
+	m := idx.media
+	for ctx.Err() == nil {
+		m.mu.Lock()
+		if len(m.queue) == 0 {
+			m.queue = nil
+			m.mu.Unlock()
+			return
+		}
+		indexPath := m.queue[0]
+		m.queue = m.queue[1:]
+		doc, ok := m.docs[indexPath]
+		m.mu.Unlock()
+		if !ok || !doc.Pending {
+			continue
+		}
+		realPath := strings.TrimRight(idx.Path, "/") + indexPath
+		meta, err := metadata.Extract(realPath, doc.Type, doc.Size, doc.ModTime)
+		m.mu.Lock()
+		if m.docs[indexPath] == doc {
+			if err != nil {
+				m.remove(indexPath)
+			} else {
+				m.docs[indexPath] = &mediaDoc{ModTime: doc.ModTime, Size: doc.Size, Type: doc.Type, Meta: meta}
+			}
+		}
+		m.mu.Unlock()
+	}
+}
+
+// candidates returns the index paths whose metadata satisfies every filter.
+func (m *mediaIndex) candidates(filters []iteminfo.MetadataFilter) map[string]struct{} {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	result := map[string]struct{}{}
+	for path, doc := range m.docs {
+		matched := doc.Meta != nil
+		for _, filter := range filters {
+			if !matched {
+				break
+			}
+			matched = doc.Meta.Matches(filter)
+		}
+		if matched {
+			result[path] = struct{}{}
+		}
+	}
+	return result
+}
+
+// Metadata returns the metadata of a file, from the index when it is still current,
+// otherwise it is read from the file. It returns nil for files without metadata.
+func (idx *Index) Metadata(indexPath, realPath, mimetype string, size int64, modTime time.Time) *iteminfo.Metadata {
+	if idx.media != nil {
+		if doc, ok := idx.media.fresh(indexPath, modTime, size); ok {
+			return doc.Meta
+		}
+	}
+	if !metadata.Supported(realPath, mimetype) {
+		return nil
+	}
+	meta, _ := metadata.Extract(realPath, mimetype, size, modTime)
+	return meta
+}
+
+func (m *mediaIndex) snapshot() map[string]*mediaDoc {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	docs := make(map[string]*mediaDoc, len(m.docs))
+	for path, doc := range m.docs {
+		docs[path] = doc
+	}
+	return docs
+}
+
+// restore loads the documents of a snapshot, files that were still pending are queued again.
+func (m *mediaIndex) restore(docs map[string]*mediaDoc) {
+	m.mu.Lock()
+	for path, doc := range docs {
+		m.set(path, doc)
+	}
+	queued := len(m.queue) > 0
+	m.mu.Unlock()
+	if queued {
+		m.notify()
+	}
+}
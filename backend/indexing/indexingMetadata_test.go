@@ -0,0 +1,89 @@
+package indexing
+
+import (
+	"context"
+	"filebrowser/indexing/iteminfo"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// a jpeg with only a 160x120 frame header
+const testJpeg = "\xFF\xD8\xFF\xC0\x00\x0B\x08\x00\x78\x00\xA0\x01\x01\x11\x00\xFF\xDA\x00\x02"
+
+func mediaCandidates(t *testing.T, idx *Index, field, value string) []string {
+	t.Helper()
+	filter, err := iteminfo.ParseMetadataFilter(field, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := []string{}
+	for path := range idx.media.candidates([]iteminfo.MetadataFilter{filter}) {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestMediaIndexUpdates(t *testing.T) {
+	root := t.TempDir()
+	for path, content := range map[string]string{
+		"photos/a.jpg":       testJpeg,
+		"photos/broken.flac": "not a flac file",
+		"photos2/b.jpg":      testJpeg,
+		"notes.txt":          "text",
+	} {
+		writeTestFile(t, filepath.Join(root, path), content)
+	}
+	idx := newTestIndex(root, 1)
+	idx.media = newMediaIndex()
+	if err := idx.indexDirectory("/", fullScan, true); err != nil {
+		t.Fatal(err)
+	}
+	// scans only queue the files, they are read in the background
+	if len(idx.media.docs) != 3 || len(idx.media.queue) != 3 {
+		t.Fatalf("media index has %v docs and %v queued, want 3 of each", len(idx.media.docs), len(idx.media.queue))
+	}
+	if got := mediaCandidates(t, idx, "width", "160"); len(got) != 0 {
+		t.Errorf("pending files matched %v", got)
+	}
+
+	idx.extractPendingMedia(context.Background())
+	if got := mediaCandidates(t, idx, "width", "160"); !reflect.DeepEqual(got, []string{"/photos/a.jpg", "/photos2/b.jpg"}) {
+		t.Errorf("candidates(width:160) = %v, want both photos", got)
+	}
+	if _, ok := idx.media.docs["/photos/broken.flac"]; ok {
+		t.Error("a file that could not be read was kept, it would not be read again")
+	}
+
+	// the next update of the directory retries the broken file and drops removed files
+	if err := os.Remove(filepath.Join(root, "photos", "a.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.indexDirectory("/photos", directRead, false); err != nil {
+		t.Fatal(err)
+	}
+	if doc, ok := idx.media.docs["/photos/broken.flac"]; !ok || !doc.Pending {
+		t.Error("the broken file was not queued again")
+	}
+	if _, ok := idx.media.docs["/photos/a.jpg"]; ok {
+		t.Error("removed file is still in the media index")
+	}
+
+	idx.removeDirectory("/photos2")
+	if got := mediaCandidates(t, idx, "width", "160"); len(got) != 0 {
+		t.Errorf("candidates after removal = %v, want none", got)
+	}
+	if len(idx.media.docs) != 1 || len(idx.media.dirs) != 1 {
+		t.Errorf("media index keeps %v docs in %v dirs, want 1 in 1", len(idx.media.docs), len(idx.media.dirs))
+	}
+
+	// pending files of a snapshot are queued again
+	restored := newMediaIndex()
+	restored.restore(idx.media.snapshot())
+	if len(restored.queue) != 1 {
+		t.Errorf("restored media index queued %v files, want 1", len(restored.queue))
+	}
+}
@@ -0,0 +1,26 @@
+package indexing
+
+import (
+	"filebrowser/common/metrics"
+)
+
+func init() {
+	metrics.OnScrape(collectIndexMetrics)
+}
+
+// collectIndexMetrics copies the statistics of every index into the metric gauges before a scrape.
+func collectIndexMetrics() {
+	indexesMutex.RLock()
+	defer indexesMutex.RUnlock()
+	metrics.IndexAssessment.Reset()
+	for _, idx := range indexes {
+		idx.mu.RLock()
+		metrics.IndexDirectories.Set(float64(idx.NumDirs), idx.Name)
+		metrics.IndexFiles.Set(float64(idx.NumFiles), idx.Name)
+		metrics.IndexDeleted.Set(float64(idx.NumDeleted), idx.Name)
+		metrics.IndexQuickScanTime.Set(float64(idx.QuickScanTime), idx.Name)
+		metrics.IndexFullScanTime.Set(float64(idx.FullScanTime), idx.Name)
+		metrics.IndexAssessment.Set(1, idx.Name, idx.Assessment)
+		idx.mu.RUnlock()
+	}
+}
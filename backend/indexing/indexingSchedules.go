@@ -2,6 +2,8 @@ package indexing
 
 import (
 	"encoding/json"
+	"filebrowser/common/errors"
+	"filebrowser/common/metrics"
 	"filebrowser/events"
 	"time"
 
@@ -34,14 +36,25 @@ func (idx *Index) PostScan() {
 	}
 }
 func (idx *Index) garbageCollection() {
-	for path := range idx.Directories {
-		_, ok := idx.DirectoriesLedger[path]
-		if !ok {
-			idx.Directories[path] = nil
-			delete(idx.Directories, path)
+	removed := []string{}
+	for path := range idx.store.all("/") {
+		if !idx.DirectoriesLedger[path] {
+			removed = append(removed, path)
+		}
+	}
+	for _, path := range removed {
+		if idx.store.delete(path) {
 			idx.NumDeleted++
+			if idx.content != nil {
+				idx.content.removeDir(path)
+			}
+			if idx.media != nil {
+				idx.media.removeDir(path)
+			}
 		}
 	}
+	idx.forgetIgnoreFiles()
+	idx.forgetLinks()
 	// Reset the ledger for the next scan.
 	idx.DirectoriesLedger = make(map[string]bool)
 }
@@ -59,18 +72,43 @@ func (idx *Index) RunIndexing(origin string, quick bool) {
 		logger.Debugf("Starting full scan for [%v]", idx.Name)
 		idx.NumDirs = 0
 		idx.NumFiles = 0
+		idx.links.reset()
 	}
 	startTime := time.Now()
 	idx.FilesChangedDuringIndexing = false
+	idx.beginScan()
 	// Perform the indexing operation
-	err := idx.indexDirectory("/", quick, true)
+	kind := fullScan
+	if quick {
+		kind = quickScan
+	}
+	err := idx.indexDirectory("/", kind, true)
+	if err == errors.ErrScanCancelled {
+		// directories that weren't reached must survive garbage collection, so it is skipped
+		logger.Infof("Scan cancelled for [%v] after %v", idx.Name, time.Since(startTime).Round(time.Second))
+		idx.mu.Lock()
+		idx.DirectoriesLedger = make(map[string]bool)
+		idx.NumDirs = prevNumDirs
+		idx.NumFiles = prevNumFiles
+		idx.mu.Unlock()
+		idx.SetStatus(READY)
+		return
+	}
 	if err != nil {
 		logger.Errorf("Error during indexing: %v", err)
 	}
+	// the watcher reads LastIndexed while journaling its changes
+	idx.mu.Lock()
 	firstRun := time.Time.Equal(idx.LastIndexed, time.Time{})
 	// Update the LastIndexed time
 	idx.LastIndexed = time.Now()
 	idx.LastIndexedUnix = idx.LastIndexed.Unix()
+	idx.mu.Unlock()
+	scanType := "full"
+	if quick {
+		scanType = "quick"
+	}
+	metrics.IndexScanDuration.Observe(time.Since(startTime).Seconds(), idx.Name, scanType)
 	if quick {
 		idx.QuickScanTime = int(time.Since(startTime).Seconds())
 		logger.Debugf("Time spent indexing [%v]: %v seconds", idx.Name, idx.QuickScanTime)
@@ -99,11 +137,15 @@ func (idx *Index) RunIndexing(origin string, quick bool) {
 	}
 
 	idx.PostScan()
+	if !quick {
+		idx.recordSizeHistory()
+	}
 }
 
 var fullScanAnchor = 3
 
 func (idx *Index) setupIndexingScanners() {
+	idx.startWatcher()
 	go idx.newScanner("/")
 }
 func (idx *Index) UpdateSchedule() {
@@ -133,7 +175,8 @@ func (idx *Index) UpdateSchedule() {
 	}
 }
 func (idx *Index) newScanner(origin string) {
-	fullScanCounter := 0 // every 5th scan is a full scan
+	plan := idx.parseScanPlan()
+	fullScanCounter := 0 // every 5th scan is a full scan, unless full scans have their own schedule
 	for {
 		// Determine sleep time with modifiers
 		fullScanCounter++
@@ -144,15 +187,38 @@ func (idx *Index) newScanner(origin string) {
 		if idx.Config.IndexingInterval > 0 {
 			sleepTime = time.Duration(idx.Config.IndexingInterval) * time.Minute
 		}
+		nextScan, fullScan := plan.next(time.Now(), sleepTime, fullScanCounter)
+		idx.setNextScan(nextScan, fullScan)
 		// Log and sleep before indexing
-		logger.Debugf("Next scan in %v", sleepTime)
-		time.Sleep(sleepTime)
-		if fullScanCounter == 5 {
+		logger.Debugf("Next scan for [%v] at %v, full=%v", idx.Name, nextScan.Format(time.DateTime), fullScan)
+		time.Sleep(time.Until(nextScan))
+		if blockedUntil := plan.blockedUntil(time.Now()); !blockedUntil.IsZero() {
+			if !fullScan {
+				logger.Infof("Skipping quick scan for [%v], inside a no-scan window until %v", idx.Name, blockedUntil.Format(time.TimeOnly))
+				continue
+			}
+			logger.Infof("Deferring full scan for [%v] until the no-scan window ends at %v", idx.Name, blockedUntil.Format(time.TimeOnly))
+			idx.setNextScan(blockedUntil, true)
+			time.Sleep(time.Until(blockedUntil))
+		}
+		if fullScan {
 			idx.RunIndexing(origin, false) // Full scan
 			fullScanCounter = 0
+		} else if idx.fullyWatched() {
+			// the watcher keeps the index current, only full scans are needed
+			logger.Debugf("Skipping quick scan for [%v], all directories are watched", idx.Name)
+			idx.FilesChangedDuringIndexing = false
 		} else {
 			idx.RunIndexing(origin, true) // Quick scan
 		}
+		idx.syncWatches()
+		// full scans also record size history, which is kept with the snapshot
+		if idx.FilesChangedDuringIndexing || fullScan {
+			err := idx.SaveSnapshot()
+			if err != nil {
+				logger.Errorf("could not save index snapshot for [%v]: %v", idx.Name, err)
+			}
+		}
 		idx.UpdateSchedule()
 	}
 }
@@ -0,0 +1,142 @@
+package indexing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// scanTime returns a time on a fixed day, days after it roll over.
+func scanTime(day, hour, minute int) time.Time {
+	return time.Date(2025, 3, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestParseScanWindow(t *testing.T) {
+	testCases := map[string]struct {
+		window  string
+		want    scanWindow
+		wantErr bool
+	}{
+		"same day":            {window: "01:00-05:30", want: scanWindow{start: 60, end: 330}},
+		"across midnight":     {window: "22:00-06:00", want: scanWindow{start: 1320, end: 360}},
+		"spaces":              {window: " 22:00 - 06:00 ", want: scanWindow{start: 1320, end: 360}},
+		"whole day but one":   {window: "00:00-23:59", want: scanWindow{start: 0, end: 1439}},
+		"equal start and end": {window: "10:00-10:00", wantErr: true},
+		"empty":               {window: "", wantErr: true},
+		"no separator":        {window: "10:00", wantErr: true},
+		"missing end":         {window: "10:00-", wantErr: true},
+		"hour out of range":   {window: "25:00-26:00", wantErr: true},
+		"no minutes":          {window: "10-11", wantErr: true},
+		"letters":             {window: "ab:cd-ef:gh", wantErr: true},
+		"three times":         {window: "10:00-11:00-12:00", wantErr: true},
+	}
+	for name, tt := range testCases {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseScanWindow(tt.window)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseScanWindow(%q) error = %v, want error: %v", tt.window, err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("parseScanWindow(%q) = %+v, want %+v", tt.window, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanWindowContains(t *testing.T) {
+	sameDay := scanWindow{start: 60, end: 300}     // 01:00-05:00
+	overnight := scanWindow{start: 1320, end: 360} // 22:00-06:00
+	testCases := map[string]struct {
+		window scanWindow
+		t      time.Time
+		want   bool
+	}{
+		"before":                 {window: sameDay, t: scanTime(1, 0, 59), want: false},
+		"at the start":           {window: sameDay, t: scanTime(1, 1, 0), want: true},
+		"inside":                 {window: sameDay, t: scanTime(1, 3, 0), want: true},
+		"at the end":             {window: sameDay, t: scanTime(1, 5, 0), want: false},
+		"overnight, evening":     {window: overnight, t: scanTime(1, 23, 30), want: true},
+		"overnight, at midnight": {window: overnight, t: scanTime(2, 0, 0), want: true},
+		"overnight, morning":     {window: overnight, t: scanTime(2, 5, 59), want: true},
+		"overnight, at the end":  {window: overnight, t: scanTime(2, 6, 0), want: false},
+		"overnight, daytime":     {window: overnight, t: scanTime(2, 12, 0), want: false},
+		"overnight, at start":    {window: overnight, t: scanTime(2, 22, 0), want: true},
+	}
+	for name, tt := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if got := tt.window.contains(tt.t); got != tt.want {
+				t.Errorf("contains(%v) = %v, want %v", tt.t.Format(time.TimeOnly), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlockedUntil(t *testing.T) {
+	// windows chain into each other in any order: 22:00-23:00, 23:00-01:00, 00:30-02:00
+	chained := scanPlan{windows: []scanWindow{{start: 30, end: 120}, {start: 1380, end: 60}, {start: 1320, end: 1380}}}
+	testCases := map[string]struct {
+		plan scanPlan
+		t    time.Time
+		want time.Time
+	}{
+		"no windows":                {plan: scanPlan{}, t: scanTime(1, 12, 0)},
+		"outside":                   {plan: chained, t: scanTime(1, 12, 0)},
+		"at the end of the last":    {plan: chained, t: scanTime(2, 2, 0)},
+		"last window":               {plan: chained, t: scanTime(2, 1, 30), want: scanTime(2, 2, 0)},
+		"chained across midnight":   {plan: chained, t: scanTime(1, 22, 30), want: scanTime(2, 2, 0)},
+		"middle of the chain":       {plan: chained, t: scanTime(2, 0, 15), want: scanTime(2, 2, 0)},
+		"single window":             {plan: scanPlan{windows: []scanWindow{{start: 60, end: 300}}}, t: scanTime(1, 2, 0), want: scanTime(1, 5, 0)},
+		"single overnight window":   {plan: scanPlan{windows: []scanWindow{{start: 1320, end: 360}}}, t: scanTime(1, 23, 0), want: scanTime(2, 6, 0)},
+		"overnight, after midnight": {plan: scanPlan{windows: []scanWindow{{start: 1320, end: 360}}}, t: scanTime(2, 1, 0), want: scanTime(2, 6, 0)},
+	}
+	for name, tt := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if got := tt.plan.blockedUntil(tt.t); !got.Equal(tt.want) {
+				t.Errorf("blockedUntil(%v) = %v, want %v", tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextScan(t *testing.T) {
+	nightly, err := cron.ParseStandard("0 2 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hourly, err := cron.ParseStandard("0 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	window := []scanWindow{{start: 60, end: 180}} // 01:00-03:00
+	testCases := map[string]struct {
+		plan     scanPlan
+		now      time.Time
+		counter  int
+		want     time.Time
+		wantFull bool
+		blocked  time.Time // end of the no-scan window the scan falls in
+	}{
+		"adaptive":                      {plan: scanPlan{}, now: scanTime(1, 12, 0), counter: 1, want: scanTime(1, 12, 30)},
+		"every 5th scan is full":        {plan: scanPlan{}, now: scanTime(1, 12, 0), counter: 5, want: scanTime(1, 12, 30), wantFull: true},
+		"quick cron":                    {plan: scanPlan{quick: hourly}, now: scanTime(1, 12, 10), counter: 5, want: scanTime(1, 13, 0), wantFull: true},
+		"full cron before quick":        {plan: scanPlan{quick: hourly, full: nightly}, now: scanTime(1, 1, 30), want: scanTime(1, 2, 0), wantFull: true},
+		"quick cron before full":        {plan: scanPlan{quick: hourly, full: nightly}, now: scanTime(1, 12, 10), counter: 5, want: scanTime(1, 13, 0)},
+		"quick tick inside a window":    {plan: scanPlan{quick: hourly, windows: window}, now: scanTime(1, 1, 10), want: scanTime(1, 2, 0), blocked: scanTime(1, 3, 0)},
+		"full tick inside a window":     {plan: scanPlan{quick: hourly, full: nightly, windows: window}, now: scanTime(1, 1, 30), want: scanTime(1, 2, 0), wantFull: true, blocked: scanTime(1, 3, 0)},
+		"adaptive scan inside a window": {plan: scanPlan{windows: window}, now: scanTime(1, 0, 45), want: scanTime(1, 1, 15), blocked: scanTime(1, 3, 0)},
+		"tick at the end of a window":   {plan: scanPlan{quick: hourly, windows: window}, now: scanTime(1, 2, 10), want: scanTime(1, 3, 0)},
+	}
+	for name, tt := range testCases {
+		t.Run(name, func(t *testing.T) {
+			got, full := tt.plan.next(tt.now, 30*time.Minute, tt.counter)
+			if !got.Equal(tt.want) || full != tt.wantFull {
+				t.Errorf("next = %v full=%v, want %v full=%v", got, full, tt.want, tt.wantFull)
+			}
+			// the scanner waits for the window to end, quick scans are skipped then
+			if blocked := tt.plan.blockedUntil(got); !blocked.Equal(tt.blocked) {
+				t.Errorf("scan at %v blocked until %v, want %v", got, blocked, tt.blocked)
+			}
+		})
+	}
+}
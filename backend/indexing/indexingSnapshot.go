@@ -0,0 +1,260 @@
+package indexing
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"filebrowser/common/settings"
+	"filebrowser/common/utils"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gtsteffaniak/go-logger/logger"
+)
+
+const (
+	// bump this whenever the layout of indexSnapshot or iteminfo.FileInfo changes,
+	// older snapshots are then discarded in favour of a full scan.
+	snapshotVersion uint32 = 7
+	snapshotMagic          = "FBIX"
+	// snapshotDirName is the folder inside the cache dir that holds index snapshots.
+	snapshotDirName = "index"
+	// snapshotInterval is how often changed indexes are saved between scans, eg. after watcher updates.
+	snapshotInterval = 15 * time.Minute
+)
+
+// background is cancelled by Shutdown, it stops the background work of all indexes.
+var background, stopBackground = context.WithCancel(context.Background())
+
+// Background returns a context that is cancelled by Shutdown, for background work
+// that should stop with the indexes.
+func Background() context.Context {
+	return background
+}
+
+// snapshotHeader is written in front of the gob encoded snapshot payload.
+type snapshotHeader struct {
+	Magic    [4]byte
+	Version  uint32
+	Checksum [sha256.Size]byte
+}
+
+// indexSnapshot is the persisted state of an index used for warm restarts.
+type indexSnapshot struct {
+	SourcePath    string
+	NumDirs       uint64
+	NumFiles      uint64
+	LastIndexed   time.Time
+	QuickScanTime int
+	FullScanTime  int
+	Assessment    string
+	SmartModifier time.Duration
+	Directories   storeSnapshot
+	Content       map[string]*contentDoc // nil when content indexing is disabled or over its disk limit
+	Media         map[string]*mediaDoc   // nil when metadata indexing is disabled
+	LinkOwners    map[fileID]string      // keeps hard links counted at the same path after a restart
+	Changes       []Change               // change journal, oldest first
+}
+
+func (idx *Index) snapshotPath() string {
+	// hashed so that any source path maps to a safe file name
+	name := utils.HashSHA256(idx.Path)[:16] + ".snapshot"
+	return filepath.Join(settings.Config.Server.CacheDir, snapshotDirName, name)
+}
+
+// SaveSnapshot serializes the index to disk so it can be loaded on the next start.
+func (idx *Index) SaveSnapshot() error {
+	if idx.mock || idx.Config.DisableIndexing {
+		return nil
+	}
+	var content map[string]*contentDoc
+	if idx.content != nil {
+		content = idx.content.persistedDocs()
+	}
+	var media map[string]*mediaDoc
+	if idx.media != nil {
+		media = idx.media.snapshot()
+	}
+	idx.mu.RLock()
+	snapshot := indexSnapshot{
+		SourcePath:    idx.Path,
+		NumDirs:       idx.NumDirs,
+		NumFiles:      idx.NumFiles,
+		LastIndexed:   idx.LastIndexed,
+		QuickScanTime: idx.QuickScanTime,
+		FullScanTime:  idx.FullScanTime,
+		Assessment:    idx.Assessment,
+		SmartModifier: idx.SmartModifier,
+		Directories:   idx.store.snapshot(),
+		Content:       content,
+		Media:         media,
+		LinkOwners:    idx.links.snapshot(),
+		Changes:       idx.journal.snapshot(),
+	}
+	changes := idx.store.changes
+	var payload bytes.Buffer
+	err := gob.NewEncoder(&payload).Encode(&snapshot)
+	idx.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("could not encode index snapshot: %v", err)
+	}
+
+	header := snapshotHeader{
+		Version:  snapshotVersion,
+		Checksum: sha256.Sum256(payload.Bytes()),
+	}
+	copy(header.Magic[:], snapshotMagic)
+
+	path := idx.snapshotPath()
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	// write to a temp file first so a crash never leaves a truncated snapshot behind
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".snapshot-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	err = binary.Write(tmp, binary.LittleEndian, header)
+	if err == nil {
+		_, err = payload.WriteTo(tmp)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return err
+	}
+	idx.savedChanges.Store(changes)
+	logger.Debugf("saved index snapshot for [%v] to %v", idx.Name, path)
+	return nil
+}
+
+// loadSnapshot restores the index from disk, returns an error if the snapshot
+// is missing, outdated or corrupt in which case a full scan is required.
+func (idx *Index) loadSnapshot() error {
+	data, err := os.ReadFile(idx.snapshotPath())
+	if err != nil {
+		return err
+	}
+	reader := bytes.NewReader(data)
+	var header snapshotHeader
+	err = binary.Read(reader, binary.LittleEndian, &header)
+	if err != nil {
+		return fmt.Errorf("could not read snapshot header: %v", err)
+	}
+	if string(header.Magic[:]) != snapshotMagic {
+		return fmt.Errorf("not an index snapshot")
+	}
+	if header.Version != snapshotVersion {
+		return fmt.Errorf("snapshot version %v does not match current version %v", header.Version, snapshotVersion)
+	}
+	payload := data[len(data)-reader.Len():]
+	if sha256.Sum256(payload) != header.Checksum {
+		return fmt.Errorf("snapshot checksum mismatch")
+	}
+	var snapshot indexSnapshot
+	err = gob.NewDecoder(bytes.NewReader(payload)).Decode(&snapshot)
+	if err != nil {
+		return fmt.Errorf("could not decode snapshot: %v", err)
+	}
+	if snapshot.SourcePath != idx.Path {
+		return fmt.Errorf("snapshot belongs to a different source: %v", snapshot.SourcePath)
+	}
+	store := restoreDirStore(snapshot.Directories)
+	if !store.has("/") {
+		return fmt.Errorf("snapshot is missing the root directory")
+	}
+
+	if idx.content != nil && snapshot.Content != nil {
+		idx.content.restore(snapshot.Content)
+	}
+	if idx.media != nil && snapshot.Media != nil {
+		idx.media.restore(snapshot.Media)
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.store = store
+	idx.NumDirs = snapshot.NumDirs
+	idx.NumFiles = snapshot.NumFiles
+	idx.LastIndexed = snapshot.LastIndexed
+	idx.LastIndexedUnix = snapshot.LastIndexed.Unix()
+	idx.QuickScanTime = snapshot.QuickScanTime
+	idx.FullScanTime = snapshot.FullScanTime
+	idx.Assessment = snapshot.Assessment
+	idx.SmartModifier = snapshot.SmartModifier
+	idx.links.restore(snapshot.LinkOwners)
+	idx.restoreJournal(snapshot.Changes)
+	idx.Stale = true
+	return nil
+}
+
+// warmStart verifies a loaded snapshot against the filesystem using a quick scan.
+func (idx *Index) warmStart() {
+	idx.RunIndexing("/", true)
+	idx.mu.Lock()
+	idx.Stale = false
+	idx.mu.Unlock()
+	idx.SendSourceUpdateEvent()
+	logger.Infof("index snapshot verified: [%v] directories=%v files=%v", idx.Name, idx.NumDirs, idx.NumFiles)
+	err := idx.SaveSnapshot()
+	if err != nil {
+		logger.Errorf("could not save index snapshot for [%v]: %v", idx.Name, err)
+	}
+	idx.setupIndexingScanners()
+}
+
+// runSnapshotSaves saves the snapshot at intervals when the index changed since it was saved,
+// until ctx is cancelled.
+func (idx *Index) runSnapshotSaves(ctx context.Context) {
+	ticker := time.NewTicker(snapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		idx.mu.RLock()
+		changed := idx.store.changes != idx.savedChanges.Load()
+		idx.mu.RUnlock()
+		if !changed {
+			continue
+		}
+		if err := idx.SaveSnapshot(); err != nil {
+			logger.Errorf("could not save index snapshot for [%v]: %v", idx.Name, err)
+		}
+	}
+}
+
+// Shutdown stops the background work of the indexes and saves their snapshots,
+// it is called when the server stops.
+func Shutdown() {
+	stopBackground()
+	SaveSnapshots()
+}
+
+// SaveSnapshots persists every index.
+func SaveSnapshots() {
+	indexesMutex.RLock()
+	defer indexesMutex.RUnlock()
+	for _, idx := range indexes {
+		err := idx.SaveSnapshot()
+		if err != nil {
+			logger.Errorf("could not save index snapshot for [%v]: %v", idx.Name, err)
+		}
+	}
+}
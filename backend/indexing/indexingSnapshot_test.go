@@ -0,0 +1,77 @@
+package indexing
+
+import (
+	"filebrowser/common/settings"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	settings.Config.Server.CacheDir = t.TempDir()
+	root := createTestTree(t, 3, 2, 2)
+	idx := newTestIndex(root, 1)
+	if err := idx.indexDirectory("/", fullScan, true); err != nil {
+		t.Fatal(err)
+	}
+	// mock indexes are never saved
+	idx.mock = false
+	if err := idx.SaveSnapshot(); err != nil {
+		t.Fatalf("saving the snapshot failed: %v", err)
+	}
+	if idx.savedChanges.Load() != idx.store.changes {
+		t.Errorf("saved changes = %v, want %v", idx.savedChanges.Load(), idx.store.changes)
+	}
+
+	loaded := newTestIndex(root, 1)
+	if err := loaded.loadSnapshot(); err != nil {
+		t.Fatalf("loading the snapshot failed: %v", err)
+	}
+	if loaded.NumDirs != idx.NumDirs || loaded.NumFiles != idx.NumFiles || !loaded.Stale {
+		t.Errorf("loaded %v dirs and %v files (stale %v), want %v and %v", loaded.NumDirs, loaded.NumFiles, loaded.Stale, idx.NumDirs, idx.NumFiles)
+	}
+	for path, dir := range idx.store.all("/") {
+		restored, ok := loaded.store.get(path)
+		if !ok || restored.Size != dir.Size || restored.Names != dir.Names {
+			t.Errorf("directory %v was not restored", path)
+		}
+	}
+
+	// a flipped byte in the payload fails the header checksum
+	data, err := os.ReadFile(idx.snapshotPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err = os.WriteFile(idx.snapshotPath(), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	err = newTestIndex(root, 1).loadSnapshot()
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("loading a corrupt snapshot: got error %v, want a checksum mismatch", err)
+	}
+}
+
+func TestSizeHistoryOutlivesSnapshot(t *testing.T) {
+	settings.Config.Server.CacheDir = t.TempDir()
+	root := createTestTree(t, 2, 1, 1)
+	idx := newTestIndex(root, 1)
+	if err := idx.indexDirectory("/", fullScan, true); err != nil {
+		t.Fatal(err)
+	}
+	idx.mock = false
+	idx.recordSizeHistory()
+	if err := idx.SaveSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	// a snapshot of an older format is discarded, the history is not
+	if err := os.Remove(idx.snapshotPath()); err != nil {
+		t.Fatal(err)
+	}
+	loaded := newTestIndex(root, 1)
+	loaded.loadSizeHistory()
+	history := loaded.SizeHistory()
+	if len(history) != 2 || len(history["/dir0"]) != 1 || history["/dir0"][0].Size != idx.SizeHistory()["/dir0"][0].Size {
+		t.Errorf("loaded size history %v, want %v", history, idx.SizeHistory())
+	}
+}
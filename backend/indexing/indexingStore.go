@@ -0,0 +1,441 @@
+package indexing
+
+import (
+	"filebrowser/indexing/iteminfo"
+	"iter"
+	"math"
+	"strings"
+	"time"
+)
+
+// dirStore keeps the indexed directories of a source in a compact form for very large sources.
+// Directories form a trie of path segments instead of being keyed by their full path,
+// the names of all items of a directory share a single string, mimetypes are interned
+// and times are stored as unix nanoseconds. It is not safe for concurrent use, all access
+// goes through idx.mu.
+type dirStore struct {
+	dirs    []*storedDir // id -> directory, nil for free slots
+	free    []int32
+	lookup  map[dirKey]int32
+	types   []string // interned mimetypes, id 0 is the empty type
+	typeIDs map[string]uint16
+	count   int    // directories with content, placeholders are not counted
+	changes uint64 // incremented on every put and delete, tells whether a snapshot is outdated
+}
+
+type dirKey struct {
+	parent int32
+	name   string
+}
+
+// storedDir is a trie node. Exported fields are written to index snapshots.
+type storedDir struct {
+	Parent        int32  // -1 for the root
+	Name          string // path segment, for the root the base name of the source path
+	Present       bool   // false for parents that only exist because a subdirectory was stored first
+	Hidden        bool
+	Size          int64
+	AllocatedSize int64
+	ModTime       int64  // unix nanoseconds
+	Names         string // names of all items concatenated
+	Items         []storedItem
+	Folders       uint32 // the first Folders items are directories, files follow
+	// subdirectory nodes form a linked list, 0 ends it since the root is nobody's child.
+	// A node is removed when it has no children and is not present.
+	firstChild int32
+	prev, next int32 // siblings
+}
+
+type storedItem struct {
+	NameEnd       uint32 // end offset in storedDir.Names, the start is the end of the previous item
+	Type          uint16
+	Hidden        bool
+	Size          int64
+	AllocatedSize int64
+	ModTime       int64
+}
+
+// storeSnapshot is the gob encoded form of a dirStore, without free slots.
+type storeSnapshot struct {
+	Dirs  []storedDir
+	Types []string
+}
+
+const rootDirID int32 = 0
+
+func newDirStore() dirStore {
+	return dirStore{
+		dirs:    []*storedDir{{Parent: -1}},
+		lookup:  make(map[dirKey]int32),
+		types:   []string{""},
+		typeIDs: map[string]uint16{"": 0},
+	}
+}
+
+func packTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func unpackTime(nanos int64) time.Time {
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+func (s *dirStore) typeID(mimetype string) uint16 {
+	id, ok := s.typeIDs[mimetype]
+	if ok {
+		return id
+	}
+	if len(s.types) > math.MaxUint16 {
+		// no realistic source gets here, the type is dropped rather than growing the items
+		return 0
+	}
+	id = uint16(len(s.types))
+	s.types = append(s.types, mimetype)
+	s.typeIDs[mimetype] = id
+	return id
+}
+
+// segments calls fn for every segment of an index path.
+func segments(indexPath string, fn func(segment string) bool) {
+	rest := strings.Trim(indexPath, "/")
+	for rest != "" {
+		segment, next, _ := strings.Cut(rest, "/")
+		if segment != "" && !fn(segment) {
+			return
+		}
+		rest = next
+	}
+}
+
+// find returns the node id of a path, -1 when there is no node.
+func (s *dirStore) find(indexPath string) int32 {
+	id := rootDirID
+	segments(indexPath, func(segment string) bool {
+		child, ok := s.lookup[dirKey{id, segment}]
+		if !ok {
+			id = -1
+			return false
+		}
+		id = child
+		return true
+	})
+	return id
+}
+
+// ensure returns the node id of a path, creating placeholder nodes as needed.
+func (s *dirStore) ensure(indexPath string) int32 {
+	id := rootDirID
+	segments(indexPath, func(segment string) bool {
+		child, ok := s.lookup[dirKey{id, segment}]
+		if !ok {
+			// copied, so the node doesn't keep the caller's full path alive
+			segment = strings.Clone(segment)
+			node := &storedDir{Parent: id, Name: segment}
+			if n := len(s.free); n > 0 {
+				child = s.free[n-1]
+				s.free = s.free[:n-1]
+				s.dirs[child] = node
+			} else {
+				child = int32(len(s.dirs))
+				s.dirs = append(s.dirs, node)
+			}
+			s.lookup[dirKey{id, segment}] = child
+			s.link(id, child)
+		}
+		id = child
+		return true
+	})
+	return id
+}
+
+// get returns a present directory.
+func (s *dirStore) get(indexPath string) (*storedDir, bool) {
+	id := s.find(indexPath)
+	if id < 0 || !s.dirs[id].Present {
+		return nil, false
+	}
+	return s.dirs[id], true
+}
+
+func (s *dirStore) has(indexPath string) bool {
+	_, ok := s.get(indexPath)
+	return ok
+}
+
+func (s *dirStore) len() int {
+	return s.count
+}
+
+// put stores a directory, replacing the previous content of its path.
+func (s *dirStore) put(info *iteminfo.FileInfo) {
+	id := s.ensure(info.Path)
+	d := s.dirs[id]
+	if id == rootDirID {
+		d.Name = info.Name
+	}
+	if !d.Present {
+		s.count++
+	}
+	s.changes++
+	d.Present = true
+	d.Hidden = info.Hidden
+	d.Size = info.Size
+	d.AllocatedSize = info.AllocatedSize
+	d.ModTime = packTime(info.ModTime)
+	d.Folders = uint32(len(info.Folders))
+	d.Items = make([]storedItem, 0, len(info.Folders)+len(info.Files))
+	var names strings.Builder
+	for _, items := range [][]iteminfo.ItemInfo{info.Folders, info.Files} {
+		for _, item := range items {
+			names.WriteString(item.Name)
+			d.Items = append(d.Items, storedItem{
+				NameEnd:       uint32(names.Len()),
+				Type:          s.typeID(item.Type),
+				Hidden:        item.Hidden,
+				Size:          item.Size,
+				AllocatedSize: item.AllocatedSize,
+				ModTime:       packTime(item.ModTime),
+			})
+		}
+	}
+	d.Names = names.String()
+}
+
+// delete removes a directory, its subdirectories stay until they are deleted themselves.
+func (s *dirStore) delete(indexPath string) bool {
+	id := s.find(indexPath)
+	if id < 0 || !s.dirs[id].Present {
+		return false
+	}
+	d := s.dirs[id]
+	d.Present = false
+	d.Items = nil
+	d.Names = ""
+	s.count--
+	s.changes++
+	s.prune(id)
+	return true
+}
+
+// prune drops nodes without content and subdirectories, walking up the tree.
+func (s *dirStore) prune(id int32) {
+	for id != rootDirID {
+		d := s.dirs[id]
+		if d.Present || d.firstChild != 0 {
+			return
+		}
+		delete(s.lookup, dirKey{d.Parent, d.Name})
+		s.unlink(id)
+		s.dirs[id] = nil
+		s.free = append(s.free, id)
+		id = d.Parent
+	}
+}
+
+// link adds a node to the children of its parent.
+func (s *dirStore) link(parent, id int32) {
+	p, d := s.dirs[parent], s.dirs[id]
+	d.prev, d.next = 0, p.firstChild
+	if p.firstChild != 0 {
+		s.dirs[p.firstChild].prev = id
+	}
+	p.firstChild = id
+}
+
+func (s *dirStore) unlink(id int32) {
+	d := s.dirs[id]
+	if d.prev != 0 {
+		s.dirs[d.prev].next = d.next
+	} else {
+		s.dirs[d.Parent].firstChild = d.next
+	}
+	if d.next != 0 {
+		s.dirs[d.next].prev = d.prev
+	}
+}
+
+func (s *dirStore) path(id int32) string {
+	if id == rootDirID {
+		return "/"
+	}
+	parts := []string{}
+	for ; id != rootDirID; id = s.dirs[id].Parent {
+		parts = append(parts, s.dirs[id].Name)
+	}
+	var b strings.Builder
+	for i := len(parts) - 1; i >= 0; i-- {
+		b.WriteString("/")
+		b.WriteString(parts[i])
+	}
+	return b.String()
+}
+
+// all iterates over the present directories at or below scope, parents before their
+// subdirectories. Only the subtree of scope is visited.
+func (s *dirStore) all(scope string) iter.Seq2[string, *storedDir] {
+	return func(yield func(string, *storedDir) bool) {
+		scopeID := s.find(scope)
+		if scopeID < 0 {
+			return
+		}
+		type node struct {
+			id   int32
+			path string
+		}
+		stack := []node{{scopeID, s.path(scopeID)}}
+		for len(stack) > 0 {
+			n := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			d := s.dirs[n.id]
+			if d.Present && !yield(n.path, d) {
+				return
+			}
+			prefix := strings.TrimSuffix(n.path, "/") + "/"
+			for child := d.firstChild; child != 0; child = s.dirs[child].next {
+				stack = append(stack, node{child, prefix + s.dirs[child].Name})
+			}
+		}
+	}
+}
+
+// addSize changes the size and allocated size of every ancestor of a directory by the deltas,
+// after the sizes of the directory itself changed. The entries of the directories in their
+// parents are updated too.
+func (s *dirStore) addSize(indexPath string, delta, allocatedDelta int64) {
+	id := s.find(indexPath)
+	if id < 0 || (delta == 0 && allocatedDelta == 0) {
+		return
+	}
+	for ; id != rootDirID; id = s.dirs[id].Parent {
+		d, parent := s.dirs[id], s.dirs[s.dirs[id].Parent]
+		start := uint32(0)
+		for i := range parent.Items[:parent.Folders] {
+			end := parent.Items[i].NameEnd
+			if parent.Names[start:end] == d.Name {
+				parent.Items[i].Size = d.Size
+				parent.Items[i].AllocatedSize = d.AllocatedSize
+				break
+			}
+			start = end
+		}
+		parent.Size += delta
+		parent.AllocatedSize += allocatedDelta
+	}
+	s.changes++
+}
+
+func (s *dirStore) item(d *storedDir, i int) iteminfo.ItemInfo {
+	start := uint32(0)
+	if i > 0 {
+		start = d.Items[i-1].NameEnd
+	}
+	item := d.Items[i]
+	return iteminfo.ItemInfo{
+		Name:          d.Names[start:item.NameEnd],
+		Size:          item.Size,
+		AllocatedSize: item.AllocatedSize,
+		ModTime:       unpackTime(item.ModTime),
+		Type:          s.types[item.Type],
+		Hidden:        item.Hidden,
+	}
+}
+
+func (s *dirStore) folders(d *storedDir) iter.Seq[iteminfo.ItemInfo] {
+	return s.items(d, 0, int(d.Folders))
+}
+
+func (s *dirStore) files(d *storedDir) iter.Seq[iteminfo.ItemInfo] {
+	return s.items(d, int(d.Folders), len(d.Items))
+}
+
+func (s *dirStore) items(d *storedDir, from, to int) iter.Seq[iteminfo.ItemInfo] {
+	return func(yield func(iteminfo.ItemInfo) bool) {
+		for i := from; i < to; i++ {
+			if !yield(s.item(d, i)) {
+				return
+			}
+		}
+	}
+}
+
+// info expands a stored directory into a FileInfo owned by the caller.
+func (s *dirStore) info(indexPath string, d *storedDir) *iteminfo.FileInfo {
+	info := &iteminfo.FileInfo{
+		Path:    indexPath,
+		Folders: make([]iteminfo.ItemInfo, 0, d.Folders),
+		Files:   make([]iteminfo.ItemInfo, 0, len(d.Items)-int(d.Folders)),
+	}
+	info.ItemInfo = iteminfo.ItemInfo{
+		Name:          d.Name,
+		Type:          "directory",
+		Size:          d.Size,
+		AllocatedSize: d.AllocatedSize,
+		ModTime:       unpackTime(d.ModTime),
+		Hidden:        d.Hidden,
+	}
+	for item := range s.folders(d) {
+		info.Folders = append(info.Folders, item)
+	}
+	for item := range s.files(d) {
+		info.Files = append(info.Files, item)
+	}
+	return info
+}
+
+func (s *dirStore) snapshot() storeSnapshot {
+	// free slots are dropped, so ids are renumbered
+	ids := make([]int32, len(s.dirs))
+	snapshot := storeSnapshot{Dirs: make([]storedDir, 0, len(s.dirs)-len(s.free)), Types: s.types}
+	for id, d := range s.dirs {
+		if d == nil {
+			continue
+		}
+		ids[id] = int32(len(snapshot.Dirs))
+		snapshot.Dirs = append(snapshot.Dirs, *d)
+	}
+	for i := range snapshot.Dirs {
+		if parent := snapshot.Dirs[i].Parent; parent >= 0 {
+			snapshot.Dirs[i].Parent = ids[parent]
+		}
+	}
+	return snapshot
+}
+
+func restoreDirStore(snapshot storeSnapshot) dirStore {
+	s := newDirStore()
+	if len(snapshot.Dirs) == 0 {
+		return s
+	}
+	if len(snapshot.Types) > 0 {
+		s.types = snapshot.Types
+		s.typeIDs = make(map[string]uint16, len(s.types))
+		for id, mimetype := range s.types {
+			s.typeIDs[mimetype] = uint16(id)
+		}
+	}
+	s.dirs = make([]*storedDir, len(snapshot.Dirs))
+	for i := range snapshot.Dirs {
+		d := &snapshot.Dirs[i]
+		// ids were renumbered, the child lists are rebuilt below
+		d.firstChild, d.prev, d.next = 0, 0, 0
+		s.dirs[i] = d
+		if d.Present {
+			s.count++
+		}
+		if d.Parent >= 0 {
+			s.lookup[dirKey{d.Parent, d.Name}] = int32(i)
+		}
+	}
+	for id, d := range s.dirs {
+		if d.Parent >= 0 {
+			s.link(d.Parent, int32(id))
+		}
+	}
+	return s
+}
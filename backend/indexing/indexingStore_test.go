@@ -0,0 +1,222 @@
+package indexing
+
+import (
+	"filebrowser/indexing/iteminfo"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"testing"
+	"time"
+)
+
+// syntheticDirs returns width^depth directories with files each, similar to a real tree.
+func syntheticDirs(width, depth, files int) []*iteminfo.FileInfo {
+	types := []string{"text/plain", "image/jpeg", "video/mp4", "application/pdf"}
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	dirs := []*iteminfo.FileInfo{}
+	var build func(path string, level int)
+	build = func(path string, level int) {
+		info := &iteminfo.FileInfo{
+			Path:     path,
+			ItemInfo: iteminfo.ItemInfo{Name: filepath.Base(path), Type: "directory", ModTime: modTime},
+			Files:    []iteminfo.ItemInfo{},
+			Folders:  []iteminfo.ItemInfo{},
+		}
+		for i := 0; i < files; i++ {
+			info.Files = append(info.Files, iteminfo.ItemInfo{
+				Name:    fmt.Sprintf("file-%04d.%v", i, level),
+				Size:    int64(i * 1024),
+				ModTime: modTime.Add(time.Duration(i) * time.Second),
+				Type:    types[i%len(types)],
+			})
+		}
+		if level < depth {
+			prefix := path + "/"
+			if path == "/" {
+				prefix = "/"
+			}
+			for i := 0; i < width; i++ {
+				name := fmt.Sprintf("folder-%02d", i)
+				info.Folders = append(info.Folders, iteminfo.ItemInfo{Name: name, Type: "directory", ModTime: modTime})
+				build(prefix+name, level+1)
+			}
+		}
+		dirs = append(dirs, info)
+	}
+	build("/", 0)
+	return dirs
+}
+
+func TestDirStoreRoundTrip(t *testing.T) {
+	dirs := syntheticDirs(3, 2, 4)
+	store := newDirStore()
+	for _, dir := range dirs {
+		store.put(dir)
+	}
+	if store.len() != len(dirs) {
+		t.Fatalf("store holds %v directories, want %v", store.len(), len(dirs))
+	}
+	restored := restoreDirStore(store.snapshot())
+	for _, want := range dirs {
+		for name, s := range map[string]*dirStore{"store": &store, "snapshot": &restored} {
+			dir, ok := s.get(want.Path)
+			if !ok {
+				t.Fatalf("%v: directory %v missing", name, want.Path)
+			}
+			if got := s.info(want.Path, dir); !reflect.DeepEqual(got, want) {
+				t.Errorf("%v: directory %v = %+v, want %+v", name, want.Path, got, want)
+			}
+		}
+	}
+}
+
+func TestDirStoreDelete(t *testing.T) {
+	store := newDirStore()
+	// children are stored before their parents during scans
+	store.put(&iteminfo.FileInfo{Path: "/a/b/c"})
+	if store.has("/a/b") || store.len() != 1 {
+		t.Fatalf("placeholder parents must not be reported as indexed")
+	}
+	store.put(&iteminfo.FileInfo{Path: "/a"})
+	store.delete("/a/b/c")
+	if store.find("/a/b") != -1 {
+		t.Errorf("empty placeholder /a/b was not pruned")
+	}
+	if !store.has("/a") {
+		t.Errorf("/a was removed with its subdirectory")
+	}
+	store.put(&iteminfo.FileInfo{Path: "/x/y"})
+	paths := []string{}
+	for path := range store.all("/") {
+		paths = append(paths, path)
+	}
+	if len(paths) != 2 || len(store.free) != 0 {
+		t.Errorf("store paths = %v, free slots = %v, want the freed slots reused", paths, store.free)
+	}
+}
+
+func TestDirStoreScope(t *testing.T) {
+	store := newDirStore()
+	for _, dir := range syntheticDirs(3, 2, 1) {
+		store.put(dir)
+	}
+	store.put(&iteminfo.FileInfo{Path: "/folder-010"})
+	scoped := func(store *dirStore, scope string) []string {
+		paths := []string{}
+		for path, dir := range store.all(scope) {
+			if store.path(store.find(path)) != path || !dir.Present {
+				t.Errorf("all(%v) yielded %v for another directory", scope, path)
+			}
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		return paths
+	}
+	want := []string{"/folder-01", "/folder-01/folder-00", "/folder-01/folder-01", "/folder-01/folder-02"}
+	if got := scoped(&store, "/folder-01"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("all(/folder-01) = %v, want %v", got, want)
+	}
+	if got := scoped(&store, "/"); len(got) != 14 {
+		t.Errorf("all(/) returned %v directories, want 14", len(got))
+	}
+
+	store.delete("/folder-01/folder-01")
+	store.delete("/folder-01")
+	want = []string{"/folder-01/folder-00", "/folder-01/folder-02"}
+	if got := scoped(&store, "/folder-01"); !reflect.DeepEqual(got, want) {
+		t.Errorf("all(/folder-01) after delete = %v, want %v", got, want)
+	}
+	restored := restoreDirStore(store.snapshot())
+	if got := scoped(&restored, "/folder-01"); !reflect.DeepEqual(got, want) {
+		t.Errorf("all(/folder-01) after restore = %v, want %v", got, want)
+	}
+}
+
+func TestDirStoreAddSize(t *testing.T) {
+	store := newDirStore()
+	store.put(&iteminfo.FileInfo{Path: "/", ItemInfo: iteminfo.ItemInfo{Size: 30, AllocatedSize: 300}, Folders: []iteminfo.ItemInfo{{Name: "a", Size: 20, AllocatedSize: 200}}, Files: []iteminfo.ItemInfo{{Name: "f", Size: 10, AllocatedSize: 100}}})
+	store.put(&iteminfo.FileInfo{Path: "/a", ItemInfo: iteminfo.ItemInfo{Size: 20, AllocatedSize: 200}, Folders: []iteminfo.ItemInfo{{Name: "b", Size: 20, AllocatedSize: 200}}})
+	store.put(&iteminfo.FileInfo{Path: "/a/b", ItemInfo: iteminfo.ItemInfo{Size: 25, AllocatedSize: 250}})
+	store.addSize("/a/b", 5, 50)
+	root, _ := store.get("/")
+	a, _ := store.get("/a")
+	if root.Size != 35 || a.Size != 25 || root.AllocatedSize != 350 || a.AllocatedSize != 250 {
+		t.Errorf("sizes root=%v/%v a=%v/%v, want 35/350 and 25/250", root.Size, root.AllocatedSize, a.Size, a.AllocatedSize)
+	}
+	if store.item(root, 0).Size != 25 || store.item(a, 0).Size != 25 || store.item(root, 1).Size != 10 {
+		t.Errorf("folder entries were not updated: %v %v", store.info("/", root).Folders, store.info("/a", a).Folders)
+	}
+	if store.item(root, 0).AllocatedSize != 250 || store.item(a, 0).AllocatedSize != 250 || store.item(root, 1).AllocatedSize != 100 {
+		t.Errorf("allocated sizes of folder entries were not updated: %v %v", store.info("/", root).Folders, store.info("/a", a).Folders)
+	}
+	// the allocated size can change alone, e.g. when a file becomes sparse
+	store.addSize("/a/b", 0, -50)
+	if root.Size != 35 || root.AllocatedSize != 300 || a.AllocatedSize != 200 {
+		t.Errorf("after an allocated change root=%v/%v a=%v, want 35/300 and 200", root.Size, root.AllocatedSize, a.AllocatedSize)
+	}
+}
+
+// heapBytes returns the live heap after a garbage collection.
+func heapBytes() uint64 {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+// benchmarkLayout reports the retained heap per indexed file for a layout.
+func benchmarkLayout(b *testing.B, load func(dirs []*iteminfo.FileInfo) any) {
+	var files int
+	var retained uint64
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		dirs := syntheticDirs(8, 3, 50)
+		files = 0
+		for _, dir := range dirs {
+			files += len(dir.Files)
+		}
+		before := heapBytes()
+		b.StartTimer()
+		layout := load(dirs)
+		b.StopTimer()
+		// the input stays alive, so only memory held by the layout is counted
+		retained = heapBytes() - before
+		runtime.KeepAlive(dirs)
+		runtime.KeepAlive(layout)
+		b.StartTimer()
+	}
+	b.ReportMetric(float64(retained)/float64(files), "B/file")
+	b.ReportMetric(float64(retained)/(1<<20), "MiB")
+}
+
+// BenchmarkMapLayout measures the previous map[string]*iteminfo.FileInfo layout.
+func BenchmarkMapLayout(b *testing.B) {
+	benchmarkLayout(b, func(dirs []*iteminfo.FileInfo) any {
+		layout := make(map[string]*iteminfo.FileInfo)
+		for _, dir := range dirs {
+			// copies so the layout owns its data like a scan would
+			info := *dir
+			info.Path = string([]byte(dir.Path))
+			info.Files = append([]iteminfo.ItemInfo{}, dir.Files...)
+			info.Folders = append([]iteminfo.ItemInfo{}, dir.Folders...)
+			for i := range info.Files {
+				info.Files[i].Name = string([]byte(info.Files[i].Name))
+				info.Files[i].Type = string([]byte(info.Files[i].Type))
+			}
+			layout[info.Path] = &info
+		}
+		return layout
+	})
+}
+
+func BenchmarkCompactLayout(b *testing.B) {
+	benchmarkLayout(b, func(dirs []*iteminfo.FileInfo) any {
+		store := newDirStore()
+		for _, dir := range dirs {
+			store.put(dir)
+		}
+		return &store
+	})
+}
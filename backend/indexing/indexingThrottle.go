@@ -0,0 +1,204 @@
+package indexing
+
+import (
+	"filebrowser/common/errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gtsteffaniak/go-logger/logger"
+)
+
+// throttlePoll is how often a paused or waiting scan checks whether it may continue.
+const throttlePoll = 250 * time.Millisecond
+
+const (
+	ThrottleNone    = "unthrottled"
+	ThrottleLimited = "throttled" // rate limit or batch pauses are configured
+	ThrottlePaused  = "paused"    // paused by an admin
+	ThrottleBusy    = "busy"      // waiting for user requests to finish
+)
+
+// ThrottleStatus is the effective scan throttle of a source.
+type ThrottleStatus struct {
+	State            string `json:"state"`
+	MaxDirsPerSecond int    `json:"maxDirsPerSecond"` // 0 is unlimited
+	BatchSize        int    `json:"batchSize"`
+	BatchPauseMs     int    `json:"batchPauseMs"`
+	BusyRequests     int    `json:"busyRequests"` // 0 never waits for user requests
+}
+
+// scanControl holds the throttle and admin state of the scans of a source.
+type scanControl struct {
+	paused    bool
+	cancelled bool
+	next      time.Time // earliest time the next directory may be read
+	batch     int       // directories read since the last batch pause
+	mu        sync.Mutex
+}
+
+var inFlightRequests atomic.Int64
+
+// TrackRequest counts a user request as in flight until the returned function is called,
+// scans of sources with scanThrottle.busyRequests wait while there are many.
+func TrackRequest() func() {
+	inFlightRequests.Add(1)
+	return func() {
+		inFlightRequests.Add(-1)
+	}
+}
+
+func (idx *Index) configuredThrottle() ThrottleStatus {
+	config := idx.Config.ScanThrottle
+	status := ThrottleStatus{
+		State:            ThrottleNone,
+		MaxDirsPerSecond: config.MaxDirsPerSecond,
+		BatchSize:        config.BatchSize,
+		BatchPauseMs:     config.BatchPauseMs,
+		BusyRequests:     config.BusyRequests,
+	}
+	if config.MaxDirsPerSecond > 0 || (config.BatchSize > 0 && config.BatchPauseMs > 0) {
+		status.State = ThrottleLimited
+	}
+	return status
+}
+
+func (idx *Index) setThrottleState(state string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.Throttle.State = state
+}
+
+// throttle is called before a scan reads a directory. It waits while the scan is paused or
+// the server is busy and spaces out reads according to the source settings.
+func (idx *Index) throttle() error {
+	config := idx.Config.ScanThrottle
+	waited := false
+	for {
+		idx.scan.mu.Lock()
+		cancelled, paused := idx.scan.cancelled, idx.scan.paused
+		idx.scan.mu.Unlock()
+		if cancelled {
+			return errors.ErrScanCancelled
+		}
+		busy := config.BusyRequests > 0 && inFlightRequests.Load() >= int64(config.BusyRequests)
+		if !paused && !busy {
+			break
+		}
+		if !waited {
+			state := ThrottleBusy
+			if paused {
+				state = ThrottlePaused
+			}
+			idx.setThrottleState(state)
+			waited = true
+		}
+		time.Sleep(throttlePoll)
+	}
+	if waited {
+		idx.setThrottleState(idx.configuredThrottle().State)
+	}
+	var interval, pause time.Duration
+	if config.MaxDirsPerSecond > 0 {
+		interval = time.Second / time.Duration(config.MaxDirsPerSecond)
+	}
+	if config.BatchSize > 0 {
+		pause = time.Duration(config.BatchPauseMs) * time.Millisecond
+	}
+	if interval == 0 && pause == 0 {
+		return nil
+	}
+	// parallel workers share the schedule, so limits apply to the whole source
+	idx.scan.mu.Lock()
+	now := time.Now()
+	start := idx.scan.next
+	if start.Before(now) {
+		start = now
+	}
+	if pause > 0 {
+		idx.scan.batch++
+		if idx.scan.batch >= config.BatchSize {
+			idx.scan.batch = 0
+			start = start.Add(pause)
+		}
+	}
+	idx.scan.next = start.Add(interval)
+	idx.scan.mu.Unlock()
+	time.Sleep(time.Until(start))
+	return nil
+}
+
+// beginScan clears a cancellation left over from the previous scan.
+func (idx *Index) beginScan() {
+	idx.scan.mu.Lock()
+	defer idx.scan.mu.Unlock()
+	idx.scan.cancelled = false
+	idx.scan.batch = 0
+}
+
+// PauseScan pauses the running scan of a source and holds upcoming scans until ResumeScan is called.
+// File operations and the watcher keep updating the index.
+func PauseScan(sourceName string) error {
+	idx, err := scanTarget(sourceName)
+	if err != nil {
+		return err
+	}
+	idx.scan.mu.Lock()
+	idx.scan.paused = true
+	idx.scan.mu.Unlock()
+	if idx.getStatus() == INDEXING {
+		idx.setThrottleState(ThrottlePaused)
+	}
+	logger.Infof("scans paused for [%v]", idx.Name)
+	idx.SendSourceUpdateEvent()
+	return nil
+}
+
+func ResumeScan(sourceName string) error {
+	idx, err := scanTarget(sourceName)
+	if err != nil {
+		return err
+	}
+	idx.scan.mu.Lock()
+	idx.scan.paused = false
+	idx.scan.mu.Unlock()
+	idx.setThrottleState(idx.configuredThrottle().State)
+	logger.Infof("scans resumed for [%v]", idx.Name)
+	idx.SendSourceUpdateEvent()
+	return nil
+}
+
+// CancelScan stops the running scan of a source. Directories it didn't reach keep their
+// previous state and the next scheduled scan runs as usual.
+func CancelScan(sourceName string) error {
+	idx, err := scanTarget(sourceName)
+	if err != nil {
+		return err
+	}
+	if idx.getStatus() != INDEXING {
+		return fmt.Errorf("no scan running for [%v]", idx.Name)
+	}
+	idx.scan.mu.Lock()
+	idx.scan.cancelled = true
+	idx.scan.mu.Unlock()
+	logger.Infof("cancelling scan for [%v]", idx.Name)
+	return nil
+}
+
+func scanTarget(sourceName string) (*Index, error) {
+	idx := GetIndex(sourceName)
+	if idx == nil {
+		return nil, fmt.Errorf("index %s not found", sourceName)
+	}
+	if idx.Config.DisableIndexing {
+		return nil, errors.ErrNotIndexed
+	}
+	return idx, nil
+}
+
+func (idx *Index) getStatus() IndexStatus {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.Status
+}
@@ -0,0 +1,362 @@
+package indexing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"filebrowser/adapters/fs/fileutils"
+	"filebrowser/common/errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gtsteffaniak/go-logger/logger"
+)
+
+const (
+	// TrashDirName is the folder at the root of a source that holds deleted items, it is never indexed or listed.
+	TrashDirName              = ".filebrowser-trash"
+	defaultTrashRetentionDays = 30
+	trashRetentionInterval    = time.Hour
+)
+
+// restore conflict modes
+const (
+	RestoreFail      = ""          // keep the existing item and return ErrExist
+	RestoreRename    = "rename"    // restore next to it as "name (1).ext"
+	RestoreOverwrite = "overwrite" // move the existing item to the trash first
+)
+
+// TrashItem is a deleted file or folder. Each item is kept in its own folder inside
+// the trash, next to a json file with this information.
+type TrashItem struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Path      string    `json:"path"` // index path the item was deleted from
+	IsDir     bool      `json:"isDir"`
+	Size      int64     `json:"size"`
+	DeletedBy string    `json:"deletedBy"`
+	DeletedAt time.Time `json:"deletedAt"`
+}
+
+// TrashHooks keep data stored by path outside the index, like tags and versions, in step
+// with the trash. Trashed items keep their data under their index path inside the trash.
+type TrashHooks struct {
+	Moved  func(idx *Index, indexPath, destPath string) // moved into or out of the trash
+	Purged func(idx *Index, indexPath string)           // permanently deleted from the trash
+}
+
+var (
+	// trash serializes changes to the trash of each source, keyed by source path
+	trashLocks sync.Map
+	trashHooks TrashHooks
+)
+
+// SetTrashHooks sets the hooks called when items enter and leave the trash.
+func SetTrashHooks(hooks TrashHooks) {
+	trashHooks = hooks
+}
+
+func trashMoved(idx *Index, indexPath, destPath string) {
+	if trashHooks.Moved != nil {
+		trashHooks.Moved(idx, indexPath, destPath)
+	}
+}
+
+// contentPath is the index path of the item inside the trash.
+func (item *TrashItem) contentPath() string {
+	return "/" + TrashDirName + "/" + item.ID + "/" + item.Name
+}
+
+func (idx *Index) lockTrash() func() {
+	lock, _ := trashLocks.LoadOrStore(idx.Path, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	return lock.(*sync.Mutex).Unlock
+}
+
+// InTrash reports whether an index path is the trash folder or inside it.
+func InTrash(indexPath string) bool {
+	return indexPath == "/"+TrashDirName || strings.HasPrefix(indexPath, "/"+TrashDirName+"/")
+}
+
+func (idx *Index) trashPath(elem ...string) string {
+	return filepath.Join(append([]string{idx.Path, TrashDirName}, elem...)...)
+}
+
+// TrashEnabled reports whether deleted items of the source go to the trash.
+func (idx *Index) TrashEnabled() bool {
+	return !idx.Config.Trash.Disabled
+}
+
+// MoveToTrash moves a file or folder into the trash of the source.
+func (idx *Index) MoveToTrash(indexPath, deletedBy string) (*TrashItem, error) {
+	unlock := idx.lockTrash()
+	defer unlock()
+	return idx.moveToTrash(indexPath, deletedBy)
+}
+
+// moveToTrash is MoveToTrash for callers that hold the trash lock.
+func (idx *Index) moveToTrash(indexPath, deletedBy string) (*TrashItem, error) {
+	indexPath = idx.MakeIndexPath(filepath.Join(idx.Path, indexPath))
+	if indexPath == "/" || InTrash(indexPath) {
+		return nil, errors.ErrPermissionDenied
+	}
+	realPath := filepath.Join(idx.Path, indexPath)
+	stat, err := os.Lstat(realPath)
+	if err != nil {
+		return nil, err
+	}
+	random := make([]byte, 4)
+	if _, err = rand.Read(random); err != nil {
+		return nil, err
+	}
+	item := &TrashItem{
+		ID:        fmt.Sprintf("%d-%s", time.Now().UnixNano(), hex.EncodeToString(random)),
+		Name:      stat.Name(),
+		Path:      indexPath,
+		IsDir:     stat.IsDir(),
+		Size:      stat.Size(),
+		DeletedBy: deletedBy,
+		DeletedAt: time.Now(),
+	}
+	if item.IsDir {
+		item.Size = idx.TreeSize(indexPath, realPath)
+	}
+	if err = os.MkdirAll(idx.trashPath(item.ID), 0755); err != nil {
+		return nil, err
+	}
+	if err = writeTrashInfo(idx.trashPath(item.ID+".json"), item); err != nil {
+		os.Remove(idx.trashPath(item.ID))
+		return nil, err
+	}
+	if err = fileutils.MoveFile(realPath, idx.trashPath(item.ID, item.Name)); err != nil {
+		os.Remove(idx.trashPath(item.ID + ".json"))
+		os.Remove(idx.trashPath(item.ID))
+		return nil, err
+	}
+	trashMoved(idx, indexPath, item.contentPath())
+	return item, nil
+}
+
+// TreeSize returns the size of a folder from the index, or by walking it when it isn't indexed.
+func (idx *Index) TreeSize(indexPath, realPath string) int64 {
+	idx.mu.RLock()
+	dir, exists := idx.store.get(indexPath)
+	idx.mu.RUnlock()
+	if exists && dir.Present {
+		return dir.Size
+	}
+	var size int64
+	_ = filepath.WalkDir(realPath, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			if info, infoErr := entry.Info(); infoErr == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+func writeTrashInfo(path string, item *TrashItem) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Trash lists the items in the trash of the source, most recently deleted first.
+func (idx *Index) Trash() ([]TrashItem, error) {
+	items := []TrashItem{}
+	entries, err := os.ReadDir(idx.trashPath())
+	if os.IsNotExist(err) {
+		return items, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		item, err := idx.TrashItem(strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil {
+			logger.Errorf("could not read trash item %v of [%v]: %v", entry.Name(), idx.Name, err)
+			continue
+		}
+		items = append(items, *item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].DeletedAt.After(items[j].DeletedAt)
+	})
+	return items, nil
+}
+
+// TrashItem returns an item of the trash by id.
+func (idx *Index) TrashItem(id string) (*TrashItem, error) {
+	if id == "" || filepath.Base(id) != id || strings.HasPrefix(id, ".") {
+		return nil, errors.ErrInvalidRequestParams
+	}
+	data, err := os.ReadFile(idx.trashPath(id + ".json"))
+	if os.IsNotExist(err) {
+		return nil, errors.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	item := &TrashItem{}
+	if err = json.Unmarshal(data, item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// RestoreFromTrash moves an item back to dest, its original path when dest is empty.
+// Missing parent folders are created. When something already exists at dest the
+// conflict mode decides what happens, see RestoreFail. It returns the restored index path.
+func (idx *Index) RestoreFromTrash(id, dest, conflict, restoredBy string) (string, error) {
+	// held from the conflict check until the item is back, so a concurrent restore
+	// to the same destination sees the first one
+	unlock := idx.lockTrash()
+	defer unlock()
+	item, err := idx.TrashItem(id)
+	if err != nil {
+		return "", err
+	}
+	if dest == "" {
+		dest = item.Path
+	}
+	dest = idx.MakeIndexPath(filepath.Join(idx.Path, dest))
+	if dest == "/" || InTrash(dest) {
+		return "", errors.ErrPermissionDenied
+	}
+	if _, err = os.Lstat(filepath.Join(idx.Path, dest)); err == nil {
+		switch conflict {
+		case RestoreFail:
+			return "", errors.ErrExist
+		case RestoreRename:
+			dest = idx.freePath(dest)
+		case RestoreOverwrite:
+			if _, err = idx.moveToTrash(dest, restoredBy); err != nil {
+				return "", err
+			}
+		default:
+			return "", errors.ErrInvalidOption
+		}
+	}
+	realDest := filepath.Join(idx.Path, dest)
+	if err = os.MkdirAll(filepath.Dir(realDest), 0755); err != nil {
+		return "", err
+	}
+	if err = fileutils.MoveFile(idx.trashPath(item.ID, item.Name), realDest); err != nil {
+		return "", err
+	}
+	trashMoved(idx, item.contentPath(), dest)
+	return dest, idx.removeTrashItem(item.ID)
+}
+
+// freePath returns the first "name (n).ext" next to indexPath that doesn't exist.
+func (idx *Index) freePath(indexPath string) string {
+	dir, name := filepath.Split(indexPath)
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for n := 1; ; n++ {
+		candidate := filepath.ToSlash(filepath.Join(dir, fmt.Sprintf("%s (%d)%s", base, n, ext)))
+		if _, err := os.Lstat(filepath.Join(idx.Path, candidate)); os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
+
+// PurgeTrashItem permanently deletes an item of the trash.
+func (idx *Index) PurgeTrashItem(id string) error {
+	item, err := idx.TrashItem(id)
+	if err != nil {
+		return err
+	}
+	unlock := idx.lockTrash()
+	defer unlock()
+	if err = idx.removeTrashItem(id); err != nil {
+		return err
+	}
+	if trashHooks.Purged != nil {
+		trashHooks.Purged(idx, item.contentPath())
+	}
+	return nil
+}
+
+func (idx *Index) removeTrashItem(id string) error {
+	if err := os.RemoveAll(idx.trashPath(id)); err != nil {
+		return err
+	}
+	err := os.Remove(idx.trashPath(id + ".json"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// enforceTrashRetention purges items older than the retention period, then the oldest
+// items until the trash fits the size limit.
+func (idx *Index) enforceTrashRetention() {
+	items, err := idx.Trash()
+	if err != nil {
+		logger.Errorf("could not list trash of [%v]: %v", idx.Name, err)
+		return
+	}
+	retentionDays := idx.Config.Trash.RetentionDays
+	if retentionDays == 0 {
+		retentionDays = defaultTrashRetentionDays
+	}
+	maxSize := idx.Config.Trash.MaxSizeMB * 1024 * 1024
+	purged := 0
+	purge := func(item TrashItem) bool {
+		if err := idx.PurgeTrashItem(item.ID); err != nil {
+			logger.Errorf("could not purge %v from trash of [%v]: %v", item.Path, idx.Name, err)
+			return false
+		}
+		purged++
+		return true
+	}
+	kept := []TrashItem{}
+	var total int64
+	for _, item := range items {
+		if retentionDays > 0 && time.Since(item.DeletedAt) > time.Duration(retentionDays)*24*time.Hour && purge(item) {
+			continue
+		}
+		kept = append(kept, item)
+		total += item.Size
+	}
+	// items are ordered newest first
+	for i := len(kept) - 1; i >= 0 && maxSize > 0 && total > maxSize; i-- {
+		if purge(kept[i]) {
+			total -= kept[i].Size
+		}
+	}
+	if purged > 0 {
+		logger.Infof("purged %v items from trash of [%v]", purged, idx.Name)
+	}
+}
+
+// runTrashRetention enforces the retention policy of the trash in the background until ctx is done.
+func (idx *Index) runTrashRetention(ctx context.Context) {
+	ticker := time.NewTicker(trashRetentionInterval)
+	defer ticker.Stop()
+	for {
+		if idx.TrashEnabled() {
+			idx.enforceTrashRetention()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
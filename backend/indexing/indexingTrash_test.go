@@ -0,0 +1,252 @@
+package indexing
+
+import (
+	"context"
+	"filebrowser/common/errors"
+	"filebrowser/common/settings"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordTrashHooks collects the hook calls of the test as "moved from to" and "purged path".
+func recordTrashHooks(t *testing.T) *[]string {
+	calls := &[]string{}
+	SetTrashHooks(TrashHooks{
+		Moved: func(idx *Index, indexPath, destPath string) {
+			*calls = append(*calls, fmt.Sprintf("moved %v %v", indexPath, destPath))
+		},
+		Purged: func(idx *Index, indexPath string) {
+			*calls = append(*calls, "purged "+indexPath)
+		},
+	})
+	t.Cleanup(func() { SetTrashHooks(TrashHooks{}) })
+	return calls
+}
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestMoveToTrash(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "docs", "a.txt"), "a")
+	writeTestFile(t, filepath.Join(root, "docs", "sub", "b.txt"), "bb")
+	idx := newTestIndex(root, 1)
+	calls := recordTrashHooks(t)
+
+	item, err := idx.MoveToTrash("/docs", "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(root, "docs")); !os.IsNotExist(err) {
+		t.Errorf("trashed folder is still there: %v", err)
+	}
+	if item.Path != "/docs" || !item.IsDir || item.Size != 3 || item.DeletedBy != "bob" {
+		t.Errorf("trash item = %+v, want /docs of 3 bytes deleted by bob", *item)
+	}
+	if got := readTestFile(t, filepath.Join(idx.trashPath(item.ID, "docs"), "sub", "b.txt")); got != "bb" {
+		t.Errorf("trashed content = %q, want bb", got)
+	}
+	items, err := idx.Trash()
+	if err != nil || len(items) != 1 || items[0].ID != item.ID {
+		t.Errorf("Trash() = %v, %v, want the trashed folder", items, err)
+	}
+	want := []string{"moved /docs " + item.contentPath()}
+	if !reflect.DeepEqual(*calls, want) {
+		t.Errorf("hooks = %v, want %v", *calls, want)
+	}
+
+	for _, indexPath := range []string{"/", "/" + TrashDirName, "/" + TrashDirName + "/" + item.ID} {
+		if _, err = idx.MoveToTrash(indexPath, "bob"); err != errors.ErrPermissionDenied {
+			t.Errorf("MoveToTrash(%v) = %v, want %v", indexPath, err, errors.ErrPermissionDenied)
+		}
+	}
+	if _, err = idx.MoveToTrash("/missing.txt", "bob"); !os.IsNotExist(err) {
+		t.Errorf("MoveToTrash of a missing file = %v, want a not exist error", err)
+	}
+	for _, id := range []string{"", "../docs", ".hidden", "missing"} {
+		if _, err = idx.TrashItem(id); err == nil {
+			t.Errorf("TrashItem(%q) returned no error", id)
+		}
+	}
+}
+
+func TestRestoreFromTrash(t *testing.T) {
+	root := t.TempDir()
+	idx := newTestIndex(root, 1)
+	trash := func(content string) *TrashItem {
+		t.Helper()
+		writeTestFile(t, filepath.Join(root, "notes.txt"), content)
+		item, err := idx.MoveToTrash("/notes.txt", "bob")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return item
+	}
+
+	// restored to where it was deleted from, missing parent folders are created
+	item := trash("first")
+	calls := recordTrashHooks(t)
+	restored, err := idx.RestoreFromTrash(item.ID, "/new/dir/notes.txt", RestoreFail, "bob")
+	if err != nil || restored != "/new/dir/notes.txt" {
+		t.Fatalf("RestoreFromTrash = %v, %v, want /new/dir/notes.txt", restored, err)
+	}
+	if got := readTestFile(t, filepath.Join(root, "new", "dir", "notes.txt")); got != "first" {
+		t.Errorf("restored content = %q, want first", got)
+	}
+	if _, err = os.Stat(idx.trashPath(item.ID + ".json")); !os.IsNotExist(err) {
+		t.Error("restored item is still listed in the trash")
+	}
+	if want := []string{"moved " + item.contentPath() + " /new/dir/notes.txt"}; !reflect.DeepEqual(*calls, want) {
+		t.Errorf("hooks = %v, want %v", *calls, want)
+	}
+
+	item = trash("second")
+	writeTestFile(t, filepath.Join(root, "notes.txt"), "current")
+	if _, err = idx.RestoreFromTrash(item.ID, "", RestoreFail, "bob"); err != errors.ErrExist {
+		t.Errorf("restore over an existing file = %v, want %v", err, errors.ErrExist)
+	}
+	if _, err = idx.RestoreFromTrash(item.ID, "", "merge", "bob"); err != errors.ErrInvalidOption {
+		t.Errorf("restore with an unknown conflict mode = %v, want %v", err, errors.ErrInvalidOption)
+	}
+	if _, err = idx.RestoreFromTrash(item.ID, "/"+TrashDirName+"/x", RestoreFail, "bob"); err != errors.ErrPermissionDenied {
+		t.Errorf("restore into the trash = %v, want %v", err, errors.ErrPermissionDenied)
+	}
+	writeTestFile(t, filepath.Join(root, "notes (1).txt"), "taken")
+	if restored, err = idx.RestoreFromTrash(item.ID, "", RestoreRename, "bob"); err != nil || restored != "/notes (2).txt" {
+		t.Fatalf("restore with rename = %v, %v, want /notes (2).txt", restored, err)
+	}
+	if got := readTestFile(t, filepath.Join(root, "notes (2).txt")); got != "second" {
+		t.Errorf("renamed restore content = %q, want second", got)
+	}
+
+	// overwriting moves the existing file to the trash, so it can be restored in turn
+	item = trash("third")
+	writeTestFile(t, filepath.Join(root, "notes.txt"), "current")
+	if restored, err = idx.RestoreFromTrash(item.ID, "", RestoreOverwrite, "alice"); err != nil || restored != "/notes.txt" {
+		t.Fatalf("restore with overwrite = %v, %v, want /notes.txt", restored, err)
+	}
+	if got := readTestFile(t, filepath.Join(root, "notes.txt")); got != "third" {
+		t.Errorf("overwritten content = %q, want third", got)
+	}
+	items, err := idx.Trash()
+	if err != nil || len(items) != 1 || items[0].DeletedBy != "alice" {
+		t.Fatalf("Trash() = %v, %v, want the overwritten file", items, err)
+	}
+	if got := readTestFile(t, idx.trashPath(items[0].ID, "notes.txt")); got != "current" {
+		t.Errorf("overwritten file in the trash = %q, want current", got)
+	}
+}
+
+func TestRestoreFromTrashLocked(t *testing.T) {
+	root := t.TempDir()
+	idx := newTestIndex(root, 1)
+	writeTestFile(t, filepath.Join(root, "notes.txt"), "trashed")
+	item, err := idx.MoveToTrash("/notes.txt", "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a file created while another trash operation runs is seen by the conflict check
+	unlock := idx.lockTrash()
+	restored := make(chan error)
+	go func() {
+		_, err := idx.RestoreFromTrash(item.ID, "", RestoreFail, "bob")
+		restored <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	writeTestFile(t, filepath.Join(root, "notes.txt"), "current")
+	unlock()
+	if err = <-restored; err != errors.ErrExist {
+		t.Errorf("restore over a file created while the trash was locked = %v, want %v", err, errors.ErrExist)
+	}
+	if got := readTestFile(t, filepath.Join(root, "notes.txt")); got != "current" {
+		t.Errorf("content = %q, want current", got)
+	}
+}
+
+func TestRunTrashRetention(t *testing.T) {
+	idx := newTestIndex(t.TempDir(), 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		idx.runTrashRetention(ctx)
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("retention kept running after its context was cancelled")
+	}
+}
+
+func TestTrashRetention(t *testing.T) {
+	root := t.TempDir()
+	idx := newTestIndex(root, 1)
+	age := func(item *TrashItem, days int) {
+		t.Helper()
+		item.DeletedAt = time.Now().AddDate(0, 0, -days)
+		if err := writeTrashInfo(idx.trashPath(item.ID+".json"), item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	trashed := map[string]*TrashItem{}
+	for name, kb := range map[string]int{"old.txt": 256, "older.txt": 256, "big.txt": 768, "new.txt": 512} {
+		writeTestFile(t, filepath.Join(root, name), strings.Repeat("x", kb*1024))
+		item, err := idx.MoveToTrash("/"+name, "bob")
+		if err != nil {
+			t.Fatal(err)
+		}
+		trashed[name] = item
+	}
+	age(trashed["old.txt"], 31)
+	age(trashed["older.txt"], 40)
+	age(trashed["big.txt"], 2)
+	calls := recordTrashHooks(t)
+
+	// items past the default retention go first, then the oldest until the size fits
+	idx.Config.Trash.MaxSizeMB = 1
+	idx.enforceTrashRetention()
+	items, err := idx.Trash()
+	if err != nil || len(items) != 1 || items[0].Name != "new.txt" {
+		t.Fatalf("trash after retention = %v, %v, want only new.txt", items, err)
+	}
+	for _, name := range []string{"old.txt", "older.txt", "big.txt"} {
+		if _, err = os.Stat(idx.trashPath(trashed[name].ID)); !os.IsNotExist(err) {
+			t.Errorf("purged %v is still on disk", name)
+		}
+	}
+	if len(*calls) != 3 {
+		t.Errorf("hooks = %v, want 3 purges", *calls)
+	}
+
+	// -1 keeps items until they are purged manually
+	age(&items[0], 365)
+	idx.Config.Trash = settings.TrashConfig{RetentionDays: -1}
+	idx.enforceTrashRetention()
+	if items, _ = idx.Trash(); len(items) != 1 {
+		t.Fatalf("retention -1 purged %v", items)
+	}
+	if err = idx.PurgeTrashItem(items[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if want := "purged " + items[0].contentPath(); (*calls)[len(*calls)-1] != want {
+		t.Errorf("last hook = %v, want %v", (*calls)[len(*calls)-1], want)
+	}
+	if items, _ = idx.Trash(); len(items) != 0 {
+		t.Errorf("trash after purge = %v, want empty", items)
+	}
+	if err = idx.PurgeTrashItem(trashed["new.txt"].ID); err != errors.ErrNotExist {
+		t.Errorf("purging a purged item = %v, want %v", err, errors.ErrNotExist)
+	}
+}
@@ -0,0 +1,434 @@
+package indexing
+
+import (
+	"encoding/json"
+	"errors"
+	"filebrowser/common/utils"
+	"filebrowser/events"
+	"filebrowser/indexing/iteminfo"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/gtsteffaniak/go-logger/logger"
+)
+
+// changes are collected for this long before they are applied to the index,
+// so bursts of writes to the same directory only cause a single refresh.
+const watcherDebounce = 500 * time.Millisecond
+
+type ChangeAction string
+
+const (
+	CREATED  ChangeAction = "created"
+	MODIFIED ChangeAction = "modified"
+	DELETED  ChangeAction = "deleted"
+)
+
+// FileChangeEvent is sent to clients subscribed to a source when the watcher applies a change.
+type FileChangeEvent struct {
+	Path   string       `json:"path"`
+	Action ChangeAction `json:"action"`
+	IsDir  bool         `json:"isDir"`
+}
+
+type pendingChange struct {
+	action ChangeAction
+	isDir  bool
+}
+
+type indexWatcher struct {
+	idx     *Index
+	fsw     *fsnotify.Watcher
+	watched map[string]bool // index paths with an active watch
+	pending map[string]pendingChange
+	limited bool // watch limit reached, scheduled scans are still required
+	done    chan struct{}
+	mu      sync.Mutex
+}
+
+// startWatcher sets up real-time watching for every indexed directory.
+func (idx *Index) startWatcher() {
+	if idx.mock || idx.Config.DisableIndexing || idx.Config.MaxWatchers <= 0 {
+		return
+	}
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		logger.Errorf("could not start watcher for [%v], falling back to scheduled scans: %v", idx.Name, err)
+		return
+	}
+	w := &indexWatcher{
+		idx:     idx,
+		fsw:     fsw,
+		watched: make(map[string]bool),
+		pending: make(map[string]pendingChange),
+		done:    make(chan struct{}),
+	}
+	idx.mu.Lock()
+	idx.watcher = w
+	idx.mu.Unlock()
+	idx.syncWatches()
+	go w.run()
+	logger.Infof("watching [%v] for changes: directories=%v limited=%v", idx.Name, len(w.watched), w.limited)
+}
+
+// fullyWatched returns true when every indexed directory is being watched,
+// in which case scheduled quick scans can be skipped.
+func (idx *Index) fullyWatched() bool {
+	idx.mu.RLock()
+	w := idx.watcher
+	idx.mu.RUnlock()
+	if w == nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return !w.limited
+}
+
+// syncWatches adds watches for indexed directories that aren't watched yet and
+// drops watches for directories no longer in the index. Shallow directories are
+// watched first, so with maxWatchers reached the deepest ones are left to scans.
+func (idx *Index) syncWatches() {
+	idx.syncWatchesUnder("/")
+}
+
+// syncWatchesUnder reconciles the watches of a directory and its subdirectories, the
+// watcher uses it for the directories a batch of changes touched instead of the whole tree.
+func (idx *Index) syncWatchesUnder(scope string) {
+	idx.mu.RLock()
+	w := idx.watcher
+	paths := []string{}
+	for path := range idx.store.all(scope) {
+		paths = append(paths, path)
+	}
+	idx.mu.RUnlock()
+	if w == nil {
+		return
+	}
+	slices.SortFunc(paths, func(a, b string) int {
+		if depth := strings.Count(a, "/") - strings.Count(b, "/"); depth != 0 {
+			return depth
+		}
+		return strings.Compare(a, b)
+	})
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	current := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		current[path] = true
+	}
+	prefix := strings.TrimSuffix(scope, "/") + "/"
+	for path := range w.watched {
+		if !current[path] && (path == scope || strings.HasPrefix(path, prefix)) {
+			w.unwatch(path)
+		}
+	}
+	for _, path := range paths {
+		w.watch(path)
+	}
+}
+
+// watch adds a watch for the directory, must be called with w.mu held.
+func (w *indexWatcher) watch(indexPath string) {
+	if w.watched[indexPath] || w.idx.neverWatch(indexPath) {
+		return
+	}
+	if len(w.watched) >= w.idx.Config.MaxWatchers {
+		if !w.limited {
+			logger.Warningf("maxWatchers limit (%v) reached for [%v], remaining directories rely on scheduled scans", w.idx.Config.MaxWatchers, w.idx.Name)
+		}
+		w.limited = true
+		return
+	}
+	realPath := strings.TrimRight(w.idx.Path, "/") + indexPath
+	err := w.fsw.Add(realPath)
+	if err != nil {
+		if errors.Is(err, syscall.ENOSPC) {
+			if !w.limited {
+				logger.Warningf("kernel watch limit reached for [%v], remaining directories rely on scheduled scans. Consider raising fs.inotify.max_user_watches", w.idx.Name)
+			}
+			w.limited = true
+			return
+		}
+		logger.Debugf("could not watch %v: %v", realPath, err)
+		return
+	}
+	w.watched[indexPath] = true
+}
+
+// unwatch removes the watch for the directory, must be called with w.mu held.
+func (w *indexWatcher) unwatch(indexPath string) {
+	if !w.watched[indexPath] {
+		return
+	}
+	delete(w.watched, indexPath)
+	// the kernel already drops watches for deleted directories, so errors are expected here
+	_ = w.fsw.Remove(strings.TrimRight(w.idx.Path, "/") + indexPath)
+}
+
+func (idx *Index) neverWatch(indexPath string) bool {
+	for _, path := range idx.Config.NeverWatch {
+		path = "/" + strings.Trim(path, "/")
+		if indexPath == path || strings.HasPrefix(indexPath, path+"/") || path == "/" {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *indexWatcher) run() {
+	ticker := time.NewTicker(watcherDebounce)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case event, ok := <-w.fsw.Events:
+			if !ok {
+				return
+			}
+			w.queue(event)
+		case err, ok := <-w.fsw.Errors:
+			if !ok {
+				return
+			}
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				// events were lost, let the next scheduled scan catch up
+				logger.Warningf("watcher event queue overflowed for [%v], changes will be picked up by the next scan", w.idx.Name)
+				w.idx.mu.Lock()
+				w.idx.FilesChangedDuringIndexing = true
+				w.idx.mu.Unlock()
+				continue
+			}
+			logger.Errorf("watcher error for [%v]: %v", w.idx.Name, err)
+		case <-ticker.C:
+			w.flush()
+		}
+	}
+}
+
+// queue records a filesystem event to be applied on the next flush.
+func (w *indexWatcher) queue(event fsnotify.Event) {
+	idx := w.idx
+	indexPath := idx.MakeIndexPath(event.Name)
+	if indexPath == "/" || idx.neverWatch(indexPath) {
+		return
+	}
+	change := pendingChange{}
+	switch {
+	case event.Has(fsnotify.Create):
+		change.action = CREATED
+	case event.Has(fsnotify.Write):
+		change.action = MODIFIED
+	case event.Has(fsnotify.Remove), event.Has(fsnotify.Rename):
+		change.action = DELETED
+	default:
+		// chmod only events don't affect the index
+		return
+	}
+	// ignore files are applied even when hidden files are not indexed
+	ignoreFile := filepath.Base(indexPath) == ignoreFileName
+	if change.action == DELETED {
+		idx.mu.RLock()
+		change.isDir = idx.store.has(indexPath)
+		idx.mu.RUnlock()
+		if !change.isDir && !ignoreFile && idx.shouldSkip(false, filepath.Base(indexPath)[0] == '.', indexPath) {
+			return
+		}
+	} else {
+		info, err := os.Lstat(event.Name)
+		if err != nil {
+			// already gone again, a following remove event handles it
+			return
+		}
+		change.isDir = iteminfo.IsDirectory(info)
+		hidden := isHidden(info, filepath.Dir(event.Name))
+		if !ignoreFile && idx.shouldSkip(change.isDir, hidden, indexPath) {
+			return
+		}
+		if change.isDir && change.action == MODIFIED {
+			// directory writes are reported through their children
+			return
+		}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if previous, ok := w.pending[indexPath]; ok && previous.action == CREATED && change.action == MODIFIED {
+		// keep reporting a new file as created
+		return
+	}
+	w.pending[indexPath] = change
+}
+
+// flush applies queued changes to the index and notifies subscribed clients.
+func (w *indexWatcher) flush() {
+	w.mu.Lock()
+	if len(w.pending) == 0 {
+		w.mu.Unlock()
+		return
+	}
+	pending := w.pending
+	w.pending = make(map[string]pendingChange)
+	w.mu.Unlock()
+
+	idx := w.idx
+	refreshDirs := map[string]bool{}
+	// subtrees that gained or lost directories, only their watches are synced
+	touched := map[string]bool{}
+	for indexPath, change := range pending {
+		parent := utils.GetParentDirectoryPath(indexPath)
+		refreshDirs[parent] = true
+		if !change.isDir {
+			if filepath.Base(indexPath) == ignoreFileName {
+				idx.applyIgnoreFileChange(parent)
+				touched[parent] = true
+			}
+			continue
+		}
+		switch change.action {
+		case DELETED:
+			idx.removeDirectory(indexPath)
+			w.mu.Lock()
+			for path := range w.watched {
+				if path == indexPath || strings.HasPrefix(path, indexPath+"/") {
+					w.unwatch(path)
+				}
+			}
+			w.mu.Unlock()
+		case CREATED:
+			idx.indexNewDirectory(indexPath)
+			touched[indexPath] = true
+		}
+	}
+	for dir := range refreshDirs {
+		if change, ok := pending[dir]; ok && change.action == DELETED {
+			continue
+		}
+		err := idx.RefreshFileInfo(iteminfo.FileOptions{Path: dir, IsDir: true})
+		if err != nil {
+			logger.Debugf("could not refresh %v after change: %v", dir, err)
+		}
+	}
+	for dir := range touched {
+		idx.syncWatchesUnder(dir)
+	}
+	for indexPath, change := range pending {
+		idx.sendFileChangeEvent(FileChangeEvent{
+			Path:   indexPath,
+			Action: change.action,
+			IsDir:  change.isDir,
+		})
+	}
+}
+
+// indexNewDirectory indexes a directory that appeared since the last scan.
+func (idx *Index) indexNewDirectory(indexPath string) {
+	idx.mu.Lock()
+	// still indexed, for example when it was moved back, its contents are counted again by the scan
+	known := idx.store.has(indexPath)
+	if known {
+		idx.uncount(indexPath, false)
+	}
+	idx.mu.Unlock()
+	err := idx.indexDirectory(indexPath, directRead, true)
+	if err != nil {
+		logger.Debugf("could not index new directory %v: %v", indexPath, err)
+		return
+	}
+	if !known {
+		// the subtree is counted by the scan, the directory itself by its parent
+		idx.mu.Lock()
+		idx.NumDirs++
+		idx.mu.Unlock()
+	}
+}
+
+// applyIgnoreFileChange rescans a directory after its ignore file changed and drops
+// subdirectories that are ignored now.
+func (idx *Index) applyIgnoreFileChange(dirPath string) {
+	before, exists := idx.GetMetadataInfo(dirPath, true)
+	if !exists {
+		return
+	}
+	idx.mu.Lock()
+	idx.uncount(dirPath, false)
+	idx.mu.Unlock()
+	err := idx.indexDirectory(dirPath, directRead, true)
+	if err != nil {
+		logger.Debugf("could not rescan %v after ignore file change: %v", dirPath, err)
+		return
+	}
+	after, _ := idx.GetMetadataInfo(dirPath, true)
+	kept := map[string]bool{}
+	for _, folder := range after.Folders {
+		kept[folder.Name] = true
+	}
+	prefix := strings.TrimSuffix(dirPath, "/") + "/"
+	for _, folder := range before.Folders {
+		if !kept[folder.Name] {
+			idx.removeDirectory(prefix + folder.Name)
+		}
+	}
+}
+
+// removeDirectory drops a directory and all of its subdirectories from the index.
+func (idx *Index) removeDirectory(indexPath string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	removed := []string{}
+	for path := range idx.store.all(indexPath) {
+		removed = append(removed, path)
+	}
+	idx.uncount(indexPath, true)
+	for _, path := range removed {
+		if idx.store.delete(path) {
+			idx.NumDeleted++
+		}
+		if idx.content != nil {
+			idx.content.removeDir(path)
+		}
+		if idx.media != nil {
+			idx.media.removeDir(path)
+		}
+	}
+	idx.links.releaseLinks(indexPath)
+}
+
+// uncount subtracts the directories and files of a subtree from the index counters,
+// must be called with idx.mu held. The directory itself is counted by its parent scan
+// and only subtracted with self.
+func (idx *Index) uncount(indexPath string, self bool) {
+	for path, d := range idx.store.all(indexPath) {
+		if path != indexPath || self {
+			idx.NumDirs = decrement(idx.NumDirs, 1)
+		}
+		idx.NumFiles = decrement(idx.NumFiles, uint64(len(d.Items)-int(d.Folders)))
+	}
+}
+
+// decrement subtracts n from a counter without wrapping around, counters restored
+// from an older snapshot may be lower than what is removed.
+func decrement(counter, n uint64) uint64 {
+	if n > counter {
+		return 0
+	}
+	return counter - n
+}
+
+func (idx *Index) sendFileChangeEvent(change FileChangeEvent) {
+	if idx.mock {
+		return
+	}
+	message, err := json.Marshal(change)
+	if err != nil {
+		logger.Errorf("Error marshalling file change message: %v", err)
+		return
+	}
+	events.SendSourceFileChange(idx.Name, string(message))
+}
@@ -0,0 +1,215 @@
+package indexing
+
+import (
+	"filebrowser/common/errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// newTestWatcher attaches a watcher to an indexed test index. Events are queued and
+// flushed by the tests instead of the run loop.
+func newTestWatcher(t *testing.T, idx *Index, maxWatchers int) *indexWatcher {
+	t.Helper()
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fsw.Close() })
+	idx.Config.MaxWatchers = maxWatchers
+	w := &indexWatcher{
+		idx:     idx,
+		fsw:     fsw,
+		watched: make(map[string]bool),
+		pending: make(map[string]pendingChange),
+		done:    make(chan struct{}),
+	}
+	idx.watcher = w
+	idx.syncWatches()
+	return w
+}
+
+func TestWatchShallowestFirst(t *testing.T) {
+	root := createTestTree(t, 2, 3, 1)
+	idx := newTestIndex(root, 1)
+	if err := idx.indexDirectory("/", fullScan, true); err != nil {
+		t.Fatal(err)
+	}
+	// root, 2 and 4 directories fill the limit, the 8 deepest are left to scans
+	w := newTestWatcher(t, idx, 7)
+	if !w.limited {
+		t.Error("watcher should be limited")
+	}
+	if len(w.watched) != 7 {
+		t.Fatalf("watching %v directories, want 7", len(w.watched))
+	}
+	for path := range w.watched {
+		if strings.Count(path, "/") > 2 {
+			t.Errorf("watching %v before shallower directories", path)
+		}
+	}
+}
+
+func TestWatcherKeepsCounts(t *testing.T) {
+	root := createTestTree(t, 2, 2, 3)
+	idx := newTestIndex(root, 1)
+	if err := idx.indexDirectory("/", fullScan, true); err != nil {
+		t.Fatal(err)
+	}
+	w := newTestWatcher(t, idx, 100)
+
+	created := filepath.Join(root, "dir0", "new")
+	if err := os.MkdirAll(filepath.Join(created, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", "sub/b.txt"} {
+		if err := os.WriteFile(filepath.Join(created, name), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.queue(fsnotify.Event{Name: created, Op: fsnotify.Create})
+	w.flush()
+	if !idx.store.has("/dir0/new/sub") {
+		t.Fatal("new directory was not indexed")
+	}
+	if !w.watched["/dir0/new/sub"] {
+		t.Error("new directory is not watched")
+	}
+
+	if err := os.RemoveAll(filepath.Join(root, "dir1")); err != nil {
+		t.Fatal(err)
+	}
+	w.queue(fsnotify.Event{Name: filepath.Join(root, "dir1"), Op: fsnotify.Remove})
+	w.flush()
+	if idx.store.has("/dir1") || idx.store.has("/dir1/dir0") {
+		t.Fatal("deleted directory is still indexed")
+	}
+	if w.watched["/dir1/dir0"] {
+		t.Error("deleted directory is still watched")
+	}
+
+	// the same counts as a fresh scan of the changed tree
+	fresh := newTestIndex(root, 1)
+	if err := fresh.indexDirectory("/", fullScan, true); err != nil {
+		t.Fatal(err)
+	}
+	if idx.NumDirs != fresh.NumDirs || idx.NumFiles != fresh.NumFiles {
+		t.Errorf("watcher counted dirs=%v files=%v, want dirs=%v files=%v", idx.NumDirs, idx.NumFiles, fresh.NumDirs, fresh.NumFiles)
+	}
+}
+
+func TestWatcherSyncsTouchedDirs(t *testing.T) {
+	root := createTestTree(t, 2, 2, 1)
+	idx := newTestIndex(root, 1)
+	if err := idx.indexDirectory("/", fullScan, true); err != nil {
+		t.Fatal(err)
+	}
+	w := newTestWatcher(t, idx, 100)
+	// a directory without a watch outside of the changed subtree stays as it is
+	w.mu.Lock()
+	w.unwatch("/dir1/dir0")
+	w.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Join(root, "dir0", "new", "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	w.queue(fsnotify.Event{Name: filepath.Join(root, "dir0", "new"), Op: fsnotify.Create})
+	w.queue(fsnotify.Event{Name: filepath.Join(root, "dir1", "file0.txt"), Op: fsnotify.Write})
+	w.flush()
+	if !w.watched["/dir0/new"] || !w.watched["/dir0/new/sub"] {
+		t.Error("new directories are not watched")
+	}
+	if w.watched["/dir1/dir0"] {
+		t.Error("flush synced the watches of the whole tree")
+	}
+	// scans reconcile every watch
+	idx.syncWatches()
+	if !w.watched["/dir1/dir0"] {
+		t.Error("scan did not restore the missing watch")
+	}
+}
+
+func TestWatcherRename(t *testing.T) {
+	root := createTestTree(t, 2, 2, 1)
+	idx := newTestIndex(root, 1)
+	if err := idx.indexDirectory("/", fullScan, true); err != nil {
+		t.Fatal(err)
+	}
+	w := newTestWatcher(t, idx, 100)
+
+	// the create can be flushed before the old path is removed, the claim of
+	// the old path must not keep the renamed directory out of the index
+	if err := os.Rename(filepath.Join(root, "dir0"), filepath.Join(root, "moved")); err != nil {
+		t.Fatal(err)
+	}
+	w.queue(fsnotify.Event{Name: filepath.Join(root, "moved"), Op: fsnotify.Create})
+	w.flush()
+	w.queue(fsnotify.Event{Name: filepath.Join(root, "dir0"), Op: fsnotify.Rename})
+	w.flush()
+	if !idx.store.has("/moved/dir1") || idx.store.has("/dir0") {
+		t.Fatal("renamed directory was not moved in the index")
+	}
+
+	// and back again, with the remove flushed first
+	if err := os.Rename(filepath.Join(root, "moved"), filepath.Join(root, "dir0")); err != nil {
+		t.Fatal(err)
+	}
+	w.queue(fsnotify.Event{Name: filepath.Join(root, "moved"), Op: fsnotify.Rename})
+	w.flush()
+	w.queue(fsnotify.Event{Name: filepath.Join(root, "dir0"), Op: fsnotify.Create})
+	w.flush()
+	if !idx.store.has("/dir0/dir1") || idx.store.has("/moved") {
+		t.Fatal("directory moved back was not indexed")
+	}
+
+	fresh := newTestIndex(root, 1)
+	if err := fresh.indexDirectory("/", fullScan, true); err != nil {
+		t.Fatal(err)
+	}
+	if idx.NumDirs != fresh.NumDirs || idx.NumFiles != fresh.NumFiles {
+		t.Errorf("watcher counted dirs=%v files=%v, want dirs=%v files=%v", idx.NumDirs, idx.NumFiles, fresh.NumDirs, fresh.NumFiles)
+	}
+	rootInfo, _ := idx.GetMetadataInfo("/", true)
+	freshInfo, _ := fresh.GetMetadataInfo("/", true)
+	if rootInfo.Size != freshInfo.Size {
+		t.Errorf("root size = %v, want %v", rootInfo.Size, freshInfo.Size)
+	}
+}
+
+func TestWatcherNotThrottled(t *testing.T) {
+	root := createTestTree(t, 1, 1, 1)
+	idx := newTestIndex(root, 1)
+	if err := idx.indexDirectory("/", fullScan, true); err != nil {
+		t.Fatal(err)
+	}
+	w := newTestWatcher(t, idx, 100)
+	// a paused and cancelled scan must neither hold nor fail watcher updates
+	idx.scan.paused, idx.scan.cancelled = true, true
+	if err := idx.indexDirectory("/", fullScan, true); err != errors.ErrScanCancelled {
+		t.Fatalf("scheduled scan returned %v, want %v", err, errors.ErrScanCancelled)
+	}
+	if err := os.Mkdir(filepath.Join(root, "dir0", "new"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "dir0", "new", "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w.queue(fsnotify.Event{Name: filepath.Join(root, "dir0", "new"), Op: fsnotify.Create})
+	done := make(chan struct{})
+	go func() {
+		w.flush()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watcher flush waited for the paused scan")
+	}
+	if !idx.store.has("/dir0/new") {
+		t.Error("new directory was not indexed while scans are paused")
+	}
+}
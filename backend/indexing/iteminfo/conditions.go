@@ -293,69 +293,10 @@ func DetectTypeByHeader(realPath string) string {
 	return http.DetectContentType(buffer[:n])
 }
 
-// returns true if the file name contains the search term
-// returns file type if the file name contains the search term
-// returns size of file/dir if the file name contains the search term
+// ContainsSearchTerm reports whether the name contains the search term and the item
+// meets the type and size conditions of the options.
 func (fi ItemInfo) ContainsSearchTerm(searchTerm string, options SearchOptions) bool {
-
-	fileTypes := map[string]bool{}
-	largerThan := int64(options.LargerThan) * 1024 * 1024
-	smallerThan := int64(options.SmallerThan) * 1024 * 1024
-	conditions := options.Conditions
-	lowerFileName := strings.ToLower(fi.Name)
-
-	// Convert to lowercase if not exact match
-	if !conditions["exact"] {
-		searchTerm = strings.ToLower(searchTerm)
-	}
-
-	// Check if the file name contains the search term
-	if !strings.Contains(lowerFileName, searchTerm) {
-		return false
-	}
-
-	// Initialize file size and fileTypes map
-	var fileSize int64
-	extension := filepath.Ext(lowerFileName)
-
-	// Collect file types
-	for _, k := range AllFiletypeOptions {
-		if IsMatchingType(extension, k) {
-			fileTypes[k] = true
-		}
-	}
-	isDir := fi.Type == "directory"
-	fileTypes["dir"] = isDir
-	fileSize = fi.Size
-
-	// Evaluate all conditions
-	for t, v := range conditions {
-		if t == "exact" {
-			continue
-		}
-		switch t {
-		case "larger":
-			if largerThan > 0 {
-				if fileSize <= largerThan {
-					return false
-				}
-			}
-		case "smaller":
-			if smallerThan > 0 {
-				if fileSize >= smallerThan {
-					return false
-				}
-			}
-		default:
-			// Handle other file type conditions
-			notMatchType := v != fileTypes[t]
-			if notMatchType {
-				return false
-			}
-		}
-	}
-
-	return true
+	return options.conditionsQuery(searchTerm).Matches(fi, "", nil)
 }
 
 // IsDirectory determines if a path should be treated as a directory.
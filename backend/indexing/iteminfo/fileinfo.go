@@ -1,16 +1,19 @@
 package iteminfo
 
 import (
+	"fmt"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
 type ItemInfo struct {
-	Name    string    `json:"name"`     // name of the file
-	Size    int64     `json:"size"`     // length in bytes for regular files
-	ModTime time.Time `json:"modified"` // modification time
-	Type    string    `json:"type"`     // type of the file, either "directory" or a file mimetype
-	Hidden  bool      `json:"hidden"`   // whether the file is hidden
+	Name          string    `json:"name"`          // name of the file
+	Size          int64     `json:"size"`          // apparent length in bytes, for directories the total of their contents
+	AllocatedSize int64     `json:"allocatedSize"` // bytes allocated on disk, hard links are counted once
+	ModTime       time.Time `json:"modified"`      // modification time
+	Type          string    `json:"type"`          // type of the file, either "directory" or a file mimetype
+	Hidden        bool      `json:"hidden"`        // whether the file is hidden
 }
 type FileInfo struct {
 	ItemInfo
@@ -23,9 +26,11 @@ type ExtendedFileInfo struct {
 	Content      string            `json:"content,omitempty"`      // text content of a file, if requested
 	Subtitles    []string          `json:"subtitles,omitempty"`    // subtitles for video files
 	Checksums    map[string]string `json:"checksums,omitempty"`    // checksums for the file
+	Metadata     *Metadata         `json:"metadata,omitempty"`     // photo, audio or video metadata, if requested
 	Token        string            `json:"token,omitempty"`        // token for the file -- used for sharing
 	OnlyOfficeId string            `json:"onlyOfficeId,omitempty"` // id for onlyoffice files
 	Source       string            `json:"source"`                 // associated index source for the file
+	ETag         string            `json:"etag,omitempty"`         // version of the file content, pass back as FileOptions.IfMatch when saving
 	RealPath     string            `json:"-"`
 }
 type FileOptions struct {
@@ -36,8 +41,25 @@ type FileOptions struct {
 	Expand     bool
 	ReadHeader bool
 	Content    bool
+	Metadata   bool // read photo, audio and video metadata
+	// write preconditions, a write fails with ErrConflict when the file changed since it was read
+	IfMatch         string    // ETag the file must still have, "*" only requires that it exists
+	ExpectedModTime time.Time // modification time the file must still have, compared in seconds when given without fractions
 }
 
 func (f FileOptions) Components() (string, string) {
 	return filepath.Dir(f.Path), filepath.Base(f.Path)
 }
+
+// ETag identifies the content of a file by its modification time and size.
+func ETag(modTime time.Time, size int64) string {
+	return fmt.Sprintf(`"%x-%x"`, modTime.UnixNano(), size)
+}
+
+// MatchesETag compares ETags, ignoring weak prefixes and missing quotes.
+func MatchesETag(etag, expected string) bool {
+	normalize := func(tag string) string {
+		return strings.Trim(strings.TrimPrefix(strings.TrimSpace(tag), "W/"), `"`)
+	}
+	return normalize(etag) == normalize(expected)
+}
@@ -0,0 +1,286 @@
+package iteminfo
+
+import (
+	"cmp"
+	"container/heap"
+	"encoding/base64"
+	"encoding/json"
+	"filebrowser/common/errors"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultListLimit = 1000
+	MaxListLimit     = 10000
+	// MaxListOffset bounds the items a pager keeps, deeper pages are reached with the cursor
+	MaxListOffset = 100000
+)
+
+// ListOptions selects a page of a directory listing.
+type ListOptions struct {
+	Offset       int    // items to skip, counted after Cursor when both are set
+	Limit        int    // items per page, DefaultListLimit when not set
+	Cursor       string // from the previous page, continues after its last item
+	Sort         string // name, natural, size, modified or type, defaults to name
+	Desc         bool
+	FoldersFirst bool
+	Filter       string // case insensitive part of the name, or a pattern with * and ?
+}
+
+// DirectoryPage is a page of a directory listing.
+type DirectoryPage struct {
+	ItemInfo            // the directory itself
+	Path     string     `json:"path"`
+	Items    []ItemInfo `json:"items"`            // in sort order, folders have the type "directory"
+	Total    int        `json:"total"`            // items matching the filter
+	Cursor   string     `json:"cursor,omitempty"` // pass to get the next page, empty on the last page
+}
+
+// IsDefaultOrder reports whether the options list items in the order directories are indexed in,
+// which is folders first, then by name.
+func (o ListOptions) IsDefaultOrder() bool {
+	return (o.Sort == "" || o.Sort == "name") && !o.Desc && o.FoldersFirst && o.Filter == ""
+}
+
+// Validate checks the options and fills in defaults.
+func (o *ListOptions) Validate() error {
+	switch o.Sort {
+	case "", "name", "natural", "size", "modified", "type":
+	default:
+		return errors.ErrInvalidRequestParams
+	}
+	if o.Offset < 0 || o.Offset > MaxListOffset || o.Limit < 0 {
+		return errors.ErrInvalidRequestParams
+	}
+	if o.Limit == 0 {
+		o.Limit = DefaultListLimit
+	}
+	if o.Limit > MaxListLimit {
+		o.Limit = MaxListLimit
+	}
+	if o.Cursor != "" {
+		if _, err := decodeCursor(o.Cursor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Compare orders two items of the same directory as the options ask.
+func (o ListOptions) Compare(a, b ItemInfo) int {
+	aDir, bDir := a.Type == "directory", b.Type == "directory"
+	if o.FoldersFirst && aDir != bDir {
+		if aDir {
+			return -1
+		}
+		return 1
+	}
+	c := 0
+	switch o.Sort {
+	case "natural":
+		c = naturalCompare(a.Name, b.Name)
+	case "size":
+		c = cmp.Compare(a.Size, b.Size)
+	case "modified":
+		c = a.ModTime.Compare(b.ModTime)
+	case "type":
+		c = strings.Compare(a.Type, b.Type)
+	}
+	if c == 0 {
+		c = compareNames(a.Name, b.Name)
+	}
+	if o.Desc {
+		return -c
+	}
+	return c
+}
+
+// MatchesFilter reports whether the name of an item passes the filter.
+func (o ListOptions) MatchesFilter(name string) bool {
+	if o.Filter == "" {
+		return true
+	}
+	filter, name := strings.ToLower(o.Filter), strings.ToLower(name)
+	if strings.ContainsAny(filter, "*?") {
+		matched, _ := filepath.Match(filter, name)
+		return matched
+	}
+	return strings.Contains(name, filter)
+}
+
+// compareNames is the order of SortItems: numeric names before the first dot are compared
+// as numbers, others case insensitively. Names that compare equal fall back to byte order.
+func compareNames(a, b string) int {
+	numA, errA := strconv.Atoi(strings.Split(a, ".")[0])
+	numB, errB := strconv.Atoi(strings.Split(b, ".")[0])
+	c := 0
+	if errA == nil && errB == nil {
+		c = cmp.Compare(numA, numB)
+	}
+	if c == 0 {
+		c = strings.Compare(strings.ToLower(a), strings.ToLower(b))
+	}
+	if c == 0 {
+		c = strings.Compare(a, b)
+	}
+	return c
+}
+
+// naturalCompare compares runs of digits by their value and everything else case insensitively,
+// so "img2" comes before "img10".
+func naturalCompare(a, b string) int {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	for a != "" && b != "" {
+		aDigits, bDigits := digitPrefix(a), digitPrefix(b)
+		if aDigits > 0 && bDigits > 0 {
+			numA, numB := strings.TrimLeft(a[:aDigits], "0"), strings.TrimLeft(b[:bDigits], "0")
+			if c := cmp.Compare(len(numA), len(numB)); c != 0 {
+				return c
+			}
+			if c := strings.Compare(numA, numB); c != 0 {
+				return c
+			}
+			a, b = a[aDigits:], b[bDigits:]
+			continue
+		}
+		if a[0] != b[0] {
+			return cmp.Compare(a[0], b[0])
+		}
+		a, b = a[1:], b[1:]
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+func digitPrefix(s string) int {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return i
+}
+
+// listCursor holds the sort key of the last item of a page and its position,
+// the position lets listings in index order continue without comparing items.
+type listCursor struct {
+	Name     string `json:"n"`
+	Size     int64  `json:"s,omitempty"`
+	Modified int64  `json:"m,omitempty"`
+	Type     string `json:"t,omitempty"`
+	Position int    `json:"p"`
+}
+
+func encodeCursor(item ItemInfo, position int) string {
+	cursor := listCursor{
+		Name:     item.Name,
+		Size:     item.Size,
+		Modified: item.ModTime.UnixNano(),
+		Type:     item.Type,
+		Position: position,
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(cursor string) (listCursor, error) {
+	var decoded listCursor
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || json.Unmarshal(data, &decoded) != nil {
+		return decoded, errors.ErrInvalidRequestParams
+	}
+	return decoded, nil
+}
+
+func (c listCursor) item() ItemInfo {
+	return ItemInfo{Name: c.Name, Size: c.Size, ModTime: time.Unix(0, c.Modified), Type: c.Type}
+}
+
+// CursorPosition returns the number of items before the page a cursor continues with,
+// and the name of the item the previous page ended with.
+func CursorPosition(cursor string) (int, string) {
+	decoded, _ := decodeCursor(cursor)
+	return decoded.Position, decoded.Name
+}
+
+// PageCursor returns the cursor of a page in index order that ends with item at position.
+func PageCursor(item ItemInfo, position int) string {
+	return encodeCursor(item, position)
+}
+
+// Pager collects a page of a listing from items added in any order. It only keeps
+// Offset+Limit items, so directories of any size can be paged with little memory.
+type Pager struct {
+	opts   ListOptions
+	after  *ItemInfo // the last item of the previous page
+	start  int       // position of the first item after the cursor
+	best   pagerHeap
+	total  int
+	behind int // matching items after the cursor
+}
+
+// NewPager returns a pager for validated options.
+func NewPager(opts ListOptions) *Pager {
+	p := &Pager{opts: opts}
+	p.best.compare = opts.Compare
+	if opts.Cursor != "" {
+		cursor, _ := decodeCursor(opts.Cursor)
+		after := cursor.item()
+		p.after = &after
+		p.start = cursor.Position
+	}
+	return p
+}
+
+// Add offers an item to the page.
+func (p *Pager) Add(item ItemInfo) {
+	if !p.opts.MatchesFilter(item.Name) {
+		return
+	}
+	p.total++
+	if p.after != nil && p.opts.Compare(item, *p.after) <= 0 {
+		return
+	}
+	p.behind++
+	keep := p.opts.Offset + p.opts.Limit
+	if p.best.Len() < keep {
+		heap.Push(&p.best, item)
+	} else if p.opts.Compare(item, p.best.items[0]) < 0 {
+		p.best.items[0] = item
+		heap.Fix(&p.best, 0)
+	}
+}
+
+// Page returns the items of the page in order, the total of matching items and the next cursor.
+func (p *Pager) Page() ([]ItemInfo, int, string) {
+	items := slices.Clone(p.best.items)
+	slices.SortFunc(items, p.opts.Compare)
+	if p.opts.Offset >= len(items) {
+		items = items[:0]
+	} else {
+		items = items[p.opts.Offset:]
+	}
+	cursor := ""
+	if end := p.opts.Offset + len(items); len(items) > 0 && end < p.behind {
+		cursor = encodeCursor(items[len(items)-1], p.start+end)
+	}
+	return items, p.total, cursor
+}
+
+// pagerHeap keeps the last item of the page on top, so it can be replaced by a better one.
+type pagerHeap struct {
+	items   []ItemInfo
+	compare func(a, b ItemInfo) int
+}
+
+func (h pagerHeap) Len() int           { return len(h.items) }
+func (h pagerHeap) Less(i, j int) bool { return h.compare(h.items[i], h.items[j]) > 0 }
+func (h pagerHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *pagerHeap) Push(x any)        { h.items = append(h.items, x.(ItemInfo)) }
+func (h *pagerHeap) Pop() any {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
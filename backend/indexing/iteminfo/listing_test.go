@@ -0,0 +1,166 @@
+package iteminfo
+
+import (
+	"filebrowser/common/errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var listNow = time.Date(2025, 6, 15, 12, 0, 0, 0, time.UTC)
+
+// listItems are added to pagers out of order, the tests expect them sorted.
+var listItems = []ItemInfo{
+	{Name: "notes.txt", Type: "text", Size: 10, ModTime: listNow.Add(2 * time.Hour)},
+	{Name: "docs", Type: "directory", Size: 100, ModTime: listNow.Add(4 * time.Hour)},
+	{Name: "img2.jpg", Type: "image", Size: 30, ModTime: listNow.Add(3 * time.Hour)},
+	{Name: "Archive", Type: "directory", Size: 50, ModTime: listNow},
+	{Name: "img10.jpg", Type: "image", Size: 5, ModTime: listNow.Add(time.Hour)},
+}
+
+func names(items []ItemInfo) []string {
+	out := []string{}
+	for _, item := range items {
+		out = append(out, item.Name)
+	}
+	return out
+}
+
+func TestValidateListOptions(t *testing.T) {
+	testCases := map[string]struct {
+		opts      ListOptions
+		wantLimit int
+		wantErr   error
+	}{
+		"defaults":            {opts: ListOptions{}, wantLimit: DefaultListLimit},
+		"limit is capped":     {opts: ListOptions{Limit: MaxListLimit + 1}, wantLimit: MaxListLimit},
+		"largest offset":      {opts: ListOptions{Offset: MaxListOffset, Limit: 10}, wantLimit: 10},
+		"offset too large":    {opts: ListOptions{Offset: MaxListOffset + 1}, wantErr: errors.ErrInvalidRequestParams},
+		"negative offset":     {opts: ListOptions{Offset: -1}, wantErr: errors.ErrInvalidRequestParams},
+		"negative limit":      {opts: ListOptions{Limit: -1}, wantErr: errors.ErrInvalidRequestParams},
+		"unknown sort":        {opts: ListOptions{Sort: "owner"}, wantErr: errors.ErrInvalidRequestParams},
+		"cursor not base64":   {opts: ListOptions{Cursor: "%%%"}, wantErr: errors.ErrInvalidRequestParams},
+		"cursor not json":     {opts: ListOptions{Cursor: "bm90IGpzb24"}, wantErr: errors.ErrInvalidRequestParams},
+		"cursor of a page":    {opts: ListOptions{Cursor: PageCursor(ItemInfo{Name: "a"}, 1)}, wantLimit: DefaultListLimit},
+		"natural sort":        {opts: ListOptions{Sort: "natural", Limit: 5}, wantLimit: 5},
+		"descending modified": {opts: ListOptions{Sort: "modified", Desc: true}, wantLimit: DefaultListLimit},
+	}
+	for name, tt := range testCases {
+		t.Run(name, func(t *testing.T) {
+			opts := tt.opts
+			err := opts.Validate()
+			if err != tt.wantErr {
+				t.Fatalf("Validate() = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && opts.Limit != tt.wantLimit {
+				t.Errorf("limit = %v, want %v", opts.Limit, tt.wantLimit)
+			}
+		})
+	}
+}
+
+func TestNaturalCompare(t *testing.T) {
+	testCases := map[string]struct {
+		a, b string
+		want int
+	}{
+		"numbers by value":       {a: "img2", b: "img10", want: -1},
+		"numbers by value, swap": {a: "img10", b: "img2", want: 1},
+		"case insensitive":       {a: "IMG2", b: "img2", want: 0},
+		"leading zeros":          {a: "a01", b: "a1", want: 0},
+		"prefix first":           {a: "a", b: "a1", want: -1},
+		"digits after text":      {a: "x9y", b: "x10", want: -1},
+		"several numbers":        {a: "1.10", b: "1.9", want: 1},
+		"text before numbers":    {a: "b", b: "a2", want: 1},
+		"empty":                  {a: "", b: "a", want: -1},
+		"large numbers":          {a: "v99999999999999999999", b: "v100000000000000000000", want: -1},
+	}
+	for name, tt := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if got := naturalCompare(tt.a, tt.b); got != tt.want {
+				t.Errorf("naturalCompare(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPager(t *testing.T) {
+	testCases := map[string]struct {
+		opts  ListOptions
+		want  []string
+		total int
+		more  bool
+	}{
+		"name, folders first":  {opts: ListOptions{FoldersFirst: true}, want: []string{"Archive", "docs", "img10.jpg", "img2.jpg", "notes.txt"}, total: 5},
+		"name descending":      {opts: ListOptions{Sort: "name", Desc: true, FoldersFirst: true}, want: []string{"docs", "Archive", "notes.txt", "img2.jpg", "img10.jpg"}, total: 5},
+		"natural":              {opts: ListOptions{Sort: "natural"}, want: []string{"Archive", "docs", "img2.jpg", "img10.jpg", "notes.txt"}, total: 5},
+		"size":                 {opts: ListOptions{Sort: "size"}, want: []string{"img10.jpg", "notes.txt", "img2.jpg", "Archive", "docs"}, total: 5},
+		"size, folders first":  {opts: ListOptions{Sort: "size", FoldersFirst: true}, want: []string{"Archive", "docs", "img10.jpg", "notes.txt", "img2.jpg"}, total: 5},
+		"modified":             {opts: ListOptions{Sort: "modified"}, want: []string{"Archive", "img10.jpg", "notes.txt", "img2.jpg", "docs"}, total: 5},
+		"modified descending":  {opts: ListOptions{Sort: "modified", Desc: true}, want: []string{"docs", "img2.jpg", "notes.txt", "img10.jpg", "Archive"}, total: 5},
+		"type, then name":      {opts: ListOptions{Sort: "type"}, want: []string{"Archive", "docs", "img10.jpg", "img2.jpg", "notes.txt"}, total: 5},
+		"filter":               {opts: ListOptions{Filter: "IMG"}, want: []string{"img10.jpg", "img2.jpg"}, total: 2},
+		"filter pattern":       {opts: ListOptions{Filter: "*.JPG", Sort: "natural"}, want: []string{"img2.jpg", "img10.jpg"}, total: 2},
+		"offset and limit":     {opts: ListOptions{Offset: 1, Limit: 2, Sort: "size"}, want: []string{"notes.txt", "img2.jpg"}, total: 5, more: true},
+		"last page":            {opts: ListOptions{Offset: 3, Limit: 2, Sort: "size"}, want: []string{"Archive", "docs"}, total: 5},
+		"offset past the end":  {opts: ListOptions{Offset: 10, Limit: 2}, want: []string{}, total: 5},
+		"nothing matches":      {opts: ListOptions{Filter: "missing"}, want: []string{}, total: 0},
+		"cursor of size order": {opts: ListOptions{Sort: "size", Limit: 2, Cursor: PageCursor(listItems[0], 2)}, want: []string{"img2.jpg", "Archive"}, total: 5, more: true},
+		"cursor and offset":    {opts: ListOptions{Sort: "size", Limit: 2, Offset: 1, Cursor: PageCursor(listItems[0], 2)}, want: []string{"Archive", "docs"}, total: 5},
+	}
+	for name, tt := range testCases {
+		t.Run(name, func(t *testing.T) {
+			opts := tt.opts
+			if err := opts.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			pager := NewPager(opts)
+			for _, item := range listItems {
+				pager.Add(item)
+			}
+			items, total, cursor := pager.Page()
+			if got := names(items); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("items = %q, want %q", got, tt.want)
+			}
+			if total != tt.total {
+				t.Errorf("total = %v, want %v", total, tt.total)
+			}
+			if (cursor != "") != tt.more {
+				t.Errorf("cursor = %q, want one: %v", cursor, tt.more)
+			}
+		})
+	}
+}
+
+func TestPagerCursors(t *testing.T) {
+	for _, sort := range []string{"name", "natural", "size", "modified", "type"} {
+		for _, desc := range []bool{false, true} {
+			opts := ListOptions{Sort: sort, Desc: desc, Limit: 2}
+			if err := opts.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			all := NewPager(ListOptions{Sort: sort, Desc: desc, Limit: len(listItems)})
+			for _, item := range listItems {
+				all.Add(item)
+			}
+			want, _, _ := all.Page()
+
+			paged := []ItemInfo{}
+			for pages := 0; pages < len(listItems); pages++ {
+				pager := NewPager(opts)
+				for _, item := range listItems {
+					pager.Add(item)
+				}
+				items, _, cursor := pager.Page()
+				paged = append(paged, items...)
+				if cursor == "" {
+					break
+				}
+				opts.Cursor = cursor
+			}
+			if !reflect.DeepEqual(names(paged), names(want)) {
+				t.Errorf("sort %v, desc %v: pages hold %q, want %q", sort, desc, names(paged), names(want))
+			}
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package iteminfo
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metadata describes the contents of a media file, fields an extractor can't read stay empty.
+type Metadata struct {
+	Camera      string     `json:"camera,omitempty"`      // camera make and model
+	CaptureTime *time.Time `json:"captureTime,omitempty"` // when the photo or video was taken
+	Width       int        `json:"width,omitempty"`
+	Height      int        `json:"height,omitempty"`
+	Duration    float64    `json:"duration,omitempty"` // seconds
+	Title       string     `json:"title,omitempty"`
+	Artist      string     `json:"artist,omitempty"`
+	Album       string     `json:"album,omitempty"`
+	Extractor   string     `json:"extractor"` // name of the extractor that read the metadata
+}
+
+// IsEmpty reports whether no field was read.
+func (m *Metadata) IsEmpty() bool {
+	return m.Camera == "" && m.CaptureTime == nil && m.Width == 0 && m.Height == 0 &&
+		m.Duration == 0 && m.Title == "" && m.Artist == "" && m.Album == ""
+}
+
+// MetadataFilter is a search condition on metadata, eg. camera:canon, taken:2023-07 or duration:>5m.
+type MetadataFilter struct {
+	Field string
+	Op    string // one of "", ">", ">=", "<", "<=", an empty op means contains for text and equals otherwise
+	Value string
+}
+
+// MetadataFields are the search prefixes handled by ParseMetadataFilter.
+var MetadataFields = []string{"camera", "artist", "album", "title", "taken", "width", "height", "duration"}
+
+// ParseMetadataFilter validates a field and its value, the value may start with a comparison operator.
+func ParseMetadataFilter(field, value string) (MetadataFilter, error) {
+	filter := MetadataFilter{Field: strings.ToLower(field)}
+	value = strings.Trim(value, "\"")
+	switch filter.Field {
+	case "camera", "artist", "album", "title":
+		filter.Value = strings.ToLower(value)
+		if filter.Value == "" {
+			return filter, fmt.Errorf("%v: needs a value", field)
+		}
+		return filter, nil
+	case "taken", "width", "height", "duration":
+	default:
+		return filter, fmt.Errorf("unknown metadata field %v", field)
+	}
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(value, op) {
+			filter.Op = strings.TrimPrefix(op, "=")
+			value = strings.TrimPrefix(value, op)
+			break
+		}
+	}
+	filter.Value = value
+	var err error
+	switch filter.Field {
+	case "taken":
+		_, _, err = dateRange(value)
+	case "duration":
+		_, err = parseSeconds(value)
+	default:
+		_, err = strconv.Atoi(value)
+	}
+	if err != nil {
+		return filter, fmt.Errorf("invalid %v: value %q", field, value)
+	}
+	return filter, nil
+}
+
+// parseSeconds accepts plain seconds or a duration like 90s, 5m or 1h30m.
+func parseSeconds(value string) (float64, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return seconds, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	return duration.Seconds(), nil
+}
+
+func compare(op string, got, want float64) bool {
+	switch op {
+	case ">":
+		return got > want
+	case ">=":
+		return got >= want
+	case "<":
+		return got < want
+	case "<=":
+		return got <= want
+	}
+	// durations are rarely whole seconds
+	return math.Round(got) == math.Round(want)
+}
+
+// Matches reports whether the metadata satisfies the filter, missing values never match.
+func (m *Metadata) Matches(filter MetadataFilter) bool {
+	if m == nil {
+		return false
+	}
+	switch filter.Field {
+	case "camera":
+		return strings.Contains(strings.ToLower(m.Camera), filter.Value)
+	case "artist":
+		return strings.Contains(strings.ToLower(m.Artist), filter.Value)
+	case "album":
+		return strings.Contains(strings.ToLower(m.Album), filter.Value)
+	case "title":
+		return strings.Contains(strings.ToLower(m.Title), filter.Value)
+	case "width", "height":
+		size := m.Width
+		if filter.Field == "height" {
+			size = m.Height
+		}
+		want, err := strconv.Atoi(filter.Value)
+		return err == nil && size > 0 && compare(filter.Op, float64(size), float64(want))
+	case "duration":
+		want, err := parseSeconds(filter.Value)
+		return err == nil && m.Duration > 0 && compare(filter.Op, m.Duration, want)
+	case "taken":
+		start, end, err := dateRange(filter.Value)
+		if err != nil || m.CaptureTime == nil {
+			return false
+		}
+		taken := *m.CaptureTime
+		switch filter.Op {
+		case ">":
+			return !taken.Before(end)
+		case ">=":
+			return !taken.Before(start)
+		case "<":
+			return taken.Before(start)
+		case "<=":
+			return taken.Before(end)
+		}
+		return !taken.Before(start) && taken.Before(end)
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package iteminfo
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMetadataFilter(t *testing.T) {
+	testCases := map[string]struct {
+		field, value string
+		want         MetadataFilter
+		err          string
+	}{
+		"text is lowercased":     {field: "Camera", value: "Canon EOS", want: MetadataFilter{Field: "camera", Value: "canon eos"}},
+		"quoted text":            {field: "artist", value: `"The Band"`, want: MetadataFilter{Field: "artist", Value: "the band"}},
+		"text keeps operators":   {field: "title", value: ">intro", want: MetadataFilter{Field: "title", Value: ">intro"}},
+		"number":                 {field: "width", value: "4000", want: MetadataFilter{Field: "width", Value: "4000"}},
+		"number with operator":   {field: "height", value: ">=1080", want: MetadataFilter{Field: "height", Op: ">=", Value: "1080"}},
+		"equals operator":        {field: "width", value: "=640", want: MetadataFilter{Field: "width", Value: "640"}},
+		"duration":               {field: "duration", value: "<90s", want: MetadataFilter{Field: "duration", Op: "<", Value: "90s"}},
+		"duration in seconds":    {field: "duration", value: "2.5", want: MetadataFilter{Field: "duration", Value: "2.5"}},
+		"taken month":            {field: "taken", value: ">2023-07", want: MetadataFilter{Field: "taken", Op: ">", Value: "2023-07"}},
+		"empty text":             {field: "album", value: `""`, err: "album: needs a value"},
+		"invalid number":         {field: "width", value: ">wide", err: `invalid width: value "wide"`},
+		"operator without value": {field: "height", value: "<=", err: "invalid height"},
+		"invalid duration":       {field: "duration", value: "long", err: "invalid duration"},
+		"invalid date":           {field: "taken", value: "2023-13", err: "invalid taken"},
+		"unknown field":          {field: "lens", value: "50mm", err: "unknown metadata field lens"},
+	}
+	for name, tt := range testCases {
+		t.Run(name, func(t *testing.T) {
+			got, err := ParseMetadataFilter(tt.field, tt.value)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("ParseMetadataFilter(%q, %q) error = %v, want %q", tt.field, tt.value, err, tt.err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("ParseMetadataFilter(%q, %q) = %+v, %v, want %+v", tt.field, tt.value, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetadataMatches(t *testing.T) {
+	taken := time.Date(2023, 7, 14, 10, 30, 0, 0, time.Local)
+	photo := &Metadata{Camera: "Canon EOS R5", CaptureTime: &taken, Width: 6000, Height: 4000}
+	song := &Metadata{Title: "Intro", Artist: "The Band", Duration: 89.6}
+	testCases := map[string]struct {
+		meta         *Metadata
+		field, value string
+		want         bool
+	}{
+		"text contains":         {photo, "camera", "eos", true},
+		"text differs":          {photo, "camera", "nikon", false},
+		"missing text":          {photo, "artist", "band", false},
+		"number equals":         {photo, "width", "6000", true},
+		"number greater":        {photo, "height", ">4000", false},
+		"number at least":       {photo, "height", ">=4000", true},
+		"missing number":        {song, "width", "<100", false},
+		"duration rounds":       {song, "duration", "90", true},
+		"duration units":        {song, "duration", "<1m30s", true},
+		"missing duration":      {photo, "duration", "<5m", false},
+		"taken in day":          {photo, "taken", "2023-07-14", true},
+		"taken in year":         {photo, "taken", "2023", true},
+		"taken after month":     {photo, "taken", ">2023-07", false},
+		"taken from month":      {photo, "taken", ">=2023-07", true},
+		"taken before next day": {photo, "taken", "<2023-07-15", true},
+		"taken until day":       {photo, "taken", "<=2023-07-13", false},
+		"missing capture time":  {song, "taken", "2023", false},
+		"nil metadata":          {nil, "camera", "canon", false},
+	}
+	for name, tt := range testCases {
+		t.Run(name, func(t *testing.T) {
+			filter, err := ParseMetadataFilter(tt.field, tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.meta.Matches(filter); got != tt.want {
+				t.Errorf("Matches(%v:%v) = %v, want %v", tt.field, tt.value, got, tt.want)
+			}
+		})
+	}
+}
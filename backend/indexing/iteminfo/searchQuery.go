@@ -1,87 +1,747 @@
 package iteminfo
 
 import (
+	"fmt"
+	"path/filepath"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 )
 
-var typeRegexp = regexp.MustCompile(`type:(\S+)`)
+// Search queries combine terms and field filters with AND, OR and NOT:
+//
+//	report ext:pdf                    terms and filters next to each other must all match
+//	notes | report OR "annual plan"   | and OR are the same, quoted phrases keep their spaces
+//	-draft NOT path:archive           - and NOT exclude
+//	(ext:jpg OR ext:png) modified:<7d parentheses group
+//
+// Terms match names, case insensitively unless case:exact is part of the query.
+// Filters are ext:, path:, name:, modified:, type:, content:, tag: and the metadata
+// filters of the metadata package.
 
-type SearchOptions struct {
-	Conditions  map[string]bool
-	LargerThan  int
-	SmallerThan int
-	Terms       []string
+type NodeKind int
+
+const (
+	NodeAnd NodeKind = iota
+	NodeOr
+	NodeNot
+	NodeTerm   // the name contains Value
+	NodeFilter // Field:Value
+)
+
+// Node is an element of a parsed query.
+type Node struct {
+	Kind     NodeKind
+	Children []*Node // operands of and, or and not
+	Field    string  // filter field, eg. "ext" or "modified"
+	Op       string  // comparison of modified: filters, eg. ">" or "<="
+	Value    string
+	Pos      int            // byte offset in the query
+	Metadata MetadataFilter // for metadata filters
+	regexp   *regexp.Regexp // name:/.../
+	exts     []string       // ext: values, lowercase with the dot
+	after    time.Time      // modified: lower bound, zero is open
+	before   time.Time      // modified: upper bound, zero is open
+	size     int64          // type:largerThan= and type:smallerThan= in bytes
 }
 
-func ParseSearch(value string) SearchOptions {
-	opts := SearchOptions{
-		Conditions: map[string]bool{
-			"exact": strings.Contains(value, "case:exact"),
-		},
-		Terms: []string{},
+// Query is a parsed search query.
+type Query struct {
+	Root  *Node // nil when the query has no terms or filters, it matches every item
+	Exact bool  // case:exact, names and paths are compared case sensitively
+}
+
+// Lookup answers filters that need more than the item itself.
+type Lookup interface {
+	HasContent(path, term string) bool
+	HasTag(path, label string) bool
+	HasMetadata(path string, filter MetadataFilter) bool
+}
+
+// QueryError points at the part of a query that could not be parsed.
+type QueryError struct {
+	Pos     int    // byte offset of the bad token
+	Token   string // empty at the end of the query
+	Message string
+}
+
+func (e *QueryError) Error() string {
+	if e.Token == "" {
+		return fmt.Sprintf("%v at the end of the query", e.Message)
 	}
+	return fmt.Sprintf("%v at column %d: %q", e.Message, e.Pos+1, e.Token)
+}
 
-	// removes the options from the value
-	value = strings.Replace(value, "case:exact", "", -1)
-	value = strings.TrimSpace(value)
+type tokenKind int
 
-	types := typeRegexp.FindAllStringSubmatch(value, -1)
-	for _, filterType := range types {
-		if len(filterType) == 1 {
-			continue
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenPhrase
+	tokenFilter
+	tokenOpen
+	tokenClose
+	tokenAnd
+	tokenOr
+	tokenNot
+)
+
+type token struct {
+	kind  tokenKind
+	text  string // as written in the query
+	field string
+	value string
+	pos   int
+}
+
+var (
+	queryFields = append([]string{"ext", "path", "name", "modified", "type", "content", "tag", "case"}, MetadataFields...)
+	// relative ages of modified: filters, eg. 12h, 7d, 2w or 1y
+	relativeAgeRegexp = regexp.MustCompile(`^(\d+)([hdwy])$`)
+)
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// lex splits a query into tokens and takes out case:exact, which applies to the whole query.
+func lex(input string) ([]token, bool, error) {
+	tokens := []token{}
+	exact := false
+	for i := 0; i < len(input); {
+		c := input[i]
+		switch {
+		case isSpace(c):
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenOpen, text: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenClose, text: ")", pos: i})
+			i++
+		case c == '|':
+			tokens = append(tokens, token{kind: tokenOr, text: "|", pos: i})
+			i++
+		case c == '"':
+			end := strings.IndexByte(input[i+1:], '"')
+			if end < 0 {
+				return nil, false, &QueryError{Pos: i, Token: input[i:], Message: "missing closing quote"}
+			}
+			tokens = append(tokens, token{kind: tokenPhrase, text: input[i : i+end+2], value: input[i+1 : i+1+end], pos: i})
+			i += end + 2
+		case c == '-' && i+1 < len(input) && !isSpace(input[i+1]) && input[i+1] != ')' && input[i+1] != '|':
+			tokens = append(tokens, token{kind: tokenNot, text: "-", pos: i})
+			i++
+		default:
+			t, end, err := lexWord(input, i)
+			if err != nil {
+				return nil, false, err
+			}
+			i = end
+			if t.kind == tokenFilter && t.field == "case" {
+				if strings.ToLower(t.value) != "exact" {
+					return nil, false, &QueryError{Pos: t.pos, Token: t.text, Message: "unknown case option, only case:exact is supported"}
+				}
+				exact = true
+				continue
+			}
+			tokens = append(tokens, t)
 		}
-		filter := filterType[1]
-		switch filter {
-		case "image":
-			opts.Conditions["image"] = true
-		case "audio", "music":
-			opts.Conditions["audio"] = true
-		case "video":
-			opts.Conditions["video"] = true
-		case "doc":
-			opts.Conditions["doc"] = true
-		case "archive":
-			opts.Conditions["archive"] = true
-		case "folder":
-			opts.Conditions["dir"] = true
-		case "file":
-			opts.Conditions["dir"] = false
-		}
-		if len(filter) < 8 {
-			continue
+	}
+	return tokens, exact, nil
+}
+
+// lexWord reads a term, keyword or field filter starting at i.
+func lexWord(input string, i int) (token, int, error) {
+	start := i
+	field := i
+	for field < len(input) && (input[field] >= 'a' && input[field] <= 'z' || input[field] >= 'A' && input[field] <= 'Z') {
+		field++
+	}
+	if field < len(input) && input[field] == ':' && slices.Contains(queryFields, strings.ToLower(input[start:field])) {
+		t := token{kind: tokenFilter, field: strings.ToLower(input[start:field]), pos: start}
+		i = field + 1
+		switch {
+		case i < len(input) && input[i] == '"':
+			end := strings.IndexByte(input[i+1:], '"')
+			if end < 0 {
+				return t, 0, &QueryError{Pos: i, Token: input[i:], Message: "missing closing quote"}
+			}
+			t.value = input[i+1 : i+1+end]
+			i += end + 2
+		case i < len(input) && input[i] == '/' && t.field == "name":
+			end := i + 1
+			for end < len(input) && input[end] != '/' {
+				if input[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(input) {
+				return t, 0, &QueryError{Pos: start, Token: input[start:], Message: "missing closing / of the regular expression"}
+			}
+			t.value = input[i : end+1]
+			i = end + 1
+		default:
+			valueStart := i
+			for i < len(input) && !isSpace(input[i]) && input[i] != ')' && input[i] != '|' {
+				i++
+			}
+			t.value = input[valueStart:i]
 		}
-		if strings.HasPrefix(filter, "largerThan=") {
-			opts.Conditions["larger"] = true
-			size := strings.TrimPrefix(filter, "largerThan=")
-			opts.LargerThan = UpdateSize(size)
+		t.text = input[start:i]
+		return t, i, nil
+	}
+	for i < len(input) && !isSpace(input[i]) && !strings.ContainsRune("()|\"", rune(input[i])) {
+		i++
+	}
+	t := token{kind: tokenWord, text: input[start:i], value: input[start:i], pos: start}
+	switch t.text {
+	case "AND":
+		t.kind = tokenAnd
+	case "OR":
+		t.kind = tokenOr
+	case "NOT":
+		t.kind = tokenNot
+	}
+	return t, i, nil
+}
+
+type queryParser struct {
+	tokens []token
+	next   int
+	exact  bool
+	now    time.Time
+	length int // of the query, errors at the end point there
+}
+
+func (p *queryParser) peek() token {
+	if p.next >= len(p.tokens) {
+		return token{kind: tokenEOF}
+	}
+	return p.tokens[p.next]
+}
+
+func (p *queryParser) take() token {
+	t := p.peek()
+	p.next++
+	return t
+}
+
+func (p *queryParser) errorAt(t token, message string) error {
+	if t.kind == tokenEOF {
+		return &QueryError{Pos: p.length, Message: message}
+	}
+	return &QueryError{Pos: t.pos, Token: t.text, Message: message}
+}
+
+// ParseQuery parses a search query, errors are *QueryError.
+func ParseQuery(query string) (*Query, error) {
+	return parseQueryAt(query, time.Now())
+}
+
+func parseQueryAt(query string, now time.Time) (*Query, error) {
+	tokens, exact, err := lex(query)
+	if err != nil {
+		return nil, err
+	}
+	parsed := &Query{Exact: exact}
+	if len(tokens) == 0 {
+		return parsed, nil
+	}
+	p := &queryParser{tokens: tokens, exact: exact, now: now, length: len(query)}
+	parsed.Root, err = p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, p.errorAt(t, "unexpected closing parenthesis")
+	}
+	return parsed, nil
+}
+
+// parseOr reads and-groups separated by OR or |.
+func (p *queryParser) parseOr() (*Node, error) {
+	first, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	nodes := []*Node{first}
+	for p.peek().kind == tokenOr {
+		p.take()
+		node, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	if len(nodes) == 1 {
+		return first, nil
+	}
+	return &Node{Kind: NodeOr, Children: nodes, Pos: first.Pos}, nil
+}
+
+// parseAnd reads operands until OR, a closing parenthesis or the end, AND between them is optional.
+func (p *queryParser) parseAnd() (*Node, error) {
+	nodes := []*Node{}
+	for {
+		t := p.peek()
+		switch t.kind {
+		case tokenEOF, tokenClose, tokenOr:
+			if len(nodes) == 0 {
+				return nil, p.errorAt(t, "expected a search term")
+			}
+			if len(nodes) == 1 {
+				return nodes[0], nil
+			}
+			return &Node{Kind: NodeAnd, Children: nodes, Pos: nodes[0].Pos}, nil
+		case tokenAnd:
+			if len(nodes) == 0 {
+				return nil, p.errorAt(t, "expected a search term before AND")
+			}
+			p.take()
+			if next := p.peek(); next.kind == tokenEOF || next.kind == tokenClose || next.kind == tokenOr || next.kind == tokenAnd {
+				return nil, p.errorAt(next, "expected a search term after AND")
+			}
 		}
-		if strings.HasPrefix(filter, "smallerThan=") {
-			opts.Conditions["smaller"] = true
-			size := strings.TrimPrefix(filter, "smallerThan=")
-			opts.SmallerThan = UpdateSize(size)
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
 		}
+		nodes = append(nodes, node)
 	}
+}
 
-	if len(types) > 0 {
-		// Remove the fields from the search value
-		value = typeRegexp.ReplaceAllString(value, "")
+func (p *queryParser) parseUnary() (*Node, error) {
+	t := p.peek()
+	if t.kind != tokenNot {
+		return p.parsePrimary()
+	}
+	p.take()
+	if next := p.peek(); next.kind == tokenEOF || next.kind == tokenClose || next.kind == tokenOr || next.kind == tokenAnd {
+		return nil, p.errorAt(next, fmt.Sprintf("expected a search term after %v", t.text))
 	}
+	operand, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return &Node{Kind: NodeNot, Children: []*Node{operand}, Pos: t.pos}, nil
+}
 
-	if value == "" {
-		return opts
+func (p *queryParser) parsePrimary() (*Node, error) {
+	t := p.take()
+	switch t.kind {
+	case tokenOpen:
+		if p.peek().kind == tokenClose {
+			return nil, p.errorAt(p.peek(), "empty parentheses")
+		}
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().kind != tokenClose {
+			return nil, p.errorAt(t, "missing closing parenthesis")
+		}
+		p.take()
+		return node, nil
+	case tokenWord, tokenPhrase:
+		value := t.value
+		if strings.TrimSpace(value) == "" {
+			// an empty phrase would match every item
+			return nil, p.errorAt(t, "expected a search term between the quotes")
+		}
+		if !p.exact {
+			value = strings.ToLower(value)
+		}
+		return &Node{Kind: NodeTerm, Value: value, Pos: t.pos}, nil
+	case tokenFilter:
+		return p.parseFilter(t)
+	case tokenClose:
+		return nil, p.errorAt(t, "unexpected closing parenthesis")
+	}
+	return nil, p.errorAt(t, "expected a search term")
+}
+
+// parseFilter validates a field filter and prepares its matcher.
+func (p *queryParser) parseFilter(t token) (*Node, error) {
+	node := &Node{Kind: NodeFilter, Field: t.field, Value: t.value, Pos: t.pos}
+	if t.value == "" {
+		return nil, p.errorAt(t, fmt.Sprintf("%v: needs a value", t.field))
+	}
+	switch t.field {
+	case "ext":
+		for _, ext := range strings.Split(strings.ToLower(t.value), ",") {
+			if ext = strings.TrimSpace(ext); ext != "" {
+				node.exts = append(node.exts, "."+strings.TrimPrefix(ext, "."))
+			}
+		}
+		if len(node.exts) == 0 {
+			return nil, p.errorAt(t, "ext: needs a value")
+		}
+		node.Value = strings.Join(node.exts, ",")
+	case "path":
+		if !p.exact {
+			node.Value = strings.ToLower(node.Value)
+		}
+	case "name":
+		if len(t.value) >= 2 && strings.HasPrefix(t.value, "/") && strings.HasSuffix(t.value, "/") {
+			expression := t.value[1 : len(t.value)-1]
+			if !p.exact {
+				expression = "(?i)" + expression
+			}
+			compiled, err := regexp.Compile(expression)
+			if err != nil {
+				return nil, p.errorAt(t, fmt.Sprintf("invalid regular expression (%v)", err))
+			}
+			node.regexp = compiled
+		} else if !p.exact {
+			node.Value = strings.ToLower(node.Value)
+		}
+	case "modified":
+		if err := p.parseModified(node); err != nil {
+			return nil, p.errorAt(t, err.Error())
+		}
+	case "type":
+		if err := parseType(node); err != nil {
+			return nil, p.errorAt(t, err.Error())
+		}
+	case "content", "tag":
+	default:
+		filter, err := ParseMetadataFilter(t.field, t.value)
+		if err != nil {
+			return nil, p.errorAt(t, err.Error())
+		}
+		node.Metadata = filter
 	}
+	return node, nil
+}
 
-	// if the value starts with " and finishes what that character, we will
-	// only search for that term
-	if value[0] == '"' && value[len(value)-1] == '"' {
-		unique := strings.TrimPrefix(value, "\"")
-		unique = strings.TrimSuffix(unique, "\"")
+// parseModified accepts a date (2025, 2025-01 or 2025-01-31) or a relative age (12h, 7d, 2w, 1y),
+// optionally prefixed with >, >=, < or <=. An age without operator means newer than it.
+func (p *queryParser) parseModified(node *Node) error {
+	value := node.Value
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(value, op) {
+			node.Op = strings.TrimPrefix(op, "=")
+			value = value[len(op):]
+			break
+		}
+	}
+	node.Value = value
+	if match := relativeAgeRegexp.FindStringSubmatch(value); match != nil {
+		count, err := strconv.Atoi(match[1])
+		if err != nil {
+			return fmt.Errorf("invalid age %v", value)
+		}
+		var cutoff time.Time
+		switch match[2] {
+		case "h":
+			cutoff = p.now.Add(-time.Duration(count) * time.Hour)
+		case "d":
+			cutoff = p.now.AddDate(0, 0, -count)
+		case "w":
+			cutoff = p.now.AddDate(0, 0, -7*count)
+		case "y":
+			cutoff = p.now.AddDate(-count, 0, 0)
+		}
+		// ages compare the other way round, <7d is newer than 7 days
+		if node.Op == ">" || node.Op == ">=" {
+			node.before = cutoff
+		} else {
+			node.after = cutoff
+		}
+		return nil
+	}
+	start, end, err := dateRange(value)
+	if err != nil {
+		return fmt.Errorf("invalid date, use YYYY-MM-DD or an age like 7d")
+	}
+	switch node.Op {
+	case ">":
+		node.after = end
+	case ">=":
+		node.after = start
+	case "<":
+		node.before = start
+	case "<=":
+		node.before = end
+	default:
+		node.after, node.before = start, end
+	}
+	return nil
+}
+
+// dateRange returns the period of a YYYY, YYYY-MM or YYYY-MM-DD date in local time,
+// it is shared by modified: and taken: filters.
+func dateRange(value string) (time.Time, time.Time, error) {
+	layouts := []struct {
+		format              string
+		years, months, days int
+	}{
+		{"2006-01-02", 0, 0, 1},
+		{"2006-01", 0, 1, 0},
+		{"2006", 1, 0, 0},
+	}
+	for _, layout := range layouts {
+		start, err := time.ParseInLocation(layout.format, value, time.Local)
+		if err == nil {
+			return start, start.AddDate(layout.years, layout.months, layout.days), nil
+		}
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("invalid date %v", value)
+}
 
-		opts.Terms = []string{unique}
+func parseType(node *Node) error {
+	value := node.Value
+	switch {
+	case strings.HasPrefix(value, "largerThan="):
+		node.size = int64(UpdateSize(strings.TrimPrefix(value, "largerThan="))) * 1024 * 1024
+	case strings.HasPrefix(value, "smallerThan="):
+		node.size = int64(UpdateSize(strings.TrimPrefix(value, "smallerThan="))) * 1024 * 1024
+	case value == "music":
+		node.Value = "audio"
+	case value == "folder", value == "file", slices.Contains(AllFiletypeOptions, value):
+	default:
+		return fmt.Errorf("unknown type, use one of %v, folder, file, largerThan=MB or smallerThan=MB", strings.Join(AllFiletypeOptions, ", "))
+	}
+	return nil
+}
+
+// Matches evaluates the query for an item, path is its index path.
+func (q *Query) Matches(item ItemInfo, path string, lookup Lookup) bool {
+	if q.Root == nil {
+		return true
+	}
+	c := matchContext{item: item, name: item.Name, path: path, indexPath: path, lookup: lookup}
+	if !q.Exact {
+		c.name = strings.ToLower(c.name)
+		c.path = strings.ToLower(c.path)
+	}
+	return q.Root.matches(&c)
+}
+
+type matchContext struct {
+	item      ItemInfo
+	name      string // lowercase unless the query is exact
+	path      string // lowercase unless the query is exact
+	indexPath string // as stored, for lookups
+	lookup    Lookup
+}
+
+func (n *Node) matches(c *matchContext) bool {
+	switch n.Kind {
+	case NodeAnd:
+		for _, child := range n.Children {
+			if !child.matches(c) {
+				return false
+			}
+		}
+		return true
+	case NodeOr:
+		for _, child := range n.Children {
+			if child.matches(c) {
+				return true
+			}
+		}
+		return false
+	case NodeNot:
+		return !n.Children[0].matches(c)
+	case NodeTerm:
+		return strings.Contains(c.name, n.Value)
+	}
+	item := c.item
+	switch n.Field {
+	case "ext":
+		return item.Type != "directory" && slices.Contains(n.exts, strings.ToLower(filepath.Ext(item.Name)))
+	case "path":
+		return strings.Contains(c.path, n.Value)
+	case "name":
+		if n.regexp != nil {
+			return n.regexp.MatchString(item.Name)
+		}
+		return strings.Contains(c.name, n.Value)
+	case "modified":
+		return (n.after.IsZero() || !item.ModTime.Before(n.after)) && (n.before.IsZero() || item.ModTime.Before(n.before))
+	case "type":
+		switch {
+		case strings.HasPrefix(n.Value, "largerThan="):
+			return item.Size > n.size
+		case strings.HasPrefix(n.Value, "smallerThan="):
+			return item.Size < n.size
+		case n.Value == "folder":
+			return item.Type == "directory"
+		case n.Value == "file":
+			return item.Type != "directory"
+		}
+		return IsMatchingType(strings.ToLower(filepath.Ext(item.Name)), n.Value)
+	}
+	if c.lookup == nil {
+		return false
+	}
+	switch n.Field {
+	case "content":
+		return c.lookup.HasContent(c.indexPath, n.Value)
+	case "tag":
+		return c.lookup.HasTag(c.indexPath, n.Value)
+	}
+	return c.lookup.HasMetadata(c.indexPath, n.Metadata)
+}
+
+// Filters returns the filters of a field anywhere in the query.
+func (q *Query) Filters(field string) []*Node {
+	filters := []*Node{}
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		if n == nil {
+			return
+		}
+		if n.Kind == NodeFilter && n.Field == field {
+			filters = append(filters, n)
+		}
+		for _, child := range n.Children {
+			walk(child)
+		}
+	}
+	walk(q.Root)
+	return filters
+}
+
+// ContentTerms returns the content: values that are not negated, used to pick snippets.
+func (q *Query) ContentTerms() []string {
+	terms := []string{}
+	q.walkPositive(func(n *Node) {
+		if n.Kind == NodeFilter && n.Field == "content" {
+			terms = append(terms, n.Value)
+		}
+	})
+	return terms
+}
+
+// walkPositive calls visit for the nodes of the query that are not negated.
+func (q *Query) walkPositive(visit func(n *Node)) {
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		if n == nil || n.Kind == NodeNot {
+			return
+		}
+		visit(n)
+		for _, child := range n.Children {
+			walk(child)
+		}
+	}
+	walk(q.Root)
+}
+
+// String renders the query tree, used by tests and debug logs.
+func (n *Node) String() string {
+	if n == nil {
+		return ""
+	}
+	switch n.Kind {
+	case NodeAnd, NodeOr, NodeNot:
+		parts := []string{map[NodeKind]string{NodeAnd: "and", NodeOr: "or", NodeNot: "not"}[n.Kind]}
+		for _, child := range n.Children {
+			parts = append(parts, child.String())
+		}
+		return "(" + strings.Join(parts, " ") + ")"
+	case NodeTerm:
+		return strconv.Quote(n.Value)
+	}
+	return n.Field + ":" + n.Op + n.Value
+}
+
+// SearchOptions is the flat form of a query, see ParseSearch.
+type SearchOptions struct {
+	Conditions   map[string]bool
+	LargerThan   int
+	SmallerThan  int
+	Terms        []string
+	ContentTerms []string // terms that must appear inside the file, from content:term or content:"some phrase"
+	Tags         []string // labels the searching user gave the item, from tag:label or tag:"two words"
+	// conditions on indexed photo, audio and video metadata, eg. camera:canon or duration:>5m
+	MetadataFilters []MetadataFilter
+}
+
+// ParseSearch flattens a query into its terms and the filters that are not negated,
+// operators are lost. Use ParseQuery to evaluate a query, queries that don't parse are
+// searched as one name term.
+func ParseSearch(value string) SearchOptions {
+	opts := SearchOptions{
+		Conditions:   map[string]bool{},
+		Terms:        []string{},
+		ContentTerms: []string{},
+		Tags:         []string{},
+	}
+	query, err := ParseQuery(value)
+	if err != nil {
+		opts.Conditions["exact"] = false
+		opts.Terms = []string{strings.TrimSpace(value)}
 		return opts
 	}
-	value = strings.TrimSpace(value)
-	opts.Terms = strings.Split(value, "|")
+	opts.Conditions["exact"] = query.Exact
+	opts.ContentTerms = query.ContentTerms()
+	query.walkPositive(func(n *Node) {
+		if n.Kind == NodeTerm {
+			opts.Terms = append(opts.Terms, n.Value)
+			return
+		}
+		if n.Kind != NodeFilter {
+			return
+		}
+		switch n.Field {
+		case "tag":
+			opts.Tags = append(opts.Tags, n.Value)
+		case "type":
+			switch {
+			case strings.HasPrefix(n.Value, "largerThan="):
+				opts.Conditions["larger"] = true
+				opts.LargerThan = int(n.size / 1024 / 1024)
+			case strings.HasPrefix(n.Value, "smallerThan="):
+				opts.Conditions["smaller"] = true
+				opts.SmallerThan = int(n.size / 1024 / 1024)
+			case n.Value == "folder":
+				opts.Conditions["dir"] = true
+			case n.Value == "file":
+				opts.Conditions["dir"] = false
+			default:
+				opts.Conditions[n.Value] = true
+			}
+		case "ext", "path", "name", "modified", "content":
+		default:
+			opts.MetadataFilters = append(opts.MetadataFilters, n.Metadata)
+		}
+	})
 	return opts
 }
+
+// conditionsQuery builds the query of a search term and the type and size conditions of opts.
+func (opts SearchOptions) conditionsQuery(searchTerm string) *Query {
+	exact := opts.Conditions["exact"]
+	if !exact {
+		searchTerm = strings.ToLower(searchTerm)
+	}
+	root := &Node{Kind: NodeAnd, Children: []*Node{{Kind: NodeTerm, Value: searchTerm}}}
+	for condition, value := range opts.Conditions {
+		node := &Node{Kind: NodeFilter, Field: "type", Value: condition}
+		switch condition {
+		case "exact":
+			continue
+		case "larger", "smaller":
+			size := map[string]int{"larger": opts.LargerThan, "smaller": opts.SmallerThan}[condition]
+			if !value || size <= 0 {
+				continue
+			}
+			node.Value = condition + "Than="
+			node.size = int64(size) * 1024 * 1024
+		case "dir":
+			node.Value = "folder"
+		}
+		if !value {
+			node = &Node{Kind: NodeNot, Children: []*Node{node}}
+		}
+		root.Children = append(root.Children, node)
+	}
+	return &Query{Root: root, Exact: exact}
+}
@@ -0,0 +1,302 @@
+package iteminfo
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var queryNow = time.Date(2025, 6, 15, 12, 0, 0, 0, time.Local)
+
+func TestParseQuery(t *testing.T) {
+	testCases := map[string]struct {
+		query string
+		want  string
+		exact bool
+	}{
+		"single term":          {query: "report", want: `"report"`},
+		"terms are lowercased": {query: "Report", want: `"report"`},
+		"exact case":           {query: "Report case:exact", want: `"Report"`, exact: true},
+		"implicit and":         {query: "annual report", want: `(and "annual" "report")`},
+		"explicit and":         {query: "annual AND report", want: `(and "annual" "report")`},
+		"or and pipe":          {query: "a OR b | c", want: `(or "a" "b" "c")`},
+		"and binds tighter":    {query: "a b OR c", want: `(or (and "a" "b") "c")`},
+		"parentheses":          {query: "a (b OR c)", want: `(and "a" (or "b" "c"))`},
+		"nested parentheses":   {query: "((a))", want: `"a"`},
+		"minus excludes":       {query: "-draft report", want: `(and (not "draft") "report")`},
+		"not keyword":          {query: "report NOT draft", want: `(and "report" (not "draft"))`},
+		"double not":           {query: "NOT NOT a", want: `(not (not "a"))`},
+		"negated group":        {query: "-(a | b)", want: `(not (or "a" "b"))`},
+		"phrases":              {query: `"annual plan" -"old copy"`, want: `(and "annual plan" (not "old copy"))`},
+		"lowercase keywords":   {query: "cats or dogs", want: `(and "cats" "or" "dogs")`},
+		"hyphen inside term":   {query: "report-1", want: `"report-1"`},
+		"lone hyphen":          {query: "a - b", want: `(and "a" "-" "b")`},
+		"unknown field":        {query: "foo:bar", want: `"foo:bar"`},
+		"ext list":             {query: "ext:PDF,.docx", want: "ext:.pdf,.docx"},
+		"path":                 {query: "path:Photos/2024", want: "path:photos/2024"},
+		"name term":            {query: "name:IMG", want: "name:img"},
+		"name regex":           {query: `name:/^IMG_\d+ (1)\.jpe?g$/`, want: `name:/^IMG_\d+ (1)\.jpe?g$/`},
+		"modified after":       {query: "modified:>2025-01-01", want: "modified:>2025-01-01"},
+		"modified age":         {query: "modified:<7d", want: "modified:<7d"},
+		"modified equals":      {query: "modified:=2025-03", want: "modified:2025-03"},
+		"type alias":           {query: "type:music", want: "type:audio"},
+		"type size":            {query: "type:largerThan=10", want: "type:largerThan=10"},
+		"quoted filter":        {query: `content:"two words"`, want: "content:two words"},
+		"tag":                  {query: "tag:work", want: "tag:work"},
+		"metadata filter":      {query: "camera:canon width:>=4000", want: "(and camera:canon width:>=4000)"},
+		"filter in or":         {query: "ext:jpg|ext:png", want: "(or ext:.jpg ext:.png)"},
+		"filter before paren":  {query: "(ext:jpg OR ext:png) modified:<7d", want: "(and (or ext:.jpg ext:.png) modified:<7d)"},
+		"only case option":     {query: "case:exact", want: "", exact: true},
+		"empty":                {query: "   ", want: ""},
+	}
+	for name, tt := range testCases {
+		t.Run(name, func(t *testing.T) {
+			query, err := parseQueryAt(tt.query, queryNow)
+			if err != nil {
+				t.Fatalf("ParseQuery(%q) error: %v", tt.query, err)
+			}
+			if got := query.Root.String(); got != tt.want {
+				t.Errorf("ParseQuery(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+			if query.Exact != tt.exact {
+				t.Errorf("ParseQuery(%q).Exact = %v, want %v", tt.query, query.Exact, tt.exact)
+			}
+		})
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	testCases := map[string]struct {
+		query   string
+		pos     int
+		token   string
+		message string
+	}{
+		"unclosed group":         {query: "(a OR b", pos: 0, token: "(", message: "missing closing parenthesis"},
+		"unclosed inner group":   {query: "a ((b) c", pos: 2, token: "(", message: "missing closing parenthesis"},
+		"stray close":            {query: "a)", pos: 1, token: ")", message: "unexpected closing parenthesis"},
+		"stray close in group":   {query: "(a))", pos: 3, token: ")", message: "unexpected closing parenthesis"},
+		"empty group":            {query: "a ()", pos: 3, token: ")", message: "empty parentheses"},
+		"trailing or":            {query: "a OR", pos: 4, message: "expected a search term"},
+		"leading or":             {query: "OR a", pos: 0, token: "OR", message: "expected a search term"},
+		"double or":              {query: "a | | b", pos: 4, token: "|", message: "expected a search term"},
+		"trailing and":           {query: "a AND", pos: 5, message: "expected a search term after AND"},
+		"and before close":       {query: "(a AND) b", pos: 6, token: ")", message: "expected a search term after AND"},
+		"leading and":            {query: "AND a", pos: 0, token: "AND", message: "expected a search term before AND"},
+		"trailing not":           {query: "a NOT", pos: 5, message: "expected a search term after NOT"},
+		"not before or":          {query: "NOT OR b", pos: 4, token: "OR", message: "expected a search term after NOT"},
+		"not before pipe":        {query: "a NOT | b", pos: 6, token: "|", message: "expected a search term after NOT"},
+		"unterminated phrase":    {query: `a "annual plan`, pos: 2, token: `"annual plan`, message: "missing closing quote"},
+		"unterminated value":     {query: `content:"two words`, pos: 8, token: `"two words`, message: "missing closing quote"},
+		"empty phrase":           {query: `report ""`, pos: 7, token: `""`, message: "expected a search term between the quotes"},
+		"bad regex":              {query: "report name:/[a-/", pos: 7, token: "name:/[a-/", message: "invalid regular expression"},
+		"unterminated regex":     {query: "name:/abc", pos: 0, token: "name:/abc", message: "missing closing /"},
+		"bad date":               {query: "report modified:>2025-13-01", pos: 7, token: "modified:>2025-13-01", message: "invalid date"},
+		"bad age":                {query: "modified:yesterday", pos: 0, token: "modified:yesterday", message: "invalid date"},
+		"unknown type":           {query: "type:spreadsheet", pos: 0, token: "type:spreadsheet", message: "unknown type"},
+		"empty filter":           {query: "a ext: b", pos: 2, token: "ext:", message: "ext: needs a value"},
+		"empty ext list":         {query: "ext:,", pos: 0, token: "ext:,", message: "ext: needs a value"},
+		"unknown case option":    {query: "a case:loose", pos: 2, token: "case:loose", message: "unknown case option"},
+		"invalid metadata value": {query: "width:wide", pos: 0, token: "width:wide", message: "invalid width"},
+	}
+	for name, tt := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseQueryAt(tt.query, queryNow)
+			queryErr, ok := err.(*QueryError)
+			if !ok {
+				t.Fatalf("ParseQuery(%q) error = %v, want a *QueryError", tt.query, err)
+			}
+			if queryErr.Pos != tt.pos || queryErr.Token != tt.token || !strings.Contains(queryErr.Message, tt.message) {
+				t.Errorf("ParseQuery(%q) error = %+v, want position %v, token %q and message %q", tt.query, *queryErr, tt.pos, tt.token, tt.message)
+			}
+			if tt.token != "" && !strings.Contains(err.Error(), tt.token) {
+				t.Errorf("error message %q does not quote the bad token %q", err.Error(), tt.token)
+			}
+		})
+	}
+}
+
+type queryItem struct {
+	path string
+	item ItemInfo
+}
+
+func queryItems() []queryItem {
+	day := func(year int, month time.Month, d int) time.Time {
+		return time.Date(year, month, d, 10, 0, 0, 0, time.Local)
+	}
+	return []queryItem{
+		{"/docs/report-2024.pdf", ItemInfo{Name: "report-2024.pdf", Size: 2 << 20, ModTime: day(2024, 12, 20), Type: "application/pdf"}},
+		{"/docs/Report-2025.docx", ItemInfo{Name: "Report-2025.docx", Size: 10 << 10, ModTime: day(2025, 6, 10), Type: "application/vnd.openxmlformats-officedocument.wordprocessingml.document"}},
+		{"/docs/drafts/draft-report.txt", ItemInfo{Name: "draft-report.txt", Size: 1 << 10, ModTime: day(2025, 6, 14), Type: "text/plain"}},
+		{"/photos/2025/IMG_0001.jpg", ItemInfo{Name: "IMG_0001.jpg", Size: 3 << 20, ModTime: day(2025, 1, 5), Type: "image/jpeg"}},
+		{"/photos/2023/holiday.png", ItemInfo{Name: "holiday.png", Size: 500 << 10, ModTime: day(2023, 8, 1), Type: "image/png"}},
+		{"/photos", ItemInfo{Name: "photos", Size: 4 << 20, ModTime: day(2025, 1, 5), Type: "directory"}},
+		{"/music/song.mp3", ItemInfo{Name: "song.mp3", Size: 4 << 20, ModTime: day(2022, 3, 3), Type: "audio/mpeg"}},
+	}
+}
+
+// fakeLookup answers content:, tag: and metadata filters from fixed sets.
+type fakeLookup struct {
+	content map[string][]string // term -> paths
+	tags    map[string][]string // label -> paths
+	cameras map[string]string   // path -> camera
+}
+
+func (f fakeLookup) HasContent(path, term string) bool {
+	return contains(f.content[term], path)
+}
+
+func (f fakeLookup) HasTag(path, label string) bool {
+	return contains(f.tags[label], path)
+}
+
+func (f fakeLookup) HasMetadata(path string, filter MetadataFilter) bool {
+	camera, ok := f.cameras[path]
+	return ok && (&Metadata{Camera: camera}).Matches(filter)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestQueryMatches(t *testing.T) {
+	lookup := fakeLookup{
+		content: map[string][]string{"budget": {"/docs/report-2024.pdf", "/docs/drafts/draft-report.txt"}},
+		tags:    map[string][]string{"done": {"/docs/report-2024.pdf"}},
+		cameras: map[string]string{"/photos/2025/IMG_0001.jpg": "Canon EOS R5"},
+	}
+	testCases := map[string]struct {
+		query string
+		want  []string
+	}{
+		"term":                 {query: "report", want: []string{"report-2024.pdf", "Report-2025.docx", "draft-report.txt"}},
+		"exact case":           {query: "Report case:exact", want: []string{"Report-2025.docx"}},
+		"and":                  {query: "report 2025", want: []string{"Report-2025.docx"}},
+		"or":                   {query: "song | holiday", want: []string{"holiday.png", "song.mp3"}},
+		"exclusion":            {query: "report -draft", want: []string{"report-2024.pdf", "Report-2025.docx"}},
+		"not group":            {query: "NOT (report OR photos)", want: []string{"IMG_0001.jpg", "holiday.png", "song.mp3"}},
+		"phrase":               {query: `"report-2"`, want: []string{"report-2024.pdf", "Report-2025.docx"}},
+		"ext":                  {query: "ext:jpg,png", want: []string{"IMG_0001.jpg", "holiday.png"}},
+		"path":                 {query: "path:/docs/drafts", want: []string{"draft-report.txt"}},
+		"path is insensitive":  {query: "path:PHOTOS/2023", want: []string{"holiday.png"}},
+		"name regex":           {query: `name:/^img_\d+\.jpe?g$/`, want: []string{"IMG_0001.jpg"}},
+		"exact name regex":     {query: `name:/^img_/ case:exact`, want: []string{}},
+		"modified after date":  {query: "modified:>2025-01-05", want: []string{"Report-2025.docx", "draft-report.txt"}},
+		"modified from date":   {query: "modified:>=2025-01-05", want: []string{"Report-2025.docx", "draft-report.txt", "IMG_0001.jpg", "photos"}},
+		"modified in month":    {query: "modified:2025-06", want: []string{"Report-2025.docx", "draft-report.txt"}},
+		"modified before year": {query: "modified:<2024", want: []string{"holiday.png", "song.mp3"}},
+		"modified until year":  {query: "modified:<=2024 -ext:mp3", want: []string{"report-2024.pdf", "holiday.png"}},
+		"modified within age":  {query: "modified:<7d", want: []string{"Report-2025.docx", "draft-report.txt"}},
+		"modified older":       {query: "modified:>1y", want: []string{"holiday.png", "song.mp3"}},
+		"modified hours":       {query: "modified:48h", want: []string{"draft-report.txt"}},
+		"type":                 {query: "type:image", want: []string{"IMG_0001.jpg", "holiday.png"}},
+		"type folder":          {query: "type:folder", want: []string{"photos"}},
+		"type file and size":   {query: "type:file type:largerThan=3", want: []string{"song.mp3"}},
+		"type smaller":         {query: "type:smallerThan=1 -type:folder", want: []string{"Report-2025.docx", "draft-report.txt", "holiday.png"}},
+		"content":              {query: "content:budget", want: []string{"report-2024.pdf", "draft-report.txt"}},
+		"content or name":      {query: "content:budget OR song", want: []string{"report-2024.pdf", "draft-report.txt", "song.mp3"}},
+		"not tagged":           {query: "report -tag:done", want: []string{"Report-2025.docx", "draft-report.txt"}},
+		"metadata":             {query: "camera:canon OR ext:png", want: []string{"IMG_0001.jpg", "holiday.png"}},
+		"only filters":         {query: "case:exact", want: []string{"report-2024.pdf", "Report-2025.docx", "draft-report.txt", "IMG_0001.jpg", "holiday.png", "photos", "song.mp3"}},
+	}
+	for name, tt := range testCases {
+		t.Run(name, func(t *testing.T) {
+			query, err := parseQueryAt(tt.query, queryNow)
+			if err != nil {
+				t.Fatalf("ParseQuery(%q) error: %v", tt.query, err)
+			}
+			got := []string{}
+			for _, candidate := range queryItems() {
+				if query.Matches(candidate.item, candidate.path, lookup) {
+					got = append(got, candidate.item.Name)
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("%q matched %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryWithoutLookup(t *testing.T) {
+	query, err := ParseQuery("content:budget OR tag:done OR camera:canon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, candidate := range queryItems() {
+		if query.Matches(candidate.item, candidate.path, nil) {
+			t.Errorf("%v matched index filters without a lookup", candidate.path)
+		}
+	}
+}
+
+func TestQueryContentTerms(t *testing.T) {
+	query, err := ParseQuery(`content:budget (content:"q3 plan" OR x) -content:draft`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(query.ContentTerms(), ","); got != "budget,q3 plan" {
+		t.Errorf("ContentTerms() = %v, want the terms that are not negated", got)
+	}
+	if got := len(query.Filters("content")); got != 3 {
+		t.Errorf("Filters(content) returned %v filters, want 3", got)
+	}
+}
+
+func TestParseSearch(t *testing.T) {
+	opts := ParseSearch(`Report | "annual plan" -draft type:image type:largerThan=5 type:file content:budget tag:done camera:canon case:exact`)
+	if got := strings.Join(opts.Terms, ","); got != "Report,annual plan" {
+		t.Errorf("terms = %v, want the terms that are not negated", got)
+	}
+	want := map[string]bool{"exact": true, "image": true, "larger": true, "dir": false}
+	if !reflect.DeepEqual(opts.Conditions, want) || opts.LargerThan != 5 {
+		t.Errorf("conditions = %v larger than %v, want %v larger than 5", opts.Conditions, opts.LargerThan, want)
+	}
+	if strings.Join(opts.ContentTerms, ",") != "budget" || strings.Join(opts.Tags, ",") != "done" || len(opts.MetadataFilters) != 1 {
+		t.Errorf("content %v, tags %v, metadata %v, want budget, done and the camera", opts.ContentTerms, opts.Tags, opts.MetadataFilters)
+	}
+	if opts = ParseSearch(`(report`); strings.Join(opts.Terms, ",") != "(report" {
+		t.Errorf("a query that doesn't parse gave terms %q, want it as one term", opts.Terms)
+	}
+}
+
+func TestContainsSearchTerm(t *testing.T) {
+	image := ItemInfo{Name: "Holiday.JPG", Size: 6 * 1024 * 1024}
+	folder := ItemInfo{Name: "holiday", Type: "directory"}
+	testCases := map[string]struct {
+		item  ItemInfo
+		term  string
+		query string
+		want  bool
+	}{
+		"name":                      {item: image, term: "holiday", want: true},
+		"other name":                {item: image, term: "work"},
+		"exact case":                {item: image, term: "holiday", query: "case:exact"},
+		"type":                      {item: image, term: "day", query: "type:image", want: true},
+		"other type":                {item: image, term: "day", query: "type:video"},
+		"larger":                    {item: image, term: "day", query: "type:largerThan=5", want: true},
+		"smaller":                   {item: image, term: "day", query: "type:smallerThan=5"},
+		"folder":                    {item: folder, term: "day", query: "type:folder", want: true},
+		"file is not folder":        {item: folder, term: "day", query: "type:file"},
+		"file":                      {item: image, term: "day", query: "type:file", want: true},
+		"index filters are ignored": {item: image, term: "day", query: "content:budget", want: true},
+	}
+	for name, tt := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if got := tt.item.ContainsSearchTerm(tt.term, ParseSearch(tt.query)); got != tt.want {
+				t.Errorf("ContainsSearchTerm(%q, %q) = %v, want %v", tt.term, tt.query, got, tt.want)
+			}
+		})
+	}
+	// negated types of options built by hand
+	if (ItemInfo{Name: "song.mp3"}).ContainsSearchTerm("song", SearchOptions{Conditions: map[string]bool{"audio": false}}) {
+		t.Error("an audio file matched a condition excluding audio")
+	}
+}
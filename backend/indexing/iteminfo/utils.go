@@ -3,7 +3,6 @@ package iteminfo
 import (
 	"path/filepath"
 	"sort"
-	"strconv"
 	"strings"
 
 	"github.com/gtsteffaniak/go-logger/logger"
@@ -33,27 +32,9 @@ func (i *ExtendedFileInfo) DetectSubtitles(parentInfo *FileInfo) {
 
 func (info *FileInfo) SortItems() {
 	sort.Slice(info.Folders, func(i, j int) bool {
-		nameWithoutExt := strings.Split(info.Folders[i].Name, ".")[0]
-		nameWithoutExt2 := strings.Split(info.Folders[j].Name, ".")[0]
-		// Convert strings to integers for numeric sorting if both are numeric
-		numI, errI := strconv.Atoi(nameWithoutExt)
-		numJ, errJ := strconv.Atoi(nameWithoutExt2)
-		if errI == nil && errJ == nil {
-			return numI < numJ
-		}
-		// Fallback to case-insensitive lexicographical sorting
-		return strings.ToLower(info.Folders[i].Name) < strings.ToLower(info.Folders[j].Name)
+		return compareNames(info.Folders[i].Name, info.Folders[j].Name) < 0
 	})
 	sort.Slice(info.Files, func(i, j int) bool {
-		nameWithoutExt := strings.Split(info.Files[i].Name, ".")[0]
-		nameWithoutExt2 := strings.Split(info.Files[j].Name, ".")[0]
-		// Convert strings to integers for numeric sorting if both are numeric
-		numI, errI := strconv.Atoi(nameWithoutExt)
-		numJ, errJ := strconv.Atoi(nameWithoutExt2)
-		if errI == nil && errJ == nil {
-			return numI < numJ
-		}
-		// Fallback to case-insensitive lexicographical sorting
-		return strings.ToLower(info.Files[i].Name) < strings.ToLower(info.Files[j].Name)
+		return compareNames(info.Files[i].Name, info.Files[j].Name) < 0
 	})
 }
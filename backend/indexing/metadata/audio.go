@@ -0,0 +1,370 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"filebrowser/indexing/iteminfo"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// id3Extractor reads ID3v2 and ID3v1 tags and the duration of mp3 files.
+type id3Extractor struct{}
+
+// flacExtractor reads the stream info and vorbis comments of flac files.
+type flacExtractor struct{}
+
+const (
+	maxTagSize    = 16 << 20 // larger tags are mostly cover art, only the text frames are needed
+	maxFrameSync  = 64 << 10 // bytes searched for the first mpeg frame after the tag
+	id3HeaderSize = 10
+)
+
+var (
+	// kbit/s by [mpeg1][layer-1], mpeg 2 and 2.5 share a table
+	mpegBitrates = [2][3][15]int{
+		{
+			{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+			{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+		},
+		{
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		},
+	}
+	mpegSampleRates = [3]int{44100, 48000, 32000}
+)
+
+func (id3Extractor) Name() string {
+	return "id3"
+}
+
+func (id3Extractor) Supports(ext, mimetype string) bool {
+	return ext == ".mp3" || mimetype == "audio/mpeg"
+}
+
+func (id3Extractor) Extract(realPath string) (*iteminfo.Metadata, error) {
+	file, size, err := open(realPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	meta := &iteminfo.Metadata{}
+	audioStart, err := readID3v2(file, meta)
+	if err != nil {
+		return nil, err
+	}
+	if meta.Title == "" && meta.Artist == "" && meta.Album == "" {
+		readID3v1(file, size, meta)
+	}
+	if meta.Duration == 0 {
+		meta.Duration = mp3Duration(file, audioStart, size)
+	}
+	return meta, nil
+}
+
+// readID3v2 reads the text frames of an ID3v2 tag and returns where the audio starts.
+func readID3v2(r io.ReaderAt, meta *iteminfo.Metadata) (int64, error) {
+	header := make([]byte, id3HeaderSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return 0, err
+	}
+	if string(header[:3]) != "ID3" {
+		return 0, nil
+	}
+	version := header[3]
+	flags := header[5]
+	tagSize := int64(syncsafe(header[6:10]))
+	audioStart := id3HeaderSize + tagSize
+	if flags&0x10 != 0 {
+		// footer
+		audioStart += id3HeaderSize
+	}
+	if version < 2 || version > 4 || tagSize > maxTagSize {
+		return audioStart, nil
+	}
+	tag := make([]byte, tagSize)
+	if _, err := r.ReadAt(tag, id3HeaderSize); err != nil && err != io.EOF {
+		return audioStart, err
+	}
+	if flags&0x40 != 0 && len(tag) >= 4 {
+		// extended header, only v2.3 excludes the size field from its size
+		extended := int(binary.BigEndian.Uint32(tag))
+		if version == 4 {
+			extended = syncsafe(tag[:4])
+		} else {
+			extended += 4
+		}
+		if extended > len(tag) {
+			return audioStart, nil
+		}
+		tag = tag[extended:]
+	}
+	idSize, headerSize := 4, 10
+	if version == 2 {
+		idSize, headerSize = 3, 6
+	}
+	for len(tag) >= headerSize && tag[0] != 0 {
+		id := string(tag[:idSize])
+		var frameSize int
+		switch version {
+		case 2:
+			frameSize = int(tag[3])<<16 | int(tag[4])<<8 | int(tag[5])
+		case 3:
+			frameSize = int(binary.BigEndian.Uint32(tag[4:8]))
+		default:
+			frameSize = syncsafe(tag[4:8])
+		}
+		if frameSize <= 0 || headerSize+frameSize > len(tag) {
+			break
+		}
+		frame := tag[headerSize : headerSize+frameSize]
+		switch id {
+		case "TIT2", "TT2":
+			meta.Title = id3Text(frame)
+		case "TPE1", "TP1":
+			meta.Artist = id3Text(frame)
+		case "TALB", "TAL":
+			meta.Album = id3Text(frame)
+		case "TLEN", "TLE":
+			if ms, err := strconv.ParseFloat(id3Text(frame), 64); err == nil && ms > 0 {
+				meta.Duration = ms / 1000
+			}
+		}
+		tag = tag[headerSize+frameSize:]
+	}
+	return audioStart, nil
+}
+
+// readID3v1 reads the fixed size tag at the end of older files.
+func readID3v1(r io.ReaderAt, size int64, meta *iteminfo.Metadata) {
+	if size < 128 {
+		return
+	}
+	tag := make([]byte, 128)
+	if _, err := r.ReadAt(tag, size-128); err != nil || string(tag[:3]) != "TAG" {
+		return
+	}
+	meta.Title = latin1(tag[3:33])
+	meta.Artist = latin1(tag[33:63])
+	meta.Album = latin1(tag[63:93])
+}
+
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7F)<<21 | int(b[1]&0x7F)<<14 | int(b[2]&0x7F)<<7 | int(b[3]&0x7F)
+}
+
+// id3Text decodes a text frame, the first byte selects the encoding.
+func id3Text(frame []byte) string {
+	if len(frame) < 2 {
+		return ""
+	}
+	data := frame[1:]
+	switch frame[0] {
+	case 0:
+		return latin1(data)
+	case 1, 2:
+		order := binary.ByteOrder(binary.BigEndian)
+		if len(data) >= 2 && data[0] == 0xFF && data[1] == 0xFE {
+			order = binary.LittleEndian
+			data = data[2:]
+		} else if len(data) >= 2 && data[0] == 0xFE && data[1] == 0xFF {
+			data = data[2:]
+		}
+		units := make([]uint16, 0, len(data)/2)
+		for i := 0; i+1 < len(data); i += 2 {
+			unit := order.Uint16(data[i:])
+			if unit == 0 {
+				// multiple values are separated by zeros, only the first one is used
+				break
+			}
+			units = append(units, unit)
+		}
+		return cleanText(string(utf16.Decode(units)))
+	default:
+		value, _, _ := bytes.Cut(data, []byte{0})
+		return cleanText(string(value))
+	}
+}
+
+func latin1(data []byte) string {
+	value, _, _ := bytes.Cut(data, []byte{0})
+	runes := make([]rune, len(value))
+	for i, b := range value {
+		runes[i] = rune(b)
+	}
+	return cleanText(string(runes))
+}
+
+// mp3Duration reads the frame count of a Xing, Info or VBRI header and falls back
+// to estimating the duration from the bitrate of the first frame.
+func mp3Duration(r io.ReaderAt, audioStart, size int64) float64 {
+	buf := make([]byte, maxFrameSync)
+	n, _ := r.ReadAt(buf, audioStart)
+	buf = buf[:n]
+	for i := 0; i+4 <= len(buf); i++ {
+		if buf[i] != 0xFF || buf[i+1]&0xE0 != 0xE0 {
+			continue
+		}
+		versionBits := (buf[i+1] >> 3) & 0x03
+		layerBits := (buf[i+1] >> 1) & 0x03
+		bitrateIndex := buf[i+2] >> 4
+		rateIndex := (buf[i+2] >> 2) & 0x03
+		if versionBits == 1 || layerBits == 0 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+			continue
+		}
+		mpeg1 := versionBits == 3
+		layer := 4 - int(layerBits)
+		table := 1
+		sampleRate := mpegSampleRates[rateIndex]
+		if mpeg1 {
+			table = 0
+		} else if versionBits == 2 {
+			sampleRate /= 2
+		} else {
+			sampleRate /= 4
+		}
+		bitrate := mpegBitrates[table][layer-1][bitrateIndex] * 1000
+		samplesPerFrame := 1152
+		if layer == 1 {
+			samplesPerFrame = 384
+		} else if layer == 3 && !mpeg1 {
+			samplesPerFrame = 576
+		}
+		mono := buf[i+3]>>6 == 3
+		sideInfo := 32
+		switch {
+		case mpeg1 && mono, !mpeg1 && !mono:
+			sideInfo = 17
+		case !mpeg1 && mono:
+			sideInfo = 9
+		}
+		frames := 0
+		if xing := i + 4 + sideInfo; xing+12 <= len(buf) {
+			id := string(buf[xing : xing+4])
+			if (id == "Xing" || id == "Info") && buf[xing+7]&0x01 != 0 {
+				frames = int(binary.BigEndian.Uint32(buf[xing+8:]))
+			}
+		}
+		if vbri := i + 36; frames == 0 && vbri+18 <= len(buf) && string(buf[vbri:vbri+4]) == "VBRI" {
+			frames = int(binary.BigEndian.Uint32(buf[vbri+14:]))
+		}
+		if frames > 0 {
+			return float64(frames) * float64(samplesPerFrame) / float64(sampleRate)
+		}
+		audioSize := size - audioStart - int64(i)
+		if tag := make([]byte, 3); size >= 128 {
+			if _, err := r.ReadAt(tag, size-128); err == nil && string(tag) == "TAG" {
+				audioSize -= 128
+			}
+		}
+		return float64(audioSize) * 8 / float64(bitrate)
+	}
+	return 0
+}
+
+func (flacExtractor) Name() string {
+	return "flac"
+}
+
+func (flacExtractor) Supports(ext, mimetype string) bool {
+	return ext == ".flac" || mimetype == "audio/flac"
+}
+
+func (flacExtractor) Extract(realPath string) (*iteminfo.Metadata, error) {
+	file, _, err := open(realPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	magic := make([]byte, 4)
+	if _, err = file.ReadAt(magic, 0); err != nil {
+		return nil, err
+	}
+	if string(magic) != "fLaC" {
+		return nil, fmt.Errorf("missing flac stream marker")
+	}
+	meta := &iteminfo.Metadata{}
+	offset := int64(4)
+	header := make([]byte, 4)
+	for {
+		if _, err = file.ReadAt(header, offset); err != nil {
+			return meta, err
+		}
+		last := header[0]&0x80 != 0
+		blockType := header[0] & 0x7F
+		length := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		switch blockType {
+		case 0:
+			info := make([]byte, 18)
+			if _, err = file.ReadAt(info, offset+4); err != nil {
+				return meta, err
+			}
+			sampleRate := int64(info[10])<<12 | int64(info[11])<<4 | int64(info[12])>>4
+			samples := int64(info[13]&0x0F)<<32 | int64(binary.BigEndian.Uint32(info[14:18]))
+			if sampleRate > 0 {
+				meta.Duration = float64(samples) / float64(sampleRate)
+			}
+		case 4:
+			if length > maxTagSize {
+				break
+			}
+			comments := make([]byte, length)
+			if _, err = file.ReadAt(comments, offset+4); err != nil {
+				return meta, err
+			}
+			readVorbisComments(comments, meta)
+		}
+		if last {
+			return meta, nil
+		}
+		offset += 4 + length
+	}
+}
+
+// readVorbisComments reads the little endian KEY=value list shared by flac and ogg.
+func readVorbisComments(data []byte, meta *iteminfo.Metadata) {
+	next := func() ([]byte, bool) {
+		if len(data) < 4 {
+			return nil, false
+		}
+		length := int(binary.LittleEndian.Uint32(data))
+		if length > len(data)-4 {
+			return nil, false
+		}
+		value := data[4 : 4+length]
+		data = data[4+length:]
+		return value, true
+	}
+	if _, ok := next(); !ok { // vendor
+		return
+	}
+	if len(data) < 4 {
+		return
+	}
+	count := int(binary.LittleEndian.Uint32(data))
+	data = data[4:]
+	for i := 0; i < count; i++ {
+		comment, ok := next()
+		if !ok {
+			return
+		}
+		key, value, found := strings.Cut(string(comment), "=")
+		if !found {
+			continue
+		}
+		switch strings.ToUpper(key) {
+		case "TITLE":
+			meta.Title = cleanText(value)
+		case "ARTIST":
+			meta.Artist = cleanText(value)
+		case "ALBUM":
+			meta.Album = cleanText(value)
+		}
+	}
+}
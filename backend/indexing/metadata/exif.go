@@ -0,0 +1,232 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"filebrowser/indexing/iteminfo"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// exifExtractor reads EXIF tags of jpeg and tiff based images, including most raw formats.
+type exifExtractor struct{}
+
+var tiffExtensions = map[string]bool{
+	".tif": true, ".tiff": true, ".dng": true, ".nef": true, ".cr2": true, ".arw": true, ".orf": true, ".rw2": true,
+}
+
+const (
+	tagImageWidth       = 0x0100
+	tagImageHeight      = 0x0101
+	tagMake             = 0x010F
+	tagModel            = 0x0110
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagDateTimeOriginal = 0x9003
+	tagPixelXDimension  = 0xA002
+	tagPixelYDimension  = 0xA003
+	exifTimeLayout      = "2006:01:02 15:04:05"
+	maxJpegSegments     = 64
+)
+
+func (exifExtractor) Name() string {
+	return "exif"
+}
+
+func (exifExtractor) Supports(ext, mimetype string) bool {
+	return ext == ".jpg" || ext == ".jpeg" || mimetype == "image/jpeg" || tiffExtensions[ext]
+}
+
+func (exifExtractor) Extract(realPath string) (*iteminfo.Metadata, error) {
+	file, size, err := open(realPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	magic := make([]byte, 2)
+	if _, err = file.ReadAt(magic, 0); err != nil {
+		return nil, err
+	}
+	meta := &iteminfo.Metadata{}
+	if magic[0] == 0xFF && magic[1] == 0xD8 {
+		err = readJpeg(file, size, meta)
+	} else {
+		err = readTiff(io.NewSectionReader(file, 0, size), meta)
+	}
+	return meta, err
+}
+
+// readJpeg walks the segments up to the image data, reading the EXIF segment and the frame size.
+func readJpeg(r io.ReaderAt, size int64, meta *iteminfo.Metadata) error {
+	offset := int64(2)
+	header := make([]byte, 4)
+	frameWidth, frameHeight := 0, 0
+	for i := 0; i < maxJpegSegments && offset+4 <= size; i++ {
+		if _, err := r.ReadAt(header, offset); err != nil {
+			return err
+		}
+		if header[0] != 0xFF {
+			return fmt.Errorf("invalid jpeg segment at %v", offset)
+		}
+		marker := header[1]
+		length := int64(binary.BigEndian.Uint16(header[2:]))
+		if marker == 0xDA || marker == 0xD9 {
+			// start of scan, no more metadata follows
+			break
+		}
+		switch {
+		case marker == 0xE1:
+			exif := make([]byte, 6)
+			if _, err := r.ReadAt(exif, offset+4); err == nil && string(exif) == "Exif\x00\x00" {
+				// a broken EXIF segment still leaves the frame size
+				_ = readTiff(io.NewSectionReader(r, offset+10, length-8), meta)
+			}
+		case marker >= 0xC0 && marker <= 0xCF && marker != 0xC4 && marker != 0xC8 && marker != 0xCC:
+			frame := make([]byte, 5)
+			if _, err := r.ReadAt(frame, offset+4); err == nil {
+				frameHeight = int(binary.BigEndian.Uint16(frame[1:]))
+				frameWidth = int(binary.BigEndian.Uint16(frame[3:]))
+			}
+		}
+		offset += 2 + length
+	}
+	if meta.Width == 0 || meta.Height == 0 {
+		meta.Width, meta.Height = frameWidth, frameHeight
+	}
+	return nil
+}
+
+type tiffReader struct {
+	r     *io.SectionReader
+	order binary.ByteOrder
+}
+
+type ifdEntry struct {
+	tag   uint16
+	kind  uint16
+	count uint32
+	value []byte // the 4 byte value field, an offset when the value doesn't fit
+}
+
+func readTiff(r *io.SectionReader, meta *iteminfo.Metadata) error {
+	header := make([]byte, 8)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return err
+	}
+	t := tiffReader{r: r}
+	switch string(header[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return fmt.Errorf("invalid tiff header")
+	}
+	if t.order.Uint16(header[2:]) != 42 {
+		return fmt.Errorf("invalid tiff header")
+	}
+	entries, err := t.ifd(int64(t.order.Uint32(header[4:])))
+	if err != nil {
+		return err
+	}
+	var cameraMake, model string
+	for _, entry := range entries {
+		switch entry.tag {
+		case tagMake:
+			cameraMake = t.text(entry)
+		case tagModel:
+			model = t.text(entry)
+		case tagDateTime:
+			if meta.CaptureTime == nil {
+				meta.CaptureTime = parseExifTime(t.text(entry))
+			}
+		case tagImageWidth:
+			meta.Width = t.number(entry)
+		case tagImageHeight:
+			meta.Height = t.number(entry)
+		case tagExifIFD:
+			exifEntries, err := t.ifd(int64(t.number(entry)))
+			if err != nil {
+				continue
+			}
+			for _, exifEntry := range exifEntries {
+				switch exifEntry.tag {
+				case tagDateTimeOriginal:
+					if captured := parseExifTime(t.text(exifEntry)); captured != nil {
+						meta.CaptureTime = captured
+					}
+				case tagPixelXDimension:
+					meta.Width = t.number(exifEntry)
+				case tagPixelYDimension:
+					meta.Height = t.number(exifEntry)
+				}
+			}
+		}
+	}
+	// the model usually repeats the make, eg. "Canon" and "Canon EOS R5"
+	if strings.HasPrefix(strings.ToLower(model), strings.ToLower(cameraMake)) {
+		meta.Camera = model
+	} else {
+		meta.Camera = strings.TrimSpace(cameraMake + " " + model)
+	}
+	return nil
+}
+
+func (t tiffReader) ifd(offset int64) ([]ifdEntry, error) {
+	countBytes := make([]byte, 2)
+	if _, err := t.r.ReadAt(countBytes, offset); err != nil {
+		return nil, err
+	}
+	count := int(t.order.Uint16(countBytes))
+	raw := make([]byte, count*12)
+	if _, err := t.r.ReadAt(raw, offset+2); err != nil {
+		return nil, err
+	}
+	entries := make([]ifdEntry, count)
+	for i := range entries {
+		field := raw[i*12 : i*12+12]
+		entries[i] = ifdEntry{
+			tag:   t.order.Uint16(field),
+			kind:  t.order.Uint16(field[2:]),
+			count: t.order.Uint32(field[4:]),
+			value: field[8:12],
+		}
+	}
+	return entries, nil
+}
+
+// number reads SHORT and LONG values.
+func (t tiffReader) number(entry ifdEntry) int {
+	switch entry.kind {
+	case 3:
+		return int(t.order.Uint16(entry.value))
+	case 4:
+		return int(t.order.Uint32(entry.value))
+	}
+	return 0
+}
+
+// text reads ASCII values, short strings are stored in the entry itself.
+func (t tiffReader) text(entry ifdEntry) string {
+	if entry.kind != 2 || entry.count == 0 || entry.count > 1024 {
+		return ""
+	}
+	if entry.count <= 4 {
+		return cleanText(string(entry.value[:entry.count]))
+	}
+	value := make([]byte, entry.count)
+	if _, err := t.r.ReadAt(value, int64(t.order.Uint32(entry.value))); err != nil {
+		return ""
+	}
+	return cleanText(string(value))
+}
+
+// parseExifTime reads EXIF dates, they carry no time zone and are read as local time.
+func parseExifTime(value string) *time.Time {
+	parsed, err := time.ParseInLocation(exifTimeLayout, value, time.Local)
+	if err != nil || parsed.Year() < 1900 {
+		return nil
+	}
+	return &parsed
+}
@@ -0,0 +1,100 @@
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"filebrowser/common/settings"
+	"filebrowser/indexing/iteminfo"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ffprobeExtractor reads audio and video files the other extractors can't, it is only
+// available when integrations.media.ffmpegPath is configured.
+type ffprobeExtractor struct{}
+
+const ffprobeTimeout = 10 * time.Second
+
+type ffprobeOutput struct {
+	Format struct {
+		Duration string            `json:"duration"`
+		Tags     map[string]string `json:"tags"`
+	} `json:"format"`
+	Streams []struct {
+		CodecType string            `json:"codec_type"`
+		Width     int               `json:"width"`
+		Height    int               `json:"height"`
+		Tags      map[string]string `json:"tags"`
+	} `json:"streams"`
+}
+
+func (ffprobeExtractor) Name() string {
+	return "ffprobe"
+}
+
+func (ffprobeExtractor) Supports(ext, mimetype string) bool {
+	if settings.Config.Integrations.Media.FfmpegPath == "" {
+		return false
+	}
+	return strings.HasPrefix(mimetype, "video/") || strings.HasPrefix(mimetype, "audio/")
+}
+
+func ffprobePath() string {
+	name := "ffprobe"
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return filepath.Join(settings.Config.Integrations.Media.FfmpegPath, name)
+}
+
+func (ffprobeExtractor) Extract(realPath string) (*iteminfo.Metadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ffprobeTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, ffprobePath(),
+		"-v", "error", "-print_format", "json", "-show_format", "-show_streams", realPath)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe failed: %v", err)
+	}
+	var probe ffprobeOutput
+	if err = json.Unmarshal(output, &probe); err != nil {
+		return nil, err
+	}
+	meta := &iteminfo.Metadata{}
+	if duration, err := strconv.ParseFloat(probe.Format.Duration, 64); err == nil {
+		meta.Duration = duration
+	}
+	tags := lowerKeys(probe.Format.Tags)
+	for _, stream := range probe.Streams {
+		if stream.CodecType == "video" && meta.Width == 0 {
+			meta.Width, meta.Height = stream.Width, stream.Height
+		}
+		// ogg and opus keep their tags on the audio stream
+		for key, value := range lowerKeys(stream.Tags) {
+			if _, ok := tags[key]; !ok {
+				tags[key] = value
+			}
+		}
+	}
+	meta.Title = cleanText(tags["title"])
+	meta.Artist = cleanText(tags["artist"])
+	meta.Album = cleanText(tags["album"])
+	if created, err := time.Parse(time.RFC3339Nano, tags["creation_time"]); err == nil {
+		created = created.Local()
+		meta.CaptureTime = &created
+	}
+	return meta, nil
+}
+
+func lowerKeys(tags map[string]string) map[string]string {
+	lowered := make(map[string]string, len(tags))
+	for key, value := range tags {
+		lowered[strings.ToLower(key)] = value
+	}
+	return lowered
+}
@@ -0,0 +1,112 @@
+package metadata
+
+import (
+	"filebrowser/indexing/iteminfo"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gtsteffaniak/go-cache/cache"
+	"github.com/gtsteffaniak/go-logger/logger"
+)
+
+// Extractor reads metadata of the file types it supports.
+type Extractor interface {
+	Name() string
+	// Supports is called with the lowercase extension including the dot and the detected mimetype.
+	Supports(ext, mimetype string) bool
+	Extract(realPath string) (*iteminfo.Metadata, error)
+}
+
+var (
+	extractors   []Extractor
+	extractorsMu sync.RWMutex
+	// results by path, size and modification time
+	metadataCache = cache.NewCache(48*time.Hour, 24*time.Hour)
+)
+
+func init() {
+	Register(exifExtractor{})
+	Register(id3Extractor{})
+	Register(flacExtractor{})
+	Register(mp4Extractor{})
+	// ffprobe is slow, it only runs when the pure Go readers don't support a file or fail
+	Register(ffprobeExtractor{})
+}
+
+// Register adds an extractor. Extractors are tried in the order they were registered
+// and the first one that returns metadata wins.
+func Register(extractor Extractor) {
+	extractorsMu.Lock()
+	defer extractorsMu.Unlock()
+	extractors = append(extractors, extractor)
+}
+
+// Supported reports whether any registered extractor handles the file type.
+func Supported(name, mimetype string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	extractorsMu.RLock()
+	defer extractorsMu.RUnlock()
+	for _, extractor := range extractors {
+		if extractor.Supports(ext, mimetype) {
+			return true
+		}
+	}
+	return false
+}
+
+// Extract returns the metadata of a file, nil when it has none. An error means every
+// extractor that supports the file failed, eg. because it is still being written, it is
+// not cached so the file is read again next time. Results are cached by path, size and
+// modification time.
+func Extract(realPath, mimetype string, size int64, modTime time.Time) (*iteminfo.Metadata, error) {
+	cacheKey := fmt.Sprintf("%v:%v:%v", realPath, size, modTime.UnixNano())
+	if cached, ok := metadataCache.Get(cacheKey).(*iteminfo.Metadata); ok {
+		return cached, nil
+	}
+	ext := strings.ToLower(filepath.Ext(realPath))
+	extractorsMu.RLock()
+	candidates := append([]Extractor{}, extractors...)
+	extractorsMu.RUnlock()
+	var lastErr error
+	for _, extractor := range candidates {
+		if !extractor.Supports(ext, mimetype) {
+			continue
+		}
+		meta, err := extractor.Extract(realPath)
+		if err != nil {
+			logger.Debugf("%v could not read metadata of %v: %v", extractor.Name(), realPath, err)
+			lastErr = err
+			continue
+		}
+		if meta == nil || meta.IsEmpty() {
+			continue
+		}
+		meta.Extractor = extractor.Name()
+		metadataCache.Set(cacheKey, meta)
+		return meta, nil
+	}
+	return nil, lastErr
+}
+
+// open is shared by the extractors, it also returns the file size.
+func open(realPath string) (*os.File, int64, error) {
+	file, err := os.Open(realPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, info.Size(), nil
+}
+
+// cleanText trims padding and zero bytes that tag formats leave in fixed size fields.
+func cleanText(value string) string {
+	return strings.TrimSpace(strings.Trim(value, "\x00"))
+}
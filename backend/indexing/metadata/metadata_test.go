@@ -0,0 +1,271 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"filebrowser/indexing/iteminfo"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+type tiffEntry struct {
+	tag   uint16
+	value any // string or int
+}
+
+// buildTiff writes a little endian tiff header with the entries of IFD0, and of an EXIF IFD
+// linked from IFD0 when exif is not empty.
+func buildTiff(ifd0, exif []tiffEntry) []byte {
+	order := binary.LittleEndian
+	ifdSize := func(entries []tiffEntry) int {
+		if len(entries) == 0 {
+			return 0
+		}
+		return 2 + len(entries)*12 + 4
+	}
+	if len(exif) > 0 {
+		ifd0 = append(ifd0, tiffEntry{tagExifIFD, 0})
+		ifd0[len(ifd0)-1].value = 8 + ifdSize(ifd0)
+	}
+	dataOffset := 8 + ifdSize(ifd0) + ifdSize(exif)
+	out := order.AppendUint32([]byte("II*\x00"), 8)
+	data := []byte{}
+	writeIFD := func(entries []tiffEntry) {
+		out = order.AppendUint16(out, uint16(len(entries)))
+		for _, entry := range entries {
+			out = order.AppendUint16(out, entry.tag)
+			switch value := entry.value.(type) {
+			case string:
+				text := append([]byte(value), 0)
+				out = order.AppendUint16(out, 2)
+				out = order.AppendUint32(out, uint32(len(text)))
+				if len(text) <= 4 {
+					out = append(out, append(text, make([]byte, 4-len(text))...)...)
+				} else {
+					out = order.AppendUint32(out, uint32(dataOffset+len(data)))
+					data = append(data, text...)
+				}
+			case int:
+				out = order.AppendUint16(out, 4)
+				out = order.AppendUint32(out, 1)
+				out = order.AppendUint32(out, uint32(value))
+			}
+		}
+		out = order.AppendUint32(out, 0)
+	}
+	writeIFD(ifd0)
+	if len(exif) > 0 {
+		writeIFD(exif)
+	}
+	return append(out, data...)
+}
+
+// buildJpeg writes the segments read by the exif extractor, an optional EXIF segment and the frame.
+func buildJpeg(tiff []byte, width, height int) []byte {
+	out := []byte{0xFF, 0xD8}
+	if tiff != nil {
+		out = append(out, 0xFF, 0xE1)
+		out = binary.BigEndian.AppendUint16(out, uint16(2+6+len(tiff)))
+		out = append(append(out, "Exif\x00\x00"...), tiff...)
+	}
+	out = append(out, 0xFF, 0xC0, 0x00, 0x0B, 0x08)
+	out = binary.BigEndian.AppendUint16(out, uint16(height))
+	out = binary.BigEndian.AppendUint16(out, uint16(width))
+	out = append(out, 0x01, 0x01, 0x11, 0x00)
+	return append(out, 0xFF, 0xDA, 0x00, 0x02)
+}
+
+func id3Frame(id string, encoding byte, text []byte) []byte {
+	out := append([]byte(id), 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(out[4:], uint32(len(text)+1))
+	return append(append(out, encoding), text...)
+}
+
+// buildMp3 writes an ID3v2.3 tag followed by an mpeg 1 layer 3 frame with a Xing header.
+func buildMp3(frames uint32) []byte {
+	title := utf16.Encode([]rune("Trällern"))
+	utf16Title := []byte{0xFF, 0xFE}
+	for _, unit := range title {
+		utf16Title = binary.LittleEndian.AppendUint16(utf16Title, unit)
+	}
+	tag := append(id3Frame("TIT2", 1, utf16Title), id3Frame("TPE1", 0, []byte("Artist\x00"))...)
+	tag = append(tag, id3Frame("TALB", 3, []byte("Album"))...)
+	size := len(tag)
+	out := []byte{'I', 'D', '3', 3, 0, 0, byte(size >> 21 & 0x7F), byte(size >> 14 & 0x7F), byte(size >> 7 & 0x7F), byte(size & 0x7F)}
+	out = append(out, tag...)
+	// 128 kbit/s, 44.1 kHz, stereo
+	out = append(out, 0xFF, 0xFB, 0x90, 0x00)
+	out = append(out, make([]byte, 32)...)
+	out = append(out, "Xing\x00\x00\x00\x01"...)
+	out = binary.BigEndian.AppendUint32(out, frames)
+	return append(out, make([]byte, 400)...)
+}
+
+func buildFlac(sampleRate, samples int, comments ...string) []byte {
+	out := append([]byte("fLaC"), 0x00, 0x00, 0x00, 34)
+	info := make([]byte, 34)
+	info[10], info[11], info[12] = byte(sampleRate>>12), byte(sampleRate>>4), byte(sampleRate<<4)
+	binary.BigEndian.PutUint32(info[14:], uint32(samples))
+	out = append(out, info...)
+	block := binary.LittleEndian.AppendUint32(nil, 4)
+	block = append(block, "test"...)
+	block = binary.LittleEndian.AppendUint32(block, uint32(len(comments)))
+	for _, comment := range comments {
+		block = binary.LittleEndian.AppendUint32(block, uint32(len(comment)))
+		block = append(block, comment...)
+	}
+	out = append(out, 0x84, byte(len(block)>>16), byte(len(block)>>8), byte(len(block)))
+	return append(out, block...)
+}
+
+func atom(kind string, contents ...[]byte) []byte {
+	size := 8
+	for _, content := range contents {
+		size += len(content)
+	}
+	out := binary.BigEndian.AppendUint32(nil, uint32(size))
+	out = append(out, kind...)
+	for _, content := range contents {
+		out = append(out, content...)
+	}
+	return out
+}
+
+func buildMp4(created time.Time, title string) []byte {
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[4:], uint32(created.Sub(mp4Epoch)/time.Second))
+	binary.BigEndian.PutUint32(mvhd[12:], 1000)
+	binary.BigEndian.PutUint32(mvhd[16:], 90500)
+	tkhd := make([]byte, 84)
+	binary.BigEndian.PutUint32(tkhd[76:], 1920<<16)
+	binary.BigEndian.PutUint32(tkhd[80:], 1080<<16)
+	data := atom("data", make([]byte, 8), []byte(title))
+	meta := atom("meta", make([]byte, 4), atom("ilst", atom("\xa9nam", data)))
+	moov := atom("moov", atom("mvhd", mvhd), atom("trak", atom("tkhd", tkhd)), atom("udta", meta))
+	return append(atom("ftyp", []byte("isom")), moov...)
+}
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+var (
+	taken      = time.Date(2023, 7, 14, 10, 30, 0, 0, time.Local)
+	exifSample = buildJpeg(buildTiff(
+		[]tiffEntry{{tagMake, "Canon"}, {tagModel, "Canon EOS R5"}},
+		[]tiffEntry{{tagDateTimeOriginal, "2023:07:14 10:30:00"}, {tagPixelXDimension, 6000}, {tagPixelYDimension, 4000}},
+	), 160, 120)
+	samples = []struct {
+		name      string
+		extractor Extractor
+		data      []byte
+		want      iteminfo.Metadata
+	}{
+		{"photo.jpg", exifExtractor{}, exifSample,
+			iteminfo.Metadata{Camera: "Canon EOS R5", CaptureTime: &taken, Width: 6000, Height: 4000}},
+		{"plain.jpg", exifExtractor{}, buildJpeg(nil, 640, 480),
+			iteminfo.Metadata{Width: 640, Height: 480}},
+		{"raw.dng", exifExtractor{}, buildTiff([]tiffEntry{{tagMake, "NIKON"}, {tagModel, "Z 6"}, {tagImageWidth, 300}, {tagImageHeight, 200}}, nil),
+			iteminfo.Metadata{Camera: "NIKON Z 6", Width: 300, Height: 200}},
+		{"song.mp3", id3Extractor{}, buildMp3(100),
+			iteminfo.Metadata{Title: "Trällern", Artist: "Artist", Album: "Album", Duration: 100 * 1152 / 44100.0}},
+		{"song.flac", flacExtractor{}, buildFlac(44100, 441000, "TITLE=Flac Title", "artist=Someone", "ALBUM=Record", "broken"),
+			iteminfo.Metadata{Title: "Flac Title", Artist: "Someone", Album: "Record", Duration: 10}},
+		{"clip.mp4", mp4Extractor{}, buildMp4(taken, "Clip"),
+			iteminfo.Metadata{Title: "Clip", CaptureTime: &taken, Width: 1920, Height: 1080, Duration: 90.5}},
+	}
+)
+
+func TestExtractors(t *testing.T) {
+	for _, sample := range samples {
+		t.Run(sample.name, func(t *testing.T) {
+			meta, err := sample.extractor.Extract(writeFile(t, sample.name, sample.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta.CaptureTime != nil && sample.want.CaptureTime != nil && meta.CaptureTime.Equal(*sample.want.CaptureTime) {
+				meta.CaptureTime = sample.want.CaptureTime
+			}
+			if !reflect.DeepEqual(*meta, sample.want) {
+				t.Errorf("got %+v, want %+v", *meta, sample.want)
+			}
+		})
+	}
+}
+
+func TestID3v1(t *testing.T) {
+	tag := make([]byte, 128)
+	copy(tag, "TAG")
+	copy(tag[3:], "Old Title")
+	copy(tag[33:], "Old Artist")
+	copy(tag[63:], "Caf\xe9")
+	meta, err := id3Extractor{}.Extract(writeFile(t, "old.mp3", append(make([]byte, 200), tag...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Title != "Old Title" || meta.Artist != "Old Artist" || meta.Album != "Café" {
+		t.Errorf("got %+v, want the ID3v1 fields", *meta)
+	}
+}
+
+// TestMalformedInput checks that truncated and corrupted files neither panic nor hang the extractors.
+func TestMalformedInput(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for _, sample := range samples {
+		t.Run(sample.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), sample.name)
+			inputs := [][]byte{}
+			for n := 0; n < len(sample.data); n++ {
+				inputs = append(inputs, sample.data[:n])
+			}
+			for i := 0; i < 200; i++ {
+				corrupted := append([]byte{}, sample.data...)
+				for j := 0; j < 4; j++ {
+					corrupted[random.Intn(len(corrupted))] = byte(random.Intn(256))
+				}
+				inputs = append(inputs, corrupted)
+			}
+			for _, input := range inputs {
+				if err := os.WriteFile(path, input, 0644); err != nil {
+					t.Fatal(err)
+				}
+				_, _ = sample.extractor.Extract(path)
+			}
+		})
+	}
+}
+
+func TestExtractRetriesFailures(t *testing.T) {
+	valid := buildFlac(44100, 441000, "TITLE=Later")
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	path := writeFile(t, "partial.flac", make([]byte, len(valid)))
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	if meta, err := Extract(path, "audio/flac", int64(len(valid)), modTime); meta != nil || err == nil {
+		t.Fatalf("Extract of an unreadable file = %v, %v, want an error", meta, err)
+	}
+	// the same size and modification time, eg. a file that was preallocated while it was copied
+	if err := os.WriteFile(path, valid, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := Extract(path, "audio/flac", int64(len(valid)), modTime)
+	if err != nil || meta == nil || meta.Title != "Later" || meta.Extractor != "flac" {
+		t.Errorf("Extract after the file was written = %+v, %v, want its metadata", meta, err)
+	}
+	if meta, err := Extract(writeFile(t, "notes.txt", []byte("text")), "text/plain", 4, modTime); meta != nil || err != nil {
+		t.Errorf("Extract of an unsupported file = %v, %v, want nil without error", meta, err)
+	}
+}
@@ -0,0 +1,181 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"filebrowser/indexing/iteminfo"
+	"io"
+	"time"
+)
+
+// mp4Extractor walks the atoms of mp4 and quicktime files for the duration,
+// video size, creation time and iTunes style tags.
+type mp4Extractor struct{}
+
+var (
+	mp4Extensions = map[string]bool{
+		".mp4": true, ".m4v": true, ".m4a": true, ".m4b": true, ".mov": true, ".3gp": true,
+	}
+	// atoms that only contain other atoms
+	mp4Containers = map[string]bool{
+		"moov": true, "trak": true, "udta": true, "meta": true, "ilst": true,
+	}
+	mp4Epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+const (
+	maxAtomDepth = 8
+	maxAtomRead  = 1 << 20 // the atoms read here are small, anything larger is skipped
+)
+
+func (mp4Extractor) Name() string {
+	return "mp4"
+}
+
+func (mp4Extractor) Supports(ext, mimetype string) bool {
+	return mp4Extensions[ext] || mimetype == "video/mp4" || mimetype == "video/quicktime"
+}
+
+func (mp4Extractor) Extract(realPath string) (*iteminfo.Metadata, error) {
+	file, size, err := open(realPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	meta := &iteminfo.Metadata{}
+	err = walkAtoms(file, 0, size, 0, "", meta)
+	return meta, err
+}
+
+func walkAtoms(r io.ReaderAt, start, end int64, depth int, parent string, meta *iteminfo.Metadata) error {
+	header := make([]byte, 16)
+	for offset := start; offset+8 <= end; {
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return err
+		}
+		size := int64(binary.BigEndian.Uint32(header))
+		kind := string(header[4:8])
+		headerSize := int64(8)
+		switch size {
+		case 0:
+			size = end - offset
+		case 1:
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if size < headerSize || offset+size > end {
+			return nil
+		}
+		contentStart, contentEnd := offset+headerSize, offset+size
+		switch {
+		case parent == "ilst":
+			readIlstItem(r, kind, contentStart, contentEnd, meta)
+		case mp4Containers[kind] && depth < maxAtomDepth:
+			if kind == "meta" && isFullMetaAtom(r, contentStart) {
+				// the iso variant carries a version and flags before its children
+				contentStart += 4
+			}
+			if err := walkAtoms(r, contentStart, contentEnd, depth+1, kind, meta); err != nil {
+				return err
+			}
+		case kind == "mvhd":
+			readMvhd(r, contentStart, contentEnd, meta)
+		case kind == "tkhd":
+			readTkhd(r, contentStart, contentEnd, meta)
+		}
+		offset += size
+	}
+	return nil
+}
+
+func readAtom(r io.ReaderAt, start, end int64) []byte {
+	if end-start > maxAtomRead || end <= start {
+		return nil
+	}
+	data := make([]byte, end-start)
+	if _, err := r.ReadAt(data, start); err != nil {
+		return nil
+	}
+	return data
+}
+
+func isFullMetaAtom(r io.ReaderAt, start int64) bool {
+	next := make([]byte, 8)
+	if _, err := r.ReadAt(next, start); err != nil {
+		return false
+	}
+	// quicktime meta atoms start with their hdlr child directly
+	return string(next[4:8]) != "hdlr"
+}
+
+func readMvhd(r io.ReaderAt, start, end int64, meta *iteminfo.Metadata) {
+	data := readAtom(r, start, end)
+	var created, timescale, duration uint64
+	switch {
+	case len(data) >= 32 && data[0] == 1:
+		created = binary.BigEndian.Uint64(data[4:])
+		timescale = uint64(binary.BigEndian.Uint32(data[20:]))
+		duration = binary.BigEndian.Uint64(data[24:])
+	case len(data) >= 20:
+		created = uint64(binary.BigEndian.Uint32(data[4:]))
+		timescale = uint64(binary.BigEndian.Uint32(data[12:]))
+		duration = uint64(binary.BigEndian.Uint32(data[16:]))
+	default:
+		return
+	}
+	if timescale > 0 {
+		meta.Duration = float64(duration) / float64(timescale)
+	}
+	if created > 0 && meta.CaptureTime == nil {
+		captured := mp4Epoch.Add(time.Duration(created) * time.Second).Local()
+		meta.CaptureTime = &captured
+	}
+}
+
+// readTkhd takes the size of the first track with one, audio tracks have none.
+func readTkhd(r io.ReaderAt, start, end int64, meta *iteminfo.Metadata) {
+	if meta.Width > 0 {
+		return
+	}
+	data := readAtom(r, start, end)
+	sizeOffset := 76
+	if len(data) > 0 && data[0] == 1 {
+		sizeOffset = 88
+	}
+	if len(data) < sizeOffset+8 {
+		return
+	}
+	// 16.16 fixed point
+	meta.Width = int(binary.BigEndian.Uint32(data[sizeOffset:]) >> 16)
+	meta.Height = int(binary.BigEndian.Uint32(data[sizeOffset+4:]) >> 16)
+}
+
+// readIlstItem reads the data atom of an iTunes style tag.
+func readIlstItem(r io.ReaderAt, kind string, start, end int64, meta *iteminfo.Metadata) {
+	var target *string
+	switch kind {
+	case "\xa9nam":
+		target = &meta.Title
+	case "\xa9ART", "aART":
+		if meta.Artist != "" {
+			return
+		}
+		target = &meta.Artist
+	case "\xa9alb":
+		target = &meta.Album
+	default:
+		return
+	}
+	data := readAtom(r, start, end)
+	// size, "data", type, locale, value
+	if len(data) < 16 || string(data[4:8]) != "data" {
+		return
+	}
+	dataSize := int(binary.BigEndian.Uint32(data))
+	if dataSize < 16 || dataSize > len(data) {
+		return
+	}
+	*target = cleanText(string(data[16:dataSize]))
+}
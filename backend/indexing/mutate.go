@@ -6,6 +6,7 @@ import (
 	"filebrowser/indexing/iteminfo"
 	"fmt"
 	"path/filepath"
+	"strings"
 	"sync"
 	"github.com/shirou/gopsutil/v3/disk"
 
@@ -40,7 +41,7 @@ var (
 func (idx *Index) UpdateMetadata(info *iteminfo.FileInfo) bool {
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
-	idx.Directories[info.Path] = info
+	idx.store.put(info)
 	return true
 }
 func (idx *Index) GetReducedMetadata(target string, isDir bool) (*iteminfo.FileInfo, bool) {
@@ -53,20 +54,20 @@ func (idx *Index) GetReducedMetadata(target string, isDir bool) (*iteminfo.FileI
 	if checkDir == "" {
 		checkDir = "/"
 	}
-	dir, exists := idx.Directories[checkDir]
+	dir, exists := idx.store.get(checkDir)
 	if !exists {
 		return nil, false
 	}
 
 	if isDir {
-		return dir, true
+		return idx.store.info(checkDir, dir), true
 	}
 	// handle file
 	if checkDir == "/" {
 		checkDir = ""
 	}
 	baseName := filepath.Base(target)
-	for _, item := range dir.Files {
+	for item := range idx.store.files(dir) {
 		if item.Name == baseName {
 			return &iteminfo.FileInfo{
 				Path:     checkDir + "/" + item.Name,
@@ -87,8 +88,26 @@ func (idx *Index) GetMetadataInfo(target string, isDir bool) (*iteminfo.FileInfo
 	if checkDir == "" {
 		checkDir = "/"
 	}
-	dir, exists := idx.Directories[checkDir]
-	return dir, exists
+	dir, exists := idx.store.get(checkDir)
+	if !exists {
+		return nil, false
+	}
+	return idx.store.info(checkDir, dir), true
+}
+
+// FilesInScope returns every indexed file under scope, with Path set to the index path of the file.
+func (idx *Index) FilesInScope(scope string) []iteminfo.FileInfo {
+	scope = normalizeScope(scope)
+	files := []iteminfo.FileInfo{}
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	for dirPath, dir := range idx.store.all(scope) {
+		prefix := strings.TrimSuffix(dirPath, "/") + "/"
+		for item := range idx.store.files(dir) {
+			files = append(files, iteminfo.FileInfo{Path: prefix + item.Name, ItemInfo: item})
+		}
+	}
+	return files
 }
 func GetIndexInfo(sourceName string) (ReducedIndex, error) {
 	idx, ok := indexes[sourceName]
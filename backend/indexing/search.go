@@ -0,0 +1,201 @@
+package indexing
+
+import (
+	"encoding/base64"
+	"filebrowser/common/errors"
+	"filebrowser/common/utils"
+	"filebrowser/indexing/iteminfo"
+	"fmt"
+	"iter"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxSearchResults is the page size used when no or a too large limit is requested.
+const maxSearchResults = 100
+
+type SearchResult struct {
+	Path     string    `json:"path"` // path relative to the searched scope
+	Type     string    `json:"type"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	Snippets []string  `json:"snippets,omitempty"` // matching lines for content: searches
+}
+
+type SearchResponse struct {
+	Results []SearchResult `json:"results"`
+	Cursor  string         `json:"cursor,omitempty"` // pass to the next search call to continue, empty when done
+}
+
+// TagLookup answers tag: filters, tags are kept per user outside the index.
+type TagLookup interface {
+	TaggedPaths(userID uint, source string, labels []string) map[string]struct{}
+}
+
+// Search returns items under scope matching the query, ordered by path.
+// Results are paged by limit, the returned cursor continues where the previous page stopped.
+// The user and tag lookup are needed for tag: filters, without a lookup they match nothing.
+// The scope must already be confined to what the user may see, Search doesn't know the
+// user's root. Scopes with ".." segments are rejected rather than resolved.
+func (idx *Index) Search(userID uint, tags TagLookup, scope, query string, limit int, cursor string) (SearchResponse, error) {
+	response := SearchResponse{Results: []SearchResult{}}
+	if slices.Contains(strings.Split(filepath.ToSlash(scope), "/"), "..") {
+		return response, errors.ErrInvalidRequestParams
+	}
+	scope = normalizeScope(scope)
+	if limit <= 0 || limit > maxSearchResults {
+		limit = maxSearchResults
+	}
+	after := ""
+	if cursor != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return response, errors.ErrInvalidRequestParams
+		}
+		after = string(decoded)
+	}
+
+	parsed, err := iteminfo.ParseQuery(strings.TrimSpace(query))
+	if err != nil {
+		return response, err
+	}
+	matches := idx.searchMatches(userID, tags, scope, query, parsed)
+	start := 0
+	if after != "" {
+		start = sort.Search(len(matches), func(i int) bool {
+			return matches[i].Path > after
+		})
+	}
+	end := start + limit
+	if end > len(matches) {
+		end = len(matches)
+	}
+	response.Results = append(response.Results, matches[start:end]...)
+	if contentTerms := parsed.ContentTerms(); len(contentTerms) > 0 && idx.content != nil {
+		// snippets are only read for the returned page
+		for i, result := range response.Results {
+			response.Results[i].Snippets = idx.contentSnippets(strings.TrimSuffix(scope, "/")+result.Path, contentTerms)
+		}
+	}
+	if end < len(matches) {
+		response.Cursor = base64.RawURLEncoding.EncodeToString([]byte(matches[end-1].Path))
+	}
+	return response, nil
+}
+
+// searchMatches returns all matches for the query sorted by path, using the results cache when possible.
+func (idx *Index) searchMatches(userID uint, tags TagLookup, scope, query string, parsed *iteminfo.Query) []SearchResult {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return []SearchResult{}
+	}
+	cacheKey := "search-" + idx.Name + ":" + scope + ":" + query
+	if len(parsed.Filters("tag")) > 0 {
+		cacheKey = fmt.Sprintf("search-%v:%v:%v:%v", idx.Name, userID, scope, query)
+	}
+	if cached, ok := utils.SearchResultsCache.Get(cacheKey).([]SearchResult); ok {
+		return cached
+	}
+	lookup, ok := idx.newSearchLookup(userID, tags, parsed)
+	if !ok {
+		return []SearchResult{}
+	}
+	scopePrefix := scope + "/"
+	if scope == "/" {
+		scopePrefix = "/"
+	}
+
+	matches := []SearchResult{}
+	idx.mu.RLock()
+	for dirPath, dir := range idx.store.all(scope) {
+		for _, items := range []iter.Seq[iteminfo.ItemInfo]{idx.store.folders(dir), idx.store.files(dir)} {
+			for item := range items {
+				itemPath := strings.TrimSuffix(dirPath, "/") + "/" + item.Name
+				if !parsed.Matches(item, itemPath, lookup) {
+					continue
+				}
+				matches = append(matches, SearchResult{
+					Path:     "/" + strings.TrimPrefix(itemPath, scopePrefix),
+					Type:     item.Type,
+					Size:     item.Size,
+					Modified: item.ModTime,
+				})
+			}
+		}
+	}
+	idx.mu.RUnlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Path < matches[j].Path
+	})
+	utils.SearchResultsCache.Set(cacheKey, matches)
+	return matches
+}
+
+// searchLookup answers content:, tag: and metadata filters from the paths matching each of them,
+// collected once before the index is walked.
+type searchLookup struct {
+	content  map[string]map[string]struct{}                  // term -> index paths
+	tags     map[string]map[string]struct{}                  // label -> index paths
+	metadata map[iteminfo.MetadataFilter]map[string]struct{} // filter -> index paths
+}
+
+// newSearchLookup returns false when the query needs a content or metadata index the source doesn't have,
+// or tags without a tag lookup.
+func (idx *Index) newSearchLookup(userID uint, tags TagLookup, parsed *iteminfo.Query) (searchLookup, bool) {
+	lookup := searchLookup{
+		content:  map[string]map[string]struct{}{},
+		tags:     map[string]map[string]struct{}{},
+		metadata: map[iteminfo.MetadataFilter]map[string]struct{}{},
+	}
+	for _, filter := range parsed.Filters("content") {
+		if idx.content == nil {
+			// content search is not enabled for this source
+			return lookup, false
+		}
+		lookup.content[filter.Value] = idx.content.candidates([]string{filter.Value})
+	}
+	for _, filter := range parsed.Filters("tag") {
+		if tags == nil {
+			return lookup, false
+		}
+		lookup.tags[filter.Value] = tags.TaggedPaths(userID, idx.Name, []string{filter.Value})
+	}
+	for _, field := range iteminfo.MetadataFields {
+		for _, filter := range parsed.Filters(field) {
+			if idx.media == nil {
+				// metadata indexing is not enabled for this source
+				return lookup, false
+			}
+			lookup.metadata[filter.Metadata] = idx.media.candidates([]iteminfo.MetadataFilter{filter.Metadata})
+		}
+	}
+	return lookup, true
+}
+
+func (l searchLookup) HasContent(path, term string) bool {
+	_, ok := l.content[term][path]
+	return ok
+}
+
+func (l searchLookup) HasTag(path, label string) bool {
+	_, ok := l.tags[label][path]
+	return ok
+}
+
+func (l searchLookup) HasMetadata(path string, filter iteminfo.MetadataFilter) bool {
+	_, ok := l.metadata[filter][path]
+	return ok
+}
+
+// normalizeScope converts a user scope into an index path without trailing slash.
+func normalizeScope(scope string) string {
+	scope = filepath.ToSlash(filepath.Clean("/" + scope))
+	if scope == "." || scope == "" {
+		return "/"
+	}
+	return scope
+}
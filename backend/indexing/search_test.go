@@ -0,0 +1,199 @@
+package indexing
+
+import (
+	"filebrowser/common/errors"
+	"filebrowser/indexing/iteminfo"
+	"strings"
+	"testing"
+)
+
+func newSearchTestIndex(name string) *Index {
+	dir := func(path string, folders []string, files ...iteminfo.ItemInfo) *iteminfo.FileInfo {
+		info := &iteminfo.FileInfo{Path: path, Files: files}
+		for _, folder := range folders {
+			info.Folders = append(info.Folders, iteminfo.ItemInfo{Name: folder, Type: "directory"})
+		}
+		return info
+	}
+	file := func(name string, size int64) iteminfo.ItemInfo {
+		return iteminfo.ItemInfo{Name: name, Size: size, Type: "text/plain"}
+	}
+	idx := newTestIndex("/srv", 1)
+	idx.Name = name
+	for _, info := range []*iteminfo.FileInfo{
+		dir("/", []string{"users", "shared"}, file("report-root.txt", 10)),
+		dir("/users", []string{"bob", "bobby"}),
+		dir("/users/bob", []string{"reports"}, file("report-1.txt", 10), file("notes.md", 10), file("Report-Big.pdf", 5*1024*1024)),
+		dir("/users/bob/reports", nil, file("report-2.txt", 10), file("report-3.txt", 10)),
+		dir("/users/bobby", nil, file("report-secret.txt", 10)),
+		dir("/shared", nil, file("report-shared.txt", 10)),
+	} {
+		idx.UpdateMetadata(info)
+	}
+	return idx
+}
+
+func TestSearchStaysInScope(t *testing.T) {
+	idx := newSearchTestIndex("scope-test")
+	testCases := map[string]struct {
+		scope string
+		want  []string
+	}{
+		"user scope": {
+			scope: "/users/bob",
+			want:  []string{"/Report-Big.pdf", "/report-1.txt", "/reports", "/reports/report-2.txt", "/reports/report-3.txt"},
+		},
+		"trailing slash": {
+			scope: "/users/bob/",
+			want:  []string{"/Report-Big.pdf", "/report-1.txt", "/reports", "/reports/report-2.txt", "/reports/report-3.txt"},
+		},
+		"sub folder": {
+			scope: "/users/bob/reports",
+			want:  []string{"/report-2.txt", "/report-3.txt"},
+		},
+		"missing scope": {
+			scope: "/users/alice",
+			want:  []string{},
+		},
+	}
+	for name, tt := range testCases {
+		t.Run(name, func(t *testing.T) {
+			response, err := idx.Search(1, nil, tt.scope, "report", 0, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, result := range response.Results {
+				got = append(got, result.Path)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Search() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	for _, scope := range []string{"/users/bob/../bobby", "../users/bobby", "/users/bob/.."} {
+		_, err := idx.Search(1, nil, scope, "report", 0, "")
+		if err != errors.ErrInvalidRequestParams {
+			t.Errorf("Search(%q) error = %v, want %v", scope, err, errors.ErrInvalidRequestParams)
+		}
+	}
+}
+
+func TestSearchPagination(t *testing.T) {
+	idx := newSearchTestIndex("page-test")
+	seen := []string{}
+	cursor := ""
+	for page := 0; page < 10; page++ {
+		response, err := idx.Search(1, nil, "/", "report", 2, cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(response.Results) > 2 {
+			t.Fatalf("page %v returned %v results, limit is 2", page, len(response.Results))
+		}
+		for _, result := range response.Results {
+			seen = append(seen, result.Path)
+		}
+		cursor = response.Cursor
+		if cursor == "" {
+			break
+		}
+	}
+	want := []string{
+		"/report-root.txt",
+		"/shared/report-shared.txt",
+		"/users/bob/Report-Big.pdf",
+		"/users/bob/report-1.txt",
+		"/users/bob/reports",
+		"/users/bob/reports/report-2.txt",
+		"/users/bob/reports/report-3.txt",
+		"/users/bobby/report-secret.txt",
+	}
+	if strings.Join(seen, ",") != strings.Join(want, ",") {
+		t.Errorf("paged results = %v, want %v", seen, want)
+	}
+	if _, err := idx.Search(1, nil, "/", "report", 2, "not base64!"); err == nil {
+		t.Error("expected an error for an invalid cursor")
+	}
+}
+
+func TestSearchConditions(t *testing.T) {
+	idx := newSearchTestIndex("condition-test")
+	testCases := map[string]struct {
+		query string
+		want  []string
+	}{
+		"folders only":  {query: "report type:folder", want: []string{"/reports"}},
+		"files only":    {query: "reports type:file", want: []string{}},
+		"larger than":   {query: "report type:largerThan=1", want: []string{"/Report-Big.pdf"}},
+		"exact case":    {query: "Report case:exact", want: []string{"/Report-Big.pdf"}},
+		"or terms":      {query: "notes|report-1", want: []string{"/notes.md", "/report-1.txt"}},
+		"filter only":   {query: "type:largerThan=1", want: []string{"/Report-Big.pdf"}},
+		"quoted phrase": {query: `"report-2"`, want: []string{"/reports/report-2.txt"}},
+		"empty query":   {query: "  ", want: []string{}},
+	}
+	for name, tt := range testCases {
+		t.Run(name, func(t *testing.T) {
+			response, err := idx.Search(1, nil, "/users/bob", tt.query, 0, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, result := range response.Results {
+				got = append(got, result.Path)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Search(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeTags answers tag: filters from fixed labels per user.
+type fakeTags map[uint]map[string][]string // user -> label -> index paths
+
+func (f fakeTags) TaggedPaths(userID uint, source string, labels []string) map[string]struct{} {
+	paths := map[string]struct{}{}
+	for _, path := range f[userID][labels[0]] {
+		paths[path] = struct{}{}
+	}
+	return paths
+}
+
+func TestSearchTags(t *testing.T) {
+	idx := newSearchTestIndex("tag-test")
+	if response, err := idx.Search(1, nil, "/users/bob", "tag:done", 0, ""); err != nil || len(response.Results) != 0 {
+		t.Errorf("tag search without a tag lookup = %v, %v, want no results", response.Results, err)
+	}
+	tags := fakeTags{
+		1: {"done": {"/users/bob/report-1.txt", "/users/bob/reports"}},
+		2: {"done": {"/users/bob/notes.md"}},
+	}
+	testCases := map[string]struct {
+		userID uint
+		query  string
+		want   []string
+	}{
+		"tagged":         {1, "tag:done", []string{"/report-1.txt", "/reports"}},
+		"other user":     {2, "tag:done", []string{"/notes.md"}},
+		"with a term":    {1, "report tag:done type:file", []string{"/report-1.txt"}},
+		"negated tag":    {1, "report -tag:done", []string{"/Report-Big.pdf", "/reports/report-2.txt", "/reports/report-3.txt"}},
+		"unknown label":  {1, "tag:todo", []string{}},
+		"user with none": {3, "tag:done", []string{}},
+	}
+	for name, tt := range testCases {
+		t.Run(name, func(t *testing.T) {
+			response, err := idx.Search(tt.userID, tags, "/users/bob", tt.query, 0, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, result := range response.Results {
+				got = append(got, result.Path)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Search(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}